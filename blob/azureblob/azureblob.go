@@ -51,11 +51,81 @@
 //  - Reader.BeforeRead: *azblob.BlockBlobURL, *azblob.BlobAccessConditions
 //  - Attributes: azblob.BlobGetPropertiesResponse
 //  - CopyOptions.BeforeCopy: azblob.Metadata, *azblob.ModifiedAccessConditions, *azblob.BlobAccessConditions
-//  - WriterOptions.BeforeWrite: *azblob.UploadStreamToBlockBlobOptions
+//  - WriterOptions.BeforeWrite: *azblob.UploadStreamToBlockBlobOptions; for
+//      multipart uploads, *azblob.BlobHTTPHeaders and *azblob.Metadata; for
+//      appends, **azblob.BlobHTTPHeaders and **azblob.Metadata
+//  - ComposeOptions.BeforeCompose: *azblob.BlobHTTPHeaders and
+//      *azblob.Metadata
+//  - UpdateAttributesOptions.BeforeUpdate: **azblob.BlobHTTPHeaders and
+//      **azblob.Metadata
+//  - Watcher, Event: the types exposed by Options.NotificationSource; see
+//      package blobpubsub.
+//
+// Multipart uploads
+//
+// azureblob stages the parts of a multipart upload as uncommitted blocks,
+// and commits them when the upload is completed. Azure has no way to delete
+// uncommitted blocks, so Abort does nothing and the blocks are garbage
+// collected by Azure after a week. An upload can only be resumed once at
+// least one part has been uploaded.
+//
+// Compose
+//
+// azureblob composes blobs by staging the content of the sources as blocks
+// of the destination with Put Block From URL, in blocks of at most 100 MiB;
+// a blob has at most 50,000 blocks. Azure reads the sources with a SAS in
+// their URL, so the bucket must be opened with a shared key credential or a
+// SAS token.
+//
+// Appending
+//
+// Appends use Azure append blobs, which are created by the first append;
+// appending to a blob written otherwise fails with a FailedPrecondition
+// error. The content of each append is buffered and appended as a single
+// block, so at most 4 MiB can be appended at once, and an append blob has at
+// most 50,000 blocks. Append blobs have no access tier, so appends with a
+// storage class return an Unimplemented error.
+//
+// Updating attributes
+//
+// Azure sets the HTTP headers and the metadata of a blob with separate
+// requests, so UpdateAttributes is not atomic if it changes both, and
+// changes the ETag of the blob.
+//
+// Expiration
+//
+// Azure has no per-blob expiration time, so blob.WriterOptions.Expires is
+// stored in the blob's metadata under the key "gocdk_expires", and returned
+// in blob.Attributes.Expires rather than in Metadata. Azure doesn't delete
+// blobs when they expire; use a lifecycle management policy to delete them.
+// Expired blobs can be read until they are deleted.
+//
+// Storage classes
+//
+// blob.StorageClass maps to Azure access tiers as follows:
+//  - StorageClassStandard: Hot
+//  - StorageClassInfrequentAccess: Cool
+//  - StorageClassArchive: Archive
+// StorageClassCold has no equivalent, so writes and copies with it return an
+// Unimplemented error. StorageClassDefault uses the account's default access
+// tier. Azure sets the tier of a blob once it has been uploaded or copied, so
+// if that fails, the blob exists in the default tier. Blobs in the Archive
+// tier must be rehydrated before they can be read.
+//
+// Watch
+//
+// azureblob reports changes using the Blob storage events of Azure Event
+// Grid, received from Options.NotificationSource; Watch returns an
+// Unimplemented error if it is nil. Messages may hold a single event or an
+// array of events, in either the Event Grid or the CloudEvents schema. Each
+// message is delivered to a single Watcher, so each Watcher needs a
+// subscription of its own.
 package azureblob
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -90,6 +160,12 @@ type Options struct {
 	// delegated privileges.
 	// See https://docs.microsoft.com/en-us/azure/storage/common/storage-dotnet-shared-access-signature-part-1#shared-access-signature-parameters.
 	SASToken SASToken
+
+	// NotificationSource receives the Event Grid events for the storage
+	// account, for example through an Azure Service Bus queue; see
+	// blobpubsub.NewNotificationSource. Required to use Watch.
+	// See https://docs.microsoft.com/en-us/azure/storage/blobs/storage-blob-event-overview.
+	NotificationSource driver.NotificationSource
 }
 
 const (
@@ -303,7 +379,7 @@ func openBucket(ctx context.Context, pipeline pipeline.Pipeline, accountName Acc
 		// don't want here.
 		blobURL.RawQuery = strings.TrimPrefix(string(opts.SASToken), "?")
 	}
-	serviceURL := azblob.NewServiceURL(*blobURL, pipeline)
+	serviceURL := azblob.NewServiceURL(*blobURL, rawPipeline{pipeline})
 	return &bucket{
 		name:         containerName,
 		pageMarkers:  map[string]azblob.Marker{},
@@ -313,6 +389,24 @@ func openBucket(ctx context.Context, pipeline pipeline.Pipeline, accountName Acc
 	}, nil
 }
 
+// rawKey is a context key that makes rawPipeline request the content of a
+// blob as stored.
+type rawKey struct{}
+
+// rawPipeline wraps a pipeline.Pipeline to implement ReaderOptions.Raw.
+// Setting Accept-Encoding prevents the HTTP client from transparently
+// decompressing gzip content.
+type rawPipeline struct {
+	pipeline.Pipeline
+}
+
+func (p rawPipeline) Do(ctx context.Context, methodFactory pipeline.Factory, request pipeline.Request) (pipeline.Response, error) {
+	if ctx.Value(rawKey{}) != nil {
+		request.Header.Set("Accept-Encoding", "identity")
+	}
+	return p.Pipeline.Do(ctx, methodFactory, request)
+}
+
 // Close implements driver.Close.
 func (b *bucket) Close() error {
 	return nil
@@ -324,9 +418,13 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	dstBlobURL := b.containerURL.NewBlobURL(dstKey)
 	srcKey = escapeKey(srcKey, false)
 	srcURL := b.containerURL.NewBlobURL(srcKey).URL()
+	tier, err := accessTier(opts.StorageClass)
+	if err != nil {
+		return err
+	}
 	md := azblob.Metadata{}
 	mac := azblob.ModifiedAccessConditions{}
-	bac := azblob.BlobAccessConditions{}
+	bac := accessConditions(opts.Conditions)
 	if opts.BeforeCopy != nil {
 		asFunc := func(i interface{}) bool {
 			switch v := i.(type) {
@@ -369,17 +467,101 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	if copyStatus != azblob.CopyStatusSuccess {
 		return fmt.Errorf("Copy failed with status: %s", copyStatus)
 	}
-	return nil
+	if tier != "" {
+		_, err = dstBlobURL.SetTier(ctx, tier, azblob.LeaseAccessConditions{})
+	}
+	return err
+}
+
+// errNoColdTier is returned for blob.StorageClassCold, which has no
+// equivalent access tier.
+var errNoColdTier = errors.New("storage class cold is not supported; use the Cool or Archive access tier")
+
+// accessTier returns the access tier for the storage class c, or "" for the
+// account's default tier.
+func accessTier(c driver.StorageClass) (azblob.AccessTierType, error) {
+	switch c {
+	case driver.StorageClassStandard:
+		return azblob.AccessTierHot, nil
+	case driver.StorageClassInfrequentAccess:
+		return azblob.AccessTierCool, nil
+	case driver.StorageClassCold:
+		return "", errNoColdTier
+	case driver.StorageClassArchive:
+		return azblob.AccessTierArchive, nil
+	default:
+		return "", nil
+	}
+}
+
+// fromAccessTier returns the portable storage class for the access tier t.
+func fromAccessTier(t string) driver.StorageClass {
+	switch azblob.AccessTierType(t) {
+	case azblob.AccessTierHot:
+		return driver.StorageClassStandard
+	case azblob.AccessTierCool:
+		return driver.StorageClassInfrequentAccess
+	case azblob.AccessTierArchive:
+		return driver.StorageClassArchive
+	default:
+		return driver.StorageClassDefault
+	}
+}
+
+// errNotImplemented is returned for features that azureblob doesn't support.
+var errNotImplemented = errors.New("not implemented")
+
+// ListVersions implements driver.ListVersions. Blob versions are not
+// supported by the version of the Azure Storage API that azureblob uses.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	return nil, errNotImplemented
 }
 
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.Version != "" {
+		return errNotImplemented
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
-	_, err := blockBlobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	_, err := blockBlobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, accessConditions(opts.Conditions))
 	return err
 }
 
+// DeleteMany implements driver.DeleteMany.
+// The version of the Azure SDK used here does not support the Blob Batch
+// API, so the concrete type falls back to deleting the blobs one at a time.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	return nil, errNotImplemented
+}
+
+// quoteETag returns e as a quoted string. Azure returns quoted ETags in
+// response headers, but not in blob listings.
+func quoteETag(e azblob.ETag) string {
+	s := string(e)
+	if s == "" || strings.HasPrefix(s, `"`) {
+		return s
+	}
+	return `"` + s + `"`
+}
+
+// accessConditions returns the Azure access conditions corresponding to
+// conds, which may be nil.
+func accessConditions(conds *driver.Conditions) azblob.BlobAccessConditions {
+	var ac azblob.BlobAccessConditions
+	if conds == nil {
+		return ac
+	}
+	if conds.IfNotExist {
+		ac.IfNoneMatch = azblob.ETagAny
+	}
+	if conds.IfMatch != "" {
+		ac.IfMatch = azblob.ETag(conds.IfMatch)
+	}
+	ac.IfModifiedSince = conds.IfModifiedSince
+	return ac
+}
+
 // reader reads an azblob. It implements io.ReadCloser.
 type reader struct {
 	body  io.ReadCloser
@@ -407,10 +589,14 @@ func (r *reader) As(i interface{}) bool {
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.Version != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	blockBlobURLp := &blockBlobURL
-	accessConditions := &azblob.BlobAccessConditions{}
+	ac := accessConditions(opts.Conditions)
+	acp := &ac
 
 	end := length
 	if end < 0 {
@@ -423,7 +609,7 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 				return true
 			}
 			if p, ok := i.(**azblob.BlobAccessConditions); ok {
-				*p = accessConditions
+				*p = acp
 				return true
 			}
 			return false
@@ -433,7 +619,10 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 		}
 	}
 
-	blobDownloadResponse, err := blockBlobURLp.Download(ctx, offset, end, *accessConditions, false)
+	if opts.Raw {
+		ctx = context.WithValue(ctx, rawKey{}, true)
+	}
+	blobDownloadResponse, err := blockBlobURLp.Download(ctx, offset, end, *acp, false)
 	if err != nil {
 		return nil, err
 	}
@@ -495,6 +684,15 @@ func (b *bucket) ErrorAs(err error, i interface{}) bool {
 }
 
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if err == errUploadNotFound {
+		return gcerrors.NotFound
+	}
+	if err == errNotImplemented || err == errNoColdTier {
+		return gcerrors.Unimplemented
+	}
+	if err == errAppendTooLarge {
+		return gcerrors.InvalidArgument
+	}
 	serr, ok := err.(azblob.StorageError)
 	switch {
 	case !ok:
@@ -502,6 +700,12 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 	case serr.ServiceCode() == azblob.ServiceCodeBlobNotFound || serr.Response().StatusCode == 404:
 		// Check and fail both the SDK ServiceCode and the Http Response Code for NotFound
 		return gcerrors.NotFound
+	case serr.ServiceCode() == azblob.ServiceCodeConditionNotMet || serr.ServiceCode() == azblob.ServiceCodeBlobAlreadyExists ||
+		serr.Response().StatusCode == http.StatusPreconditionFailed || serr.Response().StatusCode == http.StatusNotModified:
+		return gcerrors.FailedPrecondition
+	case serr.ServiceCode() == azblob.ServiceCodeInvalidBlobType:
+		// For example, appending to a block blob.
+		return gcerrors.FailedPrecondition
 	default:
 		return gcerrors.Unknown
 	}
@@ -516,13 +720,7 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		return nil, err
 	}
 
-	azureMD := blobPropertiesResponse.NewMetadata()
-	md := make(map[string]string, len(azureMD))
-	for k, v := range azureMD {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		md[escape.HexUnescape(k)] = escape.URLUnescape(v)
-	}
+	md, expires := unescapeMetadata(blobPropertiesResponse.NewMetadata())
 	return &driver.Attributes{
 		CacheControl:       blobPropertiesResponse.CacheControl(),
 		ContentDisposition: blobPropertiesResponse.ContentDisposition(),
@@ -531,8 +729,11 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ContentType:        blobPropertiesResponse.ContentType(),
 		Size:               blobPropertiesResponse.ContentLength(),
 		MD5:                blobPropertiesResponse.ContentMD5(),
+		ETag:               quoteETag(blobPropertiesResponse.ETag()),
 		ModTime:            blobPropertiesResponse.LastModified(),
 		Metadata:           md,
+		Expires:            expires,
+		StorageClass:       fromAccessTier(blobPropertiesResponse.AccessTier()),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*azblob.BlobGetPropertiesResponse)
 			if !ok {
@@ -544,6 +745,75 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	}, nil
 }
 
+// UpdateAttributes implements driver.UpdateAttributes. Azure replaces all of
+// the HTTP headers, or all of the metadata, of a blob at once, so they are
+// read first, and only the ones that change are set.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	blockBlobURL := b.containerURL.NewBlockBlobURL(escapeKey(key, false))
+	props, err := blockBlobURL.GetProperties(ctx, accessConditions(opts.Conditions))
+	if err != nil {
+		return err
+	}
+	headers := props.NewHTTPHeaders()
+	md, expires := unescapeMetadata(props.NewMetadata())
+	a := driver.Attributes{
+		CacheControl:       headers.CacheControl,
+		ContentDisposition: headers.ContentDisposition,
+		ContentEncoding:    headers.ContentEncoding,
+		ContentLanguage:    headers.ContentLanguage,
+		ContentType:        headers.ContentType,
+		Metadata:           md,
+	}
+	opts.Apply(&a)
+	headers.CacheControl = a.CacheControl
+	headers.ContentDisposition = a.ContentDisposition
+	headers.ContentEncoding = a.ContentEncoding
+	headers.ContentLanguage = a.ContentLanguage
+	headers.ContentType = a.ContentType
+	azureMD, err := escapeMetadata(a.Metadata, expires)
+	if err != nil {
+		return err
+	}
+	setHeaders := opts.CacheControl != nil || opts.ContentDisposition != nil || opts.ContentEncoding != nil || opts.ContentLanguage != nil || opts.ContentType != nil
+	setMetadata := len(opts.Metadata) > 0 || len(opts.DeleteMetadata) > 0
+	if opts.BeforeUpdate != nil {
+		asFunc := func(i interface{}) bool {
+			switch v := i.(type) {
+			case **azblob.BlobHTTPHeaders:
+				*v = &headers
+				return true
+			case **azblob.Metadata:
+				*v = &azureMD
+				return true
+			}
+			return false
+		}
+		if err := opts.BeforeUpdate(asFunc); err != nil {
+			return err
+		}
+		// BeforeUpdate may have changed either.
+		setHeaders, setMetadata = true, true
+	}
+	// Each change fails if the blob changed since it was read, so that
+	// concurrent changes aren't overwritten.
+	ac := azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()},
+	}
+	if setHeaders {
+		resp, err := blockBlobURL.SetHTTPHeaders(ctx, headers, ac)
+		if err != nil {
+			return err
+		}
+		ac.IfMatch = resp.ETag()
+	}
+	if setMetadata {
+		if _, err := blockBlobURL.SetMetadata(ctx, azureMD, ac); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ListPaged implements driver.ListPaged.
 func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
 	pageSize := opts.PageSize
@@ -603,6 +873,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			ModTime: blobInfo.Properties.LastModified,
 			Size:    *blobInfo.Properties.ContentLength,
 			MD5:     blobInfo.Properties.ContentMD5,
+			ETag:    quoteETag(blobInfo.Properties.Etag),
 			IsDir:   false,
 			AsFunc: func(i interface{}) bool {
 				p, ok := i.(*azblob.BlobItem)
@@ -633,6 +904,22 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 	if b.opts.Credential == nil {
 		return "", errors.New("to use SignedURL, you must call OpenBucket with a non-nil Options.Credential")
 	}
+	// SAS tokens can't restrict the Content-Type of an upload.
+	if opts.ContentType != "" {
+		return "", errNotImplemented
+	}
+	var perms azblob.BlobSASPermissions
+	switch opts.Method {
+	case http.MethodGet:
+		perms.Read = true
+	case http.MethodPut:
+		perms.Create = true
+		perms.Write = true
+	case http.MethodDelete:
+		perms.Delete = true
+	default:
+		return "", fmt.Errorf("unsupported Method %q", opts.Method)
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	srcBlobParts := azblob.NewBlobURLParts(blockBlobURL.URL())
@@ -643,7 +930,7 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 		ExpiryTime:    time.Now().UTC().Add(opts.Expiry),
 		ContainerName: b.name,
 		BlobName:      srcBlobParts.BlobName,
-		Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+		Permissions:   perms.String(),
 	}.NewSASQueryParameters(b.opts.Credential)
 	if err != nil {
 		return "", err
@@ -656,6 +943,8 @@ type writer struct {
 	ctx          context.Context
 	blockBlobURL *azblob.BlockBlobURL
 	uploadOpts   *azblob.UploadStreamToBlockBlobOptions
+	// tier is the access tier to set once the blob is uploaded, if any.
+	tier azblob.AccessTierType
 
 	w     *io.PipeWriter
 	donec chan struct{}
@@ -694,31 +983,21 @@ func unescapeKey(key string) string {
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
 	key = escapeKey(key, false)
+	if opts.Append {
+		return b.newAppendWriter(ctx, key, contentType, opts)
+	}
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	if opts.BufferSize == 0 {
 		opts.BufferSize = defaultUploadBlockSize
 	}
 
-	md := make(map[string]string, len(opts.Metadata))
-	for k, v := range opts.Metadata {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		e := escape.HexEscape(k, func(runes []rune, i int) bool {
-			c := runes[i]
-			switch {
-			case i == 0 && c >= '0' && c <= '9':
-				return true
-			case escape.IsASCIIAlphanumeric(c):
-				return false
-			case c == '_':
-				return false
-			}
-			return true
-		})
-		if _, ok := md[e]; ok {
-			return nil, fmt.Errorf("duplicate keys after escaping: %q => %q", k, e)
-		}
-		md[e] = escape.URLEscape(v)
+	md, err := escapeMetadata(opts.Metadata, opts.Expires)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := accessTier(opts.StorageClass)
+	if err != nil {
+		return nil, err
 	}
 	uploadOpts := &azblob.UploadStreamToBlockBlobOptions{
 		BufferSize: opts.BufferSize,
@@ -732,6 +1011,7 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 			ContentMD5:         opts.ContentMD5,
 			ContentType:        contentType,
 		},
+		AccessConditions: accessConditions(opts.Conditions),
 	}
 	if opts.BeforeWrite != nil {
 		asFunc := func(i interface{}) bool {
@@ -750,10 +1030,65 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		ctx:          ctx,
 		blockBlobURL: &blockBlobURL,
 		uploadOpts:   uploadOpts,
+		tier:         tier,
 		donec:        make(chan struct{}),
 	}, nil
 }
 
+// expiresKey is the metadata key holding the expiration time of a blob, in
+// RFC 3339 format. Writes that also set it in Metadata fail.
+const expiresKey = "gocdk_expires"
+
+// escapeMetadata escapes metadata keys and values for Azure, and adds the
+// expiration time expires if it is not zero.
+func escapeMetadata(metadata map[string]string, expires time.Time) (azblob.Metadata, error) {
+	md := make(azblob.Metadata, len(metadata)+1)
+	for k, v := range metadata {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		e := escape.HexEscape(k, func(runes []rune, i int) bool {
+			c := runes[i]
+			switch {
+			case i == 0 && c >= '0' && c <= '9':
+				return true
+			case escape.IsASCIIAlphanumeric(c):
+				return false
+			case c == '_':
+				return false
+			}
+			return true
+		})
+		if _, ok := md[e]; ok {
+			return nil, fmt.Errorf("duplicate keys after escaping: %q => %q", k, e)
+		}
+		md[e] = escape.URLEscape(v)
+	}
+	if !expires.IsZero() {
+		if _, ok := md[expiresKey]; ok {
+			return nil, fmt.Errorf("metadata key %q is reserved", expiresKey)
+		}
+		md[expiresKey] = expires.UTC().Format(time.RFC3339Nano)
+	}
+	return md, nil
+}
+
+// unescapeMetadata returns the unescaped metadata in md, without the
+// expiration time, and the expiration time, or zero if there is none.
+func unescapeMetadata(md azblob.Metadata) (map[string]string, time.Time) {
+	unescaped := make(map[string]string, len(md))
+	var expires time.Time
+	for k, v := range md {
+		if k == expiresKey {
+			expires, _ = time.Parse(time.RFC3339Nano, v)
+			continue
+		}
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		unescaped[escape.HexUnescape(k)] = escape.URLUnescape(v)
+	}
+	return unescaped, expires
+}
+
 // Write appends p to w. User must call Close to close the w after done writing.
 func (w *writer) Write(p []byte) (int, error) {
 	if len(p) == 0 {
@@ -786,6 +1121,9 @@ func (w *writer) open(pr *io.PipeReader) error {
 			}
 			return
 		}
+		if w.tier != "" {
+			_, w.err = w.blockBlobURL.SetTier(w.ctx, w.tier, azblob.LeaseAccessConditions{})
+		}
 	}()
 	return nil
 }
@@ -802,3 +1140,358 @@ func (w *writer) Close() error {
 	<-w.donec
 	return w.err
 }
+
+// errAppendTooLarge is returned when more content is appended at once than
+// fits in a single block of an append blob.
+var errAppendTooLarge = fmt.Errorf("at most %d bytes can be appended at once", azblob.AppendBlobMaxAppendBlockBytes)
+
+// appendWriter appends to an append blob, creating it if needed. The content
+// is buffered and appended as a single block when the writer is closed, so
+// that it is appended atomically.
+type appendWriter struct {
+	ctx           context.Context
+	appendBlobURL azblob.AppendBlobURL
+	headers       azblob.BlobHTTPHeaders // of the blob if it's created
+	md            azblob.Metadata        // of the blob if it's created
+	conds         *driver.Conditions     // or nil
+	buf           bytes.Buffer
+}
+
+func (b *bucket) newAppendWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	// Access tiers only apply to block blobs.
+	if opts.StorageClass != driver.StorageClassDefault {
+		return nil, errNotImplemented
+	}
+	md, err := escapeMetadata(opts.Metadata, opts.Expires)
+	if err != nil {
+		return nil, err
+	}
+	w := &appendWriter{
+		ctx:           ctx,
+		appendBlobURL: b.containerURL.NewAppendBlobURL(key),
+		headers: azblob.BlobHTTPHeaders{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        contentType,
+		},
+		md:    md,
+		conds: opts.Conditions,
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			switch v := i.(type) {
+			case **azblob.BlobHTTPHeaders:
+				*v = &w.headers
+				return true
+			case **azblob.Metadata:
+				*v = &w.md
+				return true
+			}
+			return false
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > azblob.AppendBlobMaxAppendBlockBytes {
+		return 0, errAppendTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+// Close creates the append blob if it doesn't exist, and appends the
+// buffered content to it.
+func (w *appendWriter) Close() error {
+	ac := azblob.AppendBlobAccessConditions{}
+	if w.conds == nil || w.conds.IfMatch == "" {
+		// Only create the blob if it doesn't exist yet.
+		resp, err := w.appendBlobURL.Create(w.ctx, w.headers, w.md, azblob.BlobAccessConditions{
+			ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny},
+		})
+		switch {
+		case err == nil:
+			if w.conds != nil && w.conds.IfNotExist {
+				// Fail if the blob was changed since it was created.
+				ac.IfMatch = resp.ETag()
+			}
+		case w.conds != nil && w.conds.IfNotExist || !isExistsError(err):
+			return err
+		}
+	}
+	if w.conds != nil && w.conds.IfMatch != "" {
+		ac.IfMatch = azblob.ETag(w.conds.IfMatch)
+	}
+	if w.buf.Len() == 0 {
+		if ac.IfMatch != "" {
+			// Check the precondition even though there is nothing to append.
+			_, err := w.appendBlobURL.GetProperties(w.ctx, azblob.BlobAccessConditions{ModifiedAccessConditions: ac.ModifiedAccessConditions})
+			return err
+		}
+		return nil
+	}
+	_, err := w.appendBlobURL.AppendBlock(w.ctx, bytes.NewReader(w.buf.Bytes()), ac, nil)
+	return err
+}
+
+// isExistsError reports whether err was returned because a blob that was
+// only to be created already exists.
+func isExistsError(err error) bool {
+	serr, ok := err.(azblob.StorageError)
+	if !ok {
+		return false
+	}
+	return serr.ServiceCode() == azblob.ServiceCodeBlobAlreadyExists || serr.Response().StatusCode == http.StatusConflict ||
+		serr.Response().StatusCode == http.StatusPreconditionFailed
+}
+
+// maxBlockFromURLSize is the maximum size of a block staged with Put Block
+// From URL.
+const maxBlockFromURLSize = 100 * 1024 * 1024
+
+// Compose implements driver.Compose by staging the content of the sources
+// as blocks of the destination with Put Block From URL, and committing them.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	// Get the sizes of the sources first, so that missing sources are
+	// reported before any block is staged.
+	sizes := make([]int64, len(srcKeys))
+	for i, key := range srcKeys {
+		resp, err := b.containerURL.NewBlobURL(escapeKey(key, false)).GetProperties(ctx, azblob.BlobAccessConditions{})
+		if err != nil {
+			return err
+		}
+		sizes[i] = resp.ContentLength()
+	}
+	mu, err := b.NewMultipartUpload(ctx, dstKey, opts.ContentType, &driver.WriterOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		Metadata:           opts.Metadata,
+		BeforeWrite:        opts.BeforeCompose,
+		Conditions:         opts.Conditions,
+	})
+	if err != nil {
+		return err
+	}
+	u := mu.(*multipartUpload)
+	ids := []string{}
+	for i, key := range srcKeys {
+		src, err := b.sourceURL(escapeKey(key, false))
+		if err != nil {
+			return err
+		}
+		for offset := int64(0); offset < sizes[i]; offset += maxBlockFromURLSize {
+			count := sizes[i] - offset
+			if count > maxBlockFromURLSize {
+				count = maxBlockFromURLSize
+			}
+			id := u.blockID(len(ids) + 1)
+			if _, err := u.blockBlobURL.StageBlockFromURL(ctx, id, src, offset, count, azblob.LeaseAccessConditions{}); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+	}
+	return u.commit(ctx, ids)
+}
+
+// sourceURL returns the URL of the blob at key, which is escaped, for Put
+// Block From URL. Azure authorizes reading the source with a SAS in its URL,
+// so one is added if the bucket has a shared key credential; the URLs of a
+// bucket opened with a SAS token already have one.
+func (b *bucket) sourceURL(key string) (url.URL, error) {
+	u := b.containerURL.NewBlobURL(key).URL()
+	if b.opts.Credential == nil {
+		return u, nil
+	}
+	parts := azblob.NewBlobURLParts(u)
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		ExpiryTime:    time.Now().UTC().Add(time.Hour),
+		ContainerName: b.name,
+		BlobName:      parts.BlobName,
+		Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+	}.NewSASQueryParameters(b.opts.Credential)
+	if err != nil {
+		return url.URL{}, err
+	}
+	parts.SAS = sas
+	return parts.URL(), nil
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	u, err := b.newMultipartUpload(key, uuid.New().String(), contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			switch v := i.(type) {
+			case **azblob.BlobHTTPHeaders:
+				*v = &u.headers
+				return true
+			case **azblob.Metadata:
+				*v = &u.md
+				return true
+			}
+			return false
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	u, err := b.newMultipartUpload(key, uploadID, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Azure has no record of the upload other than its staged blocks.
+	ids, err := u.blockIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, errUploadNotFound
+	}
+	return u, nil
+}
+
+func (b *bucket) newMultipartUpload(key, uploadID, contentType string, opts *driver.WriterOptions) (*multipartUpload, error) {
+	md, err := escapeMetadata(opts.Metadata, opts.Expires)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := accessTier(opts.StorageClass)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{
+		blockBlobURL: b.containerURL.NewBlockBlobURL(escapeKey(key, false)),
+		id:           uploadID,
+		headers: azblob.BlobHTTPHeaders{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        contentType,
+		},
+		md:    md,
+		tier:  tier,
+		conds: opts.Conditions,
+	}, nil
+}
+
+// errUploadNotFound is returned by ResumeMultipartUpload when there are no
+// blocks staged for the upload.
+var errUploadNotFound = errors.New("multipart upload not found")
+
+// multipartUpload implements driver.MultipartUpload using uncommitted blocks.
+// The ID of the block for a part is derived from the upload ID and the part
+// number, so the uploaded parts can be recovered from the blob's list of
+// uncommitted blocks.
+type multipartUpload struct {
+	blockBlobURL azblob.BlockBlobURL
+	id           string
+	headers      azblob.BlobHTTPHeaders
+	md           azblob.Metadata
+	tier         azblob.AccessTierType // may be ""
+	conds        *driver.Conditions    // may be nil
+}
+
+func (u *multipartUpload) ID() string { return u.id }
+
+// blockID returns the base64-encoded block ID for partNumber. All block IDs
+// of a blob must have the same length.
+func (u *multipartUpload) blockID(partNumber int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%05d", u.id, partNumber)))
+}
+
+// blockIDs returns the IDs of the uncommitted blocks staged for the upload,
+// keyed by part number.
+func (u *multipartUpload) blockIDs(ctx context.Context) (map[int]string, error) {
+	resp, err := u.blockBlobURL.GetBlockList(ctx, azblob.BlockListUncommitted, azblob.LeaseAccessConditions{})
+	if err != nil {
+		if serr, ok := err.(azblob.StorageError); ok && serr.Response().StatusCode == http.StatusNotFound {
+			// The blob doesn't exist yet, so no blocks have been staged.
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := map[int]string{}
+	for _, block := range resp.UncommittedBlocks {
+		raw, err := base64.StdEncoding.DecodeString(block.Name)
+		if err != nil {
+			continue
+		}
+		s := string(raw)
+		if !strings.HasPrefix(s, u.id+"-") {
+			// A block from another upload.
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(s, u.id+"-"))
+		if err != nil {
+			continue
+		}
+		ids[n] = block.Name
+	}
+	return ids, nil
+}
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	_, err := u.blockBlobURL.StageBlock(ctx, u.blockID(partNumber), bytes.NewReader(p), azblob.LeaseAccessConditions{}, nil)
+	return err
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	ids, err := u.blockIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]int, 0, len(ids))
+	for n := range ids {
+		parts = append(parts, n)
+	}
+	sort.Ints(parts)
+	return parts, nil
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	parts, err := u.ListParts(ctx)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(parts))
+	for i, n := range parts {
+		ids[i] = u.blockID(n)
+	}
+	return u.commit(ctx, ids)
+}
+
+// commit commits the blocks with the given IDs as the content of the blob.
+func (u *multipartUpload) commit(ctx context.Context, ids []string) error {
+	if _, err := u.blockBlobURL.CommitBlockList(ctx, ids, u.headers, u.md, accessConditions(u.conds)); err != nil {
+		return err
+	}
+	if u.tier == "" {
+		return nil
+	}
+	_, err := u.blockBlobURL.SetTier(ctx, u.tier, azblob.LeaseAccessConditions{})
+	return err
+}
+
+// Abort implements driver.MultipartUpload.Abort. Uncommitted blocks can't be
+// deleted; Azure garbage collects them after a week.
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	return nil
+}
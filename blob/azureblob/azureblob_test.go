@@ -22,13 +22,18 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/blobpubsub"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/testing/setup"
+	"github.com/eliben/gocdkx/pubsub"
+	"github.com/eliben/gocdkx/pubsub/mempubsub"
 )
 
 // Prerequisites for --record mode
@@ -235,6 +240,26 @@ func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
 	return nil
 }
 
+func TestAccessTier(t *testing.T) {
+	for _, c := range []driver.StorageClass{driver.StorageClassStandard, driver.StorageClassInfrequentAccess, driver.StorageClassArchive} {
+		tier, err := accessTier(c)
+		if err != nil {
+			t.Errorf("%v: %v", c, err)
+			continue
+		}
+		if got := fromAccessTier(string(tier)); got != c {
+			t.Errorf("%v: got %v from access tier %q", c, got, tier)
+		}
+	}
+	if tier, err := accessTier(driver.StorageClassDefault); err != nil || tier != "" {
+		t.Errorf("got %q, %v for the default storage class, want no access tier", tier, err)
+	}
+	_, err := accessTier(driver.StorageClassCold)
+	if got := (&bucket{}).ErrorCode(err); got != gcerrors.Unimplemented {
+		t.Errorf("got error code %v for the cold storage class, want Unimplemented", got)
+	}
+}
+
 func TestOpenBucket(t *testing.T) {
 	tests := []struct {
 		description   string
@@ -376,3 +401,72 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+	b := blob.NewBucket(&bucket{name: "mycontainer", opts: &Options{NotificationSource: blobpubsub.NewNotificationSource(sub)}})
+	defer b.Close()
+	w, err := b.Watch(ctx, &blob.WatchOptions{Prefix: "dir/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	send := func(body string) {
+		t.Helper()
+		if err := topic.Send(ctx, &pubsub.Message{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Ignored: malformed body, other container, other prefix, unrelated
+	// event.
+	send("not json")
+	send(`{"subject": "/blobServices/default/containers/other/blobs/dir/a", "eventType": "Microsoft.Storage.BlobCreated"}`)
+	send(`{"subject": "/blobServices/default/containers/mycontainer/blobs/other/a", "eventType": "Microsoft.Storage.BlobCreated"}`)
+	send(`{"subject": "/blobServices/default/containers/mycontainer/blobs/dir/a", "eventType": "Microsoft.Storage.BlobTierChanged"}`)
+	// Reported: an array in the Event Grid schema, and a single event in
+	// the CloudEvents schema.
+	send(`[{"subject": "/blobServices/default/containers/mycontainer/blobs/dir/a", "eventType": "Microsoft.Storage.BlobCreated", "eventTime": "2019-06-01T12:00:00.000Z", "data": {"eTag": "0x8D6E6A9C", "contentLength": 11}},
+		{"subject": "/blobServices/default/containers/mycontainer/blobs/other/b", "eventType": "Microsoft.Storage.BlobDeleted"}]`)
+	send(`{"subject": "/blobServices/default/containers/mycontainer/blobs/dir/c__0x5c__", "type": "Microsoft.Storage.BlobDeleted", "time": "2019-06-01T12:00:00.000Z"}`)
+
+	// mempubsub doesn't preserve the order of messages, so the events are
+	// compared by key.
+	want := map[string]blob.Event{
+		"dir/a":   {Type: blob.EventCreated, Key: "dir/a", ModTime: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), Size: 11, ETag: `"0x8D6E6A9C"`},
+		"dir/c\\": {Type: blob.EventDeleted, Key: "dir/c\\"},
+	}
+	for range want {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		got, err := w.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := want[got.Key]
+		if got.Type != want.Type || got.Key != want.Key || !got.ModTime.Equal(want.ModTime) || got.Size != want.Size || got.ETag != want.ETag {
+			t.Errorf("got event %+v want %+v", *got, want)
+		}
+		var m *pubsub.Message
+		if !got.As(&m) || len(m.Body) == 0 {
+			t.Error("Event.As failed to get *pubsub.Message")
+		}
+	}
+	var s *pubsub.Subscription
+	if !w.As(&s) || s != sub {
+		t.Error("Watcher.As failed to get *pubsub.Subscription")
+	}
+}
+
+func TestWatchWithoutSubscription(t *testing.T) {
+	ctx := context.Background()
+	b := blob.NewBucket(&bucket{name: "mycontainer", opts: &Options{}})
+	defer b.Close()
+	if _, err := b.Watch(ctx, nil); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("got error %v want Unimplemented", err)
+	}
+}
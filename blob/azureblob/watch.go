@@ -0,0 +1,150 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/eliben/gocdkx/blob/driver"
+)
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	if b.opts.NotificationSource == nil {
+		return nil, errNotImplemented
+	}
+	return &watcher{b: b, prefix: opts.Prefix, done: make(chan struct{})}, nil
+}
+
+// watcher implements driver.Watcher by receiving Event Grid events.
+type watcher struct {
+	b      *bucket
+	prefix string
+	// done is closed by Close.
+	done chan struct{}
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		m, err := w.b.opts.NotificationSource.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Messages that can't be parsed will never be, so they are dropped
+		// like the ones for other containers.
+		evs, err := parseNotification(m, w.b.name)
+		m.Ack()
+		if err != nil {
+			continue
+		}
+		var matching []*driver.Event
+		for _, ev := range evs {
+			if strings.HasPrefix(ev.Key, w.prefix) {
+				matching = append(matching, ev)
+			}
+		}
+		if len(matching) > 0 {
+			return matching, nil
+		}
+	}
+}
+
+// gridEvent is a Blob storage event, in the Event Grid or the CloudEvents
+// schema.
+// See https://docs.microsoft.com/en-us/azure/event-grid/event-schema-blob-storage.
+type gridEvent struct {
+	Subject string
+	// EventType and EventTime are set in the Event Grid schema, and Type
+	// and Time in the CloudEvents schema.
+	EventType string
+	EventTime time.Time
+	Type      string
+	Time      time.Time
+	Data      struct {
+		ETag          string
+		ContentLength int64
+	}
+}
+
+// parseNotification returns the events described by a message holding
+// Event Grid events, for the container named containerName.
+func parseNotification(m *driver.Notification, containerName string) ([]*driver.Event, error) {
+	var ges []gridEvent
+	if body := bytes.TrimSpace(m.Body); len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &ges); err != nil {
+			return nil, err
+		}
+	} else {
+		ges = make([]gridEvent, 1)
+		if err := json.Unmarshal(body, &ges[0]); err != nil {
+			return nil, err
+		}
+	}
+	subjectPrefix := "/blobServices/default/containers/" + containerName + "/blobs/"
+	var evs []*driver.Event
+	for _, ge := range ges {
+		if !strings.HasPrefix(ge.Subject, subjectPrefix) {
+			continue
+		}
+		ev := &driver.Event{
+			Key:    unescapeKey(strings.TrimPrefix(ge.Subject, subjectPrefix)),
+			AsFunc: m.AsFunc,
+		}
+		typ, t := ge.EventType, ge.EventTime
+		if typ == "" {
+			typ, t = ge.Type, ge.Time
+		}
+		switch typ {
+		case "Microsoft.Storage.BlobCreated":
+			ev.Type = driver.EventCreated
+			ev.ModTime = t
+			ev.Size = ge.Data.ContentLength
+			ev.ETag = quoteETag(azblob.ETag(ge.Data.ETag))
+		case "Microsoft.Storage.BlobDeleted":
+			ev.Type = driver.EventDeleted
+		default:
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	return evs, nil
+}
+
+// As implements driver.Watcher.As.
+func (w *watcher) As(i interface{}) bool {
+	return w.b.opts.NotificationSource.As(i)
+}
+
+// Close implements driver.Watcher.Close. It doesn't close the notification
+// source.
+func (w *watcher) Close() error {
+	close(w.done)
+	return nil
+}
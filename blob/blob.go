@@ -61,11 +61,19 @@
 //
 // This API collects OpenCensus traces and metrics for the following methods:
 //  - Attributes
+//  - Compose
 //  - Copy
 //  - Delete
+//  - DeleteMany
+//  - DeletePrefix
+//  - ListVersions
 //  - NewRangeReader, from creation until the call to Close. (NewReader and ReadAll
 //    are included because they call NewRangeReader.)
 //  - NewWriter, from creation until the call to Close.
+//  - NewMultipartUpload, ResumeMultipartUpload, and the methods of
+//    MultipartUpload.
+//  - UpdateAttributes
+//  - Watch, until it returns the Watcher. (Watcher.Next isn't included.)
 // All trace and metric names begin with the package import path.
 // The traces add the method name.
 // For example, "github.com/eliben/gocdkx/blob/Attributes".
@@ -97,19 +105,23 @@ import (
 	"net/http"
 	"net/url"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
 
+	gax "github.com/googleapis/gax-go"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/unwrap"
 	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/gcerr"
 	"github.com/eliben/gocdkx/internal/oc"
 	"github.com/eliben/gocdkx/internal/openurl"
+	"github.com/eliben/gocdkx/internal/retry"
 )
 
 // Reader reads bytes from a blob.
@@ -121,6 +133,10 @@ type Reader struct {
 	end      func(error) // called at Close to finish trace and metric collection
 	provider string      // for metric collection
 	closed   bool
+
+	// uncompressedSize is set if the Reader decompresses the blob.
+	decompressed     bool
+	uncompressedSize int64
 }
 
 // Read implements io.Reader (https://golang.org/pkg/io/#Reader).
@@ -149,11 +165,22 @@ func (r *Reader) ModTime() time.Time {
 	return r.r.Attributes().ModTime
 }
 
-// Size returns the size of the blob content in bytes.
+// Size returns the size of the blob content in bytes, as stored.
 func (r *Reader) Size() int64 {
 	return r.r.Attributes().Size
 }
 
+// UncompressedSize returns the size of the blob content in bytes after
+// decompression, if the Reader decompresses it (see ReaderOptions.Decompress),
+// or -1 if that size is not known; see Attributes.UncompressedSize. Otherwise,
+// it returns Size.
+func (r *Reader) UncompressedSize() int64 {
+	if r.decompressed {
+		return r.uncompressedSize
+	}
+	return r.Size()
+}
+
 // As converts i to provider-specific types.
 // See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information, the "As"
 // examples in this package for examples, and the provider-specific package
@@ -190,10 +217,30 @@ type Attributes struct {
 	Metadata map[string]string
 	// ModTime is the time the blob was last modified.
 	ModTime time.Time
-	// Size is the size of the blob's content in bytes.
+	// Size is the size of the blob's content in bytes, as stored.
 	Size int64
+	// UncompressedSize is the size of the blob's content in bytes once
+	// decoded according to ContentEncoding. It equals Size if
+	// ContentEncoding is empty. For compressed blobs, it is only known if the
+	// blob was written with WriterOptions.Compression and is small enough to
+	// be buffered by the Writer (up to 1 MiB once compressed), which stores
+	// it in Metadata; otherwise it is -1.
+	UncompressedSize int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// ETag is an opaque identifier for the current version of the blob. It
+	// changes whenever the blob is rewritten with different content, and may
+	// change even if the content is the same. It can be used as
+	// Conditions.IfMatch. For GCS, it is the generation number of the blob.
+	ETag string
+	// Expires is the time after which the blob expires, as set by
+	// WriterOptions.Expires, or the zero value if it doesn't expire or the
+	// provider doesn't report it.
+	Expires time.Time
+	// StorageClass is the storage class of the blob, or StorageClassDefault
+	// if the provider doesn't report it, or it has no portable equivalent;
+	// use As to get the provider-specific one.
+	StorageClass StorageClass
 
 	asFunc func(interface{}) bool
 }
@@ -222,6 +269,9 @@ type Writer struct {
 	md5hash    hash.Hash
 	provider   string // for metric collection
 	closed     bool
+	// throttle waits for the rate limit of the Bucket to allow a call to
+	// the named MultipartUpload method by a multipart upload.
+	throttle func(ctx context.Context, methodName string) error
 
 	// These fields exist only when w is not yet created.
 	//
@@ -230,10 +280,12 @@ type Writer struct {
 	// underlying driver.Writer. This step happens inside Write or Close and
 	// neither of them take a context.Context as an argument. The ctx is set
 	// to nil after we have passed it to NewTypedWriter.
-	ctx  context.Context
-	key  string
-	opts *driver.WriterOptions
-	buf  *bytes.Buffer
+	ctx            context.Context
+	key            string
+	opts           *driver.WriterOptions
+	buf            *bytes.Buffer
+	maxConcurrency int
+	compressor     Compressor // set if WriterOptions.Compression is set
 }
 
 // sniffLen is the byte size of Writer.buf used to detect content-type.
@@ -308,7 +360,7 @@ func (w *Writer) Close() (err error) {
 func (w *Writer) open(p []byte) (int, error) {
 	ct := http.DetectContentType(p)
 	var err error
-	if w.w, err = w.b.NewTypedWriter(w.ctx, w.key, ct, w.opts); err != nil {
+	if w.w, err = w.newDriverWriter(w.ctx, w.key, ct, w.opts); err != nil {
 		return 0, wrapError(w.b, err)
 	}
 	w.buf = nil
@@ -325,6 +377,264 @@ func (w *Writer) write(p []byte) (int, error) {
 	return n, wrapError(w.b, err)
 }
 
+// newDriverWriter creates the driver.Writer for w, which compresses the
+// content if w.compressor is set.
+func (w *Writer) newDriverWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if w.compressor == nil {
+		return newDriverWriter(ctx, w.b, key, contentType, opts, w.maxConcurrency, w.throttle)
+	}
+	open := func(ctx context.Context, size int64) (driver.Writer, error) {
+		// Record the uncompressed size if it is known.
+		o := *opts
+		o.Metadata = make(map[string]string, len(opts.Metadata)+1)
+		for k, v := range opts.Metadata {
+			o.Metadata[k] = v
+		}
+		delete(o.Metadata, UncompressedSizeKey)
+		if size >= 0 {
+			o.Metadata[UncompressedSizeKey] = strconv.FormatInt(size, 10)
+		}
+		return newDriverWriter(ctx, w.b, key, contentType, &o, w.maxConcurrency, w.throttle)
+	}
+	cw, err := newCompressingWriter(ctx, w.compressor, open)
+	if err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// defaultPartSize is the size of the parts uploaded by a Writer with
+// WriterOptions.MaxConcurrency > 1 when WriterOptions.BufferSize is 0.
+const defaultPartSize = 8 * 1024 * 1024
+
+// newDriverWriter creates the driver.Writer used by a Writer.
+//
+// If maxConcurrency > 1 and the provider supports multipart uploads, the
+// returned driver.Writer uploads the blob in parts of opts.BufferSize bytes,
+// up to maxConcurrency parts at a time, calling throttle before uploading
+// each part and completing the upload. Otherwise it is the driver's
+// NewTypedWriter.
+func newDriverWriter(ctx context.Context, b driver.Bucket, key, contentType string, opts *driver.WriterOptions, maxConcurrency int, throttle func(context.Context, string) error) (driver.Writer, error) {
+	if maxConcurrency <= 1 {
+		return b.NewTypedWriter(ctx, key, contentType, opts)
+	}
+	u, err := b.NewMultipartUpload(ctx, key, contentType, opts)
+	if err != nil {
+		if b.ErrorCode(err) == gcerrors.Unimplemented {
+			return b.NewTypedWriter(ctx, key, contentType, opts)
+		}
+		return nil, err
+	}
+	partSize := opts.BufferSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	return &multipartWriter{
+		ctx:      ctx,
+		u:        u,
+		partSize: partSize,
+		sem:      make(chan struct{}, maxConcurrency),
+		throttle: throttle,
+	}, nil
+}
+
+// multipartWriter implements driver.Writer on top of a driver.MultipartUpload.
+// It buffers writes into parts of partSize bytes and uploads them
+// concurrently; Close waits for all parts to be uploaded and then completes
+// the upload. If any part fails, or ctx is canceled, the upload is aborted.
+type multipartWriter struct {
+	ctx      context.Context
+	u        driver.MultipartUpload
+	partSize int
+	buf      []byte
+	nparts   int
+	sem      chan struct{} // limits the number of concurrent UploadPart calls
+	throttle func(ctx context.Context, methodName string) error
+	wg       sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // the first error returned by UploadPart
+}
+
+func (w *multipartWriter) Write(p []byte) (int, error) {
+	if err := w.firstErr(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.partSize)
+		}
+		m := w.partSize - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		n += m
+		if len(w.buf) == w.partSize {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush starts uploading the buffered bytes as the next part, waiting if
+// there are already too many parts in flight.
+func (w *multipartWriter) flush() error {
+	part := w.buf
+	w.buf = nil
+	if w.nparts == MaxUploadParts {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: too many parts; use a larger WriterOptions.BufferSize")
+	}
+	w.nparts++
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	w.wg.Add(1)
+	go func(partNumber int) {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		err := w.throttle(w.ctx, "MultipartUpload.UploadPart")
+		if err == nil {
+			err = w.u.UploadPart(w.ctx, partNumber, part)
+		}
+		if err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+	}(w.nparts)
+	return nil
+}
+
+func (w *multipartWriter) firstErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *multipartWriter) Close() error {
+	var err error
+	// Upload the remaining bytes. An empty blob is uploaded as a single
+	// empty part.
+	if len(w.buf) > 0 || w.nparts == 0 {
+		err = w.flush()
+	}
+	w.wg.Wait()
+	if err == nil {
+		err = w.firstErr()
+	}
+	if err == nil {
+		err = w.ctx.Err()
+	}
+	if err == nil {
+		err = w.throttle(w.ctx, "MultipartUpload.Complete")
+	}
+	if err == nil {
+		return w.u.Complete(w.ctx)
+	}
+	// Discard any uploaded parts. w.ctx may already be done, so don't use it.
+	_ = w.u.Abort(context.Background())
+	return err
+}
+
+// MaxUploadParts is the maximum number of parts in a multipart upload.
+const MaxUploadParts = 10000
+
+// MultipartUpload uploads a blob in independently uploaded parts.
+// Parts may be uploaded concurrently, in any order, and by different
+// processes; the blob's content is the concatenation of the parts in
+// ascending order of part number. The blob is not created until Complete
+// is called.
+//
+// Use Bucket.NewMultipartUpload to start an upload, and
+// Bucket.ResumeMultipartUpload with the value returned by ID to continue it,
+// e.g. after a restart.
+type MultipartUpload struct {
+	b        driver.Bucket
+	u        driver.MultipartUpload
+	tracer   *oc.Tracer
+	limiter  *limiter
+	provider string // for metric collection
+}
+
+// ID returns an identifier for the upload that can be passed to
+// Bucket.ResumeMultipartUpload.
+func (u *MultipartUpload) ID() string {
+	return u.u.ID()
+}
+
+// UploadPart uploads p as the part numbered partNumber, which must be between
+// 1 and MaxUploadParts. Uploading a part with the same number as a previously
+// uploaded part replaces it.
+//
+// Some providers require all parts except the last one to be at least a
+// minimum size; for example, S3 requires 5 MiB.
+//
+// UploadPart is safe to call from multiple goroutines.
+func (u *MultipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) (err error) {
+	if partNumber < 1 || partNumber > MaxUploadParts {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: UploadPart partNumber must be between 1 and %d (%d)", MaxUploadParts, partNumber)
+	}
+	ctx = u.tracer.Start(ctx, "MultipartUpload.UploadPart")
+	defer func() { u.tracer.End(ctx, err) }()
+	release, err := u.limiter.wait(ctx, u.tracer, "MultipartUpload.UploadPart", WriteOperation)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := u.u.UploadPart(ctx, partNumber, p); err != nil {
+		return wrapError(u.b, err)
+	}
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(oc.ProviderKey, u.provider)},
+		bytesWrittenMeasure.M(int64(len(p))))
+	return nil
+}
+
+// Parts returns the numbers of the parts uploaded so far, in ascending
+// order. It can be used to find out which parts still need to be uploaded
+// after resuming an upload.
+func (u *MultipartUpload) Parts(ctx context.Context) (_ []int, err error) {
+	ctx = u.tracer.Start(ctx, "MultipartUpload.Parts")
+	defer func() { u.tracer.End(ctx, err) }()
+	parts, err := u.u.ListParts(ctx)
+	if err != nil {
+		return nil, wrapError(u.b, err)
+	}
+	return parts, nil
+}
+
+// Complete creates the blob from the uploaded parts, replacing any existing
+// blob with the same key. At least one part must have been uploaded.
+// The upload can't be used after Complete returns successfully.
+func (u *MultipartUpload) Complete(ctx context.Context) (err error) {
+	ctx = u.tracer.Start(ctx, "MultipartUpload.Complete")
+	defer func() { u.tracer.End(ctx, err) }()
+	release, err := u.limiter.wait(ctx, u.tracer, "MultipartUpload.Complete", WriteOperation)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return wrapError(u.b, u.u.Complete(ctx))
+}
+
+// Abort cancels the upload and discards the uploaded parts.
+// The upload can't be used after Abort returns successfully.
+func (u *MultipartUpload) Abort(ctx context.Context) (err error) {
+	ctx = u.tracer.Start(ctx, "MultipartUpload.Abort")
+	defer func() { u.tracer.End(ctx, err) }()
+	return wrapError(u.b, u.u.Abort(ctx))
+}
+
 // ListOptions sets options for listing blobs via Bucket.List.
 type ListOptions struct {
 	// Prefix indicates that only blobs with a key starting with this prefix
@@ -369,14 +679,7 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 			// Next object is in the page; return it.
 			dobj := i.page.Objects[i.nextIdx]
 			i.nextIdx++
-			return &ListObject{
-				Key:     dobj.Key,
-				ModTime: dobj.ModTime,
-				Size:    dobj.Size,
-				MD5:     dobj.MD5,
-				IsDir:   dobj.IsDir,
-				asFunc:  dobj.AsFunc,
-			}, nil
+			return toListObject(dobj), nil
 		}
 		if len(i.page.NextPageToken) == 0 {
 			// Done with current page, and there are no more; return io.EOF.
@@ -385,21 +688,42 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 		// We need to load the next page.
 		i.opts.PageToken = i.page.NextPageToken
 	}
-	i.b.mu.RLock()
-	defer i.b.mu.RUnlock()
-	if i.b.closed {
+	if i.b.isClosed() {
 		return nil, errClosed
 	}
 	// Loading a new page.
-	p, err := i.b.b.ListPaged(ctx, i.opts)
+	release, err := i.b.acquire(ctx, "ListIterator.Next", ListOperation)
+	if err != nil {
+		return nil, err
+	}
+	defer i.b.mu.RUnlock()
+	defer release()
+	var p *driver.ListPage
+	err = retryCall(ctx, i.b.retryPolicy, func() (err error) {
+		p, err = i.b.b.ListPaged(ctx, i.opts)
+		return wrapError(i.b.b, err)
+	})
 	if err != nil {
-		return nil, wrapError(i.b.b, err)
+		return nil, err
 	}
 	i.page = p
 	i.nextIdx = 0
 	return i.Next(ctx)
 }
 
+// toListObject converts a driver.ListObject to a ListObject.
+func toListObject(dobj *driver.ListObject) *ListObject {
+	return &ListObject{
+		Key:     dobj.Key,
+		ModTime: dobj.ModTime,
+		Size:    dobj.Size,
+		MD5:     dobj.MD5,
+		ETag:    dobj.ETag,
+		IsDir:   dobj.IsDir,
+		asFunc:  dobj.AsFunc,
+	}
+}
+
 // ListObject represents a single blob returned from List.
 type ListObject struct {
 	// Key is the key for this blob.
@@ -410,6 +734,9 @@ type ListObject struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// ETag is an opaque identifier for the current version of the blob;
+	// see Attributes.ETag.
+	ETag string
 	// IsDir indicates that this result represents a "directory" in the
 	// hierarchical namespace, ending in ListOptions.Delimiter. Key can be
 	// passed as ListOptions.Prefix to list items in the "directory".
@@ -438,26 +765,32 @@ type Bucket struct {
 	b      driver.Bucket
 	tracer *oc.Tracer
 
-	// mu protects the closed variable.
+	// mu protects the closed, retryPolicy and limiter variables.
 	// Read locks are kept to allow holding a read lock for long-running calls,
 	// and thereby prevent closing until a call finishes.
-	mu     sync.RWMutex
-	closed bool
+	mu          sync.RWMutex
+	closed      bool
+	retryPolicy *gcerrors.RetryPolicy
+	limiter     *limiter
 }
 
 const pkgName = "github.com/eliben/gocdkx/blob"
 
 var (
 	latencyMeasure      = oc.LatencyMeasure(pkgName)
+	waitMeasure         = oc.WaitMeasure(pkgName)
+	cacheMeasure        = oc.CacheMeasure(pkgName)
 	bytesReadMeasure    = stats.Int64(pkgName+"/bytes_read", "Total bytes read", stats.UnitBytes)
 	bytesWrittenMeasure = stats.Int64(pkgName+"/bytes_written", "Total bytes written", stats.UnitBytes)
 
 	// OpenCensusViews are predefined views for OpenCensus metrics.
 	// The views include counts and latency distributions for API method calls,
-	// and total bytes read and written.
+	// distributions of the time calls wait for the limits set by
+	// Bucket.SetLimits, counts of the hits, misses and evictions of caching
+	// providers like cacheblob, and total bytes read and written.
 	// See the example at https://godoc.org/go.opencensus.io/stats/view for usage.
 	OpenCensusViews = append(
-		oc.Views(pkgName, latencyMeasure),
+		append(append(oc.Views(pkgName, latencyMeasure), oc.WaitViews(pkgName, waitMeasure)...), oc.CacheViews(pkgName, cacheMeasure)...),
 		&view.View{
 			Name:        pkgName + "/bytes_read",
 			Measure:     bytesReadMeasure,
@@ -499,6 +832,11 @@ func (b *Bucket) As(i interface{}) bool {
 	if i == nil {
 		return false
 	}
+	if d, ok := i.(*unwrap.Driver); ok {
+		// Used by the providers that wrap another bucket.
+		d.Bucket = b.b
+		return true
+	}
 	return b.b.As(i)
 }
 
@@ -513,12 +851,16 @@ func (b *Bucket) ErrorAs(err error, i interface{}) bool {
 // ReadAll is a shortcut for creating a Reader via NewReader with nil
 // ReaderOptions, and reading the entire blob.
 func (b *Bucket) ReadAll(ctx context.Context, key string) (_ []byte, err error) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	if b.closed {
+	return b.ReadAllWithOptions(ctx, key, nil)
+}
+
+// ReadAllWithOptions is like ReadAll, but takes options.
+// A nil ReaderOptions is treated the same as the zero value.
+func (b *Bucket) ReadAllWithOptions(ctx context.Context, key string, opts *ReaderOptions) (_ []byte, err error) {
+	if b.isClosed() {
 		return nil, errClosed
 	}
-	r, err := b.NewReader(ctx, key, nil)
+	r, err := b.NewReader(ctx, key, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -546,6 +888,62 @@ func (b *Bucket) List(opts *ListOptions) *ListIterator {
 	return &ListIterator{b: b, opts: dopts}
 }
 
+// ListPage returns a page of the blobs in a bucket, in lexicographical order
+// of UTF-8 encoded keys, and a token for the next page.
+//
+// pageToken must be nil for the first page, and the nextPageToken returned by
+// the previous call for the following ones, with the same opts. nextPageToken
+// is nil after the last page. pageSize is the maximum number of results in
+// the page; if it is 0, the provider implementation chooses. Pages may be
+// empty even if there are more results.
+//
+// A nil ListOptions is treated the same as the zero value.
+//
+// Most callers should use List instead. ListPage is useful when the position
+// in the listing must be kept across requests, for example to paginate
+// results in a web UI, or by wrappers that implement driver.Bucket on top
+// of a *Bucket.
+func (b *Bucket) ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *ListOptions) (_ []*ListObject, nextPageToken []byte, err error) {
+	if pageSize < 0 {
+		return nil, nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ListPage pageSize must be >= 0 (%d)", pageSize)
+	}
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	dopts := &driver.ListOptions{
+		Prefix:     opts.Prefix,
+		Delimiter:  opts.Delimiter,
+		PageSize:   pageSize,
+		PageToken:  pageToken,
+		BeforeList: opts.BeforeList,
+	}
+	if b.isClosed() {
+		return nil, nil, errClosed
+	}
+	release, err := b.acquire(ctx, "ListPage", ListOperation)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	var p *driver.ListPage
+	err = retryCall(ctx, b.retryPolicy, func() (err error) {
+		p, err = b.b.ListPaged(ctx, dopts)
+		return wrapError(b.b, err)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	objs := make([]*ListObject, len(p.Objects))
+	for i, dobj := range p.Objects {
+		objs[i] = toListObject(dobj)
+	}
+	if len(p.NextPageToken) == 0 {
+		return objs, nil, nil
+	}
+	return objs, p.NextPageToken, nil
+}
+
 // Exists returns true if a blob exists at key, false if it does not exist, or
 // an error.
 // It is a shortcut for calling Attributes and checking if it returns an error
@@ -570,17 +968,25 @@ func (b *Bucket) Attributes(ctx context.Context, key string) (_ *Attributes, err
 		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Attributes key must be a valid UTF-8 string: %q", key)
 	}
 
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	if b.closed {
+	if b.isClosed() {
 		return nil, errClosed
 	}
 	ctx = b.tracer.Start(ctx, "Attributes")
 	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "Attributes", AttributesOperation)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	defer release()
 
-	a, err := b.b.Attributes(ctx, key)
+	var a *driver.Attributes
+	err = retryCall(ctx, b.retryPolicy, func() (err error) {
+		a, err = b.b.Attributes(ctx, key)
+		return wrapError(b.b, err)
+	})
 	if err != nil {
-		return nil, wrapError(b.b, err)
+		return nil, err
 	}
 	var md map[string]string
 	if len(a.Metadata) > 0 {
@@ -601,7 +1007,11 @@ func (b *Bucket) Attributes(ctx context.Context, key string) (_ *Attributes, err
 		Metadata:           md,
 		ModTime:            a.ModTime,
 		Size:               a.Size,
+		UncompressedSize:   uncompressedSize(a),
 		MD5:                a.MD5,
+		ETag:               a.ETag,
+		Expires:            a.Expires,
+		StorageClass:       a.StorageClass,
 		asFunc:             a.AsFunc,
 	}, nil
 }
@@ -617,7 +1027,9 @@ func (b *Bucket) NewReader(ctx context.Context, key string, opts *ReaderOptions)
 //
 // If the blob does not exist, NewRangeReader returns an error for which
 // gcerrors.Code will return gcerrors.NotFound. Exists is a lighter-weight way
-// to check for existence.
+// to check for existence. If a precondition in opts.Conditions is not
+// satisfied, it returns an error for which gcerrors.Code will return
+// gcerrors.FailedPrecondition.
 //
 // A nil ReaderOptions is treated the same as the zero value.
 //
@@ -627,9 +1039,7 @@ func (b *Bucket) NewRangeReader(ctx context.Context, key string, offset, length
 }
 
 func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (_ *Reader, err error) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	if b.closed {
+	if b.isClosed() {
 		return nil, errClosed
 	}
 	if offset < 0 {
@@ -641,23 +1051,85 @@ func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length
 	if opts == nil {
 		opts = &ReaderOptions{}
 	}
+	conds, err := opts.Conditions.toDriver("NewRangeReader", false, true)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Decompress && opts.Version != "" {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ReaderOptions.Decompress and ReaderOptions.Version are mutually exclusive")
+	}
+	if opts.Decompress && opts.Raw {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ReaderOptions.Decompress and ReaderOptions.Raw are mutually exclusive")
+	}
 	dopts := &driver.ReaderOptions{
 		BeforeRead: opts.BeforeRead,
+		Conditions: conds,
+		Version:    opts.Version,
+		Raw:        opts.Raw,
 	}
 	tctx := b.tracer.Start(ctx, "NewRangeReader")
+	release, err := b.acquire(tctx, "NewRangeReader", ReadOperation)
+	if err != nil {
+		b.tracer.End(tctx, err)
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	end := func(err error) {
+		release()
+		b.tracer.End(tctx, err)
+	}
 	defer func() {
-		// If err == nil, we handed the end closure off to the returned *Writer; it
-		// will be called when the Writer is Closed.
+		// If err == nil, we handed the end closure off to the returned *Reader; it
+		// will be called when the Reader is Closed.
 		if err != nil {
-			b.tracer.End(tctx, err)
+			end(err)
 		}
 	}()
-	dr, err := b.b.NewRangeReader(ctx, key, offset, length, dopts)
+	var c Compressor
+	var a *driver.Attributes
+	var dr driver.Reader
+	err = retryCall(tctx, b.retryPolicy, func() (err error) {
+		// The blob may change between attempts, so start each one afresh.
+		readOffset, readLength := offset, length
+		c, a = nil, nil
+		dopts.Raw = opts.Raw
+		dopts.Conditions = conds
+		if opts.Decompress {
+			if a, err = b.b.Attributes(ctx, key); err != nil {
+				return wrapError(b.b, err)
+			}
+			if a.ContentEncoding != "" && a.ContentEncoding != "identity" {
+				if c = compressor(a.ContentEncoding); c == nil {
+					return gcerr.Newf(gcerr.Unimplemented, nil, "blob: no Compressor is registered for ContentEncoding %q", a.ContentEncoding)
+				}
+				// The blob is decompressed from the start, so read all of it,
+				// making sure that it is the blob whose attributes we have.
+				readOffset, readLength = 0, -1
+				dopts.Raw = true
+				if a.ETag != "" && (conds == nil || conds.IfMatch == "") {
+					dconds := driver.Conditions{IfMatch: a.ETag}
+					if conds != nil {
+						dconds.IfModifiedSince = conds.IfModifiedSince
+					}
+					dopts.Conditions = &dconds
+				}
+			}
+		}
+		dr, err = b.b.NewRangeReader(ctx, key, readOffset, readLength, dopts)
+		return wrapError(b.b, err)
+	})
 	if err != nil {
-		return nil, wrapError(b.b, err)
+		return nil, err
 	}
-	end := func(err error) { b.tracer.End(tctx, err) }
 	r := &Reader{b: b.b, r: dr, end: end, provider: b.tracer.Provider}
+	if c != nil {
+		if r.r, err = newDecompressingReader(dr, c, offset, length); err != nil {
+			dr.Close()
+			return nil, wrapError(b.b, err)
+		}
+		r.decompressed = true
+		r.uncompressedSize = uncompressedSize(a)
+	}
 	_, file, lineno, ok := runtime.Caller(2)
 	runtime.SetFinalizer(r, func(r *Reader) {
 		if !r.closed {
@@ -684,21 +1156,36 @@ func (b *Bucket) WriteAll(ctx context.Context, key string, p []byte, opts *Write
 		sum := md5.Sum(p)
 		realOpts.ContentMD5 = sum[:]
 	}
-	w, err := b.NewWriter(ctx, key, realOpts)
-	if err != nil {
-		return err
-	}
-	if _, err := w.Write(p); err != nil {
-		_ = w.Close()
-		return err
+	b.mu.RLock()
+	policy := b.retryPolicy
+	b.mu.RUnlock()
+	if realOpts.Append {
+		// An append that fails may have been applied; retrying it could
+		// append the content twice.
+		policy = nil
 	}
-	return w.Close()
+	// Each attempt is traced as its own NewWriter call.
+	return retryCall(ctx, policy, func() error {
+		w, err := b.NewWriter(ctx, key, realOpts)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(p); err != nil {
+			_ = w.Close()
+			return err
+		}
+		return w.Close()
+	})
 }
 
 // NewWriter returns a Writer that writes to the blob stored at key.
 // A nil WriterOptions is treated the same as the zero value.
 //
-// If a blob with this key already exists, it will be replaced.
+// If a blob with this key already exists, it will be replaced, unless
+// opts.Conditions is set and its preconditions are not satisfied, in which case
+// Close returns an error for which gcerrors.Code will return
+// gcerrors.FailedPrecondition. Some providers check the preconditions in
+// NewWriter as well.
 // The blob being written is not guaranteed to be readable until Close
 // has been called; until then, any previous blob will still be readable.
 // Even after Close is called, newly written blobs are not guaranteed to be
@@ -717,46 +1204,50 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 	if opts == nil {
 		opts = &WriterOptions{}
 	}
-	dopts := &driver.WriterOptions{
-		CacheControl:       opts.CacheControl,
-		ContentDisposition: opts.ContentDisposition,
-		ContentEncoding:    opts.ContentEncoding,
-		ContentLanguage:    opts.ContentLanguage,
-		ContentMD5:         opts.ContentMD5,
-		BufferSize:         opts.BufferSize,
-		BeforeWrite:        opts.BeforeWrite,
+	dopts, err := toDriverWriterOptions(opts)
+	if err != nil {
+		return nil, err
 	}
-	if len(opts.Metadata) > 0 {
-		// Providers are inconsistent, but at least some treat keys
-		// as case-insensitive. To make the behavior consistent, we
-		// force-lowercase them when writing and reading.
-		md := make(map[string]string, len(opts.Metadata))
-		for k, v := range opts.Metadata {
-			if k == "" {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata keys may not be empty strings")
-			}
-			if !utf8.ValidString(k) {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata keys must be valid UTF-8 strings: %q", k)
-			}
-			if !utf8.ValidString(v) {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata values must be valid UTF-8 strings: %q", v)
-			}
-			lowerK := strings.ToLower(k)
-			if _, found := md[lowerK]; found {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata has a duplicate case-insensitive metadata key: %q", lowerK)
-			}
-			md[lowerK] = v
+	var c Compressor
+	if opts.Compression != "" {
+		if c = compressor(opts.Compression); c == nil {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: no Compressor is registered for WriterOptions.Compression %q", opts.Compression)
 		}
-		dopts.Metadata = md
+		if opts.ContentEncoding != "" && opts.ContentEncoding != opts.Compression {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.ContentEncoding %q conflicts with WriterOptions.Compression %q", opts.ContentEncoding, opts.Compression)
+		}
+		dopts.ContentEncoding = opts.Compression
+		// ContentMD5 applies to the uncompressed content; the portable type
+		// verifies it.
+		dopts.ContentMD5 = nil
 	}
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	if b.closed {
+	maxConcurrency := opts.MaxConcurrency
+	if opts.Append {
+		if opts.Compression != "" {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Append and WriterOptions.Compression are mutually exclusive")
+		}
+		dopts.Append = true
+		// ContentMD5 applies to the appended content, not the whole blob;
+		// the portable type verifies it.
+		dopts.ContentMD5 = nil
+		maxConcurrency = 0
+	}
+	if b.isClosed() {
 		return nil, errClosed
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	tctx := b.tracer.Start(ctx, "NewWriter")
-	end := func(err error) { b.tracer.End(tctx, err) }
+	release, err := b.acquire(tctx, "NewWriter", WriteOperation)
+	if err != nil {
+		cancel()
+		b.tracer.End(tctx, err)
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	end := func(err error) {
+		release()
+		b.tracer.End(tctx, err)
+	}
 	defer func() {
 		if err != nil {
 			end(err)
@@ -764,15 +1255,21 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 	}()
 
 	w := &Writer{
-		b:          b.b,
-		end:        end,
-		cancel:     cancel,
-		key:        key,
-		opts:       dopts,
-		buf:        bytes.NewBuffer([]byte{}),
-		contentMD5: opts.ContentMD5,
-		md5hash:    md5.New(),
-		provider:   b.tracer.Provider,
+		b:              b.b,
+		end:            end,
+		cancel:         cancel,
+		key:            key,
+		opts:           dopts,
+		buf:            bytes.NewBuffer([]byte{}),
+		contentMD5:     opts.ContentMD5,
+		md5hash:        md5.New(),
+		provider:       b.tracer.Provider,
+		maxConcurrency: maxConcurrency,
+		compressor:     c,
+	}
+	l := b.limiter
+	w.throttle = func(ctx context.Context, methodName string) error {
+		return l.throttle(ctx, b.tracer, methodName, WriteOperation)
 	}
 	if opts.ContentType != "" {
 		t, p, err := mime.ParseMediaType(opts.ContentType)
@@ -781,7 +1278,7 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 			return nil, err
 		}
 		ct := mime.FormatMediaType(t, p)
-		dw, err := b.b.NewTypedWriter(ctx, key, ct, dopts)
+		dw, err := w.newDriverWriter(ctx, key, ct, dopts)
 		if err != nil {
 			cancel()
 			return nil, wrapError(b.b, err)
@@ -808,63 +1305,861 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 	return w, nil
 }
 
-// Copy the blob stored at srcKey to dstKey.
-// A nil CopyOptions is treated the same as the zero value.
-//
-// If the source blob does not exist, Copy returns an error for which
-// gcerrors.Code will return gcerrors.NotFound.
-//
-// If the destination blob already exists, it is overwritten.
-func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) (err error) {
-	if !utf8.ValidString(srcKey) {
-		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Copy srcKey must be a valid UTF-8 string: %q", srcKey)
+// toDriverWriterOptions validates opts and converts it to
+// driver.WriterOptions.
+func toDriverWriterOptions(opts *WriterOptions) (*driver.WriterOptions, error) {
+	if opts.MaxConcurrency < 0 {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.MaxConcurrency must be >= 0 (%d)", opts.MaxConcurrency)
 	}
-	if !utf8.ValidString(dstKey) {
-		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Copy dstKey must be a valid UTF-8 string: %q", dstKey)
+	conds, err := opts.Conditions.toDriver("NewWriter", true, false)
+	if err != nil {
+		return nil, err
 	}
-	if opts == nil {
-		opts = &CopyOptions{}
+	if err := checkStorageClass("WriterOptions", opts.StorageClass); err != nil {
+		return nil, err
 	}
-	dopts := &driver.CopyOptions{
-		BeforeCopy: opts.BeforeCopy,
+	dopts := &driver.WriterOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentMD5:         opts.ContentMD5,
+		BufferSize:         opts.BufferSize,
+		BeforeWrite:        opts.BeforeWrite,
+		Conditions:         conds,
+		Expires:            opts.Expires,
+		StorageClass:       opts.StorageClass,
 	}
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	if b.closed {
-		return errClosed
+	dopts.Metadata, err = toDriverMetadata("WriterOptions", opts.Metadata)
+	if err != nil {
+		return nil, err
 	}
-	ctx = b.tracer.Start(ctx, "Copy")
-	defer func() { b.tracer.End(ctx, err) }()
-	return wrapError(b.b, b.b.Copy(ctx, dstKey, srcKey, dopts))
+	return dopts, nil
 }
 
-// Delete deletes the blob stored at key.
-//
-// If the blob does not exist, Delete returns an error for which
-// gcerrors.Code will return gcerrors.NotFound.
-func (b *Bucket) Delete(ctx context.Context, key string) (err error) {
-	if !utf8.ValidString(key) {
-		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Delete key must be a valid UTF-8 string: %q", key)
+// toDriverMetadata validates metadata, the Metadata field of the options
+// named optsName, and returns it with lowercased keys, or nil if it is empty.
+func toDriverMetadata(optsName string, metadata map[string]string) (map[string]string, error) {
+	if len(metadata) == 0 {
+		return nil, nil
 	}
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	if b.closed {
-		return errClosed
+	// Providers are inconsistent, but at least some treat keys
+	// as case-insensitive. To make the behavior consistent, we
+	// force-lowercase them when writing and reading.
+	md := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if k == "" {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s.Metadata keys may not be empty strings", optsName)
+		}
+		if !utf8.ValidString(k) {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s.Metadata keys must be valid UTF-8 strings: %q", optsName, k)
+		}
+		if !utf8.ValidString(v) {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s.Metadata values must be valid UTF-8 strings: %q", optsName, v)
+		}
+		lowerK := strings.ToLower(k)
+		if _, found := md[lowerK]; found {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s.Metadata has a duplicate case-insensitive metadata key: %q", optsName, lowerK)
+		}
+		md[lowerK] = v
 	}
-	ctx = b.tracer.Start(ctx, "Delete")
-	defer func() { b.tracer.End(ctx, err) }()
-	return wrapError(b.b, b.b.Delete(ctx, key))
+	return md, nil
 }
 
-// SignedURL returns a URL that can be used to GET the blob for the duration
-// specified in opts.Expiry.
-//
-// A nil SignedURLOptions is treated the same as the zero value.
+// NewMultipartUpload starts uploading the blob stored at key in parts; see
+// MultipartUpload. A nil WriterOptions is treated the same as the zero value.
+// BufferSize, ContentMD5 and MaxConcurrency are ignored, and if ContentType is
+// not set, "application/octet-stream" is used. Compression is not supported.
 //
-// It is valid to call SignedURL for a key that does not exist.
+// Most callers should use NewWriter with WriterOptions.MaxConcurrency
+// instead, which uses a MultipartUpload under the hood. NewMultipartUpload is
+// useful for uploads that need to survive process restarts.
 //
-// If the provider implementation does not support this functionality, SignedURL
-// will return an error for which gcerrors.Code will return gcerrors.Unimplemented.
+// If the provider implementation does not support multipart uploads,
+// NewMultipartUpload returns an error for which gcerrors.Code will return
+// gcerrors.Unimplemented.
+func (b *Bucket) NewMultipartUpload(ctx context.Context, key string, opts *WriterOptions) (_ *MultipartUpload, err error) {
+	if !utf8.ValidString(key) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: NewMultipartUpload key must be a valid UTF-8 string: %q", key)
+	}
+	ct, dopts, err := multipartOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if b.isClosed() {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "NewMultipartUpload")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "NewMultipartUpload", WriteOperation)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	u, err := b.b.NewMultipartUpload(ctx, key, ct, dopts)
+	if err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	return &MultipartUpload{b: b.b, u: u, tracer: b.tracer, limiter: b.limiter, provider: b.tracer.Provider}, nil
+}
+
+// ResumeMultipartUpload returns a MultipartUpload for an upload to key that
+// was started by NewMultipartUpload, possibly in another process. uploadID
+// is the value returned by MultipartUpload.ID, and opts should be the same as
+// the options passed to NewMultipartUpload.
+//
+// If the upload does not exist, e.g. because it has already been completed
+// or aborted, ResumeMultipartUpload returns an error for which gcerrors.Code
+// will return gcerrors.NotFound.
+func (b *Bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID string, opts *WriterOptions) (_ *MultipartUpload, err error) {
+	if !utf8.ValidString(key) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ResumeMultipartUpload key must be a valid UTF-8 string: %q", key)
+	}
+	if uploadID == "" {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ResumeMultipartUpload uploadID must not be empty")
+	}
+	ct, dopts, err := multipartOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if b.isClosed() {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "ResumeMultipartUpload")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "ResumeMultipartUpload", WriteOperation)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	u, err := b.b.ResumeMultipartUpload(ctx, key, uploadID, ct, dopts)
+	if err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	return &MultipartUpload{b: b.b, u: u, tracer: b.tracer, limiter: b.limiter, provider: b.tracer.Provider}, nil
+}
+
+// multipartOptions returns the content type and driver options for
+// NewMultipartUpload and ResumeMultipartUpload.
+func multipartOptions(opts *WriterOptions) (string, *driver.WriterOptions, error) {
+	if opts == nil {
+		opts = &WriterOptions{}
+	}
+	if opts.Compression != "" {
+		return "", nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: multipart uploads do not support WriterOptions.Compression")
+	}
+	if opts.Append {
+		return "", nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: multipart uploads do not support WriterOptions.Append")
+	}
+	dopts, err := toDriverWriterOptions(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	dopts.BufferSize = 0
+	dopts.ContentMD5 = nil
+	ct := "application/octet-stream"
+	if opts.ContentType != "" {
+		t, p, err := mime.ParseMediaType(opts.ContentType)
+		if err != nil {
+			return "", nil, err
+		}
+		ct = mime.FormatMediaType(t, p)
+	}
+	return ct, dopts, nil
+}
+
+// Copy the blob stored at srcKey to dstKey.
+// A nil CopyOptions is treated the same as the zero value.
+//
+// If the source blob does not exist, Copy returns an error for which
+// gcerrors.Code will return gcerrors.NotFound.
+//
+// If the destination blob already exists, it is overwritten, unless
+// opts.Conditions is set and its preconditions are not satisfied by the
+// destination blob, in which case Copy returns an error for which
+// gcerrors.Code will return gcerrors.FailedPrecondition.
+func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) (err error) {
+	if !utf8.ValidString(srcKey) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Copy srcKey must be a valid UTF-8 string: %q", srcKey)
+	}
+	if !utf8.ValidString(dstKey) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Copy dstKey must be a valid UTF-8 string: %q", dstKey)
+	}
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	conds, err := opts.Conditions.toDriver("Copy", true, false)
+	if err != nil {
+		return err
+	}
+	if err := checkStorageClass("CopyOptions", opts.StorageClass); err != nil {
+		return err
+	}
+	dopts := &driver.CopyOptions{
+		BeforeCopy:   opts.BeforeCopy,
+		Conditions:   conds,
+		StorageClass: opts.StorageClass,
+	}
+	if b.isClosed() {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Copy")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "Copy", WriteOperation)
+	if err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	return retryCall(ctx, b.retryPolicy, func() error {
+		return wrapError(b.b, b.b.Copy(ctx, dstKey, srcKey, dopts))
+	})
+}
+
+// Compose creates or replaces the blob stored at dstKey with the
+// concatenation of the contents of the blobs stored at srcKeys, in order.
+// Providers that support it compose the blob without downloading the
+// sources; see the provider-specific package documentation for the details
+// and limits. dstKey may be one of srcKeys, to append to it.
+// A nil ComposeOptions is treated the same as the zero value.
+//
+// The attributes of the composed blob are set from opts, like for NewWriter,
+// except that if opts.ContentType is empty, the content type of the first
+// source blob is used.
+//
+// If a source blob does not exist, Compose returns an error for which
+// gcerrors.Code will return gcerrors.NotFound, and the destination blob is
+// unchanged.
+//
+// If opts.Conditions is set and its preconditions are not satisfied by the
+// destination blob, Compose returns an error for which gcerrors.Code will
+// return gcerrors.FailedPrecondition.
+func (b *Bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *ComposeOptions) (err error) {
+	if !utf8.ValidString(dstKey) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Compose dstKey must be a valid UTF-8 string: %q", dstKey)
+	}
+	if len(srcKeys) == 0 {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Compose needs at least one source key")
+	}
+	for _, k := range srcKeys {
+		if !utf8.ValidString(k) {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Compose srcKeys must be valid UTF-8 strings: %q", k)
+		}
+	}
+	if opts == nil {
+		opts = &ComposeOptions{}
+	}
+	conds, err := opts.Conditions.toDriver("Compose", true, false)
+	if err != nil {
+		return err
+	}
+	md, err := toDriverMetadata("ComposeOptions", opts.Metadata)
+	if err != nil {
+		return err
+	}
+	dopts := &driver.ComposeOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		Metadata:           md,
+		BeforeCompose:      opts.BeforeCompose,
+		Conditions:         conds,
+	}
+	if opts.ContentType != "" {
+		t, p, err := mime.ParseMediaType(opts.ContentType)
+		if err != nil {
+			return err
+		}
+		dopts.ContentType = mime.FormatMediaType(t, p)
+	}
+
+	if b.isClosed() {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Compose")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "Compose", WriteOperation)
+	if err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	return retryCall(ctx, b.retryPolicy, func() error {
+		if dopts.ContentType == "" {
+			a, err := b.b.Attributes(ctx, srcKeys[0])
+			if err != nil {
+				return wrapError(b.b, err)
+			}
+			dopts.ContentType = a.ContentType
+			if dopts.ContentType == "" {
+				dopts.ContentType = "application/octet-stream"
+			}
+		}
+		return wrapError(b.b, b.b.Compose(ctx, dstKey, srcKeys, dopts))
+	})
+}
+
+// UpdateAttributes changes attributes of the blob stored at key, as set in
+// opts, without rewriting its content. Attributes that are not set in opts
+// are unchanged. Depending on the provider, the blob's ModTime and ETag may
+// change; see the provider-specific package documentation.
+// A nil UpdateAttributesOptions is treated the same as the zero value.
+//
+// If the blob does not exist, UpdateAttributes returns an error for which
+// gcerrors.Code will return gcerrors.NotFound.
+//
+// If opts.Conditions is set and its preconditions are not satisfied,
+// UpdateAttributes returns an error for which gcerrors.Code will return
+// gcerrors.FailedPrecondition.
+func (b *Bucket) UpdateAttributes(ctx context.Context, key string, opts *UpdateAttributesOptions) (err error) {
+	if !utf8.ValidString(key) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: UpdateAttributes key must be a valid UTF-8 string: %q", key)
+	}
+	if opts == nil {
+		opts = &UpdateAttributesOptions{}
+	}
+	conds, err := opts.Conditions.toDriver("UpdateAttributes", false, false)
+	if err != nil {
+		return err
+	}
+	md, err := toDriverMetadata("UpdateAttributesOptions", opts.Metadata)
+	if err != nil {
+		return err
+	}
+	var del []string
+	for _, k := range opts.DeleteMetadata {
+		if k == "" {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: UpdateAttributesOptions.DeleteMetadata keys may not be empty strings")
+		}
+		if !utf8.ValidString(k) {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: UpdateAttributesOptions.DeleteMetadata keys must be valid UTF-8 strings: %q", k)
+		}
+		k = strings.ToLower(k)
+		if _, ok := md[k]; ok {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: UpdateAttributesOptions metadata key %q is both set and deleted", k)
+		}
+		del = append(del, k)
+	}
+	dopts := &driver.UpdateAttributesOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		Metadata:           md,
+		DeleteMetadata:     del,
+		BeforeUpdate:       opts.BeforeUpdate,
+		Conditions:         conds,
+	}
+	if opts.ContentType != nil {
+		if *opts.ContentType == "" {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: UpdateAttributesOptions.ContentType may not be empty")
+		}
+		t, p, err := mime.ParseMediaType(*opts.ContentType)
+		if err != nil {
+			return err
+		}
+		ct := mime.FormatMediaType(t, p)
+		dopts.ContentType = &ct
+	}
+
+	if b.isClosed() {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "UpdateAttributes")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "UpdateAttributes", WriteOperation)
+	if err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	return retryCall(ctx, b.retryPolicy, func() error {
+		return wrapError(b.b, b.b.UpdateAttributes(ctx, key, dopts))
+	})
+}
+
+// Version describes a version of a blob, returned from ListVersions.
+type Version struct {
+	// ID identifies the version. It can be passed as ReaderOptions.Version or
+	// DeleteOptions.Version.
+	ID string
+	// ModTime is the time the version was created.
+	ModTime time.Time
+	// Size is the size of the version's content in bytes.
+	Size int64
+	// ETag is an opaque identifier for the version's content;
+	// see Attributes.ETag.
+	ETag string
+	// IsLatest is true if the version is the current version of the blob.
+	IsLatest bool
+
+	asFunc func(interface{}) bool
+}
+
+// As converts i to provider-specific types.
+// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information, the "As"
+// examples in this package for examples, and the provider-specific package
+// documentation for the specific types supported for that provider.
+func (v *Version) As(i interface{}) bool {
+	if v.asFunc == nil {
+		return false
+	}
+	return v.asFunc(i)
+}
+
+// ListVersions returns the versions of the blob stored at key, newest first,
+// for buckets that keep previous versions of blobs when they are overwritten
+// or deleted. If the blob has been deleted, none of the returned versions is
+// the latest one.
+//
+// If the provider implementation does not support versions, ListVersions
+// returns an error for which gcerrors.Code will return gcerrors.Unimplemented.
+func (b *Bucket) ListVersions(ctx context.Context, key string) (_ []*Version, err error) {
+	if !utf8.ValidString(key) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ListVersions key must be a valid UTF-8 string: %q", key)
+	}
+	if b.isClosed() {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "ListVersions")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "ListVersions", ListOperation)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	var dvs []*driver.Version
+	err = retryCall(ctx, b.retryPolicy, func() (err error) {
+		dvs, err = b.b.ListVersions(ctx, key)
+		return wrapError(b.b, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]*Version, len(dvs))
+	for i, dv := range dvs {
+		vs[i] = &Version{
+			ID:       dv.ID,
+			ModTime:  dv.ModTime,
+			Size:     dv.Size,
+			ETag:     dv.ETag,
+			IsLatest: dv.IsLatest,
+			asFunc:   dv.AsFunc,
+		}
+	}
+	return vs, nil
+}
+
+// Delete deletes the blob stored at key.
+//
+// If the blob does not exist, Delete returns an error for which
+// gcerrors.Code will return gcerrors.NotFound.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	return b.DeleteWithOptions(ctx, key, nil)
+}
+
+// DeleteWithOptions is like Delete, but takes options.
+// A nil DeleteOptions is treated the same as the zero value.
+//
+// If a precondition in opts.Conditions is not satisfied, the blob is not
+// deleted, and DeleteWithOptions returns an error for which gcerrors.Code
+// will return gcerrors.FailedPrecondition.
+func (b *Bucket) DeleteWithOptions(ctx context.Context, key string, opts *DeleteOptions) (err error) {
+	if !utf8.ValidString(key) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Delete key must be a valid UTF-8 string: %q", key)
+	}
+	if opts == nil {
+		opts = &DeleteOptions{}
+	}
+	conds, err := opts.Conditions.toDriver("Delete", false, false)
+	if err != nil {
+		return err
+	}
+	dopts := &driver.DeleteOptions{
+		Conditions: conds,
+		Version:    opts.Version,
+	}
+	if b.isClosed() {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Delete")
+	defer func() { b.tracer.End(ctx, err) }()
+	release, err := b.acquire(ctx, "Delete", DeleteOperation)
+	if err != nil {
+		return err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	return retryCall(ctx, b.retryPolicy, func() error {
+		return wrapError(b.b, b.b.Delete(ctx, key, dopts))
+	})
+}
+
+// deleteManyBatchSize is the maximum number of keys passed to a single call
+// of driver.Bucket.DeleteMany.
+const deleteManyBatchSize = 1000
+
+// deleteManyConcurrency is the number of concurrent calls to Delete used by
+// DeleteMany and DeletePrefix for providers without a batch endpoint.
+const deleteManyConcurrency = 10
+
+// KeyError is an error for a single key of an operation on many blobs.
+type KeyError struct {
+	Key string
+	// Err can be used with gcerrors.Code and Bucket.ErrorAs.
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%q: %v", e.Key, e.Err)
+}
+
+// DeleteManyError is returned by DeleteMany and DeletePrefix when some of the
+// blobs could not be deleted. The other blobs were deleted.
+type DeleteManyError struct {
+	// Errors holds an entry for each key that could not be deleted.
+	Errors []*KeyError
+}
+
+func (e *DeleteManyError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("blob: failed to delete %v", e.Errors[0])
+	}
+	return fmt.Sprintf("blob: failed to delete %d blobs, including %v", len(e.Errors), e.Errors[0])
+}
+
+// DeleteMany deletes the blobs stored at keys. It uses the provider's batch
+// delete endpoint if there is one, and concurrent calls to Delete otherwise.
+// Keys that do not exist are ignored.
+//
+// If some of the blobs could not be deleted, DeleteMany returns a
+// *DeleteManyError holding the error for each of them. Other errors
+// indicate that the request as a whole failed, and some or all of the blobs
+// may not have been deleted.
+func (b *Bucket) DeleteMany(ctx context.Context, keys []string) (err error) {
+	for _, key := range keys {
+		if !utf8.ValidString(key) {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: DeleteMany key must be a valid UTF-8 string: %q", key)
+		}
+	}
+	if b.isClosed() {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeleteMany")
+	defer func() { b.tracer.End(ctx, err) }()
+	var kerrs []*KeyError
+	for start := 0; start < len(keys); start += deleteManyBatchSize {
+		end := start + deleteManyBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batchErrs, err := b.deleteMany(ctx, "DeleteMany", keys[start:end])
+		if err != nil {
+			return err
+		}
+		kerrs = append(kerrs, batchErrs...)
+	}
+	if len(kerrs) > 0 {
+		return &DeleteManyError{Errors: kerrs}
+	}
+	return nil
+}
+
+// DeletePrefix deletes all the blobs whose keys begin with prefix, as listed
+// by List. The deletes are batched as for DeleteMany, and errors are
+// reported in the same way. If prefix is empty, all the blobs in the bucket
+// are deleted.
+func (b *Bucket) DeletePrefix(ctx context.Context, prefix string) (err error) {
+	if !utf8.ValidString(prefix) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: DeletePrefix prefix must be a valid UTF-8 string: %q", prefix)
+	}
+	// The lock is not held for the whole call, since List and each batch
+	// delete take it themselves; the closed check is repeated per batch.
+	if b.isClosed() {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeletePrefix")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	var keys []string
+	var kerrs []*KeyError
+	flush := func() error {
+		batchErrs, err := b.deleteMany(ctx, "DeletePrefix", keys)
+		if err != nil {
+			return err
+		}
+		kerrs = append(kerrs, batchErrs...)
+		keys = keys[:0]
+		return nil
+	}
+	iter := b.List(&ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, obj.Key)
+		if len(keys) == deleteManyBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if len(kerrs) > 0 {
+		return &DeleteManyError{Errors: kerrs}
+	}
+	return nil
+}
+
+// deleteMany deletes a batch of at most deleteManyBatchSize keys for the
+// method methodName, and returns the errors for the keys that could not be
+// deleted. b.mu must not be held.
+func (b *Bucket) deleteMany(ctx context.Context, methodName string, keys []string) ([]*KeyError, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	release, err := b.acquire(ctx, methodName, DeleteOperation)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.RUnlock()
+	defer release()
+	var errs []error
+	err = retryCall(ctx, b.retryPolicy, func() (err error) {
+		errs, err = b.b.DeleteMany(ctx, keys)
+		return wrapError(b.b, err)
+	})
+	if err != nil {
+		if gcerrors.Code(err) != gcerrors.Unimplemented {
+			return nil, err
+		}
+		errs = b.deleteConcurrently(ctx, keys)
+	}
+	var kerrs []*KeyError
+	for i, err := range errs {
+		if err != nil && b.b.ErrorCode(err) != gcerrors.NotFound {
+			kerrs = append(kerrs, &KeyError{Key: keys[i], Err: wrapError(b.b, err)})
+		}
+	}
+	return kerrs, nil
+}
+
+// deleteConcurrently deletes keys using concurrent calls to Delete, and
+// returns the error for each key.
+func (b *Bucket) deleteConcurrently(ctx context.Context, keys []string) []error {
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, deleteManyConcurrency)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = b.b.Delete(ctx, key, &driver.DeleteOptions{})
+		}(i, key)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Watch returns a Watcher that reports blobs that are created, overwritten
+// or deleted after Watch returns, so that callers can react to changes
+// without polling List. A nil WatchOptions is treated the same as the zero
+// value.
+//
+// Events are delivered at least once, but may be delivered late or more than
+// once; see the provider-specific package documentation for details, and for
+// the setup that some providers require, such as a notification
+// subscription. The Watcher must be closed before the Bucket.
+//
+// If the provider implementation does not support this functionality, Watch
+// will return an error for which gcerrors.Code will return
+// gcerrors.Unimplemented.
+func (b *Bucket) Watch(ctx context.Context, opts *WatchOptions) (_ *Watcher, err error) {
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	if !utf8.ValidString(opts.Prefix) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WatchOptions.Prefix must be a valid UTF-8 string: %q", opts.Prefix)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Watch")
+	defer func() { b.tracer.End(ctx, err) }()
+	w, err := b.b.Watch(ctx, &driver.WatchOptions{Prefix: opts.Prefix})
+	if err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	return &Watcher{b: b, w: w, prefix: opts.Prefix}, nil
+}
+
+// WatchOptions sets options for Watch.
+type WatchOptions struct {
+	// Prefix indicates that only events for blobs with a key starting with
+	// this prefix should be returned.
+	Prefix string
+}
+
+// EventType is the type of an Event.
+type EventType = driver.EventType
+
+const (
+	// EventCreated means that a blob was created or overwritten.
+	EventCreated = driver.EventCreated
+	// EventDeleted means that a blob was deleted.
+	EventDeleted = driver.EventDeleted
+)
+
+// Event describes a change to a blob, returned from Watcher.Next.
+type Event struct {
+	// Type is the type of the change.
+	Type EventType
+	// Key is the key of the blob.
+	Key string
+	// ModTime is the time the blob was modified, for EventCreated, or the
+	// zero value if the provider doesn't report it.
+	ModTime time.Time
+	// Size is the size of the blob's content in bytes, for EventCreated, or 0
+	// if the provider doesn't report it.
+	Size int64
+	// ETag is an opaque identifier for the new version of the blob, for
+	// EventCreated, or "" if the provider doesn't report it; see
+	// Attributes.ETag.
+	ETag string
+
+	asFunc func(interface{}) bool
+}
+
+// As converts i to provider-specific types.
+// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information, the "As"
+// examples in this package for examples, and the provider-specific package
+// documentation for the specific types supported for that provider.
+func (e *Event) As(i interface{}) bool {
+	if e.asFunc == nil {
+		return false
+	}
+	return e.asFunc(i)
+}
+
+// Watcher reports changes to the blobs in a bucket; see Bucket.Watch.
+type Watcher struct {
+	b      *Bucket
+	w      driver.Watcher
+	prefix string
+	events []*driver.Event
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Next blocks until a blob is created, overwritten or deleted, and returns
+// an Event describing the change. Events for a blob are returned in the
+// order in which the provider reported them.
+//
+// Next returns ctx.Err() if ctx is done before an event is available.
+// Next must not be called concurrently.
+func (w *Watcher) Next(ctx context.Context) (*Event, error) {
+	for {
+		for len(w.events) > 0 {
+			dev := w.events[0]
+			w.events = w.events[1:]
+			if !strings.HasPrefix(dev.Key, w.prefix) {
+				continue
+			}
+			return &Event{
+				Type:    dev.Type,
+				Key:     dev.Key,
+				ModTime: dev.ModTime,
+				Size:    dev.Size,
+				ETag:    dev.ETag,
+				asFunc:  dev.AsFunc,
+			}, nil
+		}
+		if err := w.checkOpen(); err != nil {
+			return nil, err
+		}
+		evs, err := w.w.Next(ctx)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if cerr := w.checkOpen(); cerr != nil {
+				return nil, cerr
+			}
+			return nil, wrapError(w.b.b, err)
+		}
+		w.events = evs
+	}
+}
+
+// checkOpen returns an error if w or its Bucket is closed.
+func (w *Watcher) checkOpen() error {
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return errWatcherClosed
+	}
+	w.b.mu.RLock()
+	defer w.b.mu.RUnlock()
+	if w.b.closed {
+		return errClosed
+	}
+	return nil
+}
+
+// As converts i to provider-specific types.
+// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information, the "As"
+// examples in this package for examples, and the provider-specific package
+// documentation for the specific types supported for that provider.
+func (w *Watcher) As(i interface{}) bool {
+	if i == nil {
+		return false
+	}
+	return w.w.As(i)
+}
+
+// Close stops watching. Close may be called while Next is blocked, in which
+// case Next returns an error.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	prev := w.closed
+	w.closed = true
+	w.mu.Unlock()
+	if prev {
+		return errWatcherClosed
+	}
+	return wrapError(w.b.b, w.w.Close())
+}
+
+var errWatcherClosed = gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: Watcher has been closed")
+
+// SignedURL returns a URL that can be used to access the blob using
+// opts.Method for the duration specified in opts.Expiry. For example, a URL
+// signed for "PUT" lets a browser upload the blob directly to the provider.
+//
+// A nil SignedURLOptions is treated the same as the zero value.
+//
+// It is valid to call SignedURL for a key that does not exist.
+//
+// If the provider implementation does not support this functionality, SignedURL
+// will return an error for which gcerrors.Code will return gcerrors.Unimplemented.
 func (b *Bucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
 	if !utf8.ValidString(key) {
 		return "", gcerr.Newf(gcerr.InvalidArgument, nil, "blob: SignedURL key must be a valid UTF-8 string: %q", key)
@@ -878,8 +2173,21 @@ func (b *Bucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptio
 	if opts.Expiry == 0 {
 		opts.Expiry = DefaultSignedURLExpiry
 	}
+	method := opts.Method
+	switch method {
+	case "":
+		method = http.MethodGet
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+	default:
+		return "", gcerr.Newf(gcerr.InvalidArgument, nil, "blob: SignedURLOptions.Method must be GET, PUT or DELETE (%q)", opts.Method)
+	}
+	if opts.ContentType != "" && method != http.MethodPut {
+		return "", gcerr.Newf(gcerr.InvalidArgument, nil, "blob: SignedURLOptions.ContentType may only be set when Method is PUT")
+	}
 	dopts := driver.SignedURLOptions{
-		Expiry: opts.Expiry,
+		Expiry:      opts.Expiry,
+		Method:      method,
+		ContentType: opts.ContentType,
 	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -890,6 +2198,43 @@ func (b *Bucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptio
 	return url, wrapError(b.b, err)
 }
 
+// SetRetryPolicy sets the policy used to retry the calls to the provider
+// that fail, or disables retries if p is nil, which is the default.
+//
+// The policy applies to Attributes, Copy, Compose, Delete, DeleteMany,
+// DeletePrefix, ListVersions, UpdateAttributes and WriteAll, to the listing
+// of pages, and to opening Readers. Writes made with a Writer are not
+// retried, since the data written can't be replayed, and neither are
+// appends. The number of attempts
+// is recorded in the "attempts" attribute of the OpenCensus span of the
+// call.
+func (b *Bucket) SetRetryPolicy(p *gcerrors.RetryPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retryPolicy = p
+}
+
+// retryCall calls f, retrying it as configured by p if p isn't nil.
+// f must return errors wrapped by wrapError.
+func retryCall(ctx context.Context, p *gcerrors.RetryPolicy, f func() error) error {
+	if p == nil {
+		return f()
+	}
+	bo := gax.Backoff{Initial: p.InitialBackoff, Max: p.MaxBackoff, Multiplier: p.Multiplier}
+	n, err := retry.CallAttempts(ctx, p.MaxAttempts, bo, func(err error) bool {
+		return p.Retries(gcerrors.Code(err))
+	}, f)
+	oc.SetAttempts(ctx, n)
+	return err
+}
+
+// isClosed reports whether b has been closed.
+func (b *Bucket) isClosed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.closed
+}
+
 // Close releases any resources used for the bucket.
 func (b *Bucket) Close() error {
 	b.mu.Lock()
@@ -910,6 +2255,17 @@ type SignedURLOptions struct {
 	// Expiry sets how long the returned URL is valid for.
 	// Defaults to DefaultSignedURLExpiry.
 	Expiry time.Duration
+
+	// Method is the HTTP method that can be used on the URL; one of "GET",
+	// "PUT", or "DELETE". Defaults to "GET".
+	Method string
+
+	// ContentType, if not empty, is the Content-Type HTTP header that must be
+	// used when uploading the blob with the URL. It may only be set if Method
+	// is "PUT". If the provider implementation cannot enforce it, SignedURL
+	// returns an error for which gcerrors.Code will return
+	// gcerrors.Unimplemented.
+	ContentType string
 }
 
 // ReaderOptions sets options for NewReader and NewRangedReader.
@@ -921,6 +2277,36 @@ type ReaderOptions struct {
 	// asFunc converts its argument to provider-specific types.
 	// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information.
 	BeforeRead func(asFunc func(interface{}) bool) error
+
+	// Conditions holds preconditions for the read, or nil.
+	// IfNotExist may not be set.
+	Conditions *Conditions
+
+	// Version, if not empty, selects the version of the blob to read, as
+	// returned by Bucket.ListVersions in Version.ID. If the provider
+	// implementation does not support versions, reading returns an error for
+	// which gcerrors.Code will return gcerrors.Unimplemented.
+	Version string
+
+	// Decompress, if true, makes the Reader decompress blobs that have a
+	// ContentEncoding, such as blobs written with WriterOptions.Compression.
+	// The offset and length passed to NewRangeReader then refer to the
+	// decompressed content, but the whole blob is read from the provider.
+	// If no Compressor is registered for the blob's ContentEncoding (see
+	// RegisterCompressor), reading returns an error for which gcerrors.Code
+	// will return gcerrors.Unimplemented. Blobs without a ContentEncoding
+	// are read as usual.
+	//
+	// Decompress may not be used with Version.
+	Decompress bool
+
+	// Raw, if true, makes the Reader return the content of blobs that have a
+	// ContentEncoding as it is stored, even if the provider would otherwise
+	// decode it; for example, GCS decompresses gzip-encoded objects when
+	// they are read. Raw content matches the blob's Size and MD5 attributes.
+	//
+	// Raw may not be used with Decompress.
+	Raw bool
 }
 
 // WriterOptions sets options for NewWriter.
@@ -935,8 +2321,21 @@ type WriterOptions struct {
 	//
 	// If the Writer is used to do many small writes concurrently, using a
 	// smaller BufferSize may reduce memory usage.
+	//
+	// If MaxConcurrency > 1, BufferSize is the size of each part of the
+	// multipart upload, and defaults to 8 MiB. Some providers require parts
+	// to be at least a minimum size; for example, S3 requires 5 MiB.
 	BufferSize int
 
+	// MaxConcurrency enables parallel uploads when > 1: the Writer splits the
+	// blob into parts of BufferSize bytes and uploads up to MaxConcurrency of
+	// them at a time, using a MultipartUpload. The Writer holds up to
+	// MaxConcurrency+1 parts in memory.
+	//
+	// If the provider implementation does not support multipart uploads,
+	// the blob is uploaded as if MaxConcurrency were 0.
+	MaxConcurrency int
+
 	// CacheControl specifies caching attributes that providers may use
 	// when serving the blob.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
@@ -951,6 +2350,18 @@ type WriterOptions struct {
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Encoding
 	ContentEncoding string
 
+	// Compression, if not empty, is a content coding such as "gzip" that the
+	// Writer compresses the blob with. A Compressor must be registered for
+	// it; see RegisterCompressor. ContentEncoding is set to Compression, and
+	// must be empty or equal to it.
+	//
+	// ContentType and ContentMD5 apply to the uncompressed content. The
+	// Writer buffers up to 1 MiB of compressed content before uploading it;
+	// if the whole blob fits, its uncompressed size is stored in Metadata
+	// under UncompressedSizeKey. Use ReaderOptions.Decompress to read the
+	// blob back decompressed.
+	Compression string
+
 	// ContentLanguage specifies the language used in the blob's content, if any.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Language
 	ContentLanguage string
@@ -982,6 +2393,43 @@ type WriterOptions struct {
 	// asFunc converts its argument to provider-specific types.
 	// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information.
 	BeforeWrite func(asFunc func(interface{}) bool) error
+
+	// Conditions holds preconditions that any existing blob with the same key
+	// must satisfy for the write to succeed, or nil.
+	// IfModifiedSince may not be set.
+	Conditions *Conditions
+
+	// Expires, if not zero, is the time after which the blob expires; use
+	// time.Now().Add(ttl) to give it a time to live. Copies of the blob
+	// expire at the same time. memblob and fileblob delete expired blobs
+	// promptly, and return NotFound errors when reading them; other
+	// providers only record the expiration time, and rely on native
+	// features such as lifecycle rules configured on the bucket, which may
+	// delete blobs days after they expire. See the provider-specific package
+	// documentation for details.
+	Expires time.Time
+
+	// StorageClass is the storage class to write the blob with. The default,
+	// StorageClassDefault, uses the provider's default class, usually
+	// StorageClassStandard. Providers that have no equivalent for a class
+	// return an error for which gcerrors.Code will return
+	// gcerrors.Unimplemented.
+	StorageClass StorageClass
+
+	// Append, if true, appends the content written to the blob instead of
+	// replacing it, creating the blob if it doesn't exist. The other
+	// attributes in WriterOptions are only used when the blob is created;
+	// the attributes of an existing blob are unchanged.
+	//
+	// Appends are atomic: concurrent appends don't interleave, and an append
+	// that fails or is aborted leaves the blob unchanged. Providers that
+	// can't append atomically return an error for which gcerrors.Code will
+	// return gcerrors.Unimplemented, and some only append to blobs that were
+	// created by appending; see the provider-specific package documentation.
+	//
+	// ContentMD5 applies to the appended content. MaxConcurrency is ignored,
+	// and Compression may not be set.
+	Append bool
 }
 
 // CopyOptions sets options for Copy.
@@ -992,6 +2440,197 @@ type CopyOptions struct {
 	// asFunc converts its argument to provider-specific types.
 	// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information.
 	BeforeCopy func(asFunc func(interface{}) bool) error
+
+	// Conditions holds preconditions that the destination blob must satisfy
+	// for the copy to succeed, or nil.
+	// IfModifiedSince may not be set.
+	Conditions *Conditions
+
+	// StorageClass is the storage class of the copy, which may differ from
+	// the source's, as for WriterOptions.StorageClass. If it is
+	// StorageClassDefault, the copy gets the provider's default class even
+	// if the source is in another one.
+	StorageClass StorageClass
+}
+
+// StorageClass is a portable storage class, or tier. Colder classes have
+// lower storage costs, and higher access costs or latency. Each provider maps
+// them to its own classes; see the provider-specific package documentation.
+type StorageClass = driver.StorageClass
+
+const (
+	// StorageClassDefault means the provider's default storage class.
+	StorageClassDefault = driver.StorageClassDefault
+	// StorageClassStandard is for frequently accessed blobs.
+	StorageClassStandard = driver.StorageClassStandard
+	// StorageClassInfrequentAccess is for blobs accessed about once a month
+	// or less.
+	StorageClassInfrequentAccess = driver.StorageClassInfrequentAccess
+	// StorageClassCold is for blobs accessed about once a quarter or less.
+	StorageClassCold = driver.StorageClassCold
+	// StorageClassArchive is for blobs accessed about once a year or less.
+	// With some providers, archived blobs must be restored before they can be
+	// read.
+	StorageClassArchive = driver.StorageClassArchive
+)
+
+// checkStorageClass returns an error if c, the StorageClass field of the
+// options named optsName, is not a valid StorageClass.
+func checkStorageClass(optsName string, c StorageClass) error {
+	if c < StorageClassDefault || c > StorageClassArchive {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s.StorageClass is invalid (%d)", optsName, int(c))
+	}
+	return nil
+}
+
+// ComposeOptions sets options for Compose.
+type ComposeOptions struct {
+	// CacheControl specifies caching attributes that services may use
+	// when serving the blob.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
+	CacheControl string
+
+	// ContentDisposition specifies whether the blob content is expected to be
+	// displayed inline or as an attachment.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Disposition
+	ContentDisposition string
+
+	// ContentEncoding specifies the encoding used for the blob's content, if any.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Encoding
+	ContentEncoding string
+
+	// ContentLanguage specifies the language used in the blob's content, if any.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Language
+	ContentLanguage string
+
+	// ContentType specifies the MIME type of the blob. If empty, the content
+	// type of the first source blob is used.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Type
+	ContentType string
+
+	// Metadata holds key/value pairs to be associated with the blob, or nil.
+	// Keys and values must be valid UTF-8 strings, as for
+	// WriterOptions.Metadata.
+	Metadata map[string]string
+
+	// BeforeCompose is a callback that will be called exactly once, before
+	// the blob is composed.
+	//
+	// asFunc converts its argument to provider-specific types.
+	// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information.
+	BeforeCompose func(asFunc func(interface{}) bool) error
+
+	// Conditions holds preconditions that the destination blob must satisfy
+	// for the compose to succeed, or nil.
+	// IfModifiedSince may not be set.
+	Conditions *Conditions
+}
+
+// UpdateAttributesOptions sets the attributes changed by UpdateAttributes.
+// Attributes that are nil are unchanged; to clear one, set it to point to
+// the empty string.
+type UpdateAttributesOptions struct {
+	// CacheControl specifies caching attributes that services may use
+	// when serving the blob.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
+	CacheControl *string
+
+	// ContentDisposition specifies whether the blob content is expected to be
+	// displayed inline or as an attachment.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Disposition
+	ContentDisposition *string
+
+	// ContentEncoding specifies the encoding used for the blob's content, if any.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Encoding
+	ContentEncoding *string
+
+	// ContentLanguage specifies the language used in the blob's content, if any.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Language
+	ContentLanguage *string
+
+	// ContentType specifies the MIME type of the blob. It may not point to
+	// the empty string.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Type
+	ContentType *string
+
+	// Metadata holds key/value pairs to add to the blob's metadata, replacing
+	// the values of existing keys; other keys are unchanged. Keys and values
+	// must be valid UTF-8 strings, as for WriterOptions.Metadata.
+	Metadata map[string]string
+
+	// DeleteMetadata holds the keys to remove from the blob's metadata. Keys
+	// are case-insensitive, and may not also be set in Metadata.
+	DeleteMetadata []string
+
+	// BeforeUpdate is a callback that will be called exactly once, before
+	// the attributes are updated.
+	//
+	// asFunc converts its argument to provider-specific types.
+	// See https://godoc.org/github.com/eliben/gocdkx#hdr-As for background information.
+	BeforeUpdate func(asFunc func(interface{}) bool) error
+
+	// Conditions holds preconditions for the update, or nil.
+	// Only IfMatch may be set.
+	Conditions *Conditions
+}
+
+// DeleteOptions sets options for DeleteWithOptions.
+type DeleteOptions struct {
+	// Conditions holds preconditions for the delete, or nil.
+	// Only IfMatch may be set.
+	Conditions *Conditions
+
+	// Version, if not empty, selects a version of the blob to delete
+	// permanently, as returned by Bucket.ListVersions in Version.ID. Other
+	// versions are not affected. If the provider implementation does not
+	// support versions, deleting returns an error for which gcerrors.Code will
+	// return gcerrors.Unimplemented.
+	Version string
+}
+
+// Conditions are preconditions on the current state of a blob, used to
+// implement optimistic concurrency control. For example, a read-modify-write
+// cycle can use IfMatch to ensure that the blob was not replaced by another
+// writer in the meantime, and NewWriter can use IfNotExist to ensure that it
+// does not overwrite an existing blob.
+//
+// If a set precondition is not satisfied, the operation fails with an error
+// for which gcerrors.Code will return gcerrors.FailedPrecondition.
+// Not all preconditions are valid for every operation; see the Conditions
+// field of each options struct.
+type Conditions struct {
+	// IfNotExist requires that no blob exists at the key.
+	IfNotExist bool
+
+	// IfMatch, if not empty, requires that the blob exists and that its ETag
+	// (see Attributes.ETag) equals IfMatch.
+	IfMatch string
+
+	// IfModifiedSince, if not zero, requires that the blob was last modified
+	// after IfModifiedSince. Some providers only compare whole seconds.
+	IfModifiedSince time.Time
+}
+
+// toDriver validates c for the operation op and converts it to
+// driver.Conditions. It returns nil if no preconditions are set.
+func (c *Conditions) toDriver(op string, allowNotExist, allowModifiedSince bool) (*driver.Conditions, error) {
+	if c == nil || *c == (Conditions{}) {
+		return nil, nil
+	}
+	if c.IfNotExist && !allowNotExist {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s does not support Conditions.IfNotExist", op)
+	}
+	if !c.IfModifiedSince.IsZero() && !allowModifiedSince {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s does not support Conditions.IfModifiedSince", op)
+	}
+	if c.IfNotExist && c.IfMatch != "" {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Conditions.IfNotExist and Conditions.IfMatch are mutually exclusive")
+	}
+	return &driver.Conditions{
+		IfNotExist:      c.IfNotExist,
+		IfMatch:         c.IfMatch,
+		IfModifiedSince: c.IfModifiedSince,
+	}, nil
 }
 
 // BucketURLOpener represents types that can open buckets based on a URL.
@@ -1028,21 +2667,47 @@ func (mux *URLMux) RegisterBucket(scheme string, opener BucketURLOpener) {
 // OpenBucket calls OpenBucketURL with the URL parsed from urlstr.
 // OpenBucket is safe to call from multiple goroutines.
 func (mux *URLMux) OpenBucket(ctx context.Context, urlstr string) (*Bucket, error) {
-	opener, u, err := mux.schemes.FromString("Bucket", urlstr)
+	_, u, err := mux.schemes.FromString("Bucket", urlstr)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(BucketURLOpener).OpenBucketURL(ctx, u)
+	return mux.OpenBucketURL(ctx, u)
 }
 
 // OpenBucketURL dispatches the URL to the opener that is registered with the
 // URL's scheme. OpenBucketURL is safe to call from multiple goroutines.
+//
+// The "retry_" query parameters described in gcerrors.RetryPolicy and the
+// "limit_" query parameters described in Limits are removed from the URL
+// before it is dispatched, and set the retry policy and the limits of the
+// bucket.
 func (mux *URLMux) OpenBucketURL(ctx context.Context, u *url.URL) (*Bucket, error) {
+	policy, u, err := openurl.RetryPolicyFromURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("open blob.Bucket: %v", err)
+	}
+	limits, u, err := limitsFromURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("open blob.Bucket: %v", err)
+	}
 	opener, err := mux.schemes.FromURL("Bucket", u)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(BucketURLOpener).OpenBucketURL(ctx, u)
+	b, err := opener.(BucketURLOpener).OpenBucketURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		b.SetRetryPolicy(policy)
+	}
+	if limits != nil {
+		if err := b.SetLimits(limits); err != nil {
+			b.Close()
+			return nil, err
+		}
+	}
+	return b, nil
 }
 
 var defaultURLMux = new(URLMux)
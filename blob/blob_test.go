@@ -19,15 +19,17 @@ import (
 	"context"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
-	"github.com/google/go-cmp/cmp"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/gcerr"
+	"github.com/google/go-cmp/cmp"
 )
 
 var (
@@ -98,6 +100,183 @@ func (b *fakeAttributes) ErrorCode(err error) gcerrors.ErrorCode {
 	return gcerrors.Unknown
 }
 
+// flakyBucket implements driver.Bucket. Only Attributes and Delete are
+// implemented; they fail the first failures calls with errFlaky.
+type flakyBucket struct {
+	driver.Bucket
+	failures int
+	calls    int
+}
+
+var errFlaky = errors.New("flaky")
+
+func (b *flakyBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	b.calls++
+	if b.calls <= b.failures {
+		return nil, errFlaky
+	}
+	return &driver.Attributes{}, nil
+}
+
+func (b *flakyBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	b.calls++
+	return errNotFound
+}
+
+func (b *flakyBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch err {
+	case errFlaky:
+		return gcerrors.Internal
+	case errNotFound:
+		return gcerrors.NotFound
+	}
+	return gcerrors.Unknown
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		Description string
+		Policy      *gcerrors.RetryPolicy
+		Failures    int
+		WantCode    gcerrors.ErrorCode
+		WantCalls   int
+	}{
+		{
+			Description: "no policy",
+			Failures:    1,
+			WantCode:    gcerrors.Internal,
+			WantCalls:   1,
+		},
+		{
+			Description: "succeeds after retries",
+			Policy:      &gcerrors.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			Failures:    2,
+			WantCode:    gcerrors.OK,
+			WantCalls:   3,
+		},
+		{
+			Description: "too many failures",
+			Policy:      &gcerrors.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			Failures:    5,
+			WantCode:    gcerrors.Internal,
+			WantCalls:   3,
+		},
+		{
+			Description: "code not retried",
+			Policy:      &gcerrors.RetryPolicy{MaxAttempts: 3, Codes: []gcerrors.ErrorCode{gcerrors.Unknown}},
+			Failures:    5,
+			WantCode:    gcerrors.Internal,
+			WantCalls:   1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Description, func(t *testing.T) {
+			drv := &flakyBucket{failures: test.Failures}
+			b := NewBucket(drv)
+			b.SetRetryPolicy(test.Policy)
+			_, err := b.Attributes(ctx, "key")
+			if got := gcerrors.Code(err); got != test.WantCode {
+				t.Errorf("got code %v (%v) want %v", got, err, test.WantCode)
+			}
+			if drv.calls != test.WantCalls {
+				t.Errorf("got %d calls want %d", drv.calls, test.WantCalls)
+			}
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		drv := &flakyBucket{}
+		b := NewBucket(drv)
+		b.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: 3})
+		if err := b.Delete(ctx, "key"); gcerrors.Code(err) != gcerrors.NotFound {
+			t.Errorf("got error %v want NotFound", err)
+		}
+		if drv.calls != 1 {
+			t.Errorf("got %d calls want 1", drv.calls)
+		}
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		drv := &flakyBucket{failures: 5}
+		b := NewBucket(drv)
+		b.SetRetryPolicy(&gcerrors.RetryPolicy{InitialBackoff: time.Hour})
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := b.Attributes(ctx, "key"); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+			t.Errorf("got error %v want DeadlineExceeded", err)
+		}
+	})
+}
+
+// rewrittenBucket implements driver.Bucket. Its blob is gzip-encoded until
+// the first read fails with errFlaky; then it is rewritten uncompressed.
+type rewrittenBucket struct {
+	driver.Bucket
+	reads []driver.ReaderOptions
+}
+
+func (b *rewrittenBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	if len(b.reads) == 0 {
+		return &driver.Attributes{ContentEncoding: "gzip", ETag: "1"}, nil
+	}
+	return &driver.Attributes{ETag: "2", Size: 5}, nil
+}
+
+func (b *rewrittenBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.reads = append(b.reads, *opts)
+	if len(b.reads) == 1 {
+		return nil, errFlaky
+	}
+	return &fakeReader{Reader: strings.NewReader("hello"), attrs: driver.ReaderAttributes{Size: 5}}, nil
+}
+
+func (b *rewrittenBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if err == errFlaky {
+		return gcerrors.Internal
+	}
+	return gcerrors.Unknown
+}
+
+type fakeReader struct {
+	io.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *fakeReader) Close() error                         { return nil }
+func (r *fakeReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+func (r *fakeReader) As(interface{}) bool                  { return false }
+
+// TestRetryDecompress checks that each attempt of a decompressing read uses
+// the attributes of the blob at that time.
+func TestRetryDecompress(t *testing.T) {
+	ctx := context.Background()
+	drv := &rewrittenBucket{}
+	b := NewBucket(drv)
+	b.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	r, err := b.NewReader(ctx, "key", &ReaderOptions{Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q want %q", got, "hello")
+	}
+	if len(drv.reads) != 2 {
+		t.Fatalf("got %d reads want 2", len(drv.reads))
+	}
+	if first := drv.reads[0]; !first.Raw || first.Conditions == nil || first.Conditions.IfMatch != "1" {
+		t.Errorf("got first read %+v, want Raw with IfMatch %q", first, "1")
+	}
+	if second := drv.reads[1]; second.Raw || second.Conditions != nil {
+		t.Errorf("got second read %+v, want no Raw or Conditions for the uncompressed blob", second)
+	}
+}
+
 // Verify that ListIterator works even if driver.ListPaged returns empty pages.
 func TestListIterator(t *testing.T) {
 	ctx := context.Background()
@@ -128,11 +307,37 @@ func TestListIterator(t *testing.T) {
 	}
 }
 
+// Verify that ListPage returns each page with its token, and a nil token
+// after the last page.
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&fakeLister{pages: [][]string{{"a", "b"}, {"c"}}, lastPage: true})
+	objs, token, err := b.ListPage(ctx, nil, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 || objs[0].Key != "a" || objs[1].Key != "b" || token == nil {
+		t.Errorf("got first page %v, token %v, want [a b] and a token", objs, token)
+	}
+	objs, token, err = b.ListPage(ctx, token, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "c" || token != nil {
+		t.Errorf("got second page %v, token %v, want [c] and a nil token", objs, token)
+	}
+	if _, _, err := b.ListPage(ctx, nil, -1, nil); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v for negative pageSize, want InvalidArgument", err)
+	}
+}
+
 // fakeLister implements driver.Bucket. Only ListPaged is implemented,
 // returning static data from pages.
 type fakeLister struct {
 	driver.Bucket
 	pages [][]string
+	// lastPage makes the last of pages return an empty NextPageToken.
+	lastPage bool
 }
 
 func (b *fakeLister) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
@@ -145,14 +350,17 @@ func (b *fakeLister) ListPaged(ctx context.Context, opts *driver.ListOptions) (*
 	for _, key := range page {
 		objs = append(objs, &driver.ListObject{Key: key})
 	}
+	if b.lastPage && len(b.pages) == 0 {
+		return &driver.ListPage{Objects: objs}, nil
+	}
 	return &driver.ListPage{Objects: objs, NextPageToken: []byte{1}}, nil
 }
 
 // erroringBucket implements driver.Bucket. All interface methods that return
 // errors are implemented, and return errFake.
-// In addition, when passed the key "work", NewRangedReader and NewTypedWriter
-// will return a Reader/Writer respectively, that always return errFake
-// from Read/Write and Close.
+// In addition, when passed the key "work", NewRangedReader, NewTypedWriter
+// and NewMultipartUpload will return a Reader/Writer/MultipartUpload
+// respectively, that always return errFake from their methods.
 type erroringBucket struct {
 	driver.Bucket
 }
@@ -181,6 +389,26 @@ func (r *erroringWriter) Close() error {
 	return errFake
 }
 
+type erroringMultipartUpload struct {
+	driver.MultipartUpload
+}
+
+func (u *erroringMultipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	return errFake
+}
+
+func (u *erroringMultipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	return nil, errFake
+}
+
+func (u *erroringMultipartUpload) Complete(ctx context.Context) error {
+	return errFake
+}
+
+func (u *erroringMultipartUpload) Abort(ctx context.Context) error {
+	return errFake
+}
+
 func (b *erroringBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
 	return nil, errFake
 }
@@ -207,14 +435,60 @@ func (b *erroringBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *
 	return errFake
 }
 
-func (b *erroringBucket) Delete(ctx context.Context, key string) error {
+func (b *erroringBucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	return errFake
+}
+
+func (b *erroringBucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	return errFake
+}
+
+func (b *erroringBucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	return nil, errFake
+}
+
+func (b *erroringBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	return errFake
 }
 
+func (b *erroringBucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	return nil, errFake
+}
+
 func (b *erroringBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	return "", errFake
 }
 
+func (b *erroringBucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	if key == "work" {
+		return &erroringMultipartUpload{}, nil
+	}
+	return nil, errFake
+}
+
+func (b *erroringBucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	return nil, errFake
+}
+
+func (b *erroringBucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	if opts.Prefix == "work" {
+		return &erroringWatcher{}, nil
+	}
+	return nil, errFake
+}
+
+type erroringWatcher struct {
+	driver.Watcher
+}
+
+func (w *erroringWatcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	return nil, errFake
+}
+
+func (w *erroringWatcher) Close() error {
+	return errFake
+}
+
 func (b *erroringBucket) Close() error {
 	return errFake
 }
@@ -252,6 +526,8 @@ func TestErrorsAreWrapped(t *testing.T) {
 	iter := b.List(nil)
 	_, err = iter.Next(ctx)
 	verifyWrap("ListIterator.Next", err)
+	_, _, err = b.ListPage(ctx, nil, 0, nil)
+	verifyWrap("ListPage", err)
 
 	_, err = b.NewRangeReader(ctx, "", 0, 1, nil)
 	verifyWrap("NewRangeReader", err)
@@ -290,12 +566,59 @@ func TestErrorsAreWrapped(t *testing.T) {
 	err = b.Copy(ctx, "", "", nil)
 	verifyWrap("Copy", err)
 
+	err = b.Compose(ctx, "", []string{""}, &ComposeOptions{ContentType: "foo"})
+	verifyWrap("Compose", err)
+
+	err = b.UpdateAttributes(ctx, "", nil)
+	verifyWrap("UpdateAttributes", err)
+
+	_, err = b.ListVersions(ctx, "")
+	verifyWrap("ListVersions", err)
+
 	err = b.Delete(ctx, "")
 	verifyWrap("Delete", err)
 
+	err = b.DeleteMany(ctx, []string{"foo"})
+	verifyWrap("DeleteMany", err)
+
 	_, err = b.SignedURL(ctx, "", nil)
 	verifyWrap("SignedURL", err)
 
+	_, err = b.NewMultipartUpload(ctx, "", nil)
+	verifyWrap("NewMultipartUpload", err)
+
+	_, err = b.ResumeMultipartUpload(ctx, "", "id", nil)
+	verifyWrap("ResumeMultipartUpload", err)
+
+	_, err = b.Watch(ctx, nil)
+	verifyWrap("Watch", err)
+
+	wt, _ := b.Watch(ctx, &WatchOptions{Prefix: "work"})
+	_, err = wt.Next(ctx)
+	verifyWrap("Watcher.Next", err)
+
+	err = wt.Close()
+	verifyWrap("Watcher.Close", err)
+
+	u, _ := b.NewMultipartUpload(ctx, "work", nil)
+	err = u.UploadPart(ctx, 1, buf)
+	verifyWrap("MultipartUpload.UploadPart", err)
+
+	_, err = u.Parts(ctx)
+	verifyWrap("MultipartUpload.Parts", err)
+
+	err = u.Complete(ctx)
+	verifyWrap("MultipartUpload.Complete", err)
+
+	err = u.Abort(ctx)
+	verifyWrap("MultipartUpload.Abort", err)
+
+	// With MaxConcurrency, the Writer uses driver.NewMultipartUpload.
+	w, _ = b.NewWriter(ctx, "work", &WriterOptions{ContentType: "foo", MaxConcurrency: 2})
+	_, _ = w.Write(buf)
+	err = w.Close()
+	verifyWrap("Writer.Close (MaxConcurrency)", err)
+
 	err = b.Close()
 	verifyWrap("Close", err)
 }
@@ -305,6 +628,171 @@ var (
 	testOpenGot  *url.URL
 )
 
+// TestInvalidConditions verifies that preconditions that don't apply to an
+// operation are rejected before calling the driver.
+func TestInvalidConditions(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&erroringBucket{})
+	defer b.Close()
+
+	notExist := &Conditions{IfNotExist: true}
+	modSince := &Conditions{IfModifiedSince: time.Now()}
+	both := &Conditions{IfNotExist: true, IfMatch: "etag"}
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"NewRangeReader IfNotExist", func() error {
+			_, err := b.NewRangeReader(ctx, "work", 0, -1, &ReaderOptions{Conditions: notExist})
+			return err
+		}},
+		{"NewWriter IfModifiedSince", func() error {
+			_, err := b.NewWriter(ctx, "work", &WriterOptions{Conditions: modSince})
+			return err
+		}},
+		{"NewWriter IfNotExist and IfMatch", func() error {
+			_, err := b.NewWriter(ctx, "work", &WriterOptions{Conditions: both})
+			return err
+		}},
+		{"Copy IfModifiedSince", func() error {
+			return b.Copy(ctx, "work", "work", &CopyOptions{Conditions: modSince})
+		}},
+		{"Compose IfModifiedSince", func() error {
+			return b.Compose(ctx, "work", []string{"work"}, &ComposeOptions{Conditions: modSince})
+		}},
+		{"UpdateAttributes IfNotExist", func() error {
+			return b.UpdateAttributes(ctx, "work", &UpdateAttributesOptions{Conditions: notExist})
+		}},
+		{"UpdateAttributes IfModifiedSince", func() error {
+			return b.UpdateAttributes(ctx, "work", &UpdateAttributesOptions{Conditions: modSince})
+		}},
+		{"Delete IfNotExist", func() error {
+			return b.DeleteWithOptions(ctx, "work", &DeleteOptions{Conditions: notExist})
+		}},
+		{"Delete IfModifiedSince", func() error {
+			return b.DeleteWithOptions(ctx, "work", &DeleteOptions{Conditions: modSince})
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := gcerrors.Code(test.fn()); got != gcerrors.InvalidArgument {
+				t.Errorf("got error code %v, want %v", got, gcerrors.InvalidArgument)
+			}
+		})
+	}
+}
+
+// TestInvalidCompression verifies that invalid uses of
+// WriterOptions.Compression and ReaderOptions.Decompress are rejected before
+// calling the driver.
+func TestInvalidCompression(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&erroringBucket{})
+	defer b.Close()
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"NewWriter unregistered", func() error {
+			_, err := b.NewWriter(ctx, "work", &WriterOptions{Compression: "no-such-encoding"})
+			return err
+		}},
+		{"NewWriter ContentEncoding", func() error {
+			_, err := b.NewWriter(ctx, "work", &WriterOptions{Compression: "gzip", ContentEncoding: "br"})
+			return err
+		}},
+		{"NewMultipartUpload", func() error {
+			_, err := b.NewMultipartUpload(ctx, "work", &WriterOptions{Compression: "gzip"})
+			return err
+		}},
+		{"NewRangeReader Version", func() error {
+			_, err := b.NewRangeReader(ctx, "work", 0, -1, &ReaderOptions{Decompress: true, Version: "1"})
+			return err
+		}},
+		{"NewRangeReader Raw", func() error {
+			_, err := b.NewRangeReader(ctx, "work", 0, -1, &ReaderOptions{Decompress: true, Raw: true})
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := gcerrors.Code(test.fn()); got != gcerrors.InvalidArgument {
+				t.Errorf("got error code %v, want %v", got, gcerrors.InvalidArgument)
+			}
+		})
+	}
+}
+
+// TestInvalidAppend verifies that WriterOptions.Append is rejected where it
+// isn't supported, before calling the driver.
+func TestInvalidAppend(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&erroringBucket{})
+	defer b.Close()
+
+	if _, err := b.NewWriter(ctx, "work", &WriterOptions{Append: true, Compression: "gzip"}); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("NewWriter with Compression: got error %v, want InvalidArgument", err)
+	}
+	if _, err := b.NewMultipartUpload(ctx, "work", &WriterOptions{Append: true}); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("NewMultipartUpload: got error %v, want InvalidArgument", err)
+	}
+}
+
+// TestInvalidWalkOptions verifies that invalid WalkOptions are rejected
+// before listing.
+func TestInvalidWalkOptions(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&erroringBucket{})
+	defer b.Close()
+
+	fn := func(context.Context, *ListObject) error { return nil }
+	tests := []struct {
+		name string
+		opts *WalkOptions
+		fn   WalkFunc
+	}{
+		{"invalid Prefix", &WalkOptions{Prefix: "\xff"}, fn},
+		{"invalid Glob", &WalkOptions{Glob: "["}, fn},
+		{"negative Concurrency", &WalkOptions{Concurrency: -1}, fn},
+		{"nil fn", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := b.Walk(ctx, test.opts, test.fn); gcerrors.Code(err) != gcerrors.InvalidArgument {
+				t.Errorf("got error %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
+
+// TestInvalidUpdateAttributes verifies that invalid UpdateAttributesOptions
+// are rejected before calling the driver.
+func TestInvalidUpdateAttributes(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&erroringBucket{})
+	defer b.Close()
+
+	empty := ""
+	tests := []struct {
+		name string
+		opts *UpdateAttributesOptions
+	}{
+		{"empty ContentType", &UpdateAttributesOptions{ContentType: &empty}},
+		{"empty Metadata key", &UpdateAttributesOptions{Metadata: map[string]string{"": "v"}}},
+		{"empty DeleteMetadata key", &UpdateAttributesOptions{DeleteMetadata: []string{""}}},
+		{"key set and deleted", &UpdateAttributesOptions{Metadata: map[string]string{"Foo": "v"}, DeleteMetadata: []string{"fOO"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := b.UpdateAttributes(ctx, "work", test.opts)
+			if got := gcerrors.Code(err); got != gcerrors.InvalidArgument {
+				t.Errorf("got error code %v, want %v", got, gcerrors.InvalidArgument)
+			}
+		})
+	}
+}
+
 // TestBucketIsClosed verifies that all Bucket functions return an error
 // if the Bucket is closed.
 func TestBucketIsClosed(t *testing.T) {
@@ -321,6 +809,9 @@ func TestBucketIsClosed(t *testing.T) {
 	if _, err := iter.Next(ctx); err != errClosed {
 		t.Error(err)
 	}
+	if _, _, err := bucket.ListPage(ctx, nil, 0, nil); err != errClosed {
+		t.Error(err)
+	}
 
 	if _, err := bucket.NewRangeReader(ctx, "", 0, 1, nil); err != errClosed {
 		t.Error(err)
@@ -340,12 +831,39 @@ func TestBucketIsClosed(t *testing.T) {
 	if err := bucket.Copy(ctx, "", "", nil); err != errClosed {
 		t.Error(err)
 	}
+	if err := bucket.Compose(ctx, "", []string{""}, nil); err != errClosed {
+		t.Error(err)
+	}
+	if err := bucket.UpdateAttributes(ctx, "", nil); err != errClosed {
+		t.Error(err)
+	}
+	if _, err := bucket.ListVersions(ctx, ""); err != errClosed {
+		t.Error(err)
+	}
 	if err := bucket.Delete(ctx, ""); err != errClosed {
 		t.Error(err)
 	}
+	if err := bucket.DeleteWithOptions(ctx, "", nil); err != errClosed {
+		t.Error(err)
+	}
+	if err := bucket.DeleteMany(ctx, nil); err != errClosed {
+		t.Error(err)
+	}
+	if err := bucket.DeletePrefix(ctx, ""); err != errClosed {
+		t.Error(err)
+	}
 	if _, err := bucket.SignedURL(ctx, "", nil); err != errClosed {
 		t.Error(err)
 	}
+	if _, err := bucket.NewMultipartUpload(ctx, "", nil); err != errClosed {
+		t.Error(err)
+	}
+	if _, err := bucket.ResumeMultipartUpload(ctx, "", "id", nil); err != errClosed {
+		t.Error(err)
+	}
+	if _, err := bucket.Watch(ctx, nil); err != errClosed {
+		t.Error(err)
+	}
 	if err := bucket.Close(); err != errClosed {
 		t.Error(err)
 	}
@@ -373,6 +891,7 @@ func TestURLMux(t *testing.T) {
 		name    string
 		url     string
 		wantErr bool
+		wantURL string // the URL passed to the opener, if not url
 	}{
 		{
 			name:    "empty URL",
@@ -426,6 +945,26 @@ func TestURLMux(t *testing.T) {
 			name: "using api+type scheme prefix",
 			url:  "blob+bucket+foo:///foo/bar/baz",
 		},
+		{
+			name:    "retry options",
+			url:     "foo://mybucket?retry_max_attempts=3&x=a&retry_codes=Unknown",
+			wantURL: "foo://mybucket?x=a",
+		},
+		{
+			name:    "invalid retry options",
+			url:     "foo://mybucket?retry_max_attempts=x",
+			wantErr: true,
+		},
+		{
+			name:    "limit options",
+			url:     "foo://mybucket?limit_concurrency=2&x=a&limit_read=0.5",
+			wantURL: "foo://mybucket?x=a",
+		},
+		{
+			name:    "invalid limit options",
+			url:     "foo://mybucket?limit_read=0",
+			wantErr: true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			_, gotErr := mux.OpenBucket(ctx, tc.url)
@@ -435,8 +974,12 @@ func TestURLMux(t *testing.T) {
 			if gotErr != nil {
 				return
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			wantURL := tc.wantURL
+			if wantURL == "" {
+				wantURL = tc.url
+			}
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 			// Repeat with OpenBucketURL.
 			parsed, err := url.Parse(tc.url)
@@ -447,8 +990,8 @@ func TestURLMux(t *testing.T) {
 			if gotErr != nil {
 				t.Fatalf("got err %v want nil", gotErr)
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 		})
 	}
@@ -463,5 +1006,5 @@ func (o *fakeOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*Bucket, er
 		return nil, errors.New("fail")
 	}
 	o.u = u
-	return nil, nil
+	return &Bucket{}, nil
 }
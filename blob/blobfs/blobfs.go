@@ -0,0 +1,322 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobfs exposes a *blob.Bucket as a read-only file system, for use
+// with code that expects an fs.FS, like html/template.ParseFS, or, through
+// http.FS, an http.FileSystem, like http.FileServer.
+//
+// File names are blob keys. Directories are synthesized from listings with
+// the delimiter "/": a directory exists if at least one blob key begins with
+// its name followed by "/". Directories have no modification time. Blobs
+// whose keys aren't valid file names, like keys beginning or ending with "/"
+// or containing "//", can't be opened, and are left out of directory
+// listings. If a blob's key is also the name of a directory, it is opened as
+// a file.
+//
+// Files implement io.Seeker and io.ReaderAt using blob.RandomAccessReader.
+// The Sys method of the fs.FileInfo of a file returns its *blob.Attributes,
+// or, for entries of a directory listing, its *blob.ListObject.
+package blobfs // import "github.com/eliben/gocdkx/blob/blobfs"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+// defaultReadAhead is the default value of Options.ReadAhead.
+const defaultReadAhead = 1024 * 1024
+
+// Options sets options for New.
+type Options struct {
+	// ReadAhead is the minimum number of bytes that files read from the
+	// provider at once; see blob.RandomAccessReaderOptions.ReadAhead.
+	// Defaults to 1 MiB; a negative value disables read ahead.
+	ReadAhead int
+}
+
+// FS is a read-only fs.FS backed by a *blob.Bucket. It also implements
+// fs.StatFS, fs.ReadFileFS and fs.ReadDirFS.
+type FS struct {
+	ctx       context.Context
+	b         *blob.Bucket
+	readAhead int
+}
+
+// New returns an FS that reads from b. ctx is used for all calls to the
+// bucket, since the fs.FS interface has no context. A nil Options is
+// treated the same as the zero value.
+func New(ctx context.Context, b *blob.Bucket, opts *Options) *FS {
+	if opts == nil {
+		opts = &Options{}
+	}
+	readAhead := opts.ReadAhead
+	if readAhead == 0 {
+		readAhead = defaultReadAhead
+	} else if readAhead < 0 {
+		readAhead = 0
+	}
+	return &FS{ctx: ctx, b: b, readAhead: readAhead}
+}
+
+// Open implements fs.FS.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dir{fsys: fsys, name: name, info: info}, nil
+	}
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+// Stat implements fs.StatFS.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ReadFile implements fs.ReadFileFS, reading the blob in a single request.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := fsys.b.ReadAll(fsys.ctx, name)
+	if err == nil {
+		return data, nil
+	}
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		// Report directories like os.ReadFile does.
+		if info, serr := fsys.stat("read", name); serr == nil && info.IsDir() {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+		}
+	}
+	return nil, pathError("read", name, err)
+}
+
+// ReadDir implements fs.ReadDirFS. The entries are sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, ok := f.(*dir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	entries, err := d.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, err
+}
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// pathError returns a *fs.PathError for an error from the bucket, using the
+// errors of package fs where they apply.
+func pathError(op, name string, err error) error {
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		err = fs.ErrNotExist
+	case gcerrors.PermissionDenied:
+		err = fs.ErrPermission
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// stat returns the fileInfo of the file or directory name.
+func (fsys *FS) stat(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fileInfo{name: ".", isDir: true}, nil
+	}
+	attrs, err := fsys.b.Attributes(fsys.ctx, name)
+	if err == nil {
+		return &fileInfo{name: path.Base(name), size: attrs.Size, modTime: attrs.ModTime, sys: attrs}, nil
+	}
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, pathError(op, name, err)
+	}
+	// Not a blob; check if it is a directory.
+	iter := fsys.b.List(&blob.ListOptions{Prefix: name + "/", Delimiter: "/"})
+	if _, err := iter.Next(fsys.ctx); err == io.EOF {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, pathError(op, name, err)
+	}
+	return &fileInfo{name: path.Base(name), isDir: true}, nil
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+	sys     interface{}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return fi.sys }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *fileInfo) Type() fs.FileMode          { return fi.Mode().Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// file is an open blob. It implements fs.File, io.Seeker and io.ReaderAt.
+type file struct {
+	fsys *FS
+	name string
+	info *fileInfo
+
+	mu sync.Mutex
+	r  *blob.RandomAccessReader // created by the first read
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// reader returns the reader of the file, creating it if needed.
+func (f *file) reader(op string) (*blob.RandomAccessReader, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.r == nil {
+		r, err := f.fsys.b.NewRandomAccessReader(f.fsys.ctx, f.name, &blob.RandomAccessReaderOptions{ReadAhead: f.fsys.readAhead})
+		if err != nil {
+			return nil, pathError(op, f.name, err)
+		}
+		f.r = r
+	}
+	return f.r, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	r, err := f.reader("read")
+	if err != nil {
+		return 0, err
+	}
+	return r.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	r, err := f.reader("read")
+	if err != nil {
+		return 0, err
+	}
+	return r.ReadAt(p, off)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	r, err := f.reader("seek")
+	if err != nil {
+		return 0, err
+	}
+	return r.Seek(offset, whence)
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.r == nil {
+		return nil
+	}
+	return f.r.Close()
+}
+
+// dir is an open directory. It implements fs.ReadDirFile.
+type dir struct {
+	fsys *FS
+	name string
+	info *fileInfo
+	iter *blob.ListIterator // created by the first call to ReadDir
+	done bool
+	// files holds the names of the files listed so far, since a blob's key
+	// can also be the name of a directory, which is listed after it.
+	files map[string]bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *dir) Close() error { return nil }
+
+// ReadDir implements fs.ReadDirFile. Entries are returned in the order they
+// are listed.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	prefix := d.name + "/"
+	if d.name == "." {
+		prefix = ""
+	}
+	if d.iter == nil {
+		d.iter = d.fsys.b.List(&blob.ListOptions{Prefix: prefix, Delimiter: "/"})
+		d.files = map[string]bool{}
+	}
+	var entries []fs.DirEntry
+	for !d.done && (n <= 0 || len(entries) < n) {
+		obj, err := d.iter.Next(d.fsys.ctx)
+		if err == io.EOF {
+			d.done = true
+			break
+		}
+		if err != nil {
+			return entries, pathError("readdir", d.name, err)
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/")
+		if name == "" || name == "." || strings.Contains(name, "/") || !fs.ValidPath(name) {
+			continue
+		}
+		if obj.IsDir {
+			if d.files[name] {
+				continue
+			}
+			entries = append(entries, &fileInfo{name: name, isDir: true})
+		} else {
+			d.files[name] = true
+			entries = append(entries, &fileInfo{name: name, size: obj.Size, modTime: obj.ModTime, sys: obj})
+		}
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
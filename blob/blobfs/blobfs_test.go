@@ -0,0 +1,155 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobfs
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/memblob"
+)
+
+// newBucket returns a bucket holding keys, with the key as content.
+func newBucket(t *testing.T, keys ...string) *blob.Bucket {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	for _, key := range keys {
+		if err := b.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func TestFS(t *testing.T) {
+	b := newBucket(t, "a.txt", "dir/b.txt", "dir/sub/c.txt", "other/d.txt")
+	defer b.Close()
+
+	for _, readAhead := range []int{0, -1, 4} {
+		fsys := New(context.Background(), b, &Options{ReadAhead: readAhead})
+		if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "other/d.txt"); err != nil {
+			t.Errorf("readAhead %d: %v", readAhead, err)
+		}
+	}
+}
+
+func TestFSInvalidKeys(t *testing.T) {
+	// Keys that aren't valid file names are left out, and a key that is also
+	// the name of a directory is a file.
+	b := newBucket(t, "a", "a/b.txt", "/leading", "double//slash", "dir/", "dir/c.txt")
+	defer b.Close()
+	fsys := New(context.Background(), b, nil)
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+		if e.Name() == "a" && e.IsDir() {
+			t.Errorf("got directory entry for %q, want file", e.Name())
+		}
+	}
+	if want := "a dir double"; strings.Join(got, " ") != want {
+		t.Errorf("got entries %q want %q", got, want)
+	}
+	if data, err := fs.ReadFile(fsys, "a"); err != nil || string(data) != "a" {
+		t.Errorf("got %q, %v want %q", data, err, "a")
+	}
+	if _, err := fsys.Open("/leading"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("got error %v opening an invalid name, want ErrInvalid", err)
+	}
+	if _, err := fsys.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v opening a missing file, want ErrNotExist", err)
+	}
+	if _, err := fsys.ReadFile("dir"); err == nil {
+		t.Error("got no error reading a directory")
+	}
+}
+
+func TestParseFS(t *testing.T) {
+	b := newBucket(t)
+	defer b.Close()
+	ctx := context.Background()
+	if err := b.WriteAll(ctx, "templates/hello.tmpl", []byte("Hello, {{.}}!"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := template.ParseFS(New(ctx, b, nil), "templates/*.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := tmpl.ExecuteTemplate(&sb, "hello.tmpl", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sb.String(), "Hello, world!"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	b := newBucket(t, "static/index.html", "static/style.css")
+	defer b.Close()
+
+	srv := httptest.NewServer(http.FileServer(http.FS(New(context.Background(), b, nil))))
+	defer srv.Close()
+
+	for path, want := range map[string]string{
+		"/static/style.css": "static/style.css",
+		"/static/":          "static/index.html",
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(got) != want {
+			t.Errorf("%s: got %d %q want %q", path, resp.StatusCode, got, want)
+		}
+	}
+
+	req, err := http.NewRequest("GET", srv.URL+"/static/style.css", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=7-9")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || string(got) != "sty" {
+		t.Errorf("range request: got %d %q want %d %q", resp.StatusCode, got, http.StatusPartialContent, "sty")
+	}
+}
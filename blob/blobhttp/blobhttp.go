@@ -0,0 +1,350 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobhttp provides an http.Handler that serves blobs from a
+// *blob.Bucket.
+//
+// The handler sets the Content-Type, Cache-Control, Content-Disposition,
+// Content-Encoding and Content-Language headers of responses from the blob's
+// Attributes. It supports Range requests, and conditional requests using
+// ETag, If-None-Match, Last-Modified and If-Modified-Since.
+//
+// By default, the key of the blob is the URL path without the leading "/";
+// use http.StripPrefix to serve a bucket under a path prefix. If
+// Options.URLVerifier is set, the handler instead serves signed URLs, such as
+// the ones produced by fileblob's URLSignerHMAC, taking the key from the
+// signed URL.
+package blobhttp // import "github.com/eliben/gocdkx/blob/blobhttp"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+// URLVerifier verifies signed URLs. fileblob.URLSigner implements it.
+type URLVerifier interface {
+	// KeyFromURL returns the key of the blob that surl grants access to, or
+	// an error if surl is expired or not authentic.
+	KeyFromURL(ctx context.Context, surl *url.URL) (string, error)
+}
+
+// Options sets options for NewHandler.
+type Options struct {
+	// URLVerifier, if set, makes the handler serve only signed URLs verified
+	// by it. The signed URL must carry the HTTP method it was signed for, and
+	// optionally the required Content-Type of an upload, in the "method" and
+	// "contentType" query parameters, covered by the signature, as produced
+	// by fileblob.URLSignerHMAC. URLs signed for "PUT" and "DELETE" can be
+	// used to write and delete the blob.
+	URLVerifier URLVerifier
+}
+
+// NewHandler returns an http.Handler that serves blobs from b.
+// A nil Options is treated the same as the zero value.
+func NewHandler(b *blob.Bucket, opts *Options) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &handler{b: b, opts: opts}
+}
+
+type handler struct {
+	b    *blob.Bucket
+	opts *Options
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	method := r.Method
+	if h.opts.URLVerifier != nil {
+		var err error
+		key, err = h.opts.URLVerifier.KeyFromURL(r.Context(), r.URL)
+		if err != nil {
+			http.Error(w, "invalid signed URL", http.StatusForbidden)
+			return
+		}
+		// A URL signed for GET can also be used for HEAD.
+		signed := r.URL.Query().Get("method")
+		if signed == "" {
+			signed = http.MethodGet
+		}
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if method != signed {
+			http.Error(w, "method not allowed by signed URL", http.StatusForbidden)
+			return
+		}
+		switch method {
+		case http.MethodPut:
+			ct := r.URL.Query().Get("contentType")
+			if ct != "" && r.Header.Get("Content-Type") != ct {
+				http.Error(w, "Content-Type not allowed by signed URL", http.StatusForbidden)
+				return
+			}
+			h.put(w, r, key)
+			return
+		case http.MethodDelete:
+			h.delete(w, r, key)
+			return
+		}
+	}
+	if method != http.MethodGet && method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	h.get(w, r, key)
+}
+
+// get serves a GET or HEAD request for key.
+func (h *handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	ctx := r.Context()
+	attrs, err := h.b.Attributes(ctx, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	modTime := attrs.ModTime.UTC().Truncate(time.Second)
+	etag := httpETag(attrs.ETag)
+	hdr := w.Header()
+	setHeader(hdr, "Content-Type", attrs.ContentType)
+	setHeader(hdr, "Cache-Control", attrs.CacheControl)
+	setHeader(hdr, "Content-Disposition", attrs.ContentDisposition)
+	setHeader(hdr, "Content-Encoding", attrs.ContentEncoding)
+	setHeader(hdr, "Content-Language", attrs.ContentLanguage)
+	setHeader(hdr, "ETag", etag)
+	if !modTime.IsZero() {
+		hdr.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	}
+	hdr.Set("Accept-Ranges", "bytes")
+
+	if notModified(r, etag, modTime) {
+		// See https://tools.ietf.org/html/rfc7232#section-4.1.
+		for _, k := range []string{"Content-Type", "Content-Disposition", "Content-Encoding", "Content-Language"} {
+			hdr.Del(k)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	offset, length := int64(0), attrs.Size
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" && ifRange(r, etag, modTime) {
+		var ok bool
+		offset, length, ok = parseRange(rng, attrs.Size)
+		if !ok {
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", attrs.Size))
+			http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if offset != 0 || length != attrs.Size {
+			hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, attrs.Size))
+			status = http.StatusPartialContent
+		}
+	}
+	hdr.Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	var ropts *blob.ReaderOptions
+	if attrs.ETag != "" {
+		// Make sure that the content matches the headers.
+		ropts = &blob.ReaderOptions{Conditions: &blob.Conditions{IfMatch: attrs.ETag}}
+	}
+	rd, err := h.b.NewRangeReader(ctx, key, offset, length, ropts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rd.Close()
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, rd)
+}
+
+// put serves a PUT request for key, writing the request body to the blob.
+func (h *handler) put(w http.ResponseWriter, r *http.Request, key string) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	bw, err := h.b.NewWriter(ctx, key, &blob.WriterOptions{
+		ContentType:        r.Header.Get("Content-Type"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err := io.Copy(bw, r.Body); err != nil {
+		cancel() // cancel before Close cancels the write
+		_ = bw.Close()
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if err := bw.Close(); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete serves a DELETE request for key.
+func (h *handler) delete(w http.ResponseWriter, r *http.Request, key string) {
+	if err := h.b.Delete(r.Context(), key); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setHeader(hdr http.Header, key, value string) {
+	if value != "" {
+		hdr.Set(key, value)
+	}
+}
+
+// writeError writes an error response for err, returned from a Bucket method.
+func writeError(w http.ResponseWriter, err error) {
+	var status int
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		status = http.StatusNotFound
+	case gcerrors.InvalidArgument:
+		status = http.StatusBadRequest
+	case gcerrors.PermissionDenied:
+		status = http.StatusForbidden
+	case gcerrors.FailedPrecondition:
+		// The blob changed while the request was being served.
+		status = http.StatusConflict
+	default:
+		status = http.StatusInternalServerError
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// httpETag returns the blob ETag etag as an HTTP entity tag. ETags are opaque,
+// and some providers report them without the quotes that HTTP requires; for
+// example, gcsblob reports the generation number of the object.
+func httpETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// notModified reports whether the request's If-None-Match or
+// If-Modified-Since headers match a blob with etag and modTime, so that the
+// response should be 304 Not Modified.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// If-Modified-Since is ignored if If-None-Match is present.
+		return etag != "" && etagListMatches(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modTime.After(t)
+}
+
+// ifRange reports whether a Range header should be honored given the
+// request's If-Range header.
+func ifRange(r *http.Request, etag string, modTime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		// If-Range requires a strong comparison.
+		return etag != "" && !strings.HasPrefix(ir, "W/") && ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !modTime.IsZero() && t.Equal(modTime)
+}
+
+// etagListMatches reports whether the comma-separated list of entity tags in
+// an If-None-Match header matches etag, using a weak comparison.
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses the value of a Range header for a blob of the given
+// size, and returns the offset and length of the requested range. Requests
+// for multiple ranges are served in full. ok is false if the range can't be
+// satisfied.
+func parseRange(s string, size int64) (offset, length int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		// Unknown range units are ignored.
+		return 0, size, true
+	}
+	spec := strings.TrimSpace(s[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false
+	}
+	start, end := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if start == "" {
+		// A suffix range: the last n bytes.
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, size > 0
+	}
+	first, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || first < 0 || first >= size {
+		return 0, 0, false
+	}
+	last := size - 1
+	if end != "" {
+		last, err = strconv.ParseInt(end, 10, 64)
+		if err != nil || last < first {
+			return 0, 0, false
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	return first, last - first + 1, true
+}
@@ -0,0 +1,379 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobhttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/fileblob"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/blob/memblob"
+)
+
+const (
+	key     = "dir/hello.txt"
+	content = "hello world"
+)
+
+func newBucket(ctx context.Context, t *testing.T) *blob.Bucket {
+	b := memblob.OpenBucket(nil)
+	err := b.WriteAll(ctx, key, []byte(content), &blob.WriterOptions{
+		ContentType:        "text/plain",
+		CacheControl:       "no-cache",
+		ContentDisposition: "attachment",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	b := newBucket(ctx, t)
+	defer b.Close()
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastModified := attrs.ModTime.UTC().Format(http.TimeFormat)
+	before := attrs.ModTime.Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+		wantHeader map[string]string
+	}{
+		{
+			name:       "Get",
+			path:       "/" + key,
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+			wantHeader: map[string]string{
+				"Content-Type":        "text/plain",
+				"Cache-Control":       "no-cache",
+				"Content-Disposition": "attachment",
+				"Content-Length":      "11",
+				"ETag":                attrs.ETag,
+				"Last-Modified":       lastModified,
+			},
+		},
+		{
+			name:       "Head",
+			method:     http.MethodHead,
+			path:       "/" + key,
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"Content-Length": "11", "ETag": attrs.ETag},
+		},
+		{
+			name:       "NotFound",
+			path:       "/does-not-exist",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Post",
+			method:     http.MethodPost,
+			path:       "/" + key,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "Range",
+			path:       "/" + key,
+			header:     map[string]string{"Range": "bytes=2-5"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "llo ",
+			wantHeader: map[string]string{"Content-Range": "bytes 2-5/11", "Content-Length": "4"},
+		},
+		{
+			name:       "OpenRange",
+			path:       "/" + key,
+			header:     map[string]string{"Range": "bytes=6-"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "world",
+		},
+		{
+			name:       "SuffixRange",
+			path:       "/" + key,
+			header:     map[string]string{"Range": "bytes=-3"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "rld",
+		},
+		{
+			name:       "UnsatisfiableRange",
+			path:       "/" + key,
+			header:     map[string]string{"Range": "bytes=20-30"},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+			wantHeader: map[string]string{"Content-Range": "bytes */11"},
+		},
+		{
+			name:       "IfRangeMismatch",
+			path:       "/" + key,
+			header:     map[string]string{"Range": "bytes=2-5", "If-Range": `"stale"`},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+		{
+			name:       "IfNoneMatch",
+			path:       "/" + key,
+			header:     map[string]string{"If-None-Match": attrs.ETag},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "IfNoneMatchStale",
+			path:       "/" + key,
+			header:     map[string]string{"If-None-Match": `"stale"`},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+		{
+			name:       "IfModifiedSince",
+			path:       "/" + key,
+			header:     map[string]string{"If-Modified-Since": lastModified},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "IfModifiedSinceOlder",
+			path:       "/" + key,
+			header:     map[string]string{"If-Modified-Since": before},
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+	}
+
+	h := NewHandler(b, nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, test.path, nil)
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("got status %d want %d", rec.Code, test.wantStatus)
+			}
+			if test.wantBody != "" && rec.Body.String() != test.wantBody {
+				t.Errorf("got body %q want %q", rec.Body.String(), test.wantBody)
+			}
+			for k, want := range test.wantHeader {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("got header %s %q want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+// unquotedBucket reports the ETags of the wrapped bucket without quotes, like
+// gcsblob.
+type unquotedBucket struct {
+	wrapper.Bucket
+}
+
+func (b *unquotedBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	attrs, err := b.Bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	a := *attrs
+	a.ETag = strings.Trim(a.ETag, `"`)
+	return &a, nil
+}
+
+func (b *unquotedBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.Conditions != nil && opts.Conditions.IfMatch != "" {
+		o := *opts
+		c := *opts.Conditions
+		c.IfMatch = `"` + c.IfMatch + `"`
+		o.Conditions = &c
+		opts = &o
+	}
+	return b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+}
+
+func TestGetUnquotedETag(t *testing.T) {
+	ctx := context.Background()
+	inner := newBucket(ctx, t)
+	defer inner.Close()
+	attrs, err := inner.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := attrs.ETag // quoted by memblob
+	b := blob.NewBucket(&unquotedBucket{Bucket: wrapper.New(inner)})
+	defer b.Close()
+	h := NewHandler(b, nil)
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{"Get", nil, http.StatusOK, content},
+		{"IfNoneMatch", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+		{"IfNoneMatchWeak", map[string]string{"If-None-Match": "W/" + etag}, http.StatusNotModified, ""},
+		{"IfRange", map[string]string{"Range": "bytes=2-5", "If-Range": etag}, http.StatusPartialContent, "llo "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+key, nil)
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("got status %d want %d", rec.Code, test.wantStatus)
+			}
+			if rec.Code != http.StatusNotModified && rec.Body.String() != test.wantBody {
+				t.Errorf("got body %q want %q", rec.Body.String(), test.wantBody)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("got ETag %q want %q", got, etag)
+			}
+		})
+	}
+}
+
+func TestSignedURLs(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+	base, err := url.Parse(srv.URL + "/signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := fileblob.NewURLSignerHMAC(base, []byte("secret"))
+
+	// Serve a fileblob bucket that signs its own URLs, as an application
+	// would.
+	dir, err := ioutil.TempDir("", "blobhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := fileblob.OpenBucket(dir, &fileblob.Options{URLSigner: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = NewHandler(b, &Options{URLVerifier: signer})
+
+	sign := func(opts *blob.SignedURLOptions) string {
+		u, err := b.SignedURL(ctx, key, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	do := func(method, u, contentType, body string) int {
+		req, err := http.NewRequest(method, u, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	getURL := sign(nil)
+	if got := do(http.MethodGet, getURL, "", ""); got != http.StatusOK {
+		t.Errorf("GET: got status %d want 200", got)
+	}
+	if got := do(http.MethodGet, srv.URL+"/"+key, "", ""); got != http.StatusForbidden {
+		t.Errorf("GET unsigned: got status %d want 403", got)
+	}
+	if got := do(http.MethodDelete, getURL, "", ""); got != http.StatusForbidden {
+		t.Errorf("DELETE with GET URL: got status %d want 403", got)
+	}
+
+	putURL := sign(&blob.SignedURLOptions{Method: http.MethodPut, ContentType: "text/csv"})
+	if got := do(http.MethodPut, putURL, "text/plain", "a,b"); got != http.StatusForbidden {
+		t.Errorf("PUT with wrong Content-Type: got status %d want 403", got)
+	}
+	if got := do(http.MethodPut, putURL, "text/csv", "a,b"); got != http.StatusOK {
+		t.Errorf("PUT: got status %d want 200", got)
+	}
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/csv" || attrs.Size != 3 {
+		t.Errorf("got ContentType %q, Size %d after PUT, want %q, 3", attrs.ContentType, attrs.Size, "text/csv")
+	}
+
+	if got := do(http.MethodDelete, sign(&blob.SignedURLOptions{Method: http.MethodDelete}), "", ""); got != http.StatusNoContent {
+		t.Errorf("DELETE: got status %d want 204", got)
+	}
+	if exists, err := b.Exists(ctx, key); err != nil || exists {
+		t.Errorf("got exists %v, err %v after DELETE, want false, nil", exists, err)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+	tests := []struct {
+		in          string
+		offset, len int64
+		wantOK      bool
+	}{
+		{"bytes=0-9", 0, 10, true},
+		{"bytes=90-200", 90, 10, true},
+		{"bytes=-10", 90, 10, true},
+		{"bytes=-200", 0, 100, true},
+		{"bytes=50-", 50, 50, true},
+		{"bytes=0-1,5-6", 0, 100, true},
+		{"items=0-1", 0, 100, true},
+		{"bytes=100-", 0, 0, false},
+		{"bytes=5-1", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+		{"bytes=x-1", 0, 0, false},
+		{"bytes=1", 0, 0, false},
+	}
+	for _, test := range tests {
+		offset, length, ok := parseRange(test.in, size)
+		if ok != test.wantOK || (ok && (offset != test.offset || length != test.len)) {
+			t.Errorf("parseRange(%q): got %d, %d, %v want %d, %d, %v", test.in, offset, length, ok, test.offset, test.len, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobhttp_test
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/eliben/gocdkx/blob/blobhttp"
+	"github.com/eliben/gocdkx/blob/fileblob"
+	"github.com/eliben/gocdkx/blob/memblob"
+)
+
+func ExampleNewHandler() {
+	// Serve the blobs in a bucket under "/files/"; for example, the blob
+	// with key "a/b.txt" is served at "/files/a/b.txt".
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	http.Handle("/files/", http.StripPrefix("/files/", blobhttp.NewHandler(bucket, nil)))
+}
+
+func ExampleNewHandler_signedURLs() {
+	// Serve signed URLs for a fileblob bucket, so that the URLs returned
+	// by bucket.SignedURL resolve.
+	base, err := url.Parse("http://localhost:8080/signed")
+	if err != nil {
+		log.Fatal(err)
+	}
+	signer := fileblob.NewURLSignerHMAC(base, []byte("my secret key"))
+	bucket, err := fileblob.OpenBucket("/path/to/dir", &fileblob.Options{URLSigner: signer})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+
+	http.Handle("/signed", blobhttp.NewHandler(bucket, &blobhttp.Options{URLVerifier: signer}))
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobpubsub receives the change notifications of blob providers
+// like s3blob and gcsblob from a *pubsub.Subscription, for use with
+// Bucket.Watch.
+//
+// For example, to watch an S3 bucket whose event notifications are delivered
+// to an SQS queue:
+//
+//  sub, err := pubsub.OpenSubscription(ctx, "awssqs://...")
+//  ...
+//  b, err := s3blob.OpenBucket(ctx, sess, "mybucket", &s3blob.Options{
+//  	NotificationSource: blobpubsub.NewNotificationSource(sub),
+//  })
+//
+// As
+//
+// blobpubsub exposes the following types for As:
+//  - Watcher: *pubsub.Subscription
+//  - Event: *pubsub.Message
+package blobpubsub // import "github.com/eliben/gocdkx/blob/blobpubsub"
+
+import (
+	"context"
+
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/pubsub"
+)
+
+// NewNotificationSource returns a driver.NotificationSource that receives
+// notifications from sub. Each message is received by a single Watcher, so
+// each Watcher needs a subscription of its own. Closing a Watcher doesn't
+// shut down sub.
+func NewNotificationSource(sub *pubsub.Subscription) driver.NotificationSource {
+	return &source{sub: sub}
+}
+
+type source struct {
+	sub *pubsub.Subscription
+}
+
+// Receive implements driver.NotificationSource.Receive.
+func (s *source) Receive(ctx context.Context) (*driver.Notification, error) {
+	m, err := s.sub.Receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Notification{
+		Body:     m.Body,
+		Metadata: m.Metadata,
+		Ack:      m.Ack,
+		AsFunc: func(i interface{}) bool {
+			p, ok := i.(**pubsub.Message)
+			if !ok {
+				return false
+			}
+			*p = m
+			return true
+		},
+	}, nil
+}
+
+// As implements driver.NotificationSource.As.
+func (s *source) As(i interface{}) bool {
+	p, ok := i.(**pubsub.Subscription)
+	if !ok {
+		return false
+	}
+	*p = s.sub
+	return true
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobpubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliben/gocdkx/pubsub"
+	"github.com/eliben/gocdkx/pubsub/mempubsub"
+)
+
+func TestNotificationSource(t *testing.T) {
+	ctx := context.Background()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+	src := NewNotificationSource(sub)
+
+	md := map[string]string{"a": "b"}
+	if err := topic.Send(ctx, &pubsub.Message{Body: []byte("hello"), Metadata: md}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := src.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Ack()
+	if string(n.Body) != "hello" || n.Metadata["a"] != "b" {
+		t.Errorf("got notification %q %v, want %q %v", n.Body, n.Metadata, "hello", md)
+	}
+	var m *pubsub.Message
+	if !n.AsFunc(&m) || string(m.Body) != "hello" {
+		t.Error("Notification.AsFunc failed to get *pubsub.Message")
+	}
+	var s *pubsub.Subscription
+	if !src.As(&s) || s != sub {
+		t.Error("As failed to get *pubsub.Subscription")
+	}
+
+	// Receive returns ctx.Err() once ctx is done.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := src.Receive(cctx); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
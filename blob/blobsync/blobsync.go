@@ -0,0 +1,315 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobsync mirrors blobs from one *blob.Bucket to another. The
+// buckets can use different providers; for example, Sync can copy blobs
+// from a bucket opened with "gs://mybucket" to one opened with
+// "file:///tmp/snapshot".
+package blobsync // import "github.com/eliben/gocdkx/blob/blobsync"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/eliben/gocdkx/blob"
+)
+
+// DefaultConcurrency is the default value for Options.Concurrency.
+const DefaultConcurrency = 10
+
+// Action describes what Sync does with a blob.
+type Action int
+
+const (
+	// Copy means that the blob is copied from the source to the destination,
+	// because it is missing or different in the destination.
+	Copy Action = iota
+	// Skip means that the blob is the same in the source and the destination.
+	Skip
+	// Delete means that the blob is deleted from the destination, because it
+	// doesn't exist in the source.
+	Delete
+)
+
+func (a Action) String() string {
+	switch a {
+	case Copy:
+		return "copy"
+	case Skip:
+		return "skip"
+	case Delete:
+		return "delete"
+	default:
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+}
+
+// Event reports the progress of Sync for a single blob.
+type Event struct {
+	Action Action
+	Key    string
+	// Size is the size of the source blob for Copy and Skip, and of the
+	// destination blob for Delete.
+	Size int64
+	// Err is the error for the blob, if the action failed.
+	Err error
+}
+
+// Options sets options for Sync.
+type Options struct {
+	// Prefix restricts Sync to the blobs whose keys begin with Prefix, in
+	// both buckets.
+	Prefix string
+
+	// DryRun reports the actions that Sync would perform without changing
+	// the destination bucket.
+	DryRun bool
+
+	// DeleteExtraneous deletes blobs under Prefix in the destination bucket
+	// that don't exist in the source bucket.
+	DeleteExtraneous bool
+
+	// Concurrency is the maximum number of blobs copied concurrently.
+	// Defaults to DefaultConcurrency.
+	Concurrency int
+
+	// Progress, if not nil, is called for each blob after its action has
+	// completed, or been planned if DryRun is true. Calls are serialized.
+	Progress func(Event)
+}
+
+// Result summarizes the actions performed by Sync.
+type Result struct {
+	// Copied, Skipped and Deleted are the number of blobs for each Action,
+	// including the ones that failed.
+	Copied, Skipped, Deleted int
+	// BytesCopied is the total size of the copied blobs.
+	// For a dry run, these are the blobs that would be copied.
+	BytesCopied int64
+}
+
+// Error is returned by Sync when some of the blobs could not be copied or
+// deleted. The other blobs were synced.
+type Error struct {
+	// Errors holds an entry for each key that failed.
+	Errors []*blob.KeyError
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("blobsync: failed to sync %v", e.Errors[0])
+	}
+	return fmt.Sprintf("blobsync: failed to sync %d blobs, including %v", len(e.Errors), e.Errors[0])
+}
+
+// Sync makes the blobs under opts.Prefix in dst the same as in src.
+// A blob is copied if it is missing from dst, or if its size differs, or
+// if both buckets report MD5 hashes and they differ, or, if either bucket
+// doesn't report MD5 hashes, the blob in src was modified after the one in
+// dst. Copies preserve the blob's attributes, such as ContentType and
+// Metadata.
+//
+// A nil Options is treated the same as the zero value.
+//
+// If listing either bucket fails, Sync returns that error. If some blobs
+// could not be copied or deleted, Sync continues with the others and
+// returns an *Error. In both cases the returned Result describes the
+// actions taken so far.
+func Sync(ctx context.Context, dst, src *blob.Bucket, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	s := &syncer{dst: dst, src: src, opts: opts, res: &Result{}}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	// List the destination first, so that we can compare each source blob
+	// as it is listed.
+	dstObjs := map[string]*blob.ListObject{}
+	iter := dst.List(&blob.ListOptions{Prefix: opts.Prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s.res, err
+		}
+		dstObjs[obj.Key] = obj
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	iter = src.List(&blob.ListOptions{Prefix: opts.Prefix})
+	var listErr error
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			listErr = err
+			break
+		}
+		dobj := dstObjs[obj.Key]
+		delete(dstObjs, obj.Key)
+		if !needsCopy(obj, dobj) {
+			s.report(Event{Action: Skip, Key: obj.Key, Size: obj.Size})
+			continue
+		}
+		if opts.DryRun {
+			s.report(Event{Action: Copy, Key: obj.Key, Size: obj.Size})
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(obj *blob.ListObject) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			n, err := s.copy(ctx, obj.Key)
+			s.report(Event{Action: Copy, Key: obj.Key, Size: n, Err: err})
+		}(obj)
+	}
+	wg.Wait()
+	if listErr != nil {
+		return s.res, listErr
+	}
+
+	if opts.DeleteExtraneous && len(dstObjs) > 0 {
+		s.deleteExtraneous(ctx, dstObjs)
+	}
+	if len(s.errs) > 0 {
+		return s.res, &Error{Errors: s.errs}
+	}
+	return s.res, nil
+}
+
+// needsCopy reports whether the source blob obj needs to be copied over the
+// destination blob dobj, which is nil if it doesn't exist.
+func needsCopy(obj, dobj *blob.ListObject) bool {
+	if dobj == nil || obj.Size != dobj.Size {
+		return true
+	}
+	if len(obj.MD5) > 0 && len(dobj.MD5) > 0 {
+		return string(obj.MD5) != string(dobj.MD5)
+	}
+	return obj.ModTime.After(dobj.ModTime)
+}
+
+type syncer struct {
+	dst, src *blob.Bucket
+	opts     *Options
+
+	mu   sync.Mutex
+	res  *Result
+	errs []*blob.KeyError
+}
+
+// report records ev in the result and passes it to opts.Progress.
+func (s *syncer) report(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch ev.Action {
+	case Copy:
+		s.res.Copied++
+		if ev.Err == nil {
+			s.res.BytesCopied += ev.Size
+		}
+	case Skip:
+		s.res.Skipped++
+	case Delete:
+		s.res.Deleted++
+	}
+	if ev.Err != nil {
+		s.errs = append(s.errs, &blob.KeyError{Key: ev.Key, Err: ev.Err})
+	}
+	if s.opts.Progress != nil {
+		s.opts.Progress(ev)
+	}
+}
+
+// copy copies the blob at key from s.src to s.dst, and returns the number of
+// bytes copied.
+func (s *syncer) copy(ctx context.Context, key string) (int64, error) {
+	attrs, err := s.src.Attributes(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	// Read the blob as stored, so that it matches ContentEncoding and MD5,
+	// and make sure that it is the blob whose attributes we have.
+	ropts := &blob.ReaderOptions{Raw: true}
+	if attrs.ETag != "" {
+		ropts.Conditions = &blob.Conditions{IfMatch: attrs.ETag}
+	}
+	r, err := s.src.NewReader(ctx, key, ropts)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// Cancel the write if copying fails.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.dst.NewWriter(writeCtx, key, &blob.WriterOptions{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		ContentMD5:         attrs.MD5,
+		Metadata:           attrs.Metadata,
+		Expires:            attrs.Expires,
+		StorageClass:       attrs.StorageClass,
+	})
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// deleteExtraneous deletes the blobs in objs from s.dst.
+func (s *syncer) deleteExtraneous(ctx context.Context, objs map[string]*blob.ListObject) {
+	keys := make([]string, 0, len(objs))
+	for key := range objs {
+		keys = append(keys, key)
+	}
+	failed := map[string]error{}
+	if !s.opts.DryRun {
+		err := s.dst.DeleteMany(ctx, keys)
+		if derr, ok := err.(*blob.DeleteManyError); ok {
+			for _, kerr := range derr.Errors {
+				failed[kerr.Key] = kerr.Err
+			}
+		} else if err != nil {
+			for _, key := range keys {
+				failed[key] = err
+			}
+		}
+	}
+	for _, key := range keys {
+		s.report(Event{Action: Delete, Key: key, Size: objs[key].Size, Err: failed[key]})
+	}
+}
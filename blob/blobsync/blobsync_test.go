@@ -0,0 +1,301 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobsync
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+// write writes blobs to b, with the keys and contents in blobs.
+func write(ctx context.Context, t *testing.T, b *blob.Bucket, blobs map[string]string) {
+	for key, contents := range blobs {
+		if err := b.WriteAll(ctx, key, []byte(contents), &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// contents returns the keys and contents of all the blobs in b.
+func contents(ctx context.Context, t *testing.T, b *blob.Bucket) map[string]string {
+	got := map[string]string{}
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := b.ReadAll(ctx, obj.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[obj.Key] = string(data)
+	}
+	return got
+}
+
+func TestSync(t *testing.T) {
+	srcBlobs := map[string]string{
+		"dir/same":    "same",
+		"dir/changed": "new contents",
+		"dir/new":     "new",
+		"other/a":     "outside the prefix",
+	}
+	dstBlobs := map[string]string{
+		"dir/same":       "same",
+		"dir/changed":    "old contents",
+		"dir/extraneous": "extraneous",
+		"other/b":        "outside the prefix",
+	}
+
+	tests := []struct {
+		name       string
+		opts       *Options
+		wantEvents []Event
+		wantResult Result
+		wantDst    map[string]string
+	}{
+		{
+			name: "Copy",
+			opts: &Options{Prefix: "dir/"},
+			wantEvents: []Event{
+				{Action: Copy, Key: "dir/changed", Size: 12},
+				{Action: Copy, Key: "dir/new", Size: 3},
+				{Action: Skip, Key: "dir/same", Size: 4},
+			},
+			wantResult: Result{Copied: 2, Skipped: 1, BytesCopied: 15},
+			wantDst: map[string]string{
+				"dir/same":       "same",
+				"dir/changed":    "new contents",
+				"dir/new":        "new",
+				"dir/extraneous": "extraneous",
+				"other/b":        "outside the prefix",
+			},
+		},
+		{
+			name: "DeleteExtraneous",
+			opts: &Options{Prefix: "dir/", DeleteExtraneous: true, Concurrency: 1},
+			wantEvents: []Event{
+				{Action: Copy, Key: "dir/changed", Size: 12},
+				{Action: Delete, Key: "dir/extraneous", Size: 10},
+				{Action: Copy, Key: "dir/new", Size: 3},
+				{Action: Skip, Key: "dir/same", Size: 4},
+			},
+			wantResult: Result{Copied: 2, Skipped: 1, Deleted: 1, BytesCopied: 15},
+			wantDst: map[string]string{
+				"dir/same":    "same",
+				"dir/changed": "new contents",
+				"dir/new":     "new",
+				"other/b":     "outside the prefix",
+			},
+		},
+		{
+			name: "DryRun",
+			opts: &Options{Prefix: "dir/", DeleteExtraneous: true, DryRun: true},
+			wantEvents: []Event{
+				{Action: Copy, Key: "dir/changed", Size: 12},
+				{Action: Delete, Key: "dir/extraneous", Size: 10},
+				{Action: Copy, Key: "dir/new", Size: 3},
+				{Action: Skip, Key: "dir/same", Size: 4},
+			},
+			wantResult: Result{Copied: 2, Skipped: 1, Deleted: 1, BytesCopied: 15},
+			wantDst:    dstBlobs,
+		},
+	}
+
+	ctx := context.Background()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := memblob.OpenBucket(nil)
+			defer src.Close()
+			dst := memblob.OpenBucket(nil)
+			defer dst.Close()
+			write(ctx, t, src, srcBlobs)
+			write(ctx, t, dst, dstBlobs)
+
+			var events []Event
+			test.opts.Progress = func(ev Event) { events = append(events, ev) }
+			res, err := Sync(ctx, dst, src, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(*res, test.wantResult); diff != "" {
+				t.Errorf("got result diff (-got +want):\n%s", diff)
+			}
+			sort.Slice(events, func(i, j int) bool { return events[i].Key < events[j].Key })
+			if diff := cmp.Diff(events, test.wantEvents); diff != "" {
+				t.Errorf("got events diff (-got +want):\n%s", diff)
+			}
+			if diff := cmp.Diff(contents(ctx, t, dst), test.wantDst); diff != "" {
+				t.Errorf("got destination diff (-got +want):\n%s", diff)
+			}
+
+			// Syncing again does nothing, except in a dry run.
+			if test.opts.DryRun {
+				return
+			}
+			res, err = Sync(ctx, dst, src, &Options{Prefix: test.opts.Prefix})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Copied != 0 || res.Deleted != 0 {
+				t.Errorf("got %+v on second sync, want no copies or deletes", res)
+			}
+		})
+	}
+}
+
+func TestSyncPreservesAttributes(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	defer src.Close()
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+
+	wopts := &blob.WriterOptions{
+		ContentType:  "application/json",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"foo": "bar"},
+		StorageClass: blob.StorageClassCold,
+	}
+	if err := src.WriteAll(ctx, "key", []byte("{}"), wopts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(ctx, dst, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := dst.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != wopts.ContentType || attrs.CacheControl != wopts.CacheControl || !cmp.Equal(attrs.Metadata, wopts.Metadata) || attrs.StorageClass != wopts.StorageClass {
+		t.Errorf("got attributes %+v, want ContentType, CacheControl, Metadata and StorageClass from %+v", attrs, wopts)
+	}
+}
+
+// readOptsBucket records the ReaderOptions of the reads from a bucket.
+type readOptsBucket struct {
+	wrapper.Bucket
+	opts []driver.ReaderOptions
+}
+
+func (b *readOptsBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.opts = append(b.opts, *opts)
+	return b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+}
+
+func TestSyncEncoded(t *testing.T) {
+	ctx := context.Background()
+	mem := memblob.OpenBucket(nil)
+	defer mem.Close()
+	rb := &readOptsBucket{Bucket: wrapper.New(mem)}
+	src := blob.NewBucket(rb)
+	defer src.Close()
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+
+	if err := src.WriteAll(ctx, "key", []byte("hello world"), &blob.WriterOptions{Compression: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+	srcAttrs, err := src.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(ctx, dst, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The blob is read as stored, from the generation whose attributes
+	// were copied.
+	if len(rb.opts) != 1 {
+		t.Fatalf("got %d reads from the source, want 1", len(rb.opts))
+	}
+	if o := rb.opts[0]; !o.Raw || o.Conditions == nil || o.Conditions.IfMatch != srcAttrs.ETag {
+		t.Errorf("got ReaderOptions %+v, want Raw and IfMatch %q", o, srcAttrs.ETag)
+	}
+	dstAttrs, err := dst.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dstAttrs.ContentEncoding != "gzip" || !cmp.Equal(dstAttrs.MD5, srcAttrs.MD5) {
+		t.Errorf("got ContentEncoding %q, MD5 %x, want %q, %x", dstAttrs.ContentEncoding, dstAttrs.MD5, "gzip", srcAttrs.MD5)
+	}
+	r, err := dst.NewReader(ctx, "key", &blob.ReaderOptions{Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("got %q want %q", got, "hello world")
+	}
+}
+
+func TestSyncErrors(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	defer src.Close()
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+	write(ctx, t, src, map[string]string{"a": "a", "b": "b"})
+
+	// Delete "b" after it is listed but before it is copied, so that copying
+	// it fails.
+	progress := func(ev Event) {
+		if ev.Key == "a" {
+			if err := src.Delete(ctx, "b"); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	res, err := Sync(ctx, dst, src, &Options{Concurrency: 1, Progress: progress})
+	serr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("got error %v want *Error", err)
+	}
+	if len(serr.Errors) != 1 || serr.Errors[0].Key != "b" || gcerrors.Code(serr.Errors[0].Err) != gcerrors.NotFound {
+		t.Errorf("got errors %v want a NotFound error for %q", serr.Errors, "b")
+	}
+	if res.Copied != 2 || res.BytesCopied != 1 {
+		t.Errorf("got %+v want 2 copies of 1 byte", res)
+	}
+	if diff := cmp.Diff(contents(ctx, t, dst), map[string]string{"a": "a"}); diff != "" {
+		t.Errorf("got destination diff (-got +want):\n%s", diff)
+	}
+
+	// Errors listing the buckets are returned directly.
+	src.Close()
+	if _, err := Sync(ctx, dst, src, nil); err == nil {
+		t.Error("got nil error for closed source, want error")
+	} else if _, ok := err.(*Error); ok {
+		t.Errorf("got *Error %v for closed source, want listing error", err)
+	}
+}
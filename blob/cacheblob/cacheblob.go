@@ -0,0 +1,812 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cacheblob provides a blob implementation that caches the blobs of
+// another bucket, the origin, in a local bucket, such as a fileblob directory
+// or a memblob bucket.
+// Use OpenBucket to construct a *blob.Bucket.
+//
+// Caching
+//
+// Reads are served from the cache. The first read of a blob copies it from
+// the origin into the cache; later reads revalidate the cached copy by
+// fetching the blob's attributes from the origin, and comparing their ETag,
+// MD5 hash, or modification time and size, with the cached copy's, unless
+// the copy was validated less than Options.MaxStale ago. Range reads of a
+// cached blob only read the range from the cache.
+//
+// When the total size of the cached blobs exceeds Options.MaxBytes, the
+// least recently read blobs are deleted from the cache. Blobs that are
+// larger than Options.MaxBytes, blobs that have a ContentEncoding, which
+// providers may decode while reading, and reads of previous versions of
+// blobs, are not cached, and are read from the origin.
+//
+// All other operations, including Attributes and List, are performed on the
+// origin. Writes, copies and deletes through the returned bucket also remove
+// the affected blobs from the cache, as do the events returned by Watch.
+//
+// A fileblob cache is reused across processes: blobs cached by a previous
+// OpenBucket are revalidated on their first read.
+//
+// Retries and limits
+//
+// cacheblob calls the origin's provider directly, so the retry policy and
+// limits of the origin *blob.Bucket are not used, whether they were set with
+// SetRetryPolicy and SetLimits or with the "retry_" and "limit_" parameters
+// of its URL. Set them on the returned bucket instead, or with the parameters
+// of the "cache" URL itself. The cache bucket is used through its portable
+// methods, so its own retry policy and limits apply.
+//
+// Metrics
+//
+// cacheblob records the number of reads served from the cache (hits), the
+// number of reads that went to the origin (misses), and the number of blobs
+// evicted from the cache, in the "github.com/eliben/gocdkx/blob/cache" view
+// of blob.OpenCensusViews. Like the bucket's method calls, they are recorded
+// with the provider "github.com/eliben/gocdkx/blob/cacheblob".
+//
+// URLs
+//
+// For blob.OpenBucket, cacheblob registers for the scheme "cache".
+// To customize the URL opener, or for more details on the URL format,
+// see URLOpener.
+// See https://github.com/eliben/gocdkx/concepts/urls/ for background information.
+//
+// As
+//
+// cacheblob exposes the types of the origin's provider for As. Readers for
+// blobs served from the cache expose the types of the cache's provider.
+package cacheblob // import "github.com/eliben/gocdkx/blob/cacheblob"
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/internal/gcerr"
+	"github.com/eliben/gocdkx/internal/oc"
+)
+
+// Metadata keys under which the attributes of the origin blob are stored in
+// the cached copy.
+const (
+	metaETag    = "cacheblob-etag"
+	metaMD5     = "cacheblob-md5"
+	metaModTime = "cacheblob-modtime"
+)
+
+// DefaultMaxBytes is the default value for Options.MaxBytes.
+const DefaultMaxBytes = 1 << 30
+
+const pkgName = "github.com/eliben/gocdkx/blob/cacheblob"
+
+// cacheMeasure is the measure of the blob package, whose OpenCensusViews
+// include its view.
+var cacheMeasure = oc.CacheMeasure("github.com/eliben/gocdkx/blob")
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// Scheme is the URL scheme cacheblob registers its URLOpener under on
+// blob.DefaultMux.
+const Scheme = "cache"
+
+// URLOpener opens cacheblob URLs like
+// "cache://?bucket=gs%3A%2F%2Fmybucket&cache=file%3A%2F%2F%2Ftmp%2Fcache".
+//
+// The URL's host and path are ignored. The following query parameters are
+// supported:
+//   - bucket (required): The URL of the origin bucket, opened with
+//       URLOpener.BucketMux.
+//   - cache: The URL of the bucket that holds the cached blobs, opened with
+//       URLOpener.BucketMux. Defaults to an in-memory memblob bucket.
+//   - max_bytes: Overrides Options.MaxBytes.
+//   - max_stale: Overrides Options.MaxStale, in the format accepted by
+//       time.ParseDuration, e.g. "5m".
+// Both URLs must be query-escaped. Closing the returned bucket closes the
+// origin and cache buckets.
+type URLOpener struct {
+	// BucketMux opens the origin and cache buckets. Defaults to
+	// blob.DefaultURLMux().
+	BucketMux *blob.URLMux
+	// Options specifies the options to pass to OpenBucket.
+	Options Options
+}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	q := u.Query()
+	originURL := q.Get("bucket")
+	cacheURL := q.Get("cache")
+	opts := o.Options
+	if s := q.Get("max_bytes"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q: %q", u, "max_bytes", s)
+		}
+		opts.MaxBytes = n
+	}
+	if s := q.Get("max_stale"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q: %q", u, "max_stale", s)
+		}
+		opts.MaxStale = d
+	}
+	for _, param := range []string{"bucket", "cache", "max_bytes", "max_stale"} {
+		q.Del(param)
+	}
+	for param := range q {
+		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	}
+	if originURL == "" {
+		return nil, fmt.Errorf("open bucket %v: query parameter %q is required", u, "bucket")
+	}
+	mux := o.BucketMux
+	if mux == nil {
+		mux = blob.DefaultURLMux()
+	}
+	origin, err := mux.OpenBucket(ctx, originURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: failed to open origin bucket: %v", u, err)
+	}
+	cache := memblob.OpenBucket(nil)
+	if cacheURL != "" {
+		if cache, err = mux.OpenBucket(ctx, cacheURL); err != nil {
+			origin.Close()
+			return nil, fmt.Errorf("open bucket %v: failed to open cache bucket: %v", u, err)
+		}
+	}
+	b := openBucket(origin, cache, &opts)
+	b.Owned = true
+	return blob.NewBucket(b), nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by cacheblob.
+type Options struct {
+	// MaxBytes is the maximum total size of the blobs in the cache. Least
+	// recently read blobs are evicted to stay under it. Defaults to
+	// DefaultMaxBytes.
+	MaxBytes int64
+
+	// MaxStale is how long a cached blob is read without revalidating it
+	// against the origin. Reads may return stale content for up to MaxStale
+	// after the blob changes in the origin, other than through the returned
+	// bucket. Defaults to 0, which revalidates on every read.
+	MaxStale time.Duration
+}
+
+// OpenBucket returns a *blob.Bucket that reads the blobs of origin through a
+// cache stored in cache, which is typically a local bucket opened with
+// fileblob or memblob. The cache must not be used for anything else.
+// Closing the returned bucket doesn't close origin or cache, which must stay
+// open while the returned bucket is in use.
+// A nil Options is treated the same as the zero value.
+func OpenBucket(origin, cache *blob.Bucket, opts *Options) *blob.Bucket {
+	return blob.NewBucket(openBucket(origin, cache, opts))
+}
+
+func openBucket(origin, cache *blob.Bucket, opts *Options) *bucket {
+	b := &bucket{
+		Bucket:  wrapper.New(origin),
+		cache:   cache,
+		entries: map[string]*entry{},
+		lru:     list.New(),
+		fills:   map[string]*fill{},
+	}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.opts.MaxBytes <= 0 {
+		b.opts.MaxBytes = DefaultMaxBytes
+	}
+	return b
+}
+
+// bucket implements driver.Bucket on top of the driver of the origin, and
+// the cache *blob.Bucket.
+type bucket struct {
+	// Bucket wraps the origin. Attributes, List, ListVersions and SignedURL
+	// are performed on it as is. If Owned, Close closes the cache too.
+	wrapper.Bucket
+	cache *blob.Bucket
+	opts  Options
+
+	// indexMu serializes loading the index from the cache bucket.
+	indexMu sync.Mutex
+
+	mu      sync.Mutex
+	indexed bool // true once the cache bucket has been listed into entries
+	entries map[string]*entry
+	lru     *list.List // of *entry, most recently read first
+	size    int64      // total size of entries
+	fills   map[string]*fill
+}
+
+// entry describes a blob in the cache.
+type entry struct {
+	key  string
+	size int64
+	elem *list.Element
+
+	// The fields below are only valid if loaded is true. Entries listed from
+	// the cache bucket are loaded on their first read.
+	loaded      bool
+	contentType string
+	etag        string
+	md5         []byte
+	modTime     time.Time
+	// validated is the last time the entry was found to match the origin.
+	validated time.Time
+}
+
+// matches reports whether e holds the content of the origin blob with
+// attributes a.
+func (e *entry) matches(a *driver.Attributes) bool {
+	if e.size != a.Size {
+		return false
+	}
+	if e.etag != "" && a.ETag != "" {
+		return e.etag == a.ETag
+	}
+	if len(e.md5) > 0 && len(a.MD5) > 0 {
+		return bytes.Equal(e.md5, a.MD5)
+	}
+	return !e.modTime.IsZero() && e.modTime.Equal(a.ModTime)
+}
+
+// fill is an in-progress copy of a blob from the origin into the cache.
+type fill struct {
+	done chan struct{}
+	e    *entry
+	err  error
+}
+
+// Results of cache lookups, as recorded with cacheMeasure.
+const (
+	hit      = "hit"
+	miss     = "miss"
+	eviction = "eviction"
+)
+
+func record(result string) {
+	stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(oc.ProviderKey, pkgName), tag.Upsert(oc.CacheResultKey, result)},
+		cacheMeasure.M(1))
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.Version != "" {
+		record(miss)
+		return b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	e, cached, err := b.lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		// The blob can't be cached.
+		record(miss)
+		return b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	if err := checkConditions(key, e, opts.Conditions); err != nil {
+		return nil, err
+	}
+	r, err := b.cache.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		if gcerrors.Code(err) != gcerrors.NotFound {
+			return nil, err
+		}
+		// The blob was evicted concurrently.
+		record(miss)
+		return b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	if cached {
+		record(hit)
+	} else {
+		record(miss)
+	}
+	if opts.BeforeRead != nil {
+		if err := opts.BeforeRead(r.As); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return &reader{
+		r: r,
+		attrs: driver.ReaderAttributes{
+			ContentType: e.contentType,
+			ModTime:     e.modTime,
+			Size:        e.size,
+		},
+	}, nil
+}
+
+// checkConditions checks conds against the cached blob e.
+func checkConditions(key string, e *entry, conds *driver.Conditions) error {
+	if conds == nil {
+		return nil
+	}
+	if conds.IfMatch != "" && conds.IfMatch != e.etag {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "cacheblob: blob %q does not match ETag %q", key, conds.IfMatch)
+	}
+	if !conds.IfModifiedSince.IsZero() && !e.modTime.After(conds.IfModifiedSince) {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "cacheblob: blob %q was not modified since %v", key, conds.IfModifiedSince)
+	}
+	return nil
+}
+
+// lookup returns the up-to-date cache entry for key, copying the blob from
+// the origin if needed, and whether the blob was already cached. It returns
+// a nil entry if the blob can't be cached.
+func (b *bucket) lookup(ctx context.Context, key string) (*entry, bool, error) {
+	if err := b.loadIndex(ctx); err != nil {
+		return nil, false, err
+	}
+	// A blob may change between reading its attributes and copying it, in
+	// which case the copy fails, and we try again.
+	const maxAttempts = 3
+	for attempt := 1; ; attempt++ {
+		e, err := b.get(ctx, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if e != nil && b.fresh(e) {
+			b.touch(e, false)
+			return e, true, nil
+		}
+		a, err := b.Bucket.Attributes(ctx, key)
+		if err != nil {
+			if b.ErrorCode(err) == gcerrors.NotFound && e != nil {
+				b.invalidate(ctx, key)
+			}
+			return nil, false, err
+		}
+		if e != nil && e.matches(a) {
+			b.touch(e, true)
+			return e, true, nil
+		}
+		if a.Size > b.opts.MaxBytes || (a.ContentEncoding != "" && a.ContentEncoding != "identity") {
+			return nil, false, nil
+		}
+		e, err = b.fill(ctx, key, a)
+		if b.ErrorCode(err) == gcerrors.FailedPrecondition && attempt < maxAttempts {
+			continue
+		}
+		return e, false, err
+	}
+}
+
+// loadIndex lists the blobs already in the cache bucket into b.entries, the
+// first time it is called.
+func (b *bucket) loadIndex(ctx context.Context) error {
+	b.indexMu.Lock()
+	defer b.indexMu.Unlock()
+	b.mu.Lock()
+	indexed := b.indexed
+	b.mu.Unlock()
+	if indexed {
+		return nil
+	}
+	var objs []*blob.ListObject
+	iter := b.cache.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		objs = append(objs, obj)
+	}
+	// Treat the most recently written blobs as the most recently read.
+	sort.Slice(objs, func(i, j int) bool { return objs[i].ModTime.Before(objs[j].ModTime) })
+
+	b.mu.Lock()
+	for _, obj := range objs {
+		if b.entries[obj.Key] != nil {
+			continue
+		}
+		e := &entry{key: obj.Key, size: obj.Size}
+		e.elem = b.lru.PushFront(e)
+		b.entries[e.key] = e
+		b.size += e.size
+	}
+	b.indexed = true
+	evicted := b.evictLocked(nil)
+	b.mu.Unlock()
+	b.deleteFromCache(ctx, evicted)
+	return nil
+}
+
+// get returns the cache entry for key, or nil if there is none.
+func (b *bucket) get(ctx context.Context, key string) (*entry, error) {
+	b.mu.Lock()
+	e := b.entries[key]
+	loaded := e != nil && e.loaded
+	b.mu.Unlock()
+	if e == nil || loaded {
+		return e, nil
+	}
+	a, err := b.cache.Attributes(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			b.forget(e)
+			return nil, nil
+		}
+		return nil, err
+	}
+	md5, _ := hex.DecodeString(a.Metadata[metaMD5])
+	modTime, _ := time.Parse(time.RFC3339Nano, a.Metadata[metaModTime])
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entries[key] != e {
+		// The entry was replaced or removed concurrently.
+		return b.entries[key], nil
+	}
+	if !e.loaded {
+		e.contentType = a.ContentType
+		e.etag = a.Metadata[metaETag]
+		e.md5 = md5
+		e.modTime = modTime
+		e.loaded = true
+	}
+	return e, nil
+}
+
+// fill copies the blob at key, with attributes a, from the origin into the
+// cache, unless another call is already doing so, in which case it waits for
+// that call's result.
+func (b *bucket) fill(ctx context.Context, key string, a *driver.Attributes) (*entry, error) {
+	b.mu.Lock()
+	if f := b.fills[key]; f != nil {
+		b.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.e, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &fill{done: make(chan struct{})}
+	b.fills[key] = f
+	b.mu.Unlock()
+
+	f.e, f.err = b.copyToCache(ctx, key, a)
+	b.mu.Lock()
+	delete(b.fills, key)
+	b.mu.Unlock()
+	close(f.done)
+	return f.e, f.err
+}
+
+// copyToCache copies the blob at key, with attributes a, from the origin into
+// the cache, and adds it to the index.
+func (b *bucket) copyToCache(ctx context.Context, key string, a *driver.Attributes) (*entry, error) {
+	// Make sure that the content matches a.
+	var conds *driver.Conditions
+	if a.ETag != "" {
+		conds = &driver.Conditions{IfMatch: a.ETag}
+	}
+	r, err := b.Bucket.NewRangeReader(ctx, key, 0, -1, &driver.ReaderOptions{Conditions: conds})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	contentType := r.Attributes().ContentType
+
+	md := map[string]string{metaModTime: a.ModTime.Format(time.RFC3339Nano)}
+	if a.ETag != "" {
+		md[metaETag] = a.ETag
+	}
+	if len(a.MD5) > 0 {
+		md[metaMD5] = hex.EncodeToString(a.MD5)
+	}
+	// Cancel the write if copying fails.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.cache.NewWriter(writeCtx, key, &blob.WriterOptions{
+		ContentType: contentType,
+		ContentMD5:  a.MD5,
+		Metadata:    md,
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(w, r)
+	if err == nil && n != a.Size {
+		err = gcerr.Newf(gcerr.FailedPrecondition, nil, "cacheblob: blob %q changed while it was being copied", key)
+	}
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	e := &entry{
+		key:         key,
+		size:        n,
+		loaded:      true,
+		contentType: contentType,
+		etag:        a.ETag,
+		md5:         a.MD5,
+		modTime:     a.ModTime,
+		validated:   time.Now(),
+	}
+	b.mu.Lock()
+	if old := b.entries[key]; old != nil {
+		b.removeLocked(old)
+	}
+	e.elem = b.lru.PushFront(e)
+	b.entries[key] = e
+	b.size += e.size
+	evicted := b.evictLocked(e)
+	b.mu.Unlock()
+	b.deleteFromCache(ctx, evicted)
+	return e, nil
+}
+
+// fresh reports whether e was validated less than MaxStale ago.
+func (b *bucket) fresh(e *entry) bool {
+	if b.opts.MaxStale <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Since(e.validated) < b.opts.MaxStale
+}
+
+// touch marks e as the most recently read entry, and as just validated if
+// validated is true.
+func (b *bucket) touch(e *entry, validated bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if validated {
+		e.validated = time.Now()
+	}
+	if b.entries[e.key] == e {
+		b.lru.MoveToFront(e.elem)
+	}
+}
+
+// removeLocked removes e from the index. b.mu must be held.
+func (b *bucket) removeLocked(e *entry) {
+	b.lru.Remove(e.elem)
+	delete(b.entries, e.key)
+	b.size -= e.size
+}
+
+// forget removes e from the index, if it is still there.
+func (b *bucket) forget(e *entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.entries[e.key] == e {
+		b.removeLocked(e)
+	}
+}
+
+// evictLocked removes the least recently read entries, other than keep, from
+// the index until the cache fits in MaxBytes, and returns their keys.
+// b.mu must be held.
+func (b *bucket) evictLocked(keep *entry) []string {
+	var keys []string
+	for elem := b.lru.Back(); elem != nil && b.size > b.opts.MaxBytes; {
+		e := elem.Value.(*entry)
+		elem = elem.Prev()
+		if e == keep {
+			continue
+		}
+		b.removeLocked(e)
+		keys = append(keys, e.key)
+		record(eviction)
+	}
+	return keys
+}
+
+// deleteFromCache deletes the blobs at keys from the cache bucket. Errors are
+// ignored: blobs left behind are revalidated before they are read.
+func (b *bucket) deleteFromCache(ctx context.Context, keys []string) {
+	if len(keys) > 0 {
+		_ = b.cache.DeleteMany(ctx, keys)
+	}
+}
+
+// invalidate removes the blobs at keys from the cache.
+func (b *bucket) invalidate(ctx context.Context, keys ...string) {
+	var cached []string
+	b.mu.Lock()
+	for _, key := range keys {
+		if e := b.entries[key]; e != nil {
+			b.removeLocked(e)
+			cached = append(cached, key)
+		}
+	}
+	b.mu.Unlock()
+	b.deleteFromCache(ctx, cached)
+}
+
+// reader reads a blob from the cache.
+type reader struct {
+	r     *blob.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	return r.r.As(i)
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	w, err := b.Bucket.NewTypedWriter(ctx, key, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{Writer: w, b: b, key: key}, nil
+}
+
+// writer writes a blob to the origin, and removes it from the cache once it
+// is written.
+type writer struct {
+	driver.Writer
+	b   *bucket
+	key string
+}
+
+func (w *writer) Close() error {
+	err := w.Writer.Close()
+	w.b.invalidate(context.Background(), w.key)
+	return err
+}
+
+// Copy implements driver.Copy.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	err := b.Bucket.Copy(ctx, dstKey, srcKey, opts)
+	b.invalidate(ctx, dstKey)
+	return err
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	err := b.Bucket.Compose(ctx, dstKey, srcKeys, opts)
+	b.invalidate(ctx, dstKey)
+	return err
+}
+
+// UpdateAttributes implements driver.UpdateAttributes. The cached copy is
+// invalidated, since the ETag of the blob may not change.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	err := b.Bucket.UpdateAttributes(ctx, key, opts)
+	b.invalidate(ctx, key)
+	return err
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	err := b.Bucket.Delete(ctx, key, opts)
+	b.invalidate(ctx, key)
+	return err
+}
+
+// DeleteMany implements driver.DeleteMany.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	errs, err := b.Bucket.DeleteMany(ctx, keys)
+	b.invalidate(ctx, keys...)
+	return errs, err
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	u, err := b.Bucket.NewMultipartUpload(ctx, key, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{MultipartUpload: u, b: b, key: key}, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	u, err := b.Bucket.ResumeMultipartUpload(ctx, key, uploadID, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{MultipartUpload: u, b: b, key: key}, nil
+}
+
+// multipartUpload uploads a blob to the origin, and removes it from the cache
+// once it is completed.
+type multipartUpload struct {
+	driver.MultipartUpload
+	b   *bucket
+	key string
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	err := u.MultipartUpload.Complete(ctx)
+	u.b.invalidate(ctx, u.key)
+	return err
+}
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	w, err := b.Bucket.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{Watcher: w, b: b}, nil
+}
+
+// watcher reports the events of the origin's Watcher, and removes the blobs
+// they are about from the cache. It implements driver.Watcher.
+type watcher struct {
+	driver.Watcher
+	b *bucket
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	evs, err := w.Watcher.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(evs))
+	for i, ev := range evs {
+		keys[i] = ev.Key
+	}
+	w.b.invalidate(ctx, keys...)
+	return evs, nil
+}
+
+// Close implements driver.Close.
+func (b *bucket) Close() error {
+	err := b.Bucket.Close()
+	if b.Owned {
+		if cerr := b.cache.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,346 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cacheblob
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/blob/fileblob"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/internal/oc"
+)
+
+type harness struct {
+	origin, cache *blob.Bucket
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return &harness{origin: memblob.OpenBucket(nil), cache: memblob.OpenBucket(nil)}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.origin, h.cache, nil), nil
+}
+
+func (h *harness) Close() {
+	h.origin.Close()
+	h.cache.Close()
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+// counts returns the current values of the cache view for cacheblob.
+func counts(t *testing.T) (hits, misses, evictions int64) {
+	t.Helper()
+	rows, err := view.RetrieveData("github.com/eliben/gocdkx/blob/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		var provider, result string
+		for _, tag := range row.Tags {
+			switch tag.Key {
+			case oc.ProviderKey:
+				provider = tag.Value
+			case oc.CacheResultKey:
+				result = tag.Value
+			}
+		}
+		if provider != pkgName {
+			continue
+		}
+		n := row.Data.(*view.CountData).Value
+		switch result {
+		case hit:
+			hits = n
+		case miss:
+			misses = n
+		case eviction:
+			evictions = n
+		}
+	}
+	return hits, misses, evictions
+}
+
+func TestCaching(t *testing.T) {
+	if err := view.Register(blob.OpenCensusViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(blob.OpenCensusViews...)
+
+	ctx := context.Background()
+	origin := memblob.OpenBucket(nil)
+	defer origin.Close()
+	cache := memblob.OpenBucket(nil)
+	defer cache.Close()
+	b := OpenBucket(origin, cache, &Options{MaxBytes: 100})
+	defer b.Close()
+
+	write := func(bkt *blob.Bucket, key, content string) {
+		t.Helper()
+		if err := bkt.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(key, want string) {
+		t.Helper()
+		got, err := b.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q want %q", key, got, want)
+		}
+	}
+	cached := func(key string) bool {
+		t.Helper()
+		ok, err := cache.Exists(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	check := func(wantHits, wantMisses, wantEvictions int64) {
+		t.Helper()
+		hits, misses, evictions := counts(t)
+		if hits != wantHits || misses != wantMisses || evictions != wantEvictions {
+			t.Errorf("got %d hits, %d misses, %d evictions; want %d, %d, %d", hits, misses, evictions, wantHits, wantMisses, wantEvictions)
+		}
+	}
+
+	write(origin, "a", "aaaaaaaaaa")
+	read("a", "aaaaaaaaaa")
+	check(0, 1, 0)
+	if !cached("a") {
+		t.Error("a is not cached after reading it")
+	}
+	read("a", "aaaaaaaaaa")
+	check(1, 1, 0)
+
+	// Range reads are served from the cache.
+	r, err := b.NewRangeReader(ctx, "a", 2, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != "aaa" || r.Size() != 10 {
+		t.Errorf("got %q, %v, size %d want %q, nil, size 10", got, err, r.Size(), "aaa")
+	}
+	check(2, 1, 0)
+
+	// Changes in the origin are detected.
+	write(origin, "a", "AAAAAAAAAAAAAAAAAAAA")
+	read("a", "AAAAAAAAAAAAAAAAAAAA")
+	check(2, 2, 0)
+
+	// Writes through the bucket invalidate the cache.
+	write(b, "a", "bbbbbbbbbb")
+	if cached("a") {
+		t.Error("a is still cached after writing it")
+	}
+	read("a", "bbbbbbbbbb")
+	check(2, 3, 0)
+
+	// Least recently read blobs are evicted.
+	write(origin, "b", string(bytes.Repeat([]byte("b"), 50)))
+	write(origin, "c", string(bytes.Repeat([]byte("c"), 45)))
+	read("b", string(bytes.Repeat([]byte("b"), 50)))
+	read("a", "bbbbbbbbbb")
+	read("c", string(bytes.Repeat([]byte("c"), 45)))
+	check(3, 5, 1)
+	if cached("b") || !cached("a") || !cached("c") {
+		t.Error("got b cached or a or c not cached, want b evicted")
+	}
+
+	// A blob removed from the cache bucket after it was looked up is read
+	// from the origin, which counts as a miss.
+	if err := cache.Delete(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	read("c", string(bytes.Repeat([]byte("c"), 45)))
+	check(3, 6, 1)
+
+	// Blobs larger than MaxBytes are not cached.
+	large := string(bytes.Repeat([]byte("d"), 200))
+	write(origin, "d", large)
+	read("d", large)
+	if cached("d") {
+		t.Error("d is cached, want not cached since it is larger than MaxBytes")
+	}
+
+	// Deletes invalidate the cache.
+	if err := b.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if cached("a") {
+		t.Error("a is still cached after deleting it")
+	}
+	if _, err := b.ReadAll(ctx, "a"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v reading deleted blob, want NotFound", err)
+	}
+}
+
+func TestMaxStale(t *testing.T) {
+	ctx := context.Background()
+	origin := memblob.OpenBucket(nil)
+	defer origin.Close()
+	cache := memblob.OpenBucket(nil)
+	defer cache.Close()
+	b := OpenBucket(origin, cache, &Options{MaxStale: time.Hour})
+	defer b.Close()
+
+	if err := origin.WriteAll(ctx, "key", []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := origin.WriteAll(ctx, "key", []byte("new"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The cached copy is served without revalidating it.
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "old" {
+		t.Errorf("got %q, %v want %q", got, err, "old")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "cacheblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origin := memblob.OpenBucket(nil)
+	defer origin.Close()
+	if err := origin.WriteAll(ctx, "dir/key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(b *blob.Bucket, want string) {
+		t.Helper()
+		if got, err := b.ReadAll(ctx, "dir/key"); err != nil || string(got) != want {
+			t.Errorf("got %q, %v want %q", got, err, want)
+		}
+	}
+	open := func() (*blob.Bucket, *blob.Bucket) {
+		t.Helper()
+		cache, err := fileblob.OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return OpenBucket(origin, cache, nil), cache
+	}
+
+	b, cache := open()
+	read(b, "hello")
+	b.Close()
+	cache.Close()
+	if _, err := os.Stat(filepath.Join(dir, "dir", "key")); err != nil {
+		t.Errorf("blob is not cached on disk: %v", err)
+	}
+
+	// A new bucket reuses the cache, after revalidating it.
+	b, cache = open()
+	defer cache.Close()
+	defer b.Close()
+	read(b, "hello")
+	if err := origin.WriteAll(ctx, "dir/key", []byte("world"), nil); err != nil {
+		t.Fatal(err)
+	}
+	read(b, "world")
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "cacheblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheURL := "file://" + filepath.ToSlash(dir)
+
+	cURL := func(originURL, cacheURL, extra string) string {
+		q := url.Values{}
+		if originURL != "" {
+			q.Set("bucket", originURL)
+		}
+		if cacheURL != "" {
+			q.Set("cache", cacheURL)
+		}
+		return "cache://?" + q.Encode() + extra
+	}
+
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		// OK.
+		{cURL("mem://", "", ""), false},
+		// OK, with a file cache.
+		{cURL("mem://", cacheURL, ""), false},
+		// OK, setting max_bytes and max_stale.
+		{cURL("mem://", "", "&max_bytes=1000&max_stale=1m"), false},
+		// Missing bucket.
+		{cURL("", cacheURL, ""), true},
+		// Invalid origin bucket URL.
+		{cURL("mem://?param=value", "", ""), true},
+		// Invalid cache bucket URL.
+		{cURL("mem://", "mem://?param=value", ""), true},
+		// Invalid max_bytes.
+		{cURL("mem://", "", "&max_bytes=x"), true},
+		// Invalid max_stale.
+		{cURL("mem://", "", "&max_stale=x"), true},
+		// Invalid parameter.
+		{cURL("mem://", "", "&param=value"), true},
+	}
+	for _, test := range tests {
+		b, err := blob.OpenBucket(ctx, test.URL)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+		if err == nil {
+			if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+				t.Errorf("%s: %v", test.URL, err)
+			}
+			if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello" {
+				t.Errorf("%s: got %q, %v want %q", test.URL, got, err, "hello")
+			}
+			if err := b.Close(); err != nil {
+				t.Errorf("%s: %v", test.URL, err)
+			}
+		}
+	}
+}
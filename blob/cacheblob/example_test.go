@@ -0,0 +1,72 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cacheblob_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/cacheblob"
+	_ "github.com/eliben/gocdkx/blob/fileblob"
+	"github.com/eliben/gocdkx/blob/memblob"
+)
+
+func ExampleOpenBucket() {
+	ctx := context.Background()
+
+	// Cache the blobs of a bucket in memory. In production, the origin
+	// would typically be a Cloud bucket, and the cache a fileblob bucket.
+	origin := memblob.OpenBucket(nil)
+	defer origin.Close()
+	cache := memblob.OpenBucket(nil)
+	defer cache.Close()
+	if err := origin.WriteAll(ctx, "config.json", []byte(`{"debug": true}`), nil); err != nil {
+		log.Fatal(err)
+	}
+
+	bucket := cacheblob.OpenBucket(origin, cache, &cacheblob.Options{MaxBytes: 100 << 20})
+	defer bucket.Close()
+	// The first read copies the blob into the cache; the second one reads
+	// it from the cache.
+	for i := 0; i < 2; i++ {
+		data, err := bucket.ReadAll(ctx, "config.json")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+	}
+
+	// Output:
+	// {"debug": true}
+	// {"debug": true}
+}
+
+func Example_openBucket() {
+	ctx := context.Background()
+
+	// Compose the URLs of the origin and cache buckets.
+	q := url.Values{}
+	q.Set("bucket", "mem://")
+	q.Set("cache", "file:///var/cache/blobs")
+	q.Set("max_stale", "1m")
+	bucket, err := blob.OpenBucket(ctx, "cache://?"+q.Encode())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
@@ -0,0 +1,562 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chaosblob provides a blob implementation for tests that wraps
+// another bucket, and makes its calls fail or slow down, to exercise the
+// error handling, retry and timeout logic of code using a *blob.Bucket.
+// Use OpenBucket to construct a *blob.Bucket.
+//
+// Faults
+//
+// Options.Faults configures a Fault for each method of the driver: the
+// methods of driver.Bucket, like "Attributes" or "NewTypedWriter", and the
+// methods of the values they return, like "Reader.Read", "Writer.Write",
+// "Writer.Close", "MultipartUpload.UploadPart" or "Watcher.Next". A Fault
+// delays calls, and makes them fail with an error that carries a
+// configurable gcerrors.ErrorCode, either for the Nth call or at random.
+// Options.MaxReadSize makes reads return fewer bytes than asked for.
+//
+// A failed Writer.Write or Writer.Close aborts the write, so the blob is
+// left unchanged.
+//
+// URLs
+//
+// For blob.OpenBucket, chaosblob registers for the scheme "chaos".
+// To customize the URL opener, or for more details on the URL format,
+// see URLOpener.
+// See https://github.com/eliben/gocdkx/concepts/urls/ for background information.
+//
+// As
+//
+// chaosblob exposes the types of the inner bucket's provider for As.
+package chaosblob // import "github.com/eliben/gocdkx/blob/chaosblob"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/internal/gcerr"
+)
+
+// ErrInjected is wrapped by the errors injected by chaosblob, so that tests
+// can tell them from other errors with xerrors.Is.
+var ErrInjected = errors.New("chaosblob: injected error")
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+	wrapper.RegisterSchemes(Scheme, &URLOpener{})
+}
+
+// Scheme is the URL scheme chaosblob registers its URLOpener under on
+// blob.DefaultMux.
+const Scheme = "chaos"
+
+// URLOpener opens chaosblob URLs like "chaos+mem://?error_rate=0.1" or
+// "chaos://?bucket=mem%3A%2F%2F&fail_nth=3&methods=Writer.Close".
+//
+// For URLs with a scheme like "chaos+mem", the inner bucket is opened with
+// URLOpener.BucketMux from the URL with the scheme "mem", and the query
+// parameters that aren't listed below. These schemes are registered on
+// blob.DefaultMux for the providers in this module: "chaos+azblob",
+// "chaos+file", "chaos+gs", "chaos+mem" and "chaos+s3". For URLs with the
+// scheme "chaos", the query parameter "bucket" is required, and holds the
+// query-escaped URL of the inner bucket, which may use any scheme.
+//
+// The following query parameters configure a Fault for the methods listed in
+// "methods", overriding URLOpener.Options.Faults:
+//   - methods: A comma-separated list of method names, as for
+//       Options.Faults. Defaults to "*".
+//   - error_code: The name of the gcerrors.ErrorCode of the injected errors,
+//       e.g. "ResourceExhausted". Sets Fault.Code.
+//   - error_rate: Sets Fault.Probability, e.g. "0.1".
+//   - fail_nth: Sets Fault.Nth.
+//   - latency: Sets Fault.Latency, in the format accepted by
+//       time.ParseDuration, e.g. "100ms".
+// The following query parameters are also supported:
+//   - max_read_size: Overrides Options.MaxReadSize.
+//   - seed: Overrides Options.Seed.
+// Closing the returned bucket closes the inner bucket.
+type URLOpener struct {
+	// BucketMux opens the inner bucket. Defaults to blob.DefaultURLMux().
+	BucketMux *blob.URLMux
+	// Options specifies the options to pass to OpenBucket.
+	Options Options
+}
+
+// faultParams are the query parameters that configure a Fault.
+var faultParams = []string{"methods", "error_code", "error_rate", "fail_nth", "latency"}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	q := u.Query()
+	opts := o.Options
+	if s := q.Get("max_read_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q: %q", u, "max_read_size", s)
+		}
+		opts.MaxReadSize = n
+	}
+	if s := q.Get("seed"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q: %q", u, "seed", s)
+		}
+		opts.Seed = n
+	}
+	configured := false
+	for _, param := range faultParams {
+		if _, ok := q[param]; ok && param != "methods" {
+			configured = true
+		}
+	}
+	if configured {
+		f, err := parseFault(q)
+		if err != nil {
+			return nil, fmt.Errorf("open bucket %v: %v", u, err)
+		}
+		faults := map[string]Fault{}
+		for method, f := range opts.Faults {
+			faults[method] = f
+		}
+		methods := q.Get("methods")
+		if methods == "" {
+			methods = "*"
+		}
+		for _, method := range strings.Split(methods, ",") {
+			faults[strings.TrimSpace(method)] = f
+		}
+		opts.Faults = faults
+	} else if _, ok := q["methods"]; ok {
+		return nil, fmt.Errorf("open bucket %v: query parameter %q requires a fault to be configured", u, "methods")
+	}
+	for _, param := range append(faultParams, "max_read_size", "seed") {
+		q.Del(param)
+	}
+
+	// Find the URL of the inner bucket.
+	var innerURL *url.URL
+	if i := strings.Index(u.Scheme, Scheme+"+"); i >= 0 {
+		inner := *u
+		inner.Scheme = u.Scheme[i+len(Scheme)+1:]
+		inner.RawQuery = q.Encode()
+		innerURL = &inner
+	} else {
+		s := q.Get("bucket")
+		q.Del("bucket")
+		for param := range q {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+		if s == "" {
+			return nil, fmt.Errorf("open bucket %v: query parameter %q is required", u, "bucket")
+		}
+		var err error
+		if innerURL, err = url.Parse(s); err != nil {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q: %v", u, "bucket", err)
+		}
+	}
+	mux := o.BucketMux
+	if mux == nil {
+		mux = blob.DefaultURLMux()
+	}
+	inner, err := mux.OpenBucketURL(ctx, innerURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: failed to open inner bucket: %v", u, err)
+	}
+	b := openBucket(inner, &opts)
+	b.Owned = true
+	return blob.NewBucket(b), nil
+}
+
+// parseFault returns the Fault configured by the query parameters q.
+func parseFault(q url.Values) (Fault, error) {
+	var f Fault
+	if s := q.Get("error_code"); s != "" {
+		c, ok := gcerrors.ParseCode(s)
+		if !ok {
+			return f, fmt.Errorf("invalid query parameter %q: %q", "error_code", s)
+		}
+		f.Code = c
+	}
+	if s := q.Get("error_rate"); s != "" {
+		p, err := strconv.ParseFloat(s, 64)
+		if err != nil || p < 0 || p > 1 {
+			return f, fmt.Errorf("invalid query parameter %q: %q", "error_rate", s)
+		}
+		f.Probability = p
+	}
+	if s := q.Get("fail_nth"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("invalid query parameter %q: %q", "fail_nth", s)
+		}
+		f.Nth = n
+	}
+	if s := q.Get("latency"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return f, fmt.Errorf("invalid query parameter %q: %q", "latency", s)
+		}
+		f.Latency = d
+	}
+	return f, nil
+}
+
+// Fault describes how calls to a method fail or slow down.
+type Fault struct {
+	// Code is the error code of the injected errors. Defaults to
+	// gcerrors.Internal.
+	Code gcerrors.ErrorCode
+
+	// Probability is the probability, between 0 and 1, that a call fails.
+	Probability float64
+
+	// Nth, if positive, makes the Nth call to the method fail, counting
+	// from 1. Other calls only fail according to Probability.
+	Nth int
+
+	// Latency delays every call to the method, failed or not. If the
+	// context of the call is done first, the call returns its error.
+	Latency time.Duration
+}
+
+// Options sets options for constructing a *blob.Bucket backed by chaosblob.
+type Options struct {
+	// Faults maps the names of methods to the Fault to inject into their
+	// calls. The names are those of the methods of driver.Bucket, like
+	// "Attributes", "ListPaged", "NewRangeReader" or "NewTypedWriter", and
+	// those of the values they return, prefixed with the name of the type:
+	// "Reader.Read", "Writer.Write", "Writer.Close",
+	// "MultipartUpload.UploadPart", "MultipartUpload.ListParts",
+	// "MultipartUpload.Complete", "MultipartUpload.Abort" and "Watcher.Next".
+	// The Fault for the name "*" applies to the methods without one.
+	//
+	// Methods of the portable type can make several calls to the driver,
+	// or none: Bucket.ReadAll calls NewRangeReader once and Reader.Read
+	// until the end of the blob, and Bucket.Exists calls Attributes.
+	Faults map[string]Fault
+
+	// MaxReadSize, if positive, is the maximum number of bytes returned by a
+	// call to Reader.Read, to simulate short reads.
+	MaxReadSize int
+
+	// Seed seeds the random number generator deciding which calls fail, for
+	// reproducible tests. Defaults to a seed based on the current time.
+	Seed int64
+}
+
+// OpenBucket returns a *blob.Bucket that performs its operations on inner,
+// injecting the faults configured by opts. Closing the returned bucket
+// doesn't close inner, which must stay open while the returned bucket is in
+// use. A nil Options is treated the same as the zero value, which injects no
+// faults.
+func OpenBucket(inner *blob.Bucket, opts *Options) *blob.Bucket {
+	return blob.NewBucket(openBucket(inner, opts))
+}
+
+func openBucket(inner *blob.Bucket, opts *Options) *bucket {
+	b := &bucket{Bucket: wrapper.New(inner), calls: map[string]int{}}
+	if opts != nil {
+		b.opts = *opts
+	}
+	seed := b.opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	b.rand = rand.New(rand.NewSource(seed))
+	return b
+}
+
+// bucket implements driver.Bucket on top of the driver of another
+// *blob.Bucket.
+type bucket struct {
+	wrapper.Bucket
+	opts Options
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	calls map[string]int // number of calls of each method
+}
+
+// inject applies the Fault configured for method to a call of it. It
+// returns a non-nil error if the call should fail.
+func (b *bucket) inject(ctx context.Context, method string) error {
+	f, ok := b.opts.Faults[method]
+	if !ok {
+		f, ok = b.opts.Faults["*"]
+	}
+	b.mu.Lock()
+	b.calls[method]++
+	n := b.calls[method]
+	fail := ok && (n == f.Nth || f.Probability > 0 && b.rand.Float64() < f.Probability)
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	if !fail {
+		return nil
+	}
+	code := f.Code
+	if code == gcerrors.OK {
+		code = gcerrors.Internal
+	}
+	return gcerr.Newf(code, ErrInjected, "chaosblob: injected error in call %d of %s", n, method)
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	if err := b.inject(ctx, "Attributes"); err != nil {
+		return nil, err
+	}
+	return b.Bucket.Attributes(ctx, key)
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if err := b.inject(ctx, "ListPaged"); err != nil {
+		return nil, err
+	}
+	return b.Bucket.ListPaged(ctx, opts)
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if err := b.inject(ctx, "NewRangeReader"); err != nil {
+		return nil, err
+	}
+	r, err := b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{Reader: r, b: b, ctx: ctx}, nil
+}
+
+// reader reads a blob from the inner bucket.
+type reader struct {
+	driver.Reader
+	b   *bucket
+	ctx context.Context
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.b.inject(r.ctx, "Reader.Read"); err != nil {
+		return 0, err
+	}
+	if max := r.b.opts.MaxReadSize; max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	return r.Reader.Read(p)
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if err := b.inject(ctx, "NewTypedWriter"); err != nil {
+		return nil, err
+	}
+	// Cancel the write if an error is injected.
+	ctx, cancel := context.WithCancel(ctx)
+	w, err := b.Bucket.NewTypedWriter(ctx, key, contentType, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &writer{b: b, ctx: ctx, cancel: cancel, w: w}, nil
+}
+
+// writer writes a blob to the inner bucket.
+type writer struct {
+	b      *bucket
+	ctx    context.Context
+	cancel func()
+	w      driver.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.b.inject(w.ctx, "Writer.Write"); err != nil {
+		w.cancel()
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+func (w *writer) Close() error {
+	defer w.cancel()
+	if err := w.b.inject(w.ctx, "Writer.Close"); err != nil {
+		w.cancel()
+		_ = w.w.Close()
+		return err
+	}
+	return w.w.Close()
+}
+
+// Copy implements driver.Copy.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if err := b.inject(ctx, "Copy"); err != nil {
+		return err
+	}
+	return b.Bucket.Copy(ctx, dstKey, srcKey, opts)
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	if err := b.inject(ctx, "Compose"); err != nil {
+		return err
+	}
+	return b.Bucket.Compose(ctx, dstKey, srcKeys, opts)
+}
+
+// UpdateAttributes implements driver.UpdateAttributes.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	if err := b.inject(ctx, "UpdateAttributes"); err != nil {
+		return err
+	}
+	return b.Bucket.UpdateAttributes(ctx, key, opts)
+}
+
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	if err := b.inject(ctx, "ListVersions"); err != nil {
+		return nil, err
+	}
+	return b.Bucket.ListVersions(ctx, key)
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if err := b.inject(ctx, "Delete"); err != nil {
+		return err
+	}
+	return b.Bucket.Delete(ctx, key, opts)
+}
+
+// DeleteMany implements driver.DeleteMany.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	if err := b.inject(ctx, "DeleteMany"); err != nil {
+		return nil, err
+	}
+	return b.Bucket.DeleteMany(ctx, keys)
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	if err := b.inject(ctx, "SignedURL"); err != nil {
+		return "", err
+	}
+	return b.Bucket.SignedURL(ctx, key, opts)
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	if err := b.inject(ctx, "NewMultipartUpload"); err != nil {
+		return nil, err
+	}
+	u, err := b.Bucket.NewMultipartUpload(ctx, key, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{MultipartUpload: u, b: b}, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	if err := b.inject(ctx, "ResumeMultipartUpload"); err != nil {
+		return nil, err
+	}
+	u, err := b.Bucket.ResumeMultipartUpload(ctx, key, uploadID, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{MultipartUpload: u, b: b}, nil
+}
+
+// multipartUpload uploads a blob to the inner bucket.
+type multipartUpload struct {
+	driver.MultipartUpload
+	b *bucket
+}
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	if err := u.b.inject(ctx, "MultipartUpload.UploadPart"); err != nil {
+		return err
+	}
+	return u.MultipartUpload.UploadPart(ctx, partNumber, p)
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	if err := u.b.inject(ctx, "MultipartUpload.ListParts"); err != nil {
+		return nil, err
+	}
+	return u.MultipartUpload.ListParts(ctx)
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	if err := u.b.inject(ctx, "MultipartUpload.Complete"); err != nil {
+		return err
+	}
+	return u.MultipartUpload.Complete(ctx)
+}
+
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	if err := u.b.inject(ctx, "MultipartUpload.Abort"); err != nil {
+		return err
+	}
+	return u.MultipartUpload.Abort(ctx)
+}
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	if err := b.inject(ctx, "Watch"); err != nil {
+		return nil, err
+	}
+	w, err := b.Bucket.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{Watcher: w, b: b}, nil
+}
+
+// watcher reports the events of the inner bucket's Watcher. It implements
+// driver.Watcher.
+type watcher struct {
+	driver.Watcher
+	b *bucket
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	if err := w.b.inject(ctx, "Watcher.Next"); err != nil {
+		return nil, err
+	}
+	return w.Watcher.Next(ctx)
+}
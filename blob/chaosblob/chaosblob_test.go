@@ -0,0 +1,341 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chaosblob
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+	"golang.org/x/xerrors"
+)
+
+type harness struct {
+	inner *blob.Bucket
+	opts  *Options
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.inner, h.opts), nil
+}
+
+func (h *harness) Close() {
+	h.inner.Close()
+}
+
+func TestConformance(t *testing.T) {
+	newHarness := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{inner: memblob.OpenBucket(nil)}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	if err := inner.WriteAll(ctx, "key", []byte("hello world"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b := OpenBucket(inner, &Options{
+		Faults: map[string]Fault{
+			"Attributes":   {Nth: 2, Code: gcerrors.ResourceExhausted},
+			"Reader.Read":  {Nth: 3},
+			"Writer.Close": {Probability: 1},
+			"*":            {Nth: 1},
+		},
+		MaxReadSize: 2,
+	})
+	defer b.Close()
+
+	// Only the second call to Attributes fails.
+	for i, wantCode := range []gcerrors.ErrorCode{gcerrors.OK, gcerrors.ResourceExhausted, gcerrors.OK} {
+		_, err := b.Attributes(ctx, "key")
+		if got := gcerrors.Code(err); got != wantCode {
+			t.Errorf("Attributes call %d: got code %v want %v", i+1, got, wantCode)
+		}
+		if err != nil && !xerrors.Is(err, ErrInjected) {
+			t.Errorf("Attributes call %d: got error %v, want it to wrap ErrInjected", i+1, err)
+		}
+	}
+
+	// The first call to methods without their own Fault fails.
+	if _, err := b.NewReader(ctx, "key", nil); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v from the first NewRangeReader, want Internal", err)
+	}
+	r, err := b.NewReader(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	p := make([]byte, 10)
+	for i := 1; i <= 2; i++ {
+		n, err := r.Read(p)
+		if err != nil || string(p[:n]) != "hello world"[2*(i-1):2*i] {
+			t.Errorf("Read %d: got %q, %v want a short read", i, p[:n], err)
+		}
+	}
+	if _, err := r.Read(p); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v from the third Read, want Internal", err)
+	}
+
+	// Failed writes leave the blob unchanged.
+	if err := b.WriteAll(ctx, "key", []byte("bye"), nil); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v writing, want Internal", err)
+	}
+	if got, err := inner.ReadAll(ctx, "key"); err != nil || string(got) != "hello world" {
+		t.Errorf("got %q, %v after a failed write want %q", got, err, "hello world")
+	}
+}
+
+func TestLatency(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	b := OpenBucket(inner, &Options{Faults: map[string]Fault{"Writer.Write": {Latency: 50 * time.Millisecond}}})
+	defer b.Close()
+
+	start := time.Now()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("write took %v, want at least 50ms", d)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := b.WriteAll(ctx, "key", []byte("bye"), nil); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+		t.Errorf("got error %v writing with a short timeout, want DeadlineExceeded", err)
+	}
+	if got, err := inner.ReadAll(context.Background(), "key"); err != nil || string(got) != "hello" {
+		t.Errorf("got %q, %v after a timed out write want %q", got, err, "hello")
+	}
+}
+
+func TestProbability(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	if err := inner.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Buckets with the same seed fail the same calls.
+	failures := func() []bool {
+		b := OpenBucket(inner, &Options{Faults: map[string]Fault{"*": {Probability: 0.5}}, Seed: 1})
+		defer b.Close()
+		var failed []bool
+		for i := 0; i < 100; i++ {
+			_, err := b.Attributes(ctx, "key")
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+	a, b := failures(), failures()
+	n := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("call %d: got different results with the same seed", i)
+		}
+		if a[i] {
+			n++
+		}
+	}
+	if n < 20 || n > 80 {
+		t.Errorf("got %d failures out of 100 calls, want about 50", n)
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		URL      string
+		WantErr  bool
+		WantCode gcerrors.ErrorCode
+	}{
+		{"chaos+mem://", false, gcerrors.OK},
+		{"chaos+mem://?error_rate=1", false, gcerrors.Internal},
+		{"chaos+mem://?error_rate=1&error_code=PermissionDenied", false, gcerrors.PermissionDenied},
+		{"chaos+mem://?fail_nth=1&methods=Attributes,NewTypedWriter", false, gcerrors.Internal},
+		{"chaos+mem://?fail_nth=1&methods=Delete", false, gcerrors.OK},
+		{"chaos+mem://?max_read_size=1&seed=3&latency=1ms", false, gcerrors.OK},
+		{"blob+chaos+mem://?fail_nth=1", false, gcerrors.Internal},
+		{"chaos://?bucket=" + url.QueryEscape("mem://") + "&fail_nth=1", false, gcerrors.Internal},
+		// Invalid parameters.
+		{"chaos+mem://?error_rate=2", true, gcerrors.OK},
+		{"chaos+mem://?error_code=Oops&error_rate=1", true, gcerrors.OK},
+		{"chaos+mem://?fail_nth=0", true, gcerrors.OK},
+		{"chaos+mem://?latency=x", true, gcerrors.OK},
+		{"chaos+mem://?max_read_size=x", true, gcerrors.OK},
+		{"chaos+mem://?seed=x", true, gcerrors.OK},
+		{"chaos+mem://?methods=Attributes", true, gcerrors.OK},
+		// Parameters of the inner bucket.
+		{"chaos+mem://?param=value", true, gcerrors.OK},
+		// Missing or invalid inner bucket.
+		{"chaos://", true, gcerrors.OK},
+		{"chaos://?bucket=" + url.QueryEscape("mem://") + "&param=value", true, gcerrors.OK},
+		{"chaos+nope://", true, gcerrors.OK},
+	}
+	for _, test := range tests {
+		b, err := blob.OpenBucket(ctx, test.URL)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+		if err != nil {
+			continue
+		}
+		err = b.WriteAll(ctx, "key", []byte("hello"), nil)
+		if got := gcerrors.Code(err); got != test.WantCode {
+			t.Errorf("%s: got code %v writing want %v", test.URL, got, test.WantCode)
+		}
+		if err := b.Close(); err != nil {
+			t.Errorf("%s: %v", test.URL, err)
+		}
+	}
+}
+
+// TestShortReads checks that reads capped by Options.MaxReadSize return the
+// full content of ranged reads, a few bytes at a time.
+func TestShortReads(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	const content = "hello world"
+	if err := inner.WriteAll(ctx, "key", []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := OpenBucket(inner, &Options{MaxReadSize: 3})
+	defer b.Close()
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, content},
+		{0, 5, "hello"},
+		{6, -1, "world"},
+		{2, 7, "llo wor"},
+		{11, -1, ""},
+	}
+	for _, test := range tests {
+		r, err := b.NewRangeReader(ctx, "key", test.offset, test.length, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		p := make([]byte, len(content)+10)
+		for {
+			n, err := r.Read(p)
+			if n > 3 {
+				t.Errorf("offset %d length %d: got a read of %d bytes want at most 3", test.offset, test.length, n)
+			}
+			got = append(got, p[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if string(got) != test.want {
+			t.Errorf("offset %d length %d: got %q want %q", test.offset, test.length, got, test.want)
+		}
+		if r.Size() != int64(len(content)) {
+			t.Errorf("offset %d length %d: got size %d want %d", test.offset, test.length, r.Size(), len(content))
+		}
+		r.Close()
+	}
+}
+
+func TestReadAllShortReads(t *testing.T) {
+	ctx := context.Background()
+	b, err := blob.OpenBucket(ctx, "chaos+mem://?max_read_size=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewReader(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	p := make([]byte, 5)
+	if n, err := r.Read(p); n != 1 || err != nil {
+		t.Errorf("got %d, %v want a read of 1 byte", n, err)
+	}
+	if n, err := io.ReadFull(r, p[1:]); n != 4 || err != nil || string(p) != "hello" {
+		t.Errorf("got %q, %v want %q", p, err, "hello")
+	}
+}
+
+// rawBucket records the ReaderOptions.Raw of the reads from a bucket.
+type rawBucket struct {
+	driver.Bucket
+	raw []bool
+}
+
+func (b *rawBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.raw = append(b.raw, opts.Raw)
+	return b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+}
+
+func TestDriverOptions(t *testing.T) {
+	ctx := context.Background()
+	rb := &rawBucket{Bucket: wrapper.Driver(memblob.OpenBucket(nil))}
+	inner := blob.NewBucket(rb)
+	defer inner.Close()
+	b := OpenBucket(inner, nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{Compression: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewReader(ctx, "key", &blob.ReaderOptions{Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q want %q", got, "hello")
+	}
+	// The read must reach the inner driver once, with Raw set.
+	if len(rb.raw) != 1 || !rb.raw[0] {
+		t.Errorf("got Raw %v for the reads of the inner driver, want [true]", rb.raw)
+	}
+}
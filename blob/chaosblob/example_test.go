@@ -0,0 +1,63 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chaosblob_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/chaosblob"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+func ExampleOpenBucket() {
+	ctx := context.Background()
+
+	// Make the second write to the bucket fail, as if the provider was
+	// overloaded.
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	bucket := chaosblob.OpenBucket(inner, &chaosblob.Options{
+		Faults: map[string]chaosblob.Fault{
+			"Writer.Close": {Nth: 2, Code: gcerrors.ResourceExhausted},
+		},
+	})
+	defer bucket.Close()
+
+	for i := 1; i <= 3; i++ {
+		err := bucket.WriteAll(ctx, "key", []byte("hello"), nil)
+		fmt.Printf("write %d: %v\n", i, gcerrors.Code(err))
+	}
+
+	// Output:
+	// write 1: OK
+	// write 2: ResourceExhausted
+	// write 3: OK
+}
+
+func Example_openBucket() {
+	ctx := context.Background()
+
+	// Open an in-memory bucket in which 10% of the calls fail, and every
+	// call takes 20ms.
+	bucket, err := blob.OpenBucket(ctx, "chaos+mem://?error_rate=0.1&latency=20ms")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
@@ -0,0 +1,270 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/eliben/gocdkx/blob/driver"
+)
+
+// Compressor compresses and decompresses blob content for a content coding;
+// see RegisterCompressor.
+type Compressor interface {
+	// NewWriter returns a WriteCloser that compresses the bytes written to
+	// it, and writes them to w. Close must flush any buffered bytes to w, but
+	// not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a ReadCloser that decompresses the bytes read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// UncompressedSizeKey is the Metadata key under which a Writer with
+// WriterOptions.Compression stores the uncompressed size of the blob;
+// see Attributes.UncompressedSize.
+const UncompressedSizeKey = "uncompressed-size"
+
+// maxCompressedBuffer is the number of compressed bytes that a Writer with
+// WriterOptions.Compression buffers before it starts uploading. Blobs that
+// are at most this size once compressed are uploaded with their uncompressed
+// size in their metadata.
+const maxCompressedBuffer = 1024 * 1024
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{"gzip": gzipCompressor{}}
+)
+
+// RegisterCompressor makes c available to compress blobs with the content
+// coding encoding, using WriterOptions.Compression, and to decompress blobs
+// whose ContentEncoding is encoding, using ReaderOptions.Decompress.
+// "gzip" is registered by default. For example, to support "zstd", register
+// a Compressor implemented with a zstd package.
+//
+// RegisterCompressor panics if a Compressor is already registered for
+// encoding.
+func RegisterCompressor(encoding string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, ok := compressors[encoding]; ok {
+		panic(fmt.Sprintf("blob: a Compressor is already registered for %q", encoding))
+	}
+	compressors[encoding] = c
+}
+
+// compressor returns the Compressor registered for encoding, or nil.
+func compressor(encoding string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[encoding]
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := gzip.NewReader(r)
+	if err == io.EOF {
+		// An empty blob.
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return zr, err
+}
+
+// uncompressedSize returns the uncompressed size of a blob with attributes
+// a, or -1 if it is not known.
+func uncompressedSize(a *driver.Attributes) int64 {
+	if a.ContentEncoding == "" || a.ContentEncoding == "identity" {
+		return a.Size
+	}
+	for k, v := range a.Metadata {
+		// Some providers change the case of metadata keys.
+		if strings.EqualFold(k, UncompressedSizeKey) {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+// compressingWriter implements driver.Writer by compressing the bytes written
+// to it and writing them to a driver.Writer created with open.
+//
+// The driver.Writer is only created once maxCompressedBuffer compressed
+// bytes have been buffered, or at Close, in which case the uncompressed size
+// is known and is passed to open.
+type compressingWriter struct {
+	ctx    context.Context
+	cancel func()
+	// open creates the driver.Writer. size is the uncompressed size of the
+	// blob, or -1 if it is not known yet.
+	open func(ctx context.Context, size int64) (driver.Writer, error)
+
+	zw  io.WriteCloser
+	n   int64        // number of uncompressed bytes written
+	buf bytes.Buffer // compressed bytes, until w is created
+	w   driver.Writer
+	err error // the first error writing to w
+}
+
+func newCompressingWriter(ctx context.Context, c Compressor, open func(ctx context.Context, size int64) (driver.Writer, error)) (*compressingWriter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &compressingWriter{ctx: ctx, cancel: cancel, open: open}
+	zw, err := c.NewWriter(writerFunc(w.writeCompressed))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w.zw = zw
+	return w, nil
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func (w *compressingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.zw.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// writeCompressed writes compressed bytes, buffering them until the
+// driver.Writer is created.
+func (w *compressingWriter) writeCompressed(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.w == nil {
+		w.buf.Write(p)
+		if w.buf.Len() <= maxCompressedBuffer {
+			return len(p), nil
+		}
+		if err := w.flush(-1); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if _, err := w.w.Write(p); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush creates the driver.Writer and writes the buffered bytes to it.
+func (w *compressingWriter) flush(size int64) error {
+	if err := w.ctx.Err(); err != nil {
+		w.err = err
+		return err
+	}
+	dw, err := w.open(w.ctx, size)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.w = dw
+	if _, err := w.w.Write(w.buf.Bytes()); err != nil {
+		w.err = err
+		return err
+	}
+	w.buf = bytes.Buffer{}
+	return nil
+}
+
+func (w *compressingWriter) Close() error {
+	defer w.cancel()
+	err := w.zw.Close()
+	if err == nil {
+		err = w.err
+	}
+	if err == nil && w.w == nil {
+		err = w.flush(w.n)
+	}
+	if w.w == nil {
+		return err
+	}
+	if err != nil {
+		// Abort the write.
+		w.cancel()
+		_ = w.w.Close()
+		return err
+	}
+	return w.w.Close()
+}
+
+// decompressingReader implements driver.Reader by decompressing the content
+// read from a driver.Reader, and returning length bytes of it after skipping
+// offset bytes.
+type decompressingReader struct {
+	r      driver.Reader
+	zr     io.ReadCloser
+	lr     io.Reader
+	offset int64
+}
+
+func newDecompressingReader(r driver.Reader, c Compressor, offset, length int64) (*decompressingReader, error) {
+	zr, err := c.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	d := &decompressingReader{r: r, zr: zr, lr: zr, offset: offset}
+	if length >= 0 {
+		d.lr = io.LimitReader(zr, length)
+	}
+	return d, nil
+}
+
+func (d *decompressingReader) Read(p []byte) (int, error) {
+	if d.offset > 0 {
+		n, err := io.CopyN(ioutil.Discard, d.zr, d.offset)
+		d.offset -= n
+		if err != nil {
+			return 0, err
+		}
+	}
+	return d.lr.Read(p)
+}
+
+func (d *decompressingReader) Close() error {
+	err := d.zr.Close()
+	if cerr := d.r.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d *decompressingReader) Attributes() *driver.ReaderAttributes {
+	return d.r.Attributes()
+}
+
+func (d *decompressingReader) As(i interface{}) bool {
+	return d.r.As(i)
+}
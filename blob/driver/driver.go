@@ -18,6 +18,7 @@ package driver // import "github.com/eliben/gocdkx/blob/driver"
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"time"
 
@@ -32,6 +33,17 @@ type ReaderOptions struct {
 	// asFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	BeforeRead func(asFunc func(interface{}) bool) error
+	// Conditions holds preconditions for the read, or nil if there are none.
+	// If set, IfNotExist is guaranteed to be false.
+	Conditions *Conditions
+	// Version, if not empty, is the ID of the version of the object to read,
+	// as returned in Version.ID. If versions are not supported, NewRangeReader
+	// must return an error for which ErrorCode returns gcerrors.Unimplemented.
+	Version string
+	// Raw, if true, requires the Reader to return the object's content as
+	// stored, even if it has a ContentEncoding that the provider or the HTTP
+	// client would otherwise decode, e.g. by decompressing gzip content.
+	Raw bool
 }
 
 // Reader reads an object from the blob.
@@ -88,6 +100,91 @@ type WriterOptions struct {
 	// asFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	BeforeWrite func(asFunc func(interface{}) bool) error
+	// Conditions holds preconditions for the write, checked against any
+	// existing object with the same key, or nil if there are none.
+	// If set, IfModifiedSince is guaranteed to be zero.
+	Conditions *Conditions
+	// Expires, if not zero, is the time after which the object expires and
+	// should be deleted. Providers should map it to a native feature where
+	// possible, report it in Attributes.Expires, and keep it when the
+	// object is copied.
+	Expires time.Time
+	// StorageClass is the storage class to write the object with, or
+	// StorageClassDefault for the provider's default. If the provider has
+	// no equivalent, NewTypedWriter must return an error for which
+	// ErrorCode returns gcerrors.Unimplemented.
+	StorageClass StorageClass
+	// Append, if true, means that the content written must be appended to
+	// the object's current content, creating the object if it doesn't exist.
+	// The other attributes only apply to new objects. The append must be
+	// atomic: concurrent appends must not interleave, and an append that
+	// fails or is aborted must leave the object unchanged. If the provider
+	// can't append atomically, NewTypedWriter must return an error for which
+	// ErrorCode returns gcerrors.Unimplemented.
+	// If Append is true, ContentMD5 is guaranteed to be empty, and the
+	// options are not passed to NewMultipartUpload.
+	Append bool
+}
+
+// StorageClass is a portable storage class, or tier. Colder classes have
+// lower storage costs, and higher access costs or latency.
+type StorageClass int
+
+const (
+	// StorageClassDefault means the provider's default storage class, or in
+	// Attributes, that the provider didn't report a class with a portable
+	// equivalent.
+	StorageClassDefault StorageClass = iota
+	// StorageClassStandard is for frequently accessed objects.
+	StorageClassStandard
+	// StorageClassInfrequentAccess is for objects accessed about once a
+	// month or less.
+	StorageClassInfrequentAccess
+	// StorageClassCold is for objects accessed about once a quarter or less.
+	StorageClassCold
+	// StorageClassArchive is for objects accessed about once a year or less,
+	// which may need to be restored before they can be read.
+	StorageClassArchive
+)
+
+func (c StorageClass) String() string {
+	switch c {
+	case StorageClassDefault:
+		return "default"
+	case StorageClassStandard:
+		return "standard"
+	case StorageClassInfrequentAccess:
+		return "infrequent access"
+	case StorageClassCold:
+		return "cold"
+	case StorageClassArchive:
+		return "archive"
+	default:
+		return fmt.Sprintf("StorageClass(%d)", int(c))
+	}
+}
+
+// ComposeOptions controls options for Compose. The attributes are those of
+// the composed object, as for WriterOptions.
+type ComposeOptions struct {
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	// ContentType is guaranteed to be non-empty.
+	ContentType string
+	// Metadata holds key/value strings to be associated with the object.
+	// Keys are guaranteed to be non-empty and lowercased.
+	Metadata map[string]string
+	// BeforeCompose is a callback that must be called exactly once before
+	// the object is composed, unless Compose returns an error first.
+	// asFunc allows providers to expose provider-specific types;
+	// see Bucket.As for more details.
+	BeforeCompose func(asFunc func(interface{}) bool) error
+	// Conditions holds preconditions checked against the destination
+	// object, or nil if there are none.
+	// If set, IfModifiedSince is guaranteed to be zero.
+	Conditions *Conditions
 }
 
 // CopyOptions controls options for Copy.
@@ -96,6 +193,104 @@ type CopyOptions struct {
 	// asFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	BeforeCopy func(asFunc func(interface{}) bool) error
+	// Conditions holds preconditions checked against the destination object,
+	// or nil if there are none.
+	// If set, IfModifiedSince is guaranteed to be zero.
+	Conditions *Conditions
+	// StorageClass is the storage class of the copy, as for
+	// WriterOptions.StorageClass.
+	StorageClass StorageClass
+}
+
+// UpdateAttributesOptions controls options for UpdateAttributes. Each of
+// the attributes that is not nil replaces the current one.
+type UpdateAttributesOptions struct {
+	CacheControl       *string
+	ContentDisposition *string
+	ContentEncoding    *string
+	ContentLanguage    *string
+	// ContentType, if not nil, is guaranteed to be non-empty.
+	ContentType *string
+	// Metadata holds key/value strings to add to the object's metadata,
+	// replacing the values of existing keys. Keys are guaranteed to be
+	// non-empty and lowercased.
+	Metadata map[string]string
+	// DeleteMetadata holds the keys to remove from the object's metadata.
+	// Keys are guaranteed to be non-empty, lowercased, and not in Metadata.
+	DeleteMetadata []string
+	// BeforeUpdate is a callback that must be called exactly once before
+	// the attributes are updated, unless UpdateAttributes returns an error
+	// first.
+	// asFunc allows providers to expose provider-specific types;
+	// see Bucket.As for more details.
+	BeforeUpdate func(asFunc func(interface{}) bool) error
+	// Conditions holds preconditions for the update, or nil if there are
+	// none.
+	// If set, IfNotExist is guaranteed to be false and IfModifiedSince to be
+	// zero.
+	Conditions *Conditions
+}
+
+// Apply updates the attributes in a that are set in opts. a.Metadata is
+// replaced by a new map rather than modified.
+func (opts *UpdateAttributesOptions) Apply(a *Attributes) {
+	if opts.CacheControl != nil {
+		a.CacheControl = *opts.CacheControl
+	}
+	if opts.ContentDisposition != nil {
+		a.ContentDisposition = *opts.ContentDisposition
+	}
+	if opts.ContentEncoding != nil {
+		a.ContentEncoding = *opts.ContentEncoding
+	}
+	if opts.ContentLanguage != nil {
+		a.ContentLanguage = *opts.ContentLanguage
+	}
+	if opts.ContentType != nil {
+		a.ContentType = *opts.ContentType
+	}
+	if len(opts.Metadata) == 0 && len(opts.DeleteMetadata) == 0 {
+		return
+	}
+	md := make(map[string]string, len(a.Metadata)+len(opts.Metadata))
+	for k, v := range a.Metadata {
+		md[k] = v
+	}
+	for _, k := range opts.DeleteMetadata {
+		delete(md, k)
+	}
+	for k, v := range opts.Metadata {
+		md[k] = v
+	}
+	a.Metadata = md
+}
+
+// DeleteOptions controls options for Delete.
+type DeleteOptions struct {
+	// Conditions holds preconditions for the delete, or nil if there are none.
+	// If set, IfNotExist is guaranteed to be false and IfModifiedSince to be
+	// zero.
+	Conditions *Conditions
+	// Version, if not empty, is the ID of the version of the object to delete,
+	// as returned in Version.ID. If versions are not supported, Delete must
+	// return an error for which ErrorCode returns gcerrors.Unimplemented.
+	Version string
+}
+
+// Conditions are preconditions on the state of an existing object. If any of
+// the set preconditions is not satisfied, the operation must not be performed
+// and must return an error for which ErrorCode returns
+// gcerrors.FailedPrecondition. At least one field is guaranteed to be set.
+type Conditions struct {
+	// IfNotExist requires that no object exists with the key.
+	IfNotExist bool
+	// IfMatch, if not empty, requires that the object exists and that its
+	// ETag, as returned in Attributes.ETag, equals IfMatch.
+	IfMatch string
+	// IfModifiedSince, if not zero, requires that the object was modified
+	// after IfModifiedSince. Providers may compare times with a granularity
+	// of one second.
+	IfModifiedSince time.Time
 }
 
 // ReaderAttributes contains a subset of attributes about a blob that are
@@ -141,6 +336,18 @@ type Attributes struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// ETag is an opaque identifier for the current version of the blob. It
+	// must change whenever the blob is rewritten with different content, and
+	// must be accepted by Conditions.IfMatch.
+	ETag string
+	// Expires is the time after which the object expires, as set by
+	// WriterOptions.Expires, or zero if it doesn't expire or the provider
+	// doesn't report it.
+	Expires time.Time
+	// StorageClass is the storage class of the object, or
+	// StorageClassDefault if the provider doesn't report it, or it has no
+	// portable equivalent.
+	StorageClass StorageClass
 	// AsFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	// If not set, no provider-specific types are supported.
@@ -188,6 +395,9 @@ type ListObject struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// ETag is an opaque identifier for the current version of the blob;
+	// see Attributes.ETag.
+	ETag string
 	// IsDir indicates that this result represents a "directory" in the
 	// hierarchical namespace, ending in ListOptions.Delimiter. Key can be
 	// passed as ListOptions.Prefix to list items in the "directory".
@@ -199,6 +409,25 @@ type ListObject struct {
 	AsFunc func(interface{}) bool
 }
 
+// Version describes a version of an object, returned from ListVersions.
+type Version struct {
+	// ID identifies the version among the versions of the object.
+	ID string
+	// ModTime is the time the version was created.
+	ModTime time.Time
+	// Size is the size of the version in bytes.
+	Size int64
+	// ETag is an opaque identifier for the content of the version;
+	// see Attributes.ETag.
+	ETag string
+	// IsLatest is true if the version is the current version of the object.
+	IsLatest bool
+	// AsFunc allows providers to expose provider-specific types;
+	// see Bucket.As for more details.
+	// If not set, no provider-specific types are supported.
+	AsFunc func(interface{}) bool
+}
+
 // ListPage represents a page of results return from ListPaged.
 type ListPage struct {
 	// Objects is the slice of objects found. If ListOptions.PageSize > 0,
@@ -216,6 +445,98 @@ type ListPage struct {
 	NextPageToken []byte
 }
 
+// WatchOptions controls Watch.
+type WatchOptions struct {
+	// Prefix indicates that only events for objects with a key starting with
+	// this prefix are wanted. Providers may use it to filter events; the
+	// portable type drops events for other keys.
+	Prefix string
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventCreated means that an object was created or overwritten.
+	EventCreated EventType = iota
+	// EventDeleted means that an object was deleted.
+	EventDeleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventDeleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event describes a change to an object, returned from Watcher.Next.
+type Event struct {
+	// Type is the type of the change.
+	Type EventType
+	// Key is the key of the object.
+	Key string
+	// ModTime, Size and ETag are the attributes of the object for
+	// EventCreated, if the provider reports them.
+	ModTime time.Time
+	Size    int64
+	ETag    string
+	// AsFunc allows providers to expose provider-specific types;
+	// see Bucket.As for more details.
+	// If not set, no provider-specific types are supported.
+	AsFunc func(interface{}) bool
+}
+
+// Watcher reports changes to the objects in a bucket.
+type Watcher interface {
+	// Next blocks until changes have happened since the previous call, or
+	// since the Watcher was created, and returns events describing them,
+	// oldest first. It must return at least one event, or an error.
+	// If ctx is done, Next must return ctx.Err().
+	Next(ctx context.Context) ([]*Event, error)
+
+	// As allows providers to expose provider-specific types;
+	// see Bucket.As for more details.
+	As(i interface{}) bool
+
+	// Close stops watching. It may be called while Next is blocked, in which
+	// case Next must return promptly, with any error. Next will not be called
+	// after Close.
+	Close() error
+}
+
+// Notification is a change notification received from a
+// NotificationSource.
+type Notification struct {
+	// Body is the content of the notification.
+	Body []byte
+	// Metadata holds the attributes of the notification, if any.
+	Metadata map[string]string
+	// Ack acknowledges the notification, so that it isn't received again.
+	Ack func()
+	// AsFunc allows sources to expose source-specific types;
+	// see Bucket.As for more details.
+	// If not set, no source-specific types are supported.
+	AsFunc func(interface{}) bool
+}
+
+// NotificationSource receives the change notifications that providers like
+// s3blob and gcsblob use to implement Watch, from a messaging service.
+// Package blobpubsub implements it using a *pubsub.Subscription.
+type NotificationSource interface {
+	// Receive blocks until a notification is available, and returns it.
+	// If ctx is done, Receive must return ctx.Err().
+	Receive(ctx context.Context) (*Notification, error)
+
+	// As allows sources to expose source-specific types;
+	// see Bucket.As for more details.
+	As(i interface{}) bool
+}
+
 // Bucket provides read, write and delete operations on objects within it on the
 // blob service.
 type Bucket interface {
@@ -281,17 +602,94 @@ type Bucket interface {
 	// opts is guaranteed to be non-nil.
 	Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error
 
+	// Compose creates or replaces the object associated with dstKey with the
+	// concatenation of the contents of the objects associated with srcKeys,
+	// in order, without downloading them if the provider supports it.
+	// srcKeys is guaranteed to be non-empty, and may hold dstKey or the same
+	// key more than once.
+	//
+	// If a source object does not exist, Compose must return an error for
+	// which ErrorCode returns gcerrors.NotFound, and leave the destination
+	// unchanged.
+	//
+	// opts is guaranteed to be non-nil.
+	Compose(ctx context.Context, dstKey string, srcKeys []string, opts *ComposeOptions) error
+
+	// UpdateAttributes changes attributes of the object associated with key,
+	// as described by opts, without rewriting its content. If the object
+	// does not exist, UpdateAttributes must return an error for which
+	// ErrorCode returns gcerrors.NotFound.
+	// opts is guaranteed to be non-nil.
+	UpdateAttributes(ctx context.Context, key string, opts *UpdateAttributesOptions) error
+
+	// ListVersions returns the versions of the object associated with key,
+	// newest first. It should include noncurrent versions of an object that
+	// has been deleted, but not delete markers. If there are no versions, it
+	// should return an empty slice and no error.
+	// If not supported, return an error for which ErrorCode returns
+	// gcerrors.Unimplemented.
+	ListVersions(ctx context.Context, key string) ([]*Version, error)
+
 	// Delete deletes the object associated with key. If the specified object does
 	// not exist, Delete must return an error for which ErrorCode returns
 	// gcerrors.NotFound.
-	Delete(ctx context.Context, key string) error
+	// opts is guaranteed to be non-nil.
+	Delete(ctx context.Context, key string, opts *DeleteOptions) error
 
-	// SignedURL returns a URL that can be used to GET the blob for the duration
-	// specified in opts.Expiry. opts is guaranteed to be non-nil.
+	// DeleteMany deletes the objects associated with keys, using a batch
+	// endpoint of the provider. keys is guaranteed to be non-empty and to
+	// have at most 1000 entries.
+	// If the batch request itself fails, DeleteMany returns a nil slice and
+	// the error. Otherwise it returns a slice of the same length as keys,
+	// holding the error, if any, for deleting each key. Objects that do not
+	// exist may be reported either as deleted or with an error for which
+	// ErrorCode returns gcerrors.NotFound.
+	// If the provider has no batch endpoint, return an error for which
+	// ErrorCode returns gcerrors.Unimplemented; the concrete type will fall
+	// back to calling Delete for each key.
+	DeleteMany(ctx context.Context, keys []string) ([]error, error)
+
+	// SignedURL returns a URL that can be used to access the blob using
+	// opts.Method for the duration specified in opts.Expiry. opts is
+	// guaranteed to be non-nil.
 	// If not supported, return an error for which ErrorCode returns
 	// gcerrors.Unimplemented.
 	SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error)
 
+	// NewMultipartUpload starts a new upload of an object associated with key,
+	// whose content is uploaded in independently numbered parts.
+	//
+	// contentType sets the MIME type of the object to be written. It must not be
+	// empty. opts is guaranteed to be non-nil; BufferSize and ContentMD5 should
+	// be ignored. BeforeWrite must be called exactly once before the upload is
+	// started.
+	//
+	// If not supported, return an error for which ErrorCode returns
+	// gcerrors.Unimplemented.
+	NewMultipartUpload(ctx context.Context, key, contentType string, opts *WriterOptions) (MultipartUpload, error)
+
+	// ResumeMultipartUpload returns a MultipartUpload for an in-progress
+	// upload previously started by NewMultipartUpload for the same key.
+	// uploadID is the value returned by MultipartUpload.ID.
+	//
+	// contentType and opts are the same as those passed to NewMultipartUpload;
+	// providers that only apply them when the upload is completed may use them
+	// then. BeforeWrite should not be called.
+	//
+	// If the upload does not exist (e.g., because it was already completed or
+	// aborted), ResumeMultipartUpload must return an error for which ErrorCode
+	// returns gcerrors.NotFound. If not supported, return an error for which
+	// ErrorCode returns gcerrors.Unimplemented.
+	ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *WriterOptions) (MultipartUpload, error)
+
+	// Watch returns a Watcher that reports objects that are created,
+	// overwritten or deleted after Watch returns. Providers should deliver
+	// events for each change at least once, but may deliver them late, or
+	// more than once. opts is guaranteed to be non-nil.
+	// If not supported, return an error for which ErrorCode returns
+	// gcerrors.Unimplemented.
+	Watch(ctx context.Context, opts *WatchOptions) (Watcher, error)
+
 	// Close cleans up any resources used by the Bucket. Once Close is called,
 	// there will be no method calls to the Bucket other than As, ErrorAs, and
 	// ErrorCode. There may be open readers or writers that will receive calls.
@@ -299,8 +697,42 @@ type Bucket interface {
 	Close() error
 }
 
+// MultipartUpload uploads an object in parts. Parts may be uploaded
+// concurrently and in any order; when the upload is completed, the object's
+// content is the concatenation of the uploaded parts in ascending order of
+// part number.
+type MultipartUpload interface {
+	// ID returns an opaque identifier for the upload, that can be passed to
+	// Bucket.ResumeMultipartUpload.
+	ID() string
+
+	// UploadPart uploads p as the part numbered partNumber, replacing any part
+	// previously uploaded with the same number. partNumber is guaranteed to be
+	// between 1 and 10000. UploadPart may be called concurrently.
+	UploadPart(ctx context.Context, partNumber int, p []byte) error
+
+	// ListParts returns the numbers of the parts that have been uploaded so
+	// far, in ascending order.
+	ListParts(ctx context.Context) ([]int, error)
+
+	// Complete assembles the uploaded parts into the object. It may return an
+	// error if no parts have been uploaded.
+	Complete(ctx context.Context) error
+
+	// Abort cancels the upload and discards any uploaded parts.
+	Abort(ctx context.Context) error
+}
+
 // SignedURLOptions sets options for SignedURL.
 type SignedURLOptions struct {
 	// Expiry sets how long the returned URL is valid for. It is guaranteed to be > 0.
 	Expiry time.Duration
+	// Method is the HTTP method that can be used on the URL; one of "GET",
+	// "PUT", or "DELETE". Drivers must implement all 3.
+	Method string
+	// ContentType, if not empty, is the Content-Type HTTP header that must be
+	// used in a PUT request to the URL. It is only set if Method is "PUT".
+	// If the provider cannot enforce the Content-Type header, return an error
+	// for which ErrorCode returns gcerrors.Unimplemented.
+	ContentType string
 }
@@ -18,6 +18,7 @@ package drivertest // import "github.com/eliben/gocdkx/blob/drivertest"
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/md5"
 	"errors"
@@ -25,7 +26,10 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -212,18 +216,60 @@ func RunConformanceTests(t *testing.T, newHarness HarnessMaker, asTests []AsTest
 	t.Run("TestMD5", func(t *testing.T) {
 		testMD5(t, newHarness)
 	})
+	t.Run("TestETag", func(t *testing.T) {
+		testETag(t, newHarness)
+	})
+	t.Run("TestCompression", func(t *testing.T) {
+		testCompression(t, newHarness)
+	})
 	t.Run("TestCopy", func(t *testing.T) {
 		testCopy(t, newHarness)
 	})
 	t.Run("TestDelete", func(t *testing.T) {
 		testDelete(t, newHarness)
 	})
+	t.Run("TestDeleteMany", func(t *testing.T) {
+		testDeleteMany(t, newHarness)
+	})
+	t.Run("TestConditions", func(t *testing.T) {
+		testConditions(t, newHarness)
+	})
+	t.Run("TestVersions", func(t *testing.T) {
+		testVersions(t, newHarness)
+	})
+	t.Run("TestWatch", func(t *testing.T) {
+		testWatch(t, newHarness)
+	})
+	t.Run("TestExpires", func(t *testing.T) {
+		testExpires(t, newHarness)
+	})
+	t.Run("TestStorageClass", func(t *testing.T) {
+		testStorageClass(t, newHarness)
+	})
+	t.Run("TestCompose", func(t *testing.T) {
+		testCompose(t, newHarness)
+	})
+	t.Run("TestUpdateAttributes", func(t *testing.T) {
+		testUpdateAttributes(t, newHarness)
+	})
+	t.Run("TestAppend", func(t *testing.T) {
+		testAppend(t, newHarness)
+	})
+	t.Run("TestWalk", func(t *testing.T) {
+		testWalk(t, newHarness)
+	})
 	t.Run("TestKeys", func(t *testing.T) {
 		testKeys(t, newHarness)
 	})
 	t.Run("TestSignedURL", func(t *testing.T) {
 		testSignedURL(t, newHarness)
 	})
+	t.Run("TestSignedURLMethods", func(t *testing.T) {
+		testSignedURLMethods(t, newHarness)
+	})
+	t.Run("TestMultipartUpload", func(t *testing.T) {
+		testMultipartUpload(t, newHarness)
+	})
 	asTests = append(asTests, verifyAsFailsOnNil{})
 	t.Run("TestAs", func(t *testing.T) {
 		for _, st := range asTests {
@@ -1651,6 +1697,236 @@ func testMD5(t *testing.T, newHarness HarnessMaker) {
 	}
 }
 
+// testETag tests reading ETags via List and Attributes, and using them as
+// preconditions.
+func testETag(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-etag"
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, key, []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldETag := attrs.ETag
+	if oldETag == "" {
+		t.Fatal("got empty ETag from Attributes")
+	}
+
+	// List should return the same ETag.
+	iter := b.List(&blob.ListOptions{Prefix: key})
+	obj, err := iter.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ETag != oldETag {
+		t.Errorf("got ETag %q from List, want %q", obj.ETag, oldETag)
+	}
+
+	// Rewriting the blob with different content changes the ETag.
+	if err := b.WriteAll(ctx, key, []byte("goodbye"), &blob.WriterOptions{Conditions: &blob.Conditions{IfMatch: oldETag}}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ETag == oldETag {
+		t.Errorf("got unchanged ETag %q after rewrite", attrs.ETag)
+	}
+
+	// The old ETag no longer matches, but the new one does.
+	err = b.WriteAll(ctx, key, []byte("hello again"), &blob.WriterOptions{Conditions: &blob.Conditions{IfMatch: oldETag}})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("write with stale ETag: got %v want FailedPrecondition error", err)
+	}
+	r, err := b.NewReader(ctx, key, &blob.ReaderOptions{Conditions: &blob.Conditions{IfMatch: attrs.ETag}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if err := b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{Conditions: &blob.Conditions{IfMatch: attrs.ETag}}); err != nil {
+		t.Error(err)
+	}
+}
+
+// testCompression tests WriterOptions.Compression and
+// ReaderOptions.Decompress.
+func testCompression(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-compression"
+	content := bytes.Repeat([]byte("Hello World! "), 1000)
+	contentMD5 := md5.Sum(content)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	// Don't set ContentType, to check that it is detected from the
+	// uncompressed content.
+	opts := &blob.WriterOptions{Compression: "gzip", ContentMD5: contentMD5[:]}
+	if err := b.WriteAll(ctx, key, content, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentEncoding != "gzip" {
+		t.Errorf("got ContentEncoding %q want %q", attrs.ContentEncoding, "gzip")
+	}
+	if !strings.HasPrefix(attrs.ContentType, "text/plain") {
+		t.Errorf("got ContentType %q want text/plain", attrs.ContentType)
+	}
+	if attrs.Size <= 0 || attrs.Size >= int64(len(content)) {
+		t.Errorf("got Size %d want the compressed size, less than %d", attrs.Size, len(content))
+	}
+	if attrs.UncompressedSize != int64(len(content)) {
+		t.Errorf("got UncompressedSize %d want %d", attrs.UncompressedSize, len(content))
+	}
+
+	t.Run("ReadCompressed", func(t *testing.T) {
+		r, err := b.NewReader(ctx, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if r.Size() != attrs.Size || r.UncompressedSize() != attrs.Size {
+			t.Errorf("got Size %d, UncompressedSize %d want %d for both", r.Size(), r.UncompressedSize(), attrs.Size)
+		}
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("got %d bytes after decompressing, want %d bytes of content", len(got), len(content))
+		}
+	})
+	t.Run("Decompress", func(t *testing.T) {
+		got, err := b.ReadAllWithOptions(ctx, key, &blob.ReaderOptions{Decompress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("got %d bytes, want %d bytes of content", len(got), len(content))
+		}
+	})
+	t.Run("DecompressRange", func(t *testing.T) {
+		r, err := b.NewRangeReader(ctx, key, 100, 50, &blob.ReaderOptions{Decompress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if r.Size() != attrs.Size || r.UncompressedSize() != int64(len(content)) {
+			t.Errorf("got Size %d, UncompressedSize %d want %d, %d", r.Size(), r.UncompressedSize(), attrs.Size, len(content))
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content[100:150]) {
+			t.Errorf("got %q want %q", got, content[100:150])
+		}
+	})
+	t.Run("DecompressUncompressed", func(t *testing.T) {
+		const key = "blob-for-compression-uncompressed"
+		if err := b.WriteAll(ctx, key, content, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = b.Delete(ctx, key) }()
+		r, err := b.NewRangeReader(ctx, key, 10, 20, &blob.ReaderOptions{Decompress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content[10:30]) {
+			t.Errorf("got %q want %q", got, content[10:30])
+		}
+		if r.UncompressedSize() != int64(len(content)) {
+			t.Errorf("got UncompressedSize %d want %d", r.UncompressedSize(), len(content))
+		}
+	})
+	t.Run("Streamed", func(t *testing.T) {
+		// Blobs too large to be buffered by the Writer don't have their
+		// uncompressed size recorded.
+		const key = "blob-for-compression-streamed"
+		large := make([]byte, 2*1024*1024)
+		rand.New(rand.NewSource(1)).Read(large)
+		if err := b.WriteAll(ctx, key, large, &blob.WriterOptions{Compression: "gzip"}); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = b.Delete(ctx, key) }()
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentEncoding != "gzip" || attrs.UncompressedSize != -1 {
+			t.Errorf("got ContentEncoding %q, UncompressedSize %d want %q, -1", attrs.ContentEncoding, attrs.UncompressedSize, "gzip")
+		}
+		got, err := b.ReadAllWithOptions(ctx, key, &blob.ReaderOptions{Decompress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, large) {
+			t.Errorf("got %d bytes, want %d bytes of content", len(got), len(large))
+		}
+	})
+	t.Run("MD5Mismatch", func(t *testing.T) {
+		const key = "blob-for-compression-md5"
+		opts := &blob.WriterOptions{Compression: "gzip", ContentMD5: []byte("not the MD5")}
+		if err := b.WriteAll(ctx, key, content, opts); err == nil {
+			t.Error("got nil error for wrong ContentMD5, want error")
+			_ = b.Delete(ctx, key)
+		}
+		if exists, err := b.Exists(ctx, key); err != nil || exists {
+			t.Errorf("got exists %v, err %v after wrong ContentMD5, want false, nil", exists, err)
+		}
+	})
+	t.Run("InvalidOptions", func(t *testing.T) {
+		for _, opts := range []*blob.WriterOptions{
+			{Compression: "no-such-encoding"},
+			{Compression: "gzip", ContentEncoding: "br"},
+		} {
+			if _, err := b.NewWriter(ctx, key, opts); gcerrors.Code(err) != gcerrors.InvalidArgument {
+				t.Errorf("%+v: got error %v want InvalidArgument", opts, err)
+			}
+		}
+	})
+}
+
 // testCopy tests the functionality of Copy.
 func testCopy(t *testing.T, newHarness HarnessMaker) {
 	const (
@@ -1719,6 +1995,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			t.Fatal(err)
 		}
 		wantAttr.ModTime = time.Time{} // don't compare this field
+		wantAttr.ETag = ""             // or this one
 
 		// Create another blob that we're going to overwrite.
 		if err := b.WriteAll(ctx, dstKeyExists, []byte("clobber me"), nil); err != nil {
@@ -1743,6 +2020,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			t.Fatal(err)
 		}
 		gotAttr.ModTime = time.Time{} // don't compare this field
+		gotAttr.ETag = ""             // or this one
 		if diff := cmp.Diff(gotAttr, wantAttr, cmpopts.IgnoreUnexported(blob.Attributes{})); diff != "" {
 			t.Errorf("got %v want %v diff %s", gotAttr, wantAttr, diff)
 		}
@@ -1766,6 +2044,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			t.Fatal(err)
 		}
 		gotAttr.ModTime = time.Time{} // don't compare this field
+		gotAttr.ETag = ""             // or this one
 		if diff := cmp.Diff(gotAttr, wantAttr, cmpopts.IgnoreUnexported(blob.Attributes{})); diff != "" {
 			t.Errorf("got %v want %v diff %s", gotAttr, wantAttr, diff)
 		}
@@ -1836,6 +2115,75 @@ func testDelete(t *testing.T, newHarness HarnessMaker) {
 	})
 }
 
+// testDeleteMany tests the functionality of DeleteMany and DeletePrefix.
+func testDeleteMany(t *testing.T, newHarness HarnessMaker) {
+	const prefix = "blob-for-delete-many/"
+	var contents = []byte("Hello World")
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	// write creates blobs for keys, and returns a function that deletes them.
+	write := func(keys ...string) func() {
+		for _, key := range keys {
+			if err := b.WriteAll(ctx, key, contents, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return func() {
+			for _, key := range keys {
+				_ = b.Delete(ctx, key)
+			}
+		}
+	}
+	// exist returns the keys from keys that exist.
+	exist := func(keys ...string) []string {
+		var got []string
+		for _, key := range keys {
+			ok, err := b.Exists(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				got = append(got, key)
+			}
+		}
+		return got
+	}
+
+	t.Run("DeleteMany", func(t *testing.T) {
+		keys := []string{prefix + "many-a", prefix + "many-b", prefix + "many-c"}
+		defer write(keys...)()
+		// Keys that don't exist are ignored.
+		if err := b.DeleteMany(ctx, append(keys[:2:2], prefix+"does-not-exist")); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(exist(keys...), keys[2:]); diff != "" {
+			t.Errorf("got remaining keys diff (-got +want):\n%s", diff)
+		}
+	})
+	t.Run("DeletePrefix", func(t *testing.T) {
+		keys := []string{prefix + "dir/a", prefix + "dir/b", prefix + "dir/sub/c", prefix + "other"}
+		defer write(keys...)()
+		if err := b.DeletePrefix(ctx, prefix+"dir/"); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(exist(keys...), keys[3:]); diff != "" {
+			t.Errorf("got remaining keys diff (-got +want):\n%s", diff)
+		}
+	})
+}
+
 // testConcurrentWriteAndRead tests that concurrent writing to multiple blob
 // keys and concurrent reading from multiple blob keys works.
 func testConcurrentWriteAndRead(t *testing.T, newHarness HarnessMaker) {
@@ -1997,58 +2345,1193 @@ func testKeys(t *testing.T, newHarness HarnessMaker) {
 	}
 }
 
-// testSignedURL tests the functionality of SignedURL.
-func testSignedURL(t *testing.T, newHarness HarnessMaker) {
-	const key = "blob-for-signing"
-	var contents = []byte("hello world")
+// testMultipartUpload tests the functionality of NewMultipartUpload,
+// ResumeMultipartUpload, and parallel uploads via WriterOptions.MaxConcurrency.
+func testMultipartUpload(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key         = "blob-for-multipart-upload"
+		contentType = "text/plain"
+		// S3 requires all parts except the last one to be at least 5 MiB.
+		partSize = 5 * 1024 * 1024
+	)
+	parts := [][]byte{
+		bytes.Repeat([]byte("a"), partSize),
+		bytes.Repeat([]byte("b"), partSize),
+		[]byte("last part"),
+	}
+	want := bytes.Join(parts, nil)
 
 	ctx := context.Background()
 
-	h, err := newHarness(ctx, t)
-	if err != nil {
-		t.Fatal(err)
+	// init creates a *blob.Bucket for a subtest.
+	init := func(t *testing.T) (*blob.Bucket, func()) {
+		h, err := newHarness(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv, err := h.MakeDriver(ctx)
+		if err != nil {
+			h.Close()
+			t.Fatal(err)
+		}
+		b := blob.NewBucket(drv)
+		return b, func() {
+			b.Close()
+			h.Close()
+		}
 	}
-	defer h.Close()
 
-	drv, err := h.MakeDriver(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
-	b := blob.NewBucket(drv)
-	defer b.Close()
+	t.Run("UploadResumeAndComplete", func(t *testing.T) {
+		b, done := init(t)
+		defer done()
 
-	// Verify that a negative Expiry gives an error. This is enforced in the
-	// portable type, so works regardless of provider support.
-	_, err = b.SignedURL(ctx, key, &blob.SignedURLOptions{Expiry: -1 * time.Minute})
-	if err == nil {
-		t.Error("got nil error, expected error for negative SignedURLOptions.Expiry")
-	}
+		opts := &blob.WriterOptions{
+			ContentType: contentType,
+			Metadata:    map[string]string{"foo": "bar"},
+		}
+		u, err := b.NewMultipartUpload(ctx, key, opts)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.Unimplemented {
+				t.Skipf("multipart uploads not supported")
+			}
+			t.Fatal(err)
+		}
+		defer func() { _ = b.Delete(ctx, key) }()
 
-	// Try to generate a real signed URL.
-	url, err := b.SignedURL(ctx, key, nil)
-	if err != nil {
-		if gcerrors.Code(err) == gcerrors.Unimplemented {
-			t.Skipf("SignedURL not supported")
+		// Upload the first and last parts concurrently, out of order.
+		var wg sync.WaitGroup
+		for _, n := range []int{3, 1} {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				if err := u.UploadPart(ctx, n, parts[n-1]); err != nil {
+					t.Error(err)
+				}
+			}(n)
+		}
+		wg.Wait()
+		if t.Failed() {
 			return
 		}
-		t.Fatal(err)
-	}
-	if url == "" {
-		t.Fatal("got empty url")
-	}
-	client := h.HTTPClient()
-	if client == nil {
-		t.Fatal("can't verify SignedURL, Harness.HTTPClient() returned nil")
-	}
-	// Create the blob.
-	if err := b.WriteAll(ctx, key, contents, nil); err != nil {
-		t.Fatal(err)
-	}
-	defer func() { _ = b.Delete(ctx, key) }()
+		if got, err := u.Parts(ctx); err != nil {
+			t.Fatal(err)
+		} else if diff := cmp.Diff(got, []int{1, 3}); diff != "" {
+			t.Errorf("got parts %v want [1 3]", got)
+		}
 
-	resp, err := client.Get(url)
-	if err != nil {
-		t.Fatal(err)
+		// The blob shouldn't exist until the upload is completed.
+		if exists, err := b.Exists(ctx, key); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("got blob exists before Complete, want it to not exist")
+		}
+
+		// Resume the upload and upload the missing part.
+		u2, err := b.ResumeMultipartUpload(ctx, key, u.ID(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u2.ID() != u.ID() {
+			t.Errorf("got resumed upload ID %q want %q", u2.ID(), u.ID())
+		}
+		if err := u2.UploadPart(ctx, 2, parts[1]); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := u2.Parts(ctx); err != nil {
+			t.Fatal(err)
+		} else if diff := cmp.Diff(got, []int{1, 2, 3}); diff != "" {
+			t.Errorf("got parts %v want [1 2 3]", got)
+		}
+		if err := u2.Complete(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := b.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %d bytes, want %d bytes with the parts in order", len(got), len(want))
+		}
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(attrs.ContentType, contentType) {
+			t.Errorf("got ContentType %q want %q", attrs.ContentType, contentType)
+		}
+		if diff := cmp.Diff(attrs.Metadata, opts.Metadata); diff != "" {
+			t.Errorf("got Metadata %v want %v", attrs.Metadata, opts.Metadata)
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		b, done := init(t)
+		defer done()
+
+		u, err := b.NewMultipartUpload(ctx, key, nil)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.Unimplemented {
+				t.Skipf("multipart uploads not supported")
+			}
+			t.Fatal(err)
+		}
+		if err := u.UploadPart(ctx, 1, parts[2]); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.Abort(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := b.Exists(ctx, key); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("got blob exists after Abort, want it to not exist")
+		}
+	})
+
+	t.Run("ResumeNonExistentFails", func(t *testing.T) {
+		b, done := init(t)
+		defer done()
+
+		_, err := b.ResumeMultipartUpload(ctx, key, "does-not-exist", nil)
+		if err == nil {
+			t.Fatal("got nil want error")
+		}
+		switch gcerrors.Code(err) {
+		case gcerrors.NotFound:
+		case gcerrors.Unimplemented:
+			t.Skipf("multipart uploads not supported")
+		default:
+			t.Errorf("got %v want NotFound error", err)
+		}
+	})
+
+	t.Run("InvalidPartNumberFails", func(t *testing.T) {
+		b, done := init(t)
+		defer done()
+
+		u, err := b.NewMultipartUpload(ctx, key, nil)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.Unimplemented {
+				t.Skipf("multipart uploads not supported")
+			}
+			t.Fatal(err)
+		}
+		defer func() { _ = u.Abort(ctx) }()
+		for _, n := range []int{0, blob.MaxUploadParts + 1} {
+			if err := u.UploadPart(ctx, n, parts[2]); gcerrors.Code(err) != gcerrors.InvalidArgument {
+				t.Errorf("UploadPart(%d): got %v want InvalidArgument error", n, err)
+			}
+		}
+	})
+
+	// Writer with MaxConcurrency falls back to a regular upload if multipart
+	// uploads aren't supported, so this should work for all providers.
+	t.Run("ParallelWriter", func(t *testing.T) {
+		b, done := init(t)
+		defer done()
+
+		w, err := b.NewWriter(ctx, key, &blob.WriterOptions{
+			ContentType:    contentType,
+			BufferSize:     partSize,
+			MaxConcurrency: 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = b.Delete(ctx, key) }()
+		// Write in chunks that don't line up with the parts.
+		for p := want; len(p) > 0; {
+			n := 3 * 1024 * 1024
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %d bytes, want %d bytes with the parts in order", len(got), len(want))
+		}
+	})
+
+	t.Run("CanceledParallelWriter", func(t *testing.T) {
+		b, done := init(t)
+		defer done()
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		w, err := b.NewWriter(cancelCtx, key, &blob.WriterOptions{
+			ContentType:    contentType,
+			BufferSize:     partSize,
+			MaxConcurrency: 2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(parts[0]); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if err := w.Close(); err == nil {
+			t.Error("got nil error from Close after cancel, want error")
+		}
+		if exists, err := b.Exists(ctx, key); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("got blob exists after canceled write, want it to not exist")
+			_ = b.Delete(ctx, key)
+		}
+	})
+}
+
+// testConditions tests that preconditions on reads, writes, copies and
+// deletes are enforced.
+func testConditions(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key      = "blob-for-conditions"
+		otherKey = "blob-for-conditions-other"
+		// wrongETag is a valid ETag format for most providers, but doesn't match
+		// any blob.
+		wrongETag = `"does-not-match"`
+	)
+	var (
+		contents      = []byte("Hello World")
+		otherContents = []byte("Goodbye World")
+	)
+
+	tests := []struct {
+		name string
+		// call is called with a bucket containing key, holding contents. It
+		// should perform an operation whose precondition is not satisfied.
+		call func(ctx context.Context, b *blob.Bucket) error
+		// If wantContents is not nil, key is expected to still hold
+		// wantContents after call.
+		wantContents []byte
+	}{
+		{
+			name: "WriteIfNotExist",
+			call: func(ctx context.Context, b *blob.Bucket) error {
+				return b.WriteAll(ctx, key, otherContents, &blob.WriterOptions{Conditions: &blob.Conditions{IfNotExist: true}})
+			},
+			wantContents: contents,
+		},
+		{
+			name: "WriteIfMatch",
+			call: func(ctx context.Context, b *blob.Bucket) error {
+				return b.WriteAll(ctx, key, otherContents, &blob.WriterOptions{Conditions: &blob.Conditions{IfMatch: wrongETag}})
+			},
+			wantContents: contents,
+		},
+		{
+			name: "ReadIfMatch",
+			call: func(ctx context.Context, b *blob.Bucket) error {
+				r, err := b.NewReader(ctx, key, &blob.ReaderOptions{Conditions: &blob.Conditions{IfMatch: wrongETag}})
+				if err == nil {
+					r.Close()
+				}
+				return err
+			},
+		},
+		{
+			name: "ReadIfModifiedSince",
+			call: func(ctx context.Context, b *blob.Bucket) error {
+				since := time.Now().Add(time.Hour)
+				r, err := b.NewReader(ctx, key, &blob.ReaderOptions{Conditions: &blob.Conditions{IfModifiedSince: since}})
+				if err == nil {
+					r.Close()
+				}
+				return err
+			},
+		},
+		{
+			name: "CopyIfNotExist",
+			call: func(ctx context.Context, b *blob.Bucket) error {
+				if err := b.WriteAll(ctx, otherKey, otherContents, nil); err != nil {
+					return err
+				}
+				defer func() { _ = b.Delete(ctx, otherKey) }()
+				return b.Copy(ctx, key, otherKey, &blob.CopyOptions{Conditions: &blob.Conditions{IfNotExist: true}})
+			},
+			wantContents: contents,
+		},
+		{
+			name: "DeleteIfMatch",
+			call: func(ctx context.Context, b *blob.Bucket) error {
+				return b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{Conditions: &blob.Conditions{IfMatch: wrongETag}})
+			},
+			wantContents: contents,
+		},
+	}
+
+	ctx := context.Background()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, err := newHarness(ctx, t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Close()
+			drv, err := h.MakeDriver(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := blob.NewBucket(drv)
+			defer b.Close()
+
+			if err := b.WriteAll(ctx, key, contents, nil); err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = b.Delete(ctx, key) }()
+
+			err = test.call(ctx, b)
+			if err == nil {
+				t.Errorf("got nil want error")
+			} else if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+				t.Errorf("got %v want FailedPrecondition error", err)
+			}
+			if test.wantContents != nil {
+				got, err := b.ReadAll(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, test.wantContents) {
+					t.Errorf("got %q want %q", string(got), string(test.wantContents))
+				}
+			}
+		})
+	}
+
+	t.Run("SatisfiedPreconditionsWork", func(t *testing.T) {
+		h, err := newHarness(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		drv, err := h.MakeDriver(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := blob.NewBucket(drv)
+		defer b.Close()
+
+		// Writing a new blob with IfNotExist succeeds.
+		if err := b.WriteAll(ctx, key, contents, &blob.WriterOptions{Conditions: &blob.Conditions{IfNotExist: true}}); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = b.Delete(ctx, key) }()
+		// So does reading it if it was modified recently.
+		since := time.Now().Add(-24 * time.Hour)
+		r, err := b.NewReader(ctx, key, &blob.ReaderOptions{Conditions: &blob.Conditions{IfModifiedSince: since}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		// And copying it to a new blob with IfNotExist.
+		if err := b.Copy(ctx, otherKey, key, &blob.CopyOptions{Conditions: &blob.Conditions{IfNotExist: true}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete(ctx, otherKey); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// testExpires tests that WriterOptions.Expires is reported by Attributes, and
+// kept by Copy. Providers don't have to delete expired blobs promptly, so
+// that is left to their own tests.
+func testExpires(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key     = "blob-for-expires"
+		copyKey = "blob-for-expires-copy"
+	)
+	// Some providers store the expiration time with a precision of one
+	// second.
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	check := func(key string) {
+		t.Helper()
+		a, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Expires.Equal(expires) {
+			t.Errorf("%s: got Expires %v want %v", key, a.Expires, expires)
+		}
+		if _, ok := a.Metadata["gocdk-expires"]; ok {
+			t.Errorf("%s: got expiration time in Metadata %v", key, a.Metadata)
+		}
+	}
+
+	if err := b.WriteAll(ctx, key, []byte("hello"), &blob.WriterOptions{Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+	check(key)
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "hello" {
+		t.Errorf("got %q, %v want %q", got, err, "hello")
+	}
+	if err := b.Copy(ctx, copyKey, key, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, copyKey) }()
+	check(copyKey)
+}
+
+// testStorageClass tests writing and copying blobs with a storage class. Only
+// the standard and infrequent access classes are used, since they are
+// supported by all providers, and their blobs can be read right away.
+func testStorageClass(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key     = "blob-for-storage-class"
+		copyKey = "blob-for-storage-class-copy"
+	)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	check := func(key string, want blob.StorageClass) {
+		t.Helper()
+		a, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.StorageClass != want {
+			t.Errorf("%s: got storage class %v want %v", key, a.StorageClass, want)
+		}
+	}
+
+	if err := b.WriteAll(ctx, key, []byte("hello"), &blob.WriterOptions{StorageClass: blob.StorageClassInfrequentAccess}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+	check(key, blob.StorageClassInfrequentAccess)
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "hello" {
+		t.Errorf("got %q, %v want %q", got, err, "hello")
+	}
+
+	// Copies can change the storage class.
+	if err := b.Copy(ctx, copyKey, key, &blob.CopyOptions{StorageClass: blob.StorageClassStandard}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, copyKey) }()
+	check(copyKey, blob.StorageClassStandard)
+	check(key, blob.StorageClassInfrequentAccess)
+
+	// Invalid storage classes are rejected.
+	err = b.WriteAll(ctx, key, []byte("hello"), &blob.WriterOptions{StorageClass: blob.StorageClassArchive + 1})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v writing with an invalid storage class, want InvalidArgument", err)
+	}
+	err = b.Copy(ctx, copyKey, key, &blob.CopyOptions{StorageClass: -1})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v copying with an invalid storage class, want InvalidArgument", err)
+	}
+}
+
+// testCompose tests the functionality of Compose.
+func testCompose(t *testing.T, newHarness HarnessMaker) {
+	const (
+		keyA    = "blob-for-compose-a"
+		keyB    = "blob-for-compose-b"
+		dstKey  = "blob-for-compose-dst"
+		missing = "blob-for-compose-missing"
+	)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	write := func(key, content, contentType string) {
+		t.Helper()
+		if err := b.WriteAll(ctx, key, []byte(content), &blob.WriterOptions{ContentType: contentType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(key, want string) {
+		t.Helper()
+		got, err := b.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+	write(keyA, "aaa", "text/x-a")
+	defer func() { _ = b.Delete(ctx, keyA) }()
+	write(keyB, "bb", "text/x-b")
+	defer func() { _ = b.Delete(ctx, keyB) }()
+
+	// The sources are concatenated in order, and may be repeated. The
+	// content type is the first source's.
+	err = b.Compose(ctx, dstKey, []string{keyB, keyA, keyB}, &blob.ComposeOptions{
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"Foo": "bar"},
+	})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skip("compose not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, dstKey) }()
+	read(dstKey, "bbaaabb")
+	a, err := b.Attributes(ctx, dstKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Size != 7 {
+		t.Errorf("got size %d want 7", a.Size)
+	}
+	if a.ContentType != "text/x-b" {
+		t.Errorf("got content type %q want %q", a.ContentType, "text/x-b")
+	}
+	if a.CacheControl != "no-cache" {
+		t.Errorf("got cache control %q want %q", a.CacheControl, "no-cache")
+	}
+	if diff := cmp.Diff(a.Metadata, map[string]string{"foo": "bar"}); diff != "" {
+		t.Errorf("got metadata diff (-got +want):\n%s", diff)
+	}
+
+	// The destination can be one of the sources, to append to it. The
+	// attributes are replaced.
+	if err := b.Compose(ctx, dstKey, []string{dstKey, keyA}, &blob.ComposeOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	read(dstKey, "bbaaabbaaa")
+	if a, err = b.Attributes(ctx, dstKey); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a.ContentType, "text/plain") || len(a.Metadata) != 0 {
+		t.Errorf("got content type %q and metadata %v, want %q and none", a.ContentType, a.Metadata, "text/plain")
+	}
+
+	// Missing sources are reported, and the destination is unchanged.
+	err = b.Compose(ctx, dstKey, []string{keyA, missing}, nil)
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v composing a missing blob, want NotFound", err)
+	}
+	read(dstKey, "bbaaabbaaa")
+
+	// Preconditions are checked against the destination.
+	err = b.Compose(ctx, dstKey, []string{keyA}, &blob.ComposeOptions{Conditions: &blob.Conditions{IfNotExist: true}})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v composing over an existing blob with IfNotExist, want FailedPrecondition", err)
+	}
+	read(dstKey, "bbaaabbaaa")
+}
+
+// testUpdateAttributes tests the functionality of UpdateAttributes.
+func testUpdateAttributes(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key     = "blob-for-update-attributes"
+		missing = "blob-for-update-attributes-missing"
+		content = "hello"
+	)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	str := func(s string) *string { return &s }
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = b.WriteAll(ctx, key, []byte(content), &blob.WriterOptions{
+		CacheControl:    "max-age=60",
+		ContentLanguage: "en",
+		ContentType:     "text/plain",
+		Metadata:        map[string]string{"a": "1", "b": "2"},
+		Expires:         expires,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	// Attributes that are set are replaced, metadata keys are added,
+	// replaced or removed, and everything else is unchanged.
+	err = b.UpdateAttributes(ctx, key, &blob.UpdateAttributesOptions{
+		CacheControl:   str("no-cache"),
+		ContentType:    str("text/html"),
+		Metadata:       map[string]string{"A": "10", "c": "3"},
+		DeleteMetadata: []string{"b"},
+	})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skip("updating attributes not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.CacheControl != "no-cache" {
+		t.Errorf("got cache control %q want %q", a.CacheControl, "no-cache")
+	}
+	if !strings.HasPrefix(a.ContentType, "text/html") {
+		t.Errorf("got content type %q want %q", a.ContentType, "text/html")
+	}
+	if a.ContentLanguage != "en" {
+		t.Errorf("got content language %q want %q", a.ContentLanguage, "en")
+	}
+	if diff := cmp.Diff(a.Metadata, map[string]string{"a": "10", "c": "3"}); diff != "" {
+		t.Errorf("got metadata diff (-got +want):\n%s", diff)
+	}
+	if !a.Expires.Equal(expires) {
+		t.Errorf("got expiration time %v want %v", a.Expires, expires)
+	}
+	if a.Size != int64(len(content)) {
+		t.Errorf("got size %d want %d", a.Size, len(content))
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != content {
+		t.Errorf("got %q, %v want %q", got, err, content)
+	}
+
+	// Attributes can be cleared, with IfMatch.
+	err = b.UpdateAttributes(ctx, key, &blob.UpdateAttributesOptions{
+		ContentLanguage: str(""),
+		Conditions:      &blob.Conditions{IfMatch: a.ETag},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err = b.Attributes(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if a.ContentLanguage != "" || a.CacheControl != "no-cache" {
+		t.Errorf("got content language %q and cache control %q, want none and %q", a.ContentLanguage, a.CacheControl, "no-cache")
+	}
+
+	err = b.UpdateAttributes(ctx, key, &blob.UpdateAttributesOptions{
+		CacheControl: str("max-age=1"),
+		Conditions:   &blob.Conditions{IfMatch: `"not-the-etag"`},
+	})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v updating with a mismatched IfMatch, want FailedPrecondition", err)
+	}
+	err = b.UpdateAttributes(ctx, missing, &blob.UpdateAttributesOptions{CacheControl: str("no-cache")})
+	if gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v updating a missing blob, want NotFound", err)
+	}
+}
+
+// testAppend tests the functionality of WriterOptions.Append.
+func testAppend(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-append"
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	// The first append creates the blob, with the attributes of the write.
+	err = b.WriteAll(ctx, key, []byte("a"), &blob.WriterOptions{
+		Append:      true,
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"k": "v"},
+	})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skip("appending not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	// Later appends keep them.
+	err = b.WriteAll(ctx, key, []byte("bc"), &blob.WriterOptions{
+		Append:      true,
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "abc" {
+		t.Errorf("got %q, %v want %q", got, err, "abc")
+	}
+	a, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a.ContentType, "text/plain") {
+		t.Errorf("got content type %q want %q", a.ContentType, "text/plain")
+	}
+	if diff := cmp.Diff(a.Metadata, map[string]string{"k": "v"}); diff != "" {
+		t.Errorf("got metadata diff (-got +want):\n%s", diff)
+	}
+	if a.Size != 3 {
+		t.Errorf("got size %d want 3", a.Size)
+	}
+
+	// Appends with preconditions only happen if they hold.
+	err = b.WriteAll(ctx, key, []byte("d"), &blob.WriterOptions{
+		Append:     true,
+		Conditions: &blob.Conditions{IfMatch: a.ETag},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.WriteAll(ctx, key, []byte("e"), &blob.WriterOptions{
+		Append:     true,
+		Conditions: &blob.Conditions{IfMatch: a.ETag},
+	})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v appending with a stale IfMatch, want FailedPrecondition", err)
+	}
+	err = b.WriteAll(ctx, key, []byte("e"), &blob.WriterOptions{
+		Append:     true,
+		Conditions: &blob.Conditions{IfNotExist: true},
+	})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v appending to an existing blob with IfNotExist, want FailedPrecondition", err)
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "abcd" {
+		t.Errorf("got %q, %v want %q", got, err, "abcd")
+	}
+}
+
+// testWalk tests the functionality of Walk.
+func testWalk(t *testing.T, newHarness HarnessMaker) {
+	const prefix = "blob-for-walk/"
+	keys := []string{
+		"a.txt",
+		"b.json",
+		"dir1/c.txt",
+		"dir1/sub/d.txt",
+		"dir2/e.txt",
+		"dir2/f.json",
+	}
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	for _, key := range keys {
+		if err := b.WriteAll(ctx, prefix+key, []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+		defer func(key string) { _ = b.Delete(ctx, prefix+key) }(key)
+	}
+
+	// walk returns the keys passed to the WalkFunc, without prefix, sorted.
+	// Directories end in "/".
+	walk := func(opts *blob.WalkOptions, fn blob.WalkFunc) ([]string, error) {
+		var mu sync.Mutex
+		var got []string
+		err := b.Walk(ctx, opts, func(ctx context.Context, obj *blob.ListObject) error {
+			mu.Lock()
+			got = append(got, strings.TrimPrefix(obj.Key, prefix))
+			mu.Unlock()
+			if fn != nil {
+				return fn(ctx, obj)
+			}
+			return nil
+		})
+		sort.Strings(got)
+		return got, err
+	}
+	all := []string{"a.txt", "b.json", "dir1/", "dir1/c.txt", "dir1/sub/", "dir1/sub/d.txt", "dir2/", "dir2/e.txt", "dir2/f.json"}
+	skipDir1 := func(ctx context.Context, obj *blob.ListObject) error {
+		if obj.Key == prefix+"dir1/" {
+			return blob.SkipDir
+		}
+		return nil
+	}
+	skipAfterE := func(ctx context.Context, obj *blob.ListObject) error {
+		if obj.Key == prefix+"dir2/e.txt" {
+			return blob.SkipDir
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name string
+		opts *blob.WalkOptions
+		fn   blob.WalkFunc
+		want []string
+	}{
+		{
+			name: "all",
+			opts: &blob.WalkOptions{Prefix: prefix},
+			want: all,
+		},
+		{
+			name: "sequential",
+			opts: &blob.WalkOptions{Prefix: prefix, Concurrency: 1},
+			want: all,
+		},
+		{
+			name: "glob",
+			opts: &blob.WalkOptions{Prefix: prefix, Glob: prefix + "*/*.txt"},
+			want: []string{"dir1/", "dir1/c.txt", "dir1/sub/", "dir2/", "dir2/e.txt"},
+		},
+		{
+			name: "regexp",
+			opts: &blob.WalkOptions{Prefix: prefix, Regexp: regexp.MustCompile(`\.json$`)},
+			want: []string{"b.json", "dir1/", "dir1/sub/", "dir2/", "dir2/f.json"},
+		},
+		{
+			name: "skip directory",
+			opts: &blob.WalkOptions{Prefix: prefix},
+			fn:   skipDir1,
+			want: []string{"a.txt", "b.json", "dir1/", "dir2/", "dir2/e.txt", "dir2/f.json"},
+		},
+		{
+			name: "skip rest of directory",
+			opts: &blob.WalkOptions{Prefix: prefix},
+			fn:   skipAfterE,
+			want: []string{"a.txt", "b.json", "dir1/", "dir1/c.txt", "dir1/sub/", "dir1/sub/d.txt", "dir2/", "dir2/e.txt"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := walk(test.opts, test.fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("got keys diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	// Errors from the WalkFunc stop the walk and are returned.
+	errStop := errors.New("stop")
+	_, err = walk(&blob.WalkOptions{Prefix: prefix}, func(ctx context.Context, obj *blob.ListObject) error {
+		if obj.Key == prefix+"dir1/sub/d.txt" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("got error %v want %v", err, errStop)
+	}
+}
+
+// testVersions tests the functionality of ListVersions, and reading and
+// deleting specific versions of a blob.
+func testVersions(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-versions"
+	var (
+		v1Contents = []byte("Hello World")
+		v2Contents = []byte("Goodbye World")
+	)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, key, v1Contents, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+	if err := b.WriteAll(ctx, key, v2Contents, nil); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := b.ListVersions(ctx, key)
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skipf("versions not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, v := range versions {
+			_ = b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{Version: v.ID})
+		}
+	}()
+	if len(versions) < 2 {
+		t.Skipf("got %d versions, bucket may not have versioning enabled", len(versions))
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions want 2", len(versions))
+	}
+	latest, old := versions[0], versions[1]
+	if !latest.IsLatest || old.IsLatest {
+		t.Errorf("got IsLatest %v, %v want true, false", latest.IsLatest, old.IsLatest)
+	}
+	if latest.Size != int64(len(v2Contents)) || old.Size != int64(len(v1Contents)) {
+		t.Errorf("got sizes %d, %d want %d, %d", latest.Size, old.Size, len(v2Contents), len(v1Contents))
+	}
+	if latest.ID == "" || latest.ID == old.ID {
+		t.Errorf("got version IDs %q, %q want distinct non-empty IDs", latest.ID, old.ID)
+	}
+
+	// Each version can be read by ID.
+	for _, test := range []struct {
+		v    *blob.Version
+		want []byte
+	}{{latest, v2Contents}, {old, v1Contents}} {
+		r, err := b.NewReader(ctx, key, &blob.ReaderOptions{Version: test.v.ID})
+		if err != nil {
+			t.Fatalf("read version %q: %v", test.v.ID, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("read version %q: got %q want %q", test.v.ID, string(got), string(test.want))
+		}
+	}
+
+	// Deleting the old version leaves the latest one in place.
+	if err := b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{Version: old.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.NewReader(ctx, key, &blob.ReaderOptions{Version: old.ID}); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("read deleted version: got %v want NotFound error", err)
+	}
+	got, err := b.ReadAll(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, v2Contents) {
+		t.Errorf("got %q want %q", string(got), string(v2Contents))
+	}
+	versions, err = b.ListVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != latest.ID {
+		t.Errorf("got %d versions after deleting %q, want only %q", len(versions), old.ID, latest.ID)
+	}
+}
+
+// testWatch tests the functionality of Watch.
+func testWatch(t *testing.T, newHarness HarnessMaker) {
+	const (
+		prefix   = "blob-for-watch/"
+		key      = prefix + "key"
+		otherKey = "blob-for-watch-other"
+	)
+	var contents = []byte("hello world")
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	w, err := b.Watch(ctx, &blob.WatchOptions{Prefix: prefix})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skipf("watch not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// next returns the next event of type typ, skipping events of the other
+	// type, since events may be delivered more than once.
+	next := func(typ blob.EventType) *blob.Event {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		for {
+			ev, err := w.Next(ctx)
+			if err != nil {
+				t.Fatalf("waiting for %v event: %v", typ, err)
+			}
+			if ev.Key != key {
+				t.Errorf("got %v event for %q, want only events for %q", ev.Type, ev.Key, key)
+				continue
+			}
+			if ev.Type == typ {
+				return ev
+			}
+		}
+	}
+
+	// The blob outside of the prefix is written first, so that its event, if
+	// it were wrongly returned, would come before the expected ones.
+	if err := b.WriteAll(ctx, otherKey, contents, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, otherKey) }()
+	if err := b.WriteAll(ctx, key, contents, nil); err != nil {
+		t.Fatal(err)
+	}
+	ev := next(blob.EventCreated)
+	if ev.Size != 0 && ev.Size != int64(len(contents)) {
+		t.Errorf("got Size %d want %d", ev.Size, len(contents))
+	}
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	next(blob.EventDeleted)
+
+	// Close unblocks Next.
+	w2, err := b.Watch(ctx, &blob.WatchOptions{Prefix: prefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := w2.Next(ctx)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := w2.Close(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("got nil error from Next after Close")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Next didn't return after Close")
+	}
+	if err := w2.Close(); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v closing the Watcher twice, want FailedPrecondition", err)
+	}
+}
+
+// testSignedURL tests the functionality of SignedURL.
+func testSignedURL(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-signing"
+	var contents = []byte("hello world")
+
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	// Verify that a negative Expiry gives an error. This is enforced in the
+	// portable type, so works regardless of provider support.
+	_, err = b.SignedURL(ctx, key, &blob.SignedURLOptions{Expiry: -1 * time.Minute})
+	if err == nil {
+		t.Error("got nil error, expected error for negative SignedURLOptions.Expiry")
+	}
+	// Similarly for an unsupported Method, and a ContentType for a non-PUT URL.
+	_, err = b.SignedURL(ctx, key, &blob.SignedURLOptions{Method: http.MethodPost})
+	if err == nil {
+		t.Error("got nil error, expected error for SignedURLOptions.Method POST")
+	}
+	_, err = b.SignedURL(ctx, key, &blob.SignedURLOptions{ContentType: "text/plain"})
+	if err == nil {
+		t.Error("got nil error, expected error for SignedURLOptions.ContentType with GET")
+	}
+
+	// Try to generate a real signed URL.
+	url, err := b.SignedURL(ctx, key, nil)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.Unimplemented {
+			t.Skipf("SignedURL not supported")
+			return
+		}
+		t.Fatal(err)
+	}
+	if url == "" {
+		t.Fatal("got empty url")
+	}
+	client := h.HTTPClient()
+	if client == nil {
+		t.Fatal("can't verify SignedURL, Harness.HTTPClient() returned nil")
+	}
+	// Create the blob.
+	if err := b.WriteAll(ctx, key, contents, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
@@ -2060,6 +3543,115 @@ func testSignedURL(t *testing.T, newHarness HarnessMaker) {
 	}
 }
 
+// testSignedURLMethods tests SignedURL with the PUT and DELETE methods.
+func testSignedURLMethods(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-signing-methods"
+	var contents = []byte("hello world")
+
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	getURL, err := b.SignedURL(ctx, key, nil)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.Unimplemented {
+			t.Skipf("SignedURL not supported")
+			return
+		}
+		t.Fatal(err)
+	}
+	client := h.HTTPClient()
+	if client == nil {
+		t.Fatal("can't verify SignedURL, Harness.HTTPClient() returned nil")
+	}
+	if err := b.WriteAll(ctx, key, contents, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	// A GET URL can't be used to delete the blob.
+	if ok := doSignedRequest(t, client, http.MethodDelete, getURL, "", nil); ok {
+		t.Error("DELETE using a GET URL succeeded, want failure")
+	}
+
+	// Verify that a PUT URL can be used to upload the blob.
+	putContents := []byte("uploaded")
+	putURL, err := b.SignedURL(ctx, key, &blob.SignedURLOptions{Method: http.MethodPut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok := doSignedRequest(t, client, http.MethodPut, putURL, "", putContents); !ok {
+		t.Error("PUT using a PUT URL failed")
+	} else if got, err := b.ReadAll(ctx, key); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(got, putContents) {
+		t.Errorf("got %q after PUT, want %q", string(got), string(putContents))
+	}
+
+	// Verify that a PUT URL restricted to a content type rejects uploads with
+	// a different one.
+	const contentType = "text/plain"
+	putURL, err = b.SignedURL(ctx, key, &blob.SignedURLOptions{Method: http.MethodPut, ContentType: contentType})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Log("SignedURL with ContentType not supported")
+	} else if err != nil {
+		t.Fatal(err)
+	} else {
+		if ok := doSignedRequest(t, client, http.MethodPut, putURL, "application/json", contents); ok {
+			t.Error("PUT with the wrong Content-Type succeeded, want failure")
+		}
+		if ok := doSignedRequest(t, client, http.MethodPut, putURL, contentType, contents); !ok {
+			t.Error("PUT with the right Content-Type failed")
+		}
+	}
+
+	// Verify that a DELETE URL can be used to delete the blob.
+	deleteURL, err := b.SignedURL(ctx, key, &blob.SignedURLOptions{Method: http.MethodDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok := doSignedRequest(t, client, http.MethodDelete, deleteURL, "", nil); !ok {
+		t.Error("DELETE using a DELETE URL failed")
+	} else if exists, err := b.Exists(ctx, key); err != nil {
+		t.Error(err)
+	} else if exists {
+		t.Error("blob exists after DELETE, want it deleted")
+	}
+}
+
+// doSignedRequest sends a request to a signed URL, and reports whether it
+// got a successful response.
+func doSignedRequest(t *testing.T, client *http.Client, method, url, contentType string, body []byte) bool {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	// Azure requires the blob type for uploads; other providers ignore it.
+	if method == http.MethodPut {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
 // testAs tests the various As functions, using AsTest.
 func testAs(t *testing.T, newHarness HarnessMaker, st AsTest) {
 	const (
@@ -0,0 +1,628 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryptblob provides a blob implementation that encrypts blobs
+// client-side before storing them in another bucket.
+// Use OpenBucket to construct a *blob.Bucket.
+//
+// Encryption
+//
+// encryptblob uses envelope encryption: each blob is encrypted with its own
+// random 256-bit data key, and the data key is encrypted by a
+// *secrets.Keeper, such as a KMS key, and stored in the blob's metadata.
+// Reading a blob decrypts its data key with the Keeper.
+//
+// Blob contents are encrypted with AES-256-GCM in chunks of 64 KiB, so that
+// range reads only need to read and decrypt the chunks that overlap the
+// range. Each chunk is authenticated, along with its position in the blob and
+// whether it is the last chunk, so reads detect modified, reordered or
+// truncated content.
+//
+// The attributes of blobs, such as their keys, ContentType, ContentEncoding
+// and Metadata, are not encrypted. The ContentEncoding is stored in the
+// metadata of the inner blob. Attributes and List report the size of the unencrypted
+// content and no MD5 hash.
+//
+// Reading the attributes or contents of a blob in the inner bucket that was
+// not written by encryptblob returns an error for which gcerrors.Code will
+// return gcerrors.FailedPrecondition. Signed URLs, multipart uploads and
+// reading previous versions of blobs are not supported.
+//
+// Retries and limits
+//
+// encryptblob calls the inner bucket's provider directly, so the retry
+// policy and limits of the inner *blob.Bucket are not used, whether they were
+// set with SetRetryPolicy and SetLimits or with the "retry_" and "limit_"
+// parameters of its URL. Set them on the returned bucket instead, or with the
+// parameters of the "encrypt" URL itself.
+//
+// URLs
+//
+// For blob.OpenBucket, encryptblob registers for the scheme "encrypt".
+// To customize the URL opener, or for more details on the URL format,
+// see URLOpener.
+// See https://github.com/eliben/gocdkx/concepts/urls/ for background information.
+//
+// As
+//
+// encryptblob exposes the types of the inner bucket's provider for As.
+package encryptblob // import "github.com/eliben/gocdkx/blob/encryptblob"
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/internal/gcerr"
+	"github.com/eliben/gocdkx/secrets"
+)
+
+const (
+	// metaKey is the metadata key holding the data key of a blob, encrypted
+	// by the Keeper and base64-encoded.
+	metaKey = "encryptblob-key"
+	// metaAlg is the metadata key holding the encryption scheme of a blob.
+	metaAlg = "encryptblob-alg"
+	// metaEncoding is the metadata key holding the ContentEncoding of the
+	// unencrypted content of a blob. The inner blob has no ContentEncoding,
+	// so that the inner bucket, or an HTTP client, doesn't try to decode
+	// the encrypted content.
+	metaEncoding = "encryptblob-encoding"
+	// alg identifies the encryption scheme implemented here.
+	alg = "aes256gcm-64k"
+
+	// chunkSize is the size of the plaintext of each chunk but the last one.
+	chunkSize = 64 * 1024
+	// tagSize is the number of bytes added to each chunk by AES-GCM.
+	tagSize = 16
+	// dataKeySize is the size of the AES-256 data keys.
+	dataKeySize = 32
+)
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// Scheme is the URL scheme encryptblob registers its URLOpener under on
+// blob.DefaultMux.
+const Scheme = "encrypt"
+
+// URLOpener opens encryptblob URLs like
+// "encrypt://?bucket=s3%3A%2F%2Fmybucket&keeper=awskms%3A%2F%2Fmykey".
+//
+// The URL's host and path are ignored. The following query parameters are
+// required:
+//   - bucket: The URL of the inner bucket, which stores the encrypted
+//       blobs, opened with URLOpener.BucketMux.
+//   - keeper: The URL of the secrets.Keeper that encrypts the data keys,
+//       opened with URLOpener.KeeperMux.
+// Both URLs must be query-escaped. Closing the returned bucket closes the
+// inner bucket and the Keeper.
+type URLOpener struct {
+	// BucketMux opens the inner bucket. Defaults to blob.DefaultURLMux().
+	BucketMux *blob.URLMux
+	// KeeperMux opens the Keeper. Defaults to secrets.DefaultURLMux().
+	KeeperMux *secrets.URLMux
+	// Options specifies the options to pass to OpenBucket.
+	Options Options
+}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	q := u.Query()
+	bucketURL := q.Get("bucket")
+	keeperURL := q.Get("keeper")
+	q.Del("bucket")
+	q.Del("keeper")
+	for param := range q {
+		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	}
+	if bucketURL == "" {
+		return nil, fmt.Errorf("open bucket %v: query parameter %q is required", u, "bucket")
+	}
+	if keeperURL == "" {
+		return nil, fmt.Errorf("open bucket %v: query parameter %q is required", u, "keeper")
+	}
+	bucketMux := o.BucketMux
+	if bucketMux == nil {
+		bucketMux = blob.DefaultURLMux()
+	}
+	keeperMux := o.KeeperMux
+	if keeperMux == nil {
+		keeperMux = secrets.DefaultURLMux()
+	}
+	inner, err := bucketMux.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: failed to open inner bucket: %v", u, err)
+	}
+	keeper, err := keeperMux.OpenKeeper(ctx, keeperURL)
+	if err != nil {
+		inner.Close()
+		return nil, fmt.Errorf("open bucket %v: failed to open keeper: %v", u, err)
+	}
+	b := openBucket(inner, keeper, &o.Options)
+	b.Owned = true
+	return blob.NewBucket(b), nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by encryptblob.
+type Options struct{}
+
+// OpenBucket returns a *blob.Bucket that encrypts blobs with data keys
+// encrypted by keeper, and stores them in inner. Closing the returned bucket
+// doesn't close inner or keeper, which must stay open while the returned
+// bucket is in use.
+// A nil Options is treated the same as the zero value.
+func OpenBucket(inner *blob.Bucket, keeper *secrets.Keeper, opts *Options) *blob.Bucket {
+	return blob.NewBucket(openBucket(inner, keeper, opts))
+}
+
+func openBucket(inner *blob.Bucket, keeper *secrets.Keeper, _ *Options) *bucket {
+	return &bucket{Bucket: wrapper.New(inner), keeper: keeper}
+}
+
+// bucket implements driver.Bucket on top of the driver of another
+// *blob.Bucket. Copies, deletes and errors are handled by the inner bucket.
+type bucket struct {
+	wrapper.Bucket
+	keeper *secrets.Keeper
+}
+
+// plaintextSize returns the size of the content of a blob whose encrypted
+// content is size bytes, or 0 if size is invalid.
+func plaintextSize(size int64) int64 {
+	n := (size + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	if p := size - n*tagSize; p > 0 {
+		return p
+	}
+	return 0
+}
+
+// nonce returns the AES-GCM nonce for the chunk at index i. Since every blob
+// has its own data key, nonces only need to be unique within a blob.
+func nonce(i int64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n, uint64(i))
+	if last {
+		n[8] = 1
+	}
+	return n
+}
+
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	a, err := b.Bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := encryptedKey(key, a); err != nil {
+		return nil, err
+	}
+	var md map[string]string
+	for k, v := range a.Metadata {
+		if isReserved(k) {
+			continue
+		}
+		if md == nil {
+			md = map[string]string{}
+		}
+		md[k] = v
+	}
+	pa := *a
+	pa.ContentEncoding = a.Metadata[metaEncoding]
+	pa.Metadata = md
+	pa.Size = plaintextSize(a.Size)
+	pa.MD5 = nil
+	return &pa, nil
+}
+
+// isReserved reports whether k is a metadata key used by encryptblob, which
+// can't be set by users.
+func isReserved(k string) bool {
+	return k == metaKey || k == metaAlg || k == metaEncoding
+}
+
+// encryptedKey returns the encrypted data key of the blob at key, with
+// attributes a, or an error if the blob was not written by encryptblob.
+func encryptedKey(key string, a *driver.Attributes) ([]byte, error) {
+	if a.Metadata[metaAlg] != alg {
+		if a.Metadata[metaAlg] == "" {
+			return nil, gcerr.Newf(gcerr.FailedPrecondition, nil, "encryptblob: blob %q is not encrypted", key)
+		}
+		return nil, gcerr.Newf(gcerr.FailedPrecondition, nil, "encryptblob: blob %q is encrypted with unsupported scheme %q", key, a.Metadata[metaAlg])
+	}
+	ek, err := base64.StdEncoding.DecodeString(a.Metadata[metaKey])
+	if err != nil || len(ek) == 0 {
+		return nil, gcerr.Newf(gcerr.FailedPrecondition, err, "encryptblob: blob %q has an invalid data key", key)
+	}
+	return ek, nil
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	page, err := b.Bucket.ListPaged(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, obj := range page.Objects {
+		if obj.IsDir {
+			continue
+		}
+		pobj := *obj
+		pobj.Size = plaintextSize(obj.Size)
+		pobj.MD5 = nil
+		page.Objects[i] = &pobj
+	}
+	return page, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.Version != "" {
+		return nil, gcerr.Newf(gcerr.Unimplemented, nil, "encryptblob: reading versions is not supported")
+	}
+	a, err := b.Bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	ek, err := encryptedKey(key, a)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := b.keeper.Decrypt(ctx, ek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, gcerr.Newf(gcerr.FailedPrecondition, err, "encryptblob: blob %q has an invalid data key", key)
+	}
+
+	// Read the blob version whose data key we have.
+	conds := &driver.Conditions{IfMatch: a.ETag}
+	if opts.Conditions != nil {
+		conds.IfModifiedSince = opts.Conditions.IfModifiedSince
+		if opts.Conditions.IfMatch != "" {
+			conds.IfMatch = opts.Conditions.IfMatch
+		}
+	}
+	if *conds == (driver.Conditions{}) {
+		conds = nil
+	}
+
+	size := plaintextSize(a.Size)
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	nchunks := (a.Size + chunkSize + tagSize - 1) / (chunkSize + tagSize)
+	r := &reader{
+		aead: aead,
+		last: nchunks - 1,
+		buf:  make([]byte, chunkSize+tagSize),
+	}
+	// Read the chunks that overlap [offset, end), or nothing if the range is
+	// empty.
+	var innerOffset, innerLength int64
+	if offset < end {
+		r.chunk = offset / chunkSize
+		r.skip = int(offset - r.chunk*chunkSize)
+		r.remaining = end - offset
+		innerOffset = r.chunk * (chunkSize + tagSize)
+		innerLength = ((end-1)/chunkSize+1)*(chunkSize+tagSize) - innerOffset
+	}
+	// The encrypted content is read as stored.
+	r.r, err = b.Bucket.NewRangeReader(ctx, key, innerOffset, innerLength, &driver.ReaderOptions{
+		BeforeRead: opts.BeforeRead,
+		Conditions: conds,
+		Raw:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ra := r.r.Attributes()
+	r.attrs = driver.ReaderAttributes{
+		ContentType: ra.ContentType,
+		ModTime:     ra.ModTime,
+		Size:        size,
+	}
+	return r, nil
+}
+
+// reader reads and decrypts chunks of a blob.
+type reader struct {
+	r     driver.Reader
+	aead  cipher.AEAD
+	attrs driver.ReaderAttributes
+
+	chunk     int64  // index of the next chunk
+	last      int64  // index of the last chunk of the blob
+	skip      int    // number of bytes to skip at the start of the next chunk
+	remaining int64  // number of bytes left to decrypt
+	buf       []byte // holds one encrypted chunk
+	plain     []byte // decrypted bytes not yet returned, in buf
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk into r.plain.
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Only the last chunk may be shorter.
+		if r.chunk != r.last || n < tagSize {
+			return gcerr.Newf(gcerr.FailedPrecondition, nil, "encryptblob: encrypted content is truncated")
+		}
+	} else if err != nil {
+		return err
+	}
+	plain, err := r.aead.Open(r.buf[:0], nonce(r.chunk, r.chunk == r.last), r.buf[:n], nil)
+	if err != nil {
+		return gcerr.Newf(gcerr.FailedPrecondition, err, "encryptblob: failed to decrypt chunk %d", r.chunk)
+	}
+	r.chunk++
+	if r.skip > len(plain) {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "encryptblob: encrypted content is truncated")
+	}
+	plain = plain[r.skip:]
+	r.skip = 0
+	if int64(len(plain)) > r.remaining {
+		plain = plain[:r.remaining]
+	}
+	r.remaining -= int64(len(plain))
+	r.plain = plain
+	return nil
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	return r.r.As(i)
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.Append {
+		// The last chunk of a blob is encrypted differently, and the data
+		// key is per blob, so content can't be appended.
+		return nil, gcerr.Newf(gcerr.Unimplemented, nil, "encryptblob: appending is not supported")
+	}
+	md := map[string]string{}
+	for k, v := range opts.Metadata {
+		if isReserved(k) {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "encryptblob: metadata key %q is reserved", k)
+		}
+		md[k] = v
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	ek, err := b.keeper.Encrypt(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	md[metaKey] = base64.StdEncoding.EncodeToString(ek)
+	md[metaAlg] = alg
+	if opts.ContentEncoding != "" {
+		md[metaEncoding] = opts.ContentEncoding
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// ContentMD5 is checked by the portable type against the unencrypted
+	// content, so it isn't passed on.
+	wopts := *opts
+	wopts.ContentEncoding = ""
+	wopts.ContentMD5 = nil
+	wopts.Metadata = md
+	w, err := b.Bucket.NewTypedWriter(ctx, key, contentType, &wopts)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, chunkSize+tagSize)}, nil
+}
+
+// writer encrypts chunks of a blob and writes them to the inner bucket.
+type writer struct {
+	w     driver.Writer
+	aead  cipher.AEAD
+	chunk int64  // index of the next chunk
+	buf   []byte // bytes of the next chunk
+	err   error  // set if a chunk could not be written
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// Only encrypt a full chunk once more bytes are written, because the
+		// last chunk is encrypted differently.
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		m := chunkSize - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// flush encrypts and writes the buffered chunk.
+func (w *writer) flush(last bool) error {
+	sealed := w.aead.Seal(w.buf[:0], nonce(w.chunk, last), w.buf, nil)
+	w.chunk++
+	w.buf = w.buf[:0]
+	if _, err := w.w.Write(sealed); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *writer) Close() error {
+	if w.err == nil {
+		// Ignore the error; the inner writer's Close returns it too.
+		_ = w.flush(true)
+	}
+	return w.w.Close()
+}
+
+// Compose implements driver.Compose. The content of each blob is encrypted
+// with a key of its own, so it can't be concatenated with others.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	return gcerr.Newf(gcerr.Unimplemented, nil, "encryptblob: Compose is not supported")
+}
+
+// UpdateAttributes implements driver.UpdateAttributes. The metadata holding
+// the data key can't be changed.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	for k := range opts.Metadata {
+		if isReserved(k) {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "encryptblob: metadata key %q is reserved", k)
+		}
+	}
+	for _, k := range opts.DeleteMetadata {
+		if isReserved(k) {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "encryptblob: metadata key %q is reserved", k)
+		}
+	}
+	uopts := *opts
+	if opts.ContentEncoding != nil {
+		uopts.ContentEncoding = nil
+		if *opts.ContentEncoding == "" {
+			uopts.DeleteMetadata = append(append([]string(nil), opts.DeleteMetadata...), metaEncoding)
+		} else {
+			uopts.Metadata = make(map[string]string, len(opts.Metadata)+1)
+			for k, v := range opts.Metadata {
+				uopts.Metadata[k] = v
+			}
+			uopts.Metadata[metaEncoding] = *opts.ContentEncoding
+		}
+	}
+	return b.Bucket.UpdateAttributes(ctx, key, &uopts)
+}
+
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	vs, err := b.Bucket.ListVersions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vs {
+		pv := *v
+		pv.Size = plaintextSize(v.Size)
+		vs[i] = &pv
+	}
+	return vs, nil
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", gcerr.Newf(gcerr.Unimplemented, nil, "encryptblob: SignedURL is not supported")
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	return nil, gcerr.Newf(gcerr.Unimplemented, nil, "encryptblob: multipart uploads are not supported")
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	return nil, gcerr.Newf(gcerr.Unimplemented, nil, "encryptblob: multipart uploads are not supported")
+}
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	w, err := b.Bucket.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{w}, nil
+}
+
+// watcher reports the events of the inner bucket's Watcher, with the sizes
+// of the unencrypted content. It implements driver.Watcher.
+type watcher struct {
+	driver.Watcher
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	evs, err := w.Watcher.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, ev := range evs {
+		pev := *ev
+		pev.Size = plaintextSize(ev.Size)
+		evs[i] = &pev
+	}
+	return evs, nil
+}
+
+// Close implements driver.Close.
+func (b *bucket) Close() error {
+	err := b.Bucket.Close()
+	if b.Owned {
+		if kerr := b.keeper.Close(); err == nil {
+			err = kerr
+		}
+	}
+	return err
+}
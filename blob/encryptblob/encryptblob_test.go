@@ -0,0 +1,328 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/secrets"
+	"github.com/eliben/gocdkx/secrets/localsecrets"
+)
+
+type harness struct {
+	inner  *blob.Bucket
+	keeper *secrets.Keeper
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return &harness{inner: memblob.OpenBucket(nil), keeper: newKeeper(t)}, nil
+}
+
+func newKeeper(t *testing.T) *secrets.Keeper {
+	sk, err := localsecrets.NewRandomKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return localsecrets.NewKeeper(sk)
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.inner, h.keeper, nil), nil
+}
+
+func (h *harness) Close() {
+	h.inner.Close()
+	h.keeper.Close()
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+func TestPlaintextSize(t *testing.T) {
+	for _, size := range []int64{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 7} {
+		nchunks := size/chunkSize + 1
+		if size > 0 && size%chunkSize == 0 {
+			nchunks--
+		}
+		if got := plaintextSize(size + nchunks*tagSize); got != size {
+			t.Errorf("plaintextSize for %d bytes in %d chunks: got %d", size, nchunks, got)
+		}
+	}
+}
+
+func TestRangeRead(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	keeper := newKeeper(t)
+	defer keeper.Close()
+	b := OpenBucket(inner, keeper, nil)
+	defer b.Close()
+
+	content := make([]byte, 3*chunkSize+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := b.WriteAll(ctx, "key", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The inner bucket holds the encrypted content.
+	stored, err := inner.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(content)+4*tagSize || bytes.Contains(stored, content[:100]) {
+		t.Errorf("got %d stored bytes, want %d encrypted bytes", len(stored), len(content)+4*tagSize)
+	}
+
+	size := int64(len(content))
+	tests := []struct {
+		offset, length int64
+	}{
+		{0, -1},
+		{0, 10},
+		{10, 20},
+		{chunkSize - 5, 10},
+		{chunkSize, chunkSize},
+		{chunkSize + 1, 2 * chunkSize},
+		{size - 50, -1},
+		{size - 50, 1000},
+		{size, -1},
+		{size + 10, 5},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d-%d", test.offset, test.length), func(t *testing.T) {
+			r, err := b.NewRangeReader(ctx, "key", test.offset, test.length, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, end := test.offset, size
+			if start > size {
+				start = size
+			}
+			if test.length >= 0 && start+test.length < end {
+				end = start + test.length
+			}
+			if !bytes.Equal(got, content[start:end]) {
+				t.Errorf("got %d bytes, want content[%d:%d]", len(got), start, end)
+			}
+			if r.Size() != size {
+				t.Errorf("got Size %d want %d", r.Size(), size)
+			}
+		})
+	}
+}
+
+func TestTampering(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	keeper := newKeeper(t)
+	defer keeper.Close()
+	b := OpenBucket(inner, keeper, nil)
+	defer b.Close()
+
+	content := bytes.Repeat([]byte("x"), 2*chunkSize+10)
+	if err := b.WriteAll(ctx, "key", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := inner.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := inner.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrite := func(t *testing.T, p []byte) {
+		if err := inner.WriteAll(ctx, "key", p, &blob.WriterOptions{Metadata: attrs.Metadata}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Modified", func(t *testing.T) {
+		p := append([]byte{}, stored...)
+		p[10] ^= 1
+		rewrite(t, p)
+		if _, err := b.ReadAll(ctx, "key"); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+			t.Errorf("got error %v want FailedPrecondition", err)
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		// Drop the last chunk, so that the previous one is the last.
+		rewrite(t, stored[:2*(chunkSize+tagSize)])
+		if _, err := b.ReadAll(ctx, "key"); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+			t.Errorf("got error %v want FailedPrecondition", err)
+		}
+	})
+	t.Run("NotEncrypted", func(t *testing.T) {
+		if err := inner.WriteAll(ctx, "plain", []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Attributes(ctx, "plain"); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+			t.Errorf("Attributes: got error %v want FailedPrecondition", err)
+		}
+		if _, err := b.ReadAll(ctx, "plain"); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+			t.Errorf("ReadAll: got error %v want FailedPrecondition", err)
+		}
+	})
+	t.Run("OtherKeeper", func(t *testing.T) {
+		rewrite(t, stored)
+		other := OpenBucket(inner, newKeeper(t), nil)
+		defer other.Close()
+		if _, err := other.ReadAll(ctx, "key"); err == nil {
+			t.Error("got nil error reading with a different Keeper, want error")
+		}
+	})
+}
+
+func TestReservedMetadata(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	keeper := newKeeper(t)
+	defer keeper.Close()
+	b := OpenBucket(inner, keeper, nil)
+	defer b.Close()
+
+	err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{Metadata: map[string]string{metaKey: "x"}})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	ctx := context.Background()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	keeper := newKeeper(t)
+	defer keeper.Close()
+	b := OpenBucket(inner, keeper, nil)
+	defer b.Close()
+
+	// check verifies that b reports want as the ContentEncoding of the blob,
+	// and that the inner blob has none.
+	check := func(want string) {
+		t.Helper()
+		a, err := b.Attributes(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.ContentEncoding != want {
+			t.Errorf("got ContentEncoding %q want %q", a.ContentEncoding, want)
+		}
+		if _, ok := a.Metadata[metaEncoding]; ok {
+			t.Errorf("got metadata key %q in %v, want it hidden", metaEncoding, a.Metadata)
+		}
+		ia, err := inner.Attributes(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ia.ContentEncoding != "" {
+			t.Errorf("got inner ContentEncoding %q want empty", ia.ContentEncoding)
+		}
+	}
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{ContentEncoding: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+	check("gzip")
+	br := "br"
+	if err := b.UpdateAttributes(ctx, "key", &blob.UpdateAttributesOptions{ContentEncoding: &br}); err != nil {
+		t.Fatal(err)
+	}
+	check("br")
+	empty := ""
+	if err := b.UpdateAttributes(ctx, "key", &blob.UpdateAttributesOptions{ContentEncoding: &empty}); err != nil {
+		t.Fatal(err)
+	}
+	check("")
+	err := b.UpdateAttributes(ctx, "key", &blob.UpdateAttributesOptions{DeleteMetadata: []string{metaEncoding}})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+	// An empty key makes localsecrets generate a random one.
+	const keeperURL = "base64key://"
+	encURL := func(bucketURL, keeperURL string) string {
+		q := url.Values{}
+		if bucketURL != "" {
+			q.Set("bucket", bucketURL)
+		}
+		if keeperURL != "" {
+			q.Set("keeper", keeperURL)
+		}
+		return "encrypt://?" + q.Encode()
+	}
+
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		// OK.
+		{encURL("mem://", keeperURL), false},
+		// Missing bucket.
+		{encURL("", keeperURL), true},
+		// Missing keeper.
+		{encURL("mem://", ""), true},
+		// Invalid inner bucket URL.
+		{encURL("mem://?param=value", keeperURL), true},
+		// Invalid keeper URL.
+		{encURL("mem://", "base64key://?param=value"), true},
+		// Invalid parameter.
+		{encURL("mem://", keeperURL) + "&param=value", true},
+	}
+	for _, test := range tests {
+		b, err := blob.OpenBucket(ctx, test.URL)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+		if err == nil {
+			if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+				t.Errorf("%s: %v", test.URL, err)
+			}
+			if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello" {
+				t.Errorf("%s: got %q, %v want %q", test.URL, got, err, "hello")
+			}
+			if err := b.Close(); err != nil {
+				t.Errorf("%s: %v", test.URL, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptblob_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/encryptblob"
+	_ "github.com/eliben/gocdkx/blob/fileblob"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/secrets/localsecrets"
+)
+
+func ExampleOpenBucket() {
+	ctx := context.Background()
+
+	// Encrypt blobs with a local key before storing them in an in-memory
+	// bucket. In production, use a KMS-backed Keeper and a Cloud bucket.
+	secretKey, err := localsecrets.NewRandomKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	keeper := localsecrets.NewKeeper(secretKey)
+	defer keeper.Close()
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+
+	bucket := encryptblob.OpenBucket(inner, keeper, nil)
+	defer bucket.Close()
+	if err := bucket.WriteAll(ctx, "secret.txt", []byte("Hello, World!"), nil); err != nil {
+		log.Fatal(err)
+	}
+	data, err := bucket.ReadAll(ctx, "secret.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+
+	// Output:
+	// Hello, World!
+}
+
+func Example_openBucket() {
+	ctx := context.Background()
+
+	// Compose the URLs of the inner bucket and the Keeper.
+	q := url.Values{}
+	q.Set("bucket", "file:///path/to/dir")
+	q.Set("keeper", "base64key://smGbjm71Nxd1Ig5FS0wj9SlbzAIrnolCz9bQQ6uAhl4=")
+	bucket, err := blob.OpenBucket(ctx, "encrypt://?"+q.Encode())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
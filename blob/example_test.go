@@ -294,6 +294,56 @@ func ExampleBucket_List_withDelimiter() {
 	//   dir2/c.txt
 }
 
+func ExampleBucket_Walk() {
+	// Connect to a bucket when your program starts up.
+	// This example uses the file-based implementation.
+	dir, cleanup := newTempDir()
+	defer cleanup()
+
+	// Create the file-based bucket.
+	bucket, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+
+	// Create some blob objects in a hierarchy.
+	ctx := context.Background()
+	for _, key := range []string{
+		"logs/2019/app.log",
+		"logs/2019/app.txt",
+		"logs/2020/app.log",
+		"tmp/scratch.log",
+	} {
+		if err := bucket.WriteAll(ctx, key, []byte("Go Cloud Development Kit"), nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Walk the tree, printing the ".log" files. Concurrency is set to 1 so
+	// that the output is deterministic; by default, directories are listed
+	// concurrently, and the WalkFunc must be safe to call from several
+	// goroutines.
+	opts := &blob.WalkOptions{Glob: "*/*/*.log", Concurrency: 1}
+	err = bucket.Walk(ctx, opts, func(ctx context.Context, obj *blob.ListObject) error {
+		if obj.IsDir {
+			// Don't descend into "tmp/".
+			if obj.Key == "tmp/" {
+				return blob.SkipDir
+			}
+			return nil
+		}
+		fmt.Println(obj.Key)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// logs/2019/app.log
+	// logs/2020/app.log
+}
 func ExampleBucket_As() {
 	// This example is specific to the gcsblob implementation; it demonstrates
 	// access to the underlying cloud.google.com/go/storage.Client type.
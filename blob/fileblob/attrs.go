@@ -18,11 +18,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/eliben/gocdkx/blob/driver"
 )
 
-const attrsExt = ".attrs"
+const (
+	attrsExt    = ".attrs"
+	uploadsExt  = ".uploads"
+	versionsExt = ".versions"
+)
 
-var errAttrsExt = fmt.Errorf("file extension %q is reserved", attrsExt)
+var (
+	errAttrsExt    = fmt.Errorf("file extension %q is reserved", attrsExt)
+	errUploadsExt  = fmt.Errorf("file extension %q is reserved", uploadsExt)
+	errVersionsExt = fmt.Errorf("file extension %q is reserved", versionsExt)
+)
 
 // xattrs stores extended attributes for an object. The format is like
 // filesystem extended attributes, see
@@ -35,6 +46,16 @@ type xattrs struct {
 	ContentType        string            `json:"user.content_type"`
 	Metadata           map[string]string `json:"user.metadata"`
 	MD5                []byte            `json:"md5"`
+	Version            string            `json:"version,omitempty"`
+	Expires            *time.Time        `json:"expires,omitempty"`
+	// StorageClass is omitted for the default class, which is reported as
+	// driver.StorageClassStandard.
+	StorageClass driver.StorageClass `json:"storage_class,omitempty"`
+}
+
+// expired reports whether the blob with attributes xa has expired at now.
+func (xa *xattrs) expired(now time.Time) bool {
+	return xa.Expires != nil && !xa.Expires.After(now)
 }
 
 // setAttrs creates a "path.attrs" file along with blob to store the attributes,
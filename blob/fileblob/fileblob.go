@@ -33,6 +33,57 @@
 //    "/" is key names are escaped in the same way.
 //    On Windows, the characters "<>:"|?*" are also escaped.
 //
+// Preconditions
+//
+// fileblob checks blob.Conditions immediately before performing an
+// operation, so they do not protect against concurrent writes from other
+// processes sharing the directory. The ETag of a blob is the hex encoding of
+// its MD5 hash, or a value derived from its modification time and size for
+// files that were not written by fileblob.
+//
+// Versions
+//
+// If Options.Versioning is set, fileblob keeps noncurrent versions of a blob
+// in a sidecar directory named "<file>.versions" next to it. Version IDs are
+// derived from the time the version was written, so they sort in the order
+// the versions were created.
+//
+// Expiration
+//
+// The expiration time of blobs written with blob.WriterOptions.Expires is
+// stored with their attributes. Expired blobs are reported as not found, and
+// are deleted by a janitor goroutine that scans the directory every
+// Options.JanitorInterval. The janitor is started by the first write of an
+// expiring blob, or the first read of an expired one, so blobs written by
+// other processes are deleted once they are noticed.
+//
+// Storage classes
+//
+// All of the storage classes of blob.StorageClass are supported, and stored
+// with the attributes of blobs, but they don't change how blobs are stored.
+// The default class is blob.StorageClassStandard.
+//
+// Appending
+//
+// Writers with blob.WriterOptions.Append set copy their content to the file,
+// opened with O_APPEND, when they are closed, so concurrent appends from any
+// process don't overwrite each other; large concurrent appends may
+// interleave, though, and readers may see an append that is in progress.
+// Appending doesn't keep a noncurrent version of the blob. Since the MD5 hash
+// of the whole content isn't known after an append, a blob that is appended
+// to, or created by an append, doesn't report one, and its ETag is derived
+// from its modification time and size.
+//
+// Watch
+//
+// fileblob watches the directory and its subdirectories for changes using
+// fsnotify, so changes made by other processes are reported too. Events are
+// collected for a short time after the first one, and the files they are
+// about are compared with their state when they were last reported, so a
+// file that changes several times in quick succession may only be reported
+// once. Files named like fileblob's temporary files, "fileblob" followed by
+// digits, are not reported.
+//
 // As
 //
 // fileblob exposes the following types for As:
@@ -50,13 +101,16 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/eliben/gocdkx/blob"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/gcerrors"
@@ -111,11 +165,28 @@ type Options struct {
 	// contains a signature produced by the URLSigner.
 	// URLSigner is only required for utilizing the SignedURL API.
 	URLSigner URLSigner
+
+	// Versioning enables keeping noncurrent versions of blobs when they are
+	// overwritten or deleted. If it is false, ListVersions, and reading or
+	// deleting a specific version, return an error with code Unimplemented.
+	Versioning bool
+
+	// JanitorInterval is how often the directory is scanned for expired
+	// blobs once the janitor is running; see the package documentation.
+	// The default is one minute.
+	JanitorInterval time.Duration
 }
 
+// defaultJanitorInterval is the default for Options.JanitorInterval.
+const defaultJanitorInterval = time.Minute
+
 type bucket struct {
 	dir  string
 	opts *Options
+
+	// janitorOnce starts the janitor, which runs until done is closed.
+	janitorOnce sync.Once
+	done        chan struct{}
 }
 
 // openBucket creates a driver.Bucket that reads and writes to dir.
@@ -132,7 +203,7 @@ func openBucket(dir string, opts *Options) (driver.Bucket, error) {
 	if opts == nil {
 		opts = &Options{}
 	}
-	return &bucket{dir: dir, opts: opts}, nil
+	return &bucket{dir: dir, opts: opts, done: make(chan struct{})}, nil
 }
 
 // OpenBucket creates a *blob.Bucket backed by the filesystem and rooted at
@@ -146,9 +217,66 @@ func OpenBucket(dir string, opts *Options) (*blob.Bucket, error) {
 }
 
 func (b *bucket) Close() error {
+	close(b.done)
 	return nil
 }
 
+// startJanitor starts the janitor if it isn't running yet. The janitor
+// deletes expired blobs right away, and then every JanitorInterval.
+func (b *bucket) startJanitor() {
+	b.janitorOnce.Do(func() {
+		interval := b.opts.JanitorInterval
+		if interval <= 0 {
+			interval = defaultJanitorInterval
+		}
+		go func() {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				b.deleteExpired()
+				select {
+				case <-t.C:
+				case <-b.done:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// deleteExpired deletes the blobs in the directory that have expired.
+func (b *bucket) deleteExpired() {
+	now := time.Now()
+	_ = filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The file or directory was removed concurrently; skip it.
+			return nil
+		}
+		if strings.HasSuffix(path, uploadsExt) || strings.HasSuffix(path, versionsExt) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || strings.HasSuffix(path, attrsExt) {
+			return nil
+		}
+		if xa, err := getAttrs(path); err == nil && xa.expired(now) {
+			_ = b.remove(path)
+		}
+		return nil
+	})
+}
+
+// remove deletes the blob at path, keeping it as a noncurrent version if
+// versioning is enabled.
+func (b *bucket) remove(path string) error {
+	if b.opts.Versioning {
+		return archive(path)
+	}
+	return removeFile(path)
+}
+
 // escapeKey does all required escaping for UTF-8 strings to work the filesystem.
 func escapeKey(s string) string {
 	s = escape.HexEscape(s, func(r []rune, i int) bool {
@@ -196,6 +324,10 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 	switch {
 	case os.IsNotExist(err):
 		return gcerrors.NotFound
+	case err == errPreconditionFailed:
+		return gcerrors.FailedPrecondition
+	case err == errNotImplemented:
+		return gcerrors.Unimplemented
 	default:
 		return gcerrors.Unknown
 	}
@@ -207,6 +339,12 @@ func (b *bucket) path(key string) (string, error) {
 	if strings.HasSuffix(path, attrsExt) {
 		return "", errAttrsExt
 	}
+	if strings.HasSuffix(path, uploadsExt) || strings.Contains(path, uploadsExt+string(os.PathSeparator)) {
+		return "", errUploadsExt
+	}
+	if strings.HasSuffix(path, versionsExt) || strings.Contains(path, versionsExt+string(os.PathSeparator)) {
+		return "", errVersionsExt
+	}
 	return path, nil
 }
 
@@ -224,9 +362,136 @@ func (b *bucket) forKey(key string) (string, os.FileInfo, *xattrs, error) {
 	if err != nil {
 		return "", nil, nil, err
 	}
+	if xa.expired(time.Now()) {
+		// The janitor may not be running, if the blob was written by another
+		// process.
+		b.startJanitor()
+		return "", nil, nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
 	return path, info, &xa, nil
 }
 
+// forVersion returns the full path, os.FileInfo, and attributes for version
+// of key, which may be the current version or one in the versions directory.
+func (b *bucket) forVersion(key, version string) (string, os.FileInfo, *xattrs, error) {
+	if !b.opts.Versioning {
+		return "", nil, nil, errNotImplemented
+	}
+	path, info, xa, err := b.forKey(key)
+	if err == nil && versionID(info, xa) == version {
+		return path, info, xa, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, nil, err
+	}
+	if path == "" {
+		if path, err = b.path(key); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	// Don't allow version to escape the versions directory.
+	if version == "" || filepath.Base(version) != version || strings.HasSuffix(version, attrsExt) {
+		return "", nil, nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	vpath := filepath.Join(path+versionsExt, version)
+	info, err = os.Stat(vpath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	va, err := getAttrs(vpath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return vpath, info, &va, nil
+}
+
+// newVersionID returns the version ID for a blob being written now.
+func newVersionID() string {
+	return fmt.Sprintf("%020d", time.Now().UnixNano())
+}
+
+// versionID returns the version ID of the file described by info and xa.
+// Files that were not written by fileblob use their modification time.
+func versionID(info os.FileInfo, xa *xattrs) string {
+	if xa.Version != "" {
+		return xa.Version
+	}
+	return fmt.Sprintf("%020d", info.ModTime().UnixNano())
+}
+
+// archive moves the file at path and its attributes into the versions
+// directory for path, making it a noncurrent version.
+func archive(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.PathError{Op: "archive", Path: path, Err: errors.New("is a directory")}
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		return err
+	}
+	dir := path + versionsExt
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	vpath := filepath.Join(dir, versionID(info, &xa))
+	if err := os.Rename(path+attrsExt, vpath+attrsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(path, vpath)
+}
+
+var errNotImplemented = errors.New("not implemented")
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// checkConditions returns errPreconditionFailed if the file at path, which
+// may not exist, does not satisfy conds.
+func checkConditions(path string, conds *driver.Conditions) error {
+	if conds == nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+	var xa xattrs
+	if exists {
+		if xa, err = getAttrs(path); err != nil {
+			return err
+		}
+		// Expired blobs that haven't been deleted yet don't exist.
+		exists = !xa.expired(time.Now())
+	}
+	if conds.IfNotExist && exists {
+		return errPreconditionFailed
+	}
+	if conds.IfMatch != "" {
+		if !exists {
+			return errPreconditionFailed
+		}
+		if etag(info, &xa) != conds.IfMatch {
+			return errPreconditionFailed
+		}
+	}
+	if !conds.IfModifiedSince.IsZero() && (!exists || !info.ModTime().After(conds.IfModifiedSince)) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// etag returns the ETag of the file described by info and xa.
+func etag(info os.FileInfo, xa *xattrs) string {
+	if len(xa.MD5) > 0 {
+		return fmt.Sprintf(`"%x"`, xa.MD5)
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
 // ListPaged implements driver.ListPaged.
 func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
 
@@ -255,6 +520,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 
 	// Do a full recursive scan of the root directory.
 	var result driver.ListPage
+	now := time.Now()
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			// Couldn't read this file/directory for some reason; just skip it.
@@ -264,6 +530,14 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 		if strings.HasSuffix(path, attrsExt) {
 			return nil
 		}
+		// Skip the directories holding parts of multipart uploads, and
+		// noncurrent versions of blobs.
+		if strings.HasSuffix(path, uploadsExt) || strings.HasSuffix(path, versionsExt) {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		// os.Walk returns the root directory; skip it.
 		if path == b.dir {
 			return nil
@@ -295,17 +569,23 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 		if !strings.HasPrefix(key, opts.Prefix) {
 			return nil
 		}
-		var md5 []byte
-		if xa, err := getAttrs(path); err == nil {
+		var xa xattrs
+		// path was made relative to b.dir above.
+		if a, err := getAttrs(filepath.Join(b.dir, path)); err == nil {
 			// Note: we only have the MD5 hash for blobs that we wrote.
-			// For other blobs, md5 will remain nil.
-			md5 = xa.MD5
+			// For other blobs, xa.MD5 will remain nil.
+			xa = a
+		}
+		// Skip blobs that have expired, but haven't been deleted yet.
+		if xa.expired(now) {
+			return nil
 		}
 		obj := &driver.ListObject{
 			Key:     key,
 			ModTime: info.ModTime(),
 			Size:    info.Size(),
-			MD5:     md5,
+			MD5:     xa.MD5,
+			ETag:    etag(info, &xa),
 		}
 		// If using Delimiter, collapse "directories".
 		if opts.Delimiter != "" {
@@ -367,7 +647,7 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	if err != nil {
 		return nil, err
 	}
-	return &driver.Attributes{
+	a := &driver.Attributes{
 		CacheControl:       xa.CacheControl,
 		ContentDisposition: xa.ContentDisposition,
 		ContentEncoding:    xa.ContentEncoding,
@@ -377,15 +657,35 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ModTime:            info.ModTime(),
 		Size:               info.Size(),
 		MD5:                xa.MD5,
-	}, nil
+		ETag:               etag(info, xa),
+		StorageClass:       xa.StorageClass,
+	}
+	if a.StorageClass == driver.StorageClassDefault {
+		a.StorageClass = driver.StorageClassStandard
+	}
+	if xa.Expires != nil {
+		a.Expires = *xa.Expires
+	}
+	return a, nil
 }
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
-	path, info, xa, err := b.forKey(key)
+	var path string
+	var info os.FileInfo
+	var xa *xattrs
+	var err error
+	if opts.Version != "" {
+		path, info, xa, err = b.forVersion(key, opts.Version)
+	} else {
+		path, info, xa, err = b.forKey(key)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := checkConditions(path, opts.Conditions); err != nil {
+		return nil, err
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -470,27 +770,41 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		ContentLanguage:    opts.ContentLanguage,
 		ContentType:        contentType,
 		Metadata:           metadata,
+		Version:            newVersionID(),
+		StorageClass:       opts.StorageClass,
+	}
+	if !opts.Expires.IsZero() {
+		attrs.Expires = &opts.Expires
+		b.startJanitor()
 	}
 	w := &writer{
 		ctx:        ctx,
+		b:          b,
 		f:          f,
 		path:       path,
 		attrs:      attrs,
 		contentMD5: opts.ContentMD5,
 		md5hash:    md5.New(),
+		conditions: opts.Conditions,
+		versioning: b.opts.Versioning,
+		append:     opts.Append,
 	}
 	return w, nil
 }
 
 type writer struct {
 	ctx        context.Context
+	b          *bucket
 	f          *os.File
 	path       string
 	attrs      xattrs
 	contentMD5 []byte
 	// We compute the MD5 hash so that we can store it with the file attributes,
 	// not for verification.
-	md5hash hash.Hash
+	md5hash    hash.Hash
+	conditions *driver.Conditions
+	versioning bool
+	append     bool
 }
 
 func (w *writer) Write(p []byte) (n int, err error) {
@@ -519,6 +833,18 @@ func (w *writer) Close() error {
 	md5sum := w.md5hash.Sum(nil)
 	w.attrs.MD5 = md5sum
 
+	if w.append {
+		return w.appendContent()
+	}
+	if err := checkConditions(w.path, w.conditions); err != nil {
+		return err
+	}
+	// Keep the blob being overwritten, if any, as a noncurrent version.
+	if w.versioning {
+		if err := archive(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	// Write the attributes file.
 	if err := setAttrs(w.path, w.attrs); err != nil {
 		return err
@@ -531,6 +857,68 @@ func (w *writer) Close() error {
 	return nil
 }
 
+// appendContent appends the content in the temporary file to the blob's file
+// opened with O_APPEND, so that concurrent appends don't overwrite each other.
+// If the blob doesn't exist, it is created from the temporary file instead.
+func (w *writer) appendContent() error {
+	src, err := os.Open(w.f.Name())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	for {
+		if err := checkConditions(w.path, w.conditions); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0)
+		if os.IsNotExist(err) {
+			// Link rather than rename the temporary file, so that a blob
+			// created concurrently by another append isn't replaced.
+			if err := os.Link(w.f.Name(), w.path); err != nil {
+				if os.IsExist(err) {
+					continue
+				}
+				return err
+			}
+			// Another append may extend the blob before its attributes are
+			// written, so the MD5 hash of its content isn't known either.
+			attrs := w.attrs
+			attrs.MD5 = nil
+			return setAttrs(w.path, attrs)
+		}
+		if err != nil {
+			return err
+		}
+		xa, err := getAttrs(w.path)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if xa.expired(time.Now()) {
+			// Replace the expired blob, which the janitor hasn't deleted yet.
+			f.Close()
+			if err := w.b.remove(w.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if _, err := io.Copy(f, src); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if len(xa.MD5) == 0 {
+			return nil
+		}
+		// The MD5 hash of the whole content is unknown, so the ETag is
+		// derived from the modification time and size from now on.
+		xa.MD5 = nil
+		return setAttrs(w.path, xa)
+	}
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
 	// Note: we could use NewRangedReader here, but since we need to copy all of
@@ -554,6 +942,11 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 		ContentLanguage:    xa.ContentLanguage,
 		Metadata:           xa.Metadata,
 		BeforeWrite:        opts.BeforeCopy,
+		Conditions:         opts.Conditions,
+		StorageClass:       opts.StorageClass,
+	}
+	if xa.Expires != nil {
+		wopts.Expires = *xa.Expires
 	}
 	// Create a cancelable context so we can cancel the write if there are
 	// problems.
@@ -572,22 +965,202 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	return w.Close()
 }
 
+// Compose implements driver.Compose. The sources are concatenated into a
+// temporary file, like for a write.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	srcPaths := make([]string, len(srcKeys))
+	for i, key := range srcKeys {
+		path, _, _, err := b.forKey(key)
+		if err != nil {
+			return err
+		}
+		srcPaths[i] = path
+	}
+	// Create a cancelable context so we can cancel the write if there are
+	// problems.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewTypedWriter(writeCtx, dstKey, opts.ContentType, &driver.WriterOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		Metadata:           opts.Metadata,
+		BeforeWrite:        opts.BeforeCompose,
+		Conditions:         opts.Conditions,
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range srcPaths {
+		if err := appendFile(w, path); err != nil {
+			cancel() // cancel before Close cancels the write
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// appendFile copies the content of the file at path to w.
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.Version != "" {
+		return b.deleteVersion(key, opts)
+	}
 	path, err := b.path(key)
 	if err != nil {
 		return err
 	}
-	err = os.Remove(path)
+	if xa, err := getAttrs(path); err == nil && xa.expired(time.Now()) {
+		// The janitor may not have deleted it yet.
+		if err := b.remove(path); err != nil {
+			return err
+		}
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	if opts.Conditions != nil {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		if err := checkConditions(path, opts.Conditions); err != nil {
+			return err
+		}
+	}
+	return b.remove(path)
+}
+
+// DeleteMany implements driver.DeleteMany.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	return nil, errNotImplemented
+}
+
+// deleteVersion permanently removes the version opts.Version of key.
+func (b *bucket) deleteVersion(key string, opts *driver.DeleteOptions) error {
+	path, _, _, err := b.forVersion(key, opts.Version)
 	if err != nil {
 		return err
 	}
-	if err = os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+	if err := checkConditions(path, opts.Conditions); err != nil {
+		return err
+	}
+	if err := removeFile(path); err != nil {
 		return err
 	}
+	// This fails if the directory isn't empty, or if path was the current
+	// version, which is fine.
+	_ = os.Remove(filepath.Dir(path))
 	return nil
 }
 
+// removeFile removes the file at path and its attributes.
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// UpdateAttributes implements driver.UpdateAttributes. Only the attributes
+// file of the blob is rewritten, so its modification time and ETag are
+// unchanged.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	path, _, xa, err := b.forKey(key)
+	if err != nil {
+		return err
+	}
+	if err := checkConditions(path, opts.Conditions); err != nil {
+		return err
+	}
+	if opts.BeforeUpdate != nil {
+		if err := opts.BeforeUpdate(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
+	}
+	a := driver.Attributes{
+		CacheControl:       xa.CacheControl,
+		ContentDisposition: xa.ContentDisposition,
+		ContentEncoding:    xa.ContentEncoding,
+		ContentLanguage:    xa.ContentLanguage,
+		ContentType:        xa.ContentType,
+		Metadata:           xa.Metadata,
+	}
+	opts.Apply(&a)
+	xa.CacheControl = a.CacheControl
+	xa.ContentDisposition = a.ContentDisposition
+	xa.ContentEncoding = a.ContentEncoding
+	xa.ContentLanguage = a.ContentLanguage
+	xa.ContentType = a.ContentType
+	xa.Metadata = a.Metadata
+	return setAttrs(path, *xa)
+}
+
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	if !b.opts.Versioning {
+		return nil, errNotImplemented
+	}
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	versions := []*driver.Version{}
+	add := func(path, id string, info os.FileInfo, isLatest bool) error {
+		xa, err := getAttrs(path)
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			id = versionID(info, &xa)
+		}
+		versions = append(versions, &driver.Version{
+			ID:       id,
+			ModTime:  info.ModTime(),
+			Size:     info.Size(),
+			ETag:     etag(info, &xa),
+			IsLatest: isLatest,
+		})
+		return nil
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := add(path, "", info, true); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	dir := path + versionsExt
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// ReadDir returns entries sorted by name, and version IDs are zero-padded,
+	// so iterate backwards to get the newest version first.
+	for i := len(infos) - 1; i >= 0; i-- {
+		info := infos[i]
+		if info.IsDir() || strings.HasSuffix(info.Name(), attrsExt) {
+			continue
+		}
+		if err := add(filepath.Join(dir, info.Name()), info.Name(), info, false); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
 // SignedURL implements driver.SignedURL
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	if b.opts.URLSigner == nil {
@@ -600,6 +1173,155 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 	return surl.String(), nil
 }
 
+// NewMultipartUpload implements driver.NewMultipartUpload.
+// The parts of an upload are stored as files in the directory
+// "<path>.uploads/<upload ID>", and concatenated into the blob by Complete.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	id := uuid.New().String()
+	dir := filepath.Join(path+uploadsExt, id)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	return &multipartUpload{b: b, id: id, key: key, dir: dir, contentType: contentType, opts: opts}, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	// Don't allow uploadID to escape the uploads directory.
+	if filepath.Base(uploadID) != uploadID {
+		return nil, fmt.Errorf("invalid upload ID %q", uploadID)
+	}
+	dir := filepath.Join(path+uploadsExt, uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &multipartUpload{b: b, id: uploadID, key: key, dir: dir, contentType: contentType, opts: opts}, nil
+}
+
+type multipartUpload struct {
+	b           *bucket
+	id          string
+	key         string
+	dir         string
+	contentType string
+	opts        *driver.WriterOptions
+}
+
+func (u *multipartUpload) ID() string { return u.id }
+
+func (u *multipartUpload) partPath(partNumber int) string {
+	return filepath.Join(u.dir, fmt.Sprintf("%05d", partNumber))
+}
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	// Write to a temp file and rename it, so that a partially written part
+	// is never visible.
+	f, err := ioutil.TempFile(u.dir, "part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(p); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), u.partPath(partNumber))
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	infos, err := ioutil.ReadDir(u.dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []int
+	for _, info := range infos {
+		// Skip temp files of in-progress UploadPart calls.
+		n, err := strconv.Atoi(info.Name())
+		if err != nil {
+			continue
+		}
+		parts = append(parts, n)
+	}
+	// ReadDir returns entries sorted by name, and names are zero-padded.
+	return parts, nil
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	parts, err := u.ListParts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.New("complete multipart upload: no parts were uploaded")
+	}
+	// We'll write the blob using Writer, to avoid re-implementing making of a
+	// temp file, cleaning up after partial failures, etc.
+	// BeforeWrite was already called when the upload was created.
+	opts := *u.opts
+	opts.BeforeWrite = nil
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := u.b.NewTypedWriter(writeCtx, u.key, u.contentType, &opts)
+	if err != nil {
+		return err
+	}
+	for _, n := range parts {
+		if err := u.copyPart(w, n); err != nil {
+			cancel() // cancel before Close cancels the write
+			w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return u.removeAll()
+}
+
+func (u *multipartUpload) copyPart(w io.Writer, partNumber int) error {
+	f, err := os.Open(u.partPath(partNumber))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	if _, err := os.Stat(u.dir); err != nil {
+		return err
+	}
+	return u.removeAll()
+}
+
+// removeAll removes the parts of the upload, and the uploads directory for
+// the key if there are no other uploads in progress.
+func (u *multipartUpload) removeAll() error {
+	if err := os.RemoveAll(u.dir); err != nil {
+		return err
+	}
+	// This fails if the directory isn't empty, which is fine.
+	_ = os.Remove(filepath.Dir(u.dir))
+	return nil
+}
+
 // URLSigner defines an interface for creating and verifying a signed URL for
 // objects in a fileblob bucket. Signed URLs are typically used for granting
 // access to an otherwise-protected resource without requiring further
@@ -618,8 +1340,14 @@ type URLSigner interface {
 	KeyFromURL(ctx context.Context, surl *url.URL) (string, error)
 }
 
-// URLSignerHMAC signs URLs by adding the object key, expiration time, and a
-// hash-based message authentication code (HMAC) into the query parameters.
+// URLSignerHMAC signs URLs by adding the object key, expiration time, HTTP
+// method, optional content type, and a hash-based message authentication code
+// (HMAC) into the query parameters. The HMAC covers all of the other
+// parameters, so a server verifying the URL with KeyFromURL can trust the
+// "method" and "contentType" query parameters, and should reject requests
+// that don't match them; blobhttp.NewHandler does so. URLs for GET have no
+// "method" parameter, and are signed the same way as before methods were
+// supported, so that they keep working.
 // Values of URLSignerHMAC with the same secret key will accept URLs produced by
 // others as valid.
 type URLSignerHMAC struct {
@@ -642,7 +1370,7 @@ func NewURLSignerHMAC(baseURL *url.URL, secretKey []byte) *URLSignerHMAC {
 }
 
 // URLFromKey creates a signed URL by copying the baseURL and appending the
-// object key, expiry, and signature as a query params.
+// object key, expiry, method, content type, and signature as a query params.
 func (h *URLSignerHMAC) URLFromKey(ctx context.Context, key string, opts *driver.SignedURLOptions) (*url.URL, error) {
 	sURL := new(url.URL)
 	*sURL = *h.baseURL
@@ -650,6 +1378,12 @@ func (h *URLSignerHMAC) URLFromKey(ctx context.Context, key string, opts *driver
 	q := sURL.Query()
 	q.Set("obj", key)
 	q.Set("expiry", strconv.FormatInt(time.Now().Add(opts.Expiry).Unix(), 10))
+	if opts.Method != http.MethodGet {
+		q.Set("method", opts.Method)
+		if opts.ContentType != "" {
+			q.Set("contentType", opts.ContentType)
+		}
+	}
 	q.Set("signature", h.getMAC(q))
 	sURL.RawQuery = q.Encode()
 
@@ -660,6 +1394,14 @@ func (h *URLSignerHMAC) getMAC(q url.Values) string {
 	signedVals := url.Values{}
 	signedVals.Set("obj", q.Get("obj"))
 	signedVals.Set("expiry", q.Get("expiry"))
+	// A URL without a method is for GET; its MAC doesn't cover the method or
+	// content type, like the URLs signed before they were supported.
+	if method := q.Get("method"); method != "" && method != http.MethodGet {
+		signedVals.Set("method", method)
+		if contentType := q.Get("contentType"); contentType != "" {
+			signedVals.Set("contentType", contentType)
+		}
+	}
 	msg := signedVals.Encode()
 
 	hsh := hmac.New(sha256.New, h.secretKey)
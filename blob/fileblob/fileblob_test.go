@@ -15,7 +15,12 @@
 package fileblob
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -25,12 +30,15 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/eliben/gocdkx/blob"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/gcerrors"
 )
 
 type harness struct {
@@ -67,19 +75,61 @@ func (h *harness) serveSignedURL(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
+	// The method and content type are covered by the signature. URLs for GET
+	// have no method.
+	q := r.URL.Query()
+	method := q.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	contentType := q.Get("contentType")
+	if contentType != "" && r.Header.Get("Content-Type") != contentType {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
 	bucket, err := OpenBucket(h.dir, &Options{})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	reader, err := bucket.NewReader(r.Context(), objKey, nil)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
+	defer bucket.Close()
+	switch r.Method {
+	case http.MethodGet:
+		reader, err := bucket.NewReader(r.Context(), objKey, nil)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer reader.Close()
+		io.Copy(w, reader)
+	case http.MethodPut:
+		writer, err := bucket.NewWriter(r.Context(), objKey, &blob.WriterOptions{ContentType: r.Header.Get("Content-Type")})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, err := io.Copy(writer, r.Body); err != nil {
+			writer.Close()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := writer.Close(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		if err := bucket.Delete(r.Context(), objKey); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
-	defer reader.Close()
-	io.Copy(w, reader)
 }
 
 func (h *harness) HTTPClient() *http.Client {
@@ -88,7 +138,8 @@ func (h *harness) HTTPClient() *http.Client {
 
 func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
 	opts := &Options{
-		URLSigner: h.urlSigner,
+		URLSigner:  h.urlSigner,
+		Versioning: true,
 	}
 	return openBucket(h.dir, opts)
 }
@@ -219,3 +270,312 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestURLSignerHMAC(t *testing.T) {
+	ctx := context.Background()
+	base, err := url.Parse("http://localhost:8080/signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewURLSignerHMAC(base, []byte("secret"))
+	surl, err := signer.URLFromKey(ctx, "mykey", &driver.SignedURLOptions{
+		Expiry:      time.Minute,
+		Method:      http.MethodPut,
+		ContentType: "text/plain",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := signer.KeyFromURL(ctx, surl); err != nil || key != "mykey" {
+		t.Fatalf("got key %q, err %v want %q, nil", key, err, "mykey")
+	}
+
+	// Changing any of the signed parameters invalidates the URL.
+	for _, test := range []struct {
+		param, value string
+	}{
+		{"obj", "otherkey"},
+		{"method", http.MethodGet},
+		{"contentType", "text/html"},
+		{"contentType", ""},
+	} {
+		u := *surl
+		q := u.Query()
+		if test.value == "" {
+			q.Del(test.param)
+		} else {
+			q.Set(test.param, test.value)
+		}
+		u.RawQuery = q.Encode()
+		if _, err := signer.KeyFromURL(ctx, &u); err == nil {
+			t.Errorf("%s=%q: got nil error want error", test.param, test.value)
+		}
+	}
+}
+
+// TestURLSignerHMACLegacyGet checks that GET URLs signed before methods
+// were supported still verify, and that they can't be used for other
+// methods.
+func TestURLSignerHMACLegacyGet(t *testing.T) {
+	ctx := context.Background()
+	base, err := url.Parse("http://localhost:8080/signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewURLSignerHMAC(base, []byte("secret"))
+
+	// Sign a URL the way URLFromKey used to, with only the key and expiry.
+	q := base.Query()
+	q.Set("obj", "mykey")
+	q.Set("expiry", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	signed := url.Values{}
+	signed.Set("obj", q.Get("obj"))
+	signed.Set("expiry", q.Get("expiry"))
+	hsh := hmac.New(sha256.New, []byte("secret"))
+	hsh.Write([]byte(signed.Encode()))
+	q.Set("signature", base64.RawURLEncoding.EncodeToString(hsh.Sum(nil)))
+	old := *base
+	old.RawQuery = q.Encode()
+	if key, err := signer.KeyFromURL(ctx, &old); err != nil || key != "mykey" {
+		t.Fatalf("got key %q, err %v want %q, nil", key, err, "mykey")
+	}
+
+	// URLFromKey still signs GET URLs that way.
+	surl, err := signer.URLFromKey(ctx, "mykey", &driver.SignedURLOptions{Expiry: time.Minute, Method: http.MethodGet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if surl.Query().Get("method") != "" {
+		t.Errorf("got method %q in GET URL, want none", surl.Query().Get("method"))
+	}
+
+	// Adding a method invalidates the URL.
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		u := old
+		q := u.Query()
+		q.Set("method", method)
+		u.RawQuery = q.Encode()
+		if _, err := signer.KeyFromURL(ctx, &u); err == nil {
+			t.Errorf("method=%s: got nil error want error", method)
+		}
+	}
+}
+
+// TestListAttributes checks that List reads the attributes stored alongside
+// blobs, so it reports the same MD5 and ETag as Attributes.
+func TestListAttributes(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.WriteAll(ctx, "dir/key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "dir/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := b.List(&blob.ListOptions{Prefix: "dir/"}).Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := md5.Sum([]byte("hello")); !bytes.Equal(obj.MD5, want[:]) {
+		t.Errorf("got MD5 %x from List, want %x", obj.MD5, want)
+	}
+	if obj.ETag != attrs.ETag {
+		t.Errorf("got ETag %q from List, want %q", obj.ETag, attrs.ETag)
+	}
+}
+
+func TestWatchExternalChanges(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	w, err := b.Watch(ctx, &blob.WatchOptions{Prefix: "sub/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	next := func(wantType blob.EventType, wantKey string) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		ev, err := w.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != wantType || ev.Key != wantKey {
+			t.Errorf("got %v event for %q want %v event for %q", ev.Type, ev.Key, wantType, wantKey)
+		}
+	}
+
+	// Files written by other processes, in a directory created after Watch,
+	// are reported; temporary files and files outside of the prefix are not.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "dir", "fileblob123"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "dir", "key"), []byte("hello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	next(blob.EventCreated, "sub/dir/key")
+
+	// Removing a directory reports the files in it as deleted.
+	if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	next(blob.EventDeleted, "sub/dir/key")
+}
+
+func TestExpires(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, &Options{JanitorInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	const key = "dir/key"
+	expires := time.Now().Add(100 * time.Millisecond)
+	if err := b.WriteAll(ctx, key, []byte("hello"), &blob.WriterOptions{Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "other", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "hello" {
+		t.Fatalf("got %q, %v want %q before the blob expires", got, err, "hello")
+	}
+	time.Sleep(time.Until(expires))
+
+	// Expired blobs are not found, even before the janitor deletes them.
+	if _, err := b.ReadAll(ctx, key); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v reading an expired blob, want NotFound", err)
+	}
+	if ok, err := b.Exists(ctx, key); err != nil || ok {
+		t.Errorf("got %v, %v from Exists for an expired blob, want false, nil", ok, err)
+	}
+
+	// The janitor removes the file and its attributes.
+	path := filepath.Join(dir, "dir", "key")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, err := os.Stat(path)
+		_, attrsErr := os.Stat(path + attrsExt)
+		if os.IsNotExist(err) && os.IsNotExist(attrsErr) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired blob was not deleted from disk")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ok, err := b.Exists(ctx, "other"); err != nil || !ok {
+		t.Errorf("got %v, %v from Exists for a blob that doesn't expire, want true, nil", ok, err)
+	}
+}
+
+func TestAppendAfterWrite(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.MD5 == nil {
+		t.Fatal("got no MD5 for a written blob")
+	}
+	if err := b.WriteAll(ctx, "key", []byte(" world"), &blob.WriterOptions{Append: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello world" {
+		t.Errorf("got %q, %v want %q", got, err, "hello world")
+	}
+	after, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ContentType != before.ContentType {
+		t.Errorf("got content type %q want %q", after.ContentType, before.ContentType)
+	}
+	// The MD5 of the whole content is unknown after an append.
+	if after.MD5 != nil {
+		t.Errorf("got MD5 %x after appending, want none", after.MD5)
+	}
+	if after.ETag == before.ETag {
+		t.Errorf("got unchanged ETag %q after appending", after.ETag)
+	}
+}
+
+// TestAppendCreate checks that a blob created by an append doesn't report an
+// MD5 hash, since another append may extend it before its attributes are
+// written.
+func TestAppendCreate(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{Append: true, ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.MD5 != nil {
+		t.Errorf("got MD5 %x for a blob created by an append, want none", attrs.MD5)
+	}
+	if attrs.ContentType != "text/plain" {
+		t.Errorf("got content type %q want %q", attrs.ContentType, "text/plain")
+	}
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello" {
+		t.Errorf("got %q, %v want %q", got, err, "hello")
+	}
+}
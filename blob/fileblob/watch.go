@@ -0,0 +1,236 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/eliben/gocdkx/blob/driver"
+)
+
+// settleTime is how long Watcher.Next keeps collecting filesystem events
+// after the first one, so that the events for a single change, like the
+// renames that complete a versioned write, are reported together.
+const settleTime = 20 * time.Millisecond
+
+var errWatcherClosed = errors.New("watcher closed")
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		b:        b,
+		prefix:   opts.Prefix,
+		notifier: notifier,
+		known:    map[string]fileState{},
+	}
+	if err := w.addDir(b.dir, nil); err != nil {
+		notifier.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// fileState describes a file when it was last reported.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// watcher implements driver.Watcher using fsnotify. Since fsnotify doesn't
+// watch directories recursively, each subdirectory is watched separately.
+type watcher struct {
+	b        *bucket
+	prefix   string
+	notifier *fsnotify.Watcher
+	// known holds the files that exist, by path.
+	known map[string]fileState
+}
+
+// key returns the blob key for path, which is in the bucket's directory.
+func (w *watcher) key(path string) string {
+	return unescapeKey(path[len(w.b.dir)+1:])
+}
+
+// ignored reports whether path holds something other than a blob: attributes,
+// noncurrent versions, parts of multipart uploads, or temporary files
+// created by writers, which are named "fileblob" followed by digits.
+func ignored(path string) bool {
+	if strings.HasSuffix(path, attrsExt) || strings.HasSuffix(path, uploadsExt) || strings.HasSuffix(path, versionsExt) {
+		return true
+	}
+	base := filepath.Base(path)
+	if !strings.HasPrefix(base, "fileblob") || len(base) == len("fileblob") {
+		return false
+	}
+	return strings.Trim(base[len("fileblob"):], "0123456789") == ""
+}
+
+// addDir watches dir and its subdirectories that may hold blobs matching the
+// prefix, and records the files in them. Files that are new or changed are
+// appended to evs, if it is not nil, since they may have been written before
+// the directory was watched.
+func (w *watcher) addDir(dir string, evs *[]*driver.Event) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// The file or directory was removed concurrently; skip it.
+			return nil
+		}
+		if path != w.b.dir && ignored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if path != w.b.dir {
+				key := w.key(path) + "/"
+				if !strings.HasPrefix(key, w.prefix) && !strings.HasPrefix(w.prefix, key) {
+					return filepath.SkipDir
+				}
+			}
+			return w.notifier.Add(path)
+		}
+		if ev := w.update(path, info); ev != nil && evs != nil {
+			*evs = append(*evs, ev)
+		}
+		return nil
+	})
+}
+
+// update records the file at path, and returns an EventCreated if it is new
+// or changed, and matches the prefix.
+func (w *watcher) update(path string, info os.FileInfo) *driver.Event {
+	key := w.key(path)
+	if !strings.HasPrefix(key, w.prefix) {
+		return nil
+	}
+	var xa xattrs
+	if a, err := getAttrs(path); err == nil {
+		xa = a
+	}
+	st := fileState{modTime: info.ModTime(), size: info.Size(), etag: etag(info, &xa)}
+	if prev, ok := w.known[path]; ok && prev.modTime.Equal(st.modTime) && prev.size == st.size && prev.etag == st.etag {
+		return nil
+	}
+	w.known[path] = st
+	return &driver.Event{
+		Type:    driver.EventCreated,
+		Key:     key,
+		ModTime: st.modTime,
+		Size:    st.size,
+		ETag:    st.etag,
+	}
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	for {
+		paths, err := w.collect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var evs []*driver.Event
+		for _, path := range paths {
+			evs = append(evs, w.check(path)...)
+		}
+		if len(evs) > 0 {
+			return evs, nil
+		}
+	}
+}
+
+// collect blocks until there are filesystem events, and returns the paths
+// they are about, in the order in which they were first reported.
+func (w *watcher) collect(ctx context.Context) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	var settle <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.notifier.Events:
+			if !ok {
+				return nil, errWatcherClosed
+			}
+			if ignored(ev.Name) || seen[ev.Name] {
+				continue
+			}
+			seen[ev.Name] = true
+			paths = append(paths, ev.Name)
+			if settle == nil {
+				settle = time.After(settleTime)
+			}
+		case err, ok := <-w.notifier.Errors:
+			if !ok {
+				return nil, errWatcherClosed
+			}
+			return nil, err
+		case <-settle:
+			return paths, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// check returns the events for the current state of path, compared to the
+// one previously recorded.
+func (w *watcher) check(path string) []*driver.Event {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.IsDir() {
+			var evs []*driver.Event
+			_ = w.addDir(path, &evs)
+			return evs
+		}
+		if ev := w.update(path, info); ev != nil {
+			return []*driver.Event{ev}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return nil
+	}
+	// path was removed, and may have been a directory.
+	var evs []*driver.Event
+	for p := range w.known {
+		if p == path || strings.HasPrefix(p, path+string(os.PathSeparator)) {
+			delete(w.known, p)
+			evs = append(evs, &driver.Event{Type: driver.EventDeleted, Key: w.key(p)})
+		}
+	}
+	return evs
+}
+
+// As implements driver.Watcher.As.
+func (w *watcher) As(i interface{}) bool { return false }
+
+// Close implements driver.Watcher.Close.
+func (w *watcher) Close() error {
+	return w.notifier.Close()
+}
@@ -43,24 +43,115 @@
 //  - Reader: *storage.Reader
 //  - ReaderOptions.BeforeRead: **storage.ObjectHandle, *storage.Reader
 //  - Attributes: storage.ObjectAttrs
+//  - Version: storage.ObjectAttrs
 //  - CopyOptions.BeforeCopy: *CopyObjectHandles, *storage.Copier
-//  - WriterOptions.BeforeWrite: **storage.ObjectHandle, *storage.Writer
+//  - WriterOptions.BeforeWrite: **storage.ObjectHandle, *storage.Writer; for
+//      multipart uploads, *storage.ObjectAttrs holding the attributes of
+//      the composed object; for appends, *storage.Writer writing the
+//      appended content to a temporary object
+//  - ComposeOptions.BeforeCompose: **storage.ObjectAttrs holding the
+//      attributes of the composed object
+//  - UpdateAttributesOptions.BeforeUpdate: **storage.ObjectAttrsToUpdate, or
+//      **storage.Copier if metadata keys are removed
+//  - Watcher, Event: the types exposed by Options.NotificationSource; see
+//      package blobpubsub.
+//
+// Multipart uploads
+//
+// GCS has no native multipart upload API. gcsblob stores the parts of an
+// upload as temporary objects with the prefix ".gocdk-uploads/", and
+// composes them into the final object when the upload is completed. The
+// temporary objects are not listed, unless the prefix of the listing starts
+// with ".gocdk-uploads/".
+//
+// Compose
+//
+// GCS composes at most 32 objects at a time. gcsblob composes more sources
+// in rounds, through temporary objects with the prefix ".gocdk-uploads/",
+// which are deleted afterwards.
+//
+// Appending
+//
+// GCS objects are immutable, so appends write the content to a temporary
+// object with the prefix ".gocdk-uploads/", and compose the object with it,
+// on the condition that the object wasn't changed in the meantime. Composed
+// objects have no MD5 hash, and an object can be composed from at most 1024
+// components, so at most 1023 appends can be made to an object.
+//
+// Updating attributes
+//
+// UpdateAttributes patches the attributes of objects, which keeps their
+// generation, and so their ETag. Removing metadata keys rewrites the object
+// onto itself instead, which creates a new generation; GCS does it without
+// copying the content, but like Copy, it resets the object's ACL to the
+// bucket's default.
+//
+// Preconditions
+//
+// The ETag reported in blob.Attributes and blob.ListObject, and expected by
+// blob.Conditions.IfMatch, is the generation number of the object, in decimal;
+// IfMatch and IfNotExist are mapped to GCS generation preconditions. GCS has
+// no native If-Modified-Since precondition, so gcsblob checks the
+// modification time returned with the object's content.
+//
+// Versions
+//
+// The ID of a blob.Version is the generation number of the object, in
+// decimal. Versions are only kept if Object Versioning is enabled for the
+// bucket.
+//
+// Expiration
+//
+// GCS has no per-object expiration time, so blob.WriterOptions.Expires is
+// stored in the object's metadata under the key "gocdk-expires", and
+// returned in blob.Attributes.Expires rather than in Metadata. GCS doesn't
+// delete objects when they expire; use an Object Lifecycle Management rule,
+// for example with an age condition, to delete them. Expired objects can be
+// read until they are deleted.
+//
+// Storage classes
+//
+// blob.StorageClass maps to GCS storage classes as follows:
+//  - StorageClassStandard: STANDARD; MULTI_REGIONAL and REGIONAL are also
+//    reported as StorageClassStandard
+//  - StorageClassInfrequentAccess: NEARLINE
+//  - StorageClassCold: COLDLINE
+//  - StorageClassArchive: ARCHIVE
+// StorageClassDefault uses the bucket's default storage class. Objects in all
+// classes can be read right away.
+//
+// Watch
+//
+// gcsblob reports changes using Cloud Pub/Sub notifications for Cloud
+// Storage, received from Options.NotificationSource; Watch returns an
+// Unimplemented error if it is nil. Each notification is delivered to a single
+// Watcher, so each Watcher needs a subscription of its own. Only objects
+// that are created, overwritten, deleted or archived are reported; events
+// carry the size and modification time of created objects if the
+// notification configuration uses the JSON_API_V1 payload format.
 package gcsblob // import "github.com/eliben/gocdkx/blob/gcsblob"
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
 	"github.com/google/wire"
 	"github.com/eliben/gocdkx/blob"
 	"github.com/eliben/gocdkx/blob/driver"
@@ -181,6 +272,11 @@ type Options struct {
 	// Exactly one of PrivateKey or SignBytes must be non-nil to use SignedURL.
 	// See https://godoc.org/cloud.google.com/go/storage#SignedURLOptions.
 	SignBytes func([]byte) ([]byte, error)
+
+	// NotificationSource receives the Cloud Pub/Sub notifications for the
+	// bucket; see blobpubsub.NewNotificationSource. Required to use Watch.
+	// See https://cloud.google.com/storage/docs/pubsub-notifications.
+	NotificationSource driver.NotificationSource
 }
 
 // openBucket returns a GCS Bucket that communicates using the given HTTP client.
@@ -192,14 +288,15 @@ func openBucket(ctx context.Context, client *gcp.HTTPClient, bucketName string,
 		return nil, errors.New("gcsblob.OpenBucket: bucketName is required")
 	}
 	// We wrap the provided http.Client to add a Go CDK User-Agent.
-	c, err := storage.NewClient(ctx, option.WithHTTPClient(useragent.HTTPClient(&client.Client, "blob")))
+	hc := useragent.HTTPClient(&client.Client, "blob")
+	c, err := storage.NewClient(ctx, option.WithHTTPClient(hc))
 	if err != nil {
 		return nil, err
 	}
 	if opts == nil {
 		opts = &Options{}
 	}
-	return &bucket{name: bucketName, client: c, opts: opts}, nil
+	return &bucket{name: bucketName, client: c, httpClient: hc, opts: opts}, nil
 }
 
 // OpenBucket returns a *blob.Bucket backed by an existing GCS bucket. See the
@@ -217,7 +314,9 @@ func OpenBucket(ctx context.Context, client *gcp.HTTPClient, bucketName string,
 type bucket struct {
 	name   string
 	client *storage.Client
-	opts   *Options
+	// httpClient is used for requests that client doesn't support.
+	httpClient *http.Client
+	opts       *Options
 }
 
 var emptyBody = ioutil.NopCloser(strings.NewReader(""))
@@ -251,10 +350,17 @@ func (r *reader) As(i interface{}) bool {
 	return true
 }
 
+// errNotImplemented is returned for features that gcsblob doesn't support
+// with the given Options.
+var errNotImplemented = errors.New("not implemented")
+
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 	if err == storage.ErrObjectNotExist {
 		return gcerrors.NotFound
 	}
+	if err == errNotImplemented {
+		return gcerrors.Unimplemented
+	}
 	if gerr, ok := err.(*googleapi.Error); ok {
 		switch gerr.Code {
 		case http.StatusNotFound:
@@ -301,6 +407,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	if err != nil {
 		return nil, err
 	}
+	objects = hideUploads(query.Prefix, objects)
 	page := driver.ListPage{NextPageToken: []byte(nextPageToken)}
 	if len(objects) > 0 {
 		page.Objects = make([]*driver.ListObject, len(objects))
@@ -320,6 +427,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 					ModTime: obj.Updated,
 					Size:    obj.Size,
 					MD5:     obj.MD5,
+					ETag:    strconv.FormatInt(obj.Generation, 10),
 					AsFunc:  asFunc,
 				}
 			} else {
@@ -370,16 +478,20 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	if err != nil {
 		return nil, err
 	}
+	md, expires := splitExpires(attrs.Metadata)
 	return &driver.Attributes{
 		CacheControl:       attrs.CacheControl,
 		ContentDisposition: attrs.ContentDisposition,
 		ContentEncoding:    attrs.ContentEncoding,
 		ContentLanguage:    attrs.ContentLanguage,
 		ContentType:        attrs.ContentType,
-		Metadata:           attrs.Metadata,
+		Metadata:           md,
 		ModTime:            attrs.Updated,
 		Size:               attrs.Size,
 		MD5:                attrs.MD5,
+		ETag:               strconv.FormatInt(attrs.Generation, 10),
+		Expires:            expires,
+		StorageClass:       fromStorageClass(attrs.StorageClass),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*storage.ObjectAttrs)
 			if !ok {
@@ -391,11 +503,83 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	}, nil
 }
 
+// storageClasses maps portable storage classes to GCS storage classes. The
+// default class maps to "", which uses the bucket's default class.
+var storageClasses = map[driver.StorageClass]string{
+	driver.StorageClassStandard:         "STANDARD",
+	driver.StorageClassInfrequentAccess: "NEARLINE",
+	driver.StorageClassCold:             "COLDLINE",
+	driver.StorageClassArchive:          "ARCHIVE",
+}
+
+// fromStorageClass returns the portable storage class for the GCS storage
+// class sc.
+func fromStorageClass(sc string) driver.StorageClass {
+	switch sc {
+	case "STANDARD", "MULTI_REGIONAL", "REGIONAL":
+		return driver.StorageClassStandard
+	case "NEARLINE":
+		return driver.StorageClassInfrequentAccess
+	case "COLDLINE":
+		return driver.StorageClassCold
+	case "ARCHIVE":
+		return driver.StorageClassArchive
+	default:
+		return driver.StorageClassDefault
+	}
+}
+
+// expiresKey is the metadata key holding the expiration time of an object,
+// in RFC 3339 format.
+const expiresKey = "gocdk-expires"
+
+// withExpires returns md, with the expiration time exp added if it is not
+// zero.
+func withExpires(md map[string]string, exp time.Time) map[string]string {
+	if exp.IsZero() {
+		return md
+	}
+	withExp := make(map[string]string, len(md)+1)
+	for k, v := range md {
+		withExp[k] = v
+	}
+	withExp[expiresKey] = exp.UTC().Format(time.RFC3339Nano)
+	return withExp
+}
+
+// splitExpires returns md without the expiration time, and the expiration
+// time, or zero if there is none.
+func splitExpires(md map[string]string) (map[string]string, time.Time) {
+	v, ok := md[expiresKey]
+	if !ok {
+		return md, time.Time{}
+	}
+	withoutExp := make(map[string]string, len(md)-1)
+	for k, v := range md {
+		if k != expiresKey {
+			withoutExp[k] = v
+		}
+	}
+	exp, _ := time.Parse(time.RFC3339Nano, v)
+	return withoutExp, exp
+}
+
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
 	key = escapeKey(key)
 	bkt := b.client.Bucket(b.name)
-	obj := bkt.Object(key)
+	obj, err := withVersion(bkt.Object(key), opts.Version)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = withConditions(obj, opts.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Raw {
+		// Disable decompressive transcoding of gzip content.
+		obj = obj.ReadCompressed(true)
+	}
 
 	// Add an extra level of indirection so that BeforeRead can replace obj
 	// if needed. For example, ObjectHandle.If returns a new ObjectHandle.
@@ -435,6 +619,10 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 		return nil, rerr
 	}
 	modTime, _ := r.LastModified()
+	if c := opts.Conditions; c != nil && !c.IfModifiedSince.IsZero() && !modTime.After(c.IfModifiedSince) {
+		r.Close()
+		return nil, errPreconditionFailed
+	}
 	return &reader{
 		body: r,
 		attrs: driver.ReaderAttributes{
@@ -469,8 +657,14 @@ func unescapeKey(key string) string {
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
 	key = escapeKey(key)
+	if opts.Append {
+		return b.newAppendWriter(ctx, key, contentType, opts)
+	}
 	bkt := b.client.Bucket(b.name)
-	obj := bkt.Object(key)
+	obj, err := withConditions(bkt.Object(key), opts.Conditions)
+	if err != nil {
+		return nil, err
+	}
 
 	// Add an extra level of indirection so that BeforeWrite can replace obj
 	// if needed. For example, ObjectHandle.If returns a new ObjectHandle.
@@ -484,7 +678,8 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		w.ContentLanguage = opts.ContentLanguage
 		w.ContentType = contentType
 		w.ChunkSize = bufferSize(opts.BufferSize)
-		w.Metadata = opts.Metadata
+		w.Metadata = withExpires(opts.Metadata, opts.Expires)
+		w.StorageClass = storageClasses[opts.StorageClass]
 		w.MD5 = opts.ContentMD5
 		return w
 	}
@@ -515,6 +710,111 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	return w, nil
 }
 
+// appendWriter appends to an object by writing the content to a temporary
+// object, and composing the object with it when closed.
+type appendWriter struct {
+	ctx   context.Context
+	b     *bucket
+	key   string // escaped
+	tmp   *storage.ObjectHandle
+	w     *storage.Writer      // writes tmp
+	attrs *storage.ObjectAttrs // attributes of the object if it's created
+	conds *driver.Conditions   // or nil
+}
+
+func (b *bucket) newAppendWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	tmp := b.client.Bucket(b.name).Object(uploadsPrefix + "append-" + uuid.New().String())
+	w := tmp.NewWriter(ctx)
+	w.ChunkSize = bufferSize(opts.BufferSize)
+	w.MD5 = opts.ContentMD5
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**storage.Writer)
+			if !ok {
+				return false
+			}
+			*p = w
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	attrs := &storage.ObjectAttrs{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        contentType,
+		Metadata:           withExpires(opts.Metadata, opts.Expires),
+		StorageClass:       storageClasses[opts.StorageClass],
+	}
+	return &appendWriter{ctx: ctx, b: b, key: key, tmp: tmp, w: w, attrs: attrs, conds: opts.Conditions}, nil
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Close finishes writing the temporary object and composes the object with
+// it. The compose is conditional on the generation of the object that was
+// read, so it is retried if the object was changed concurrently, unless the
+// caller set preconditions.
+func (w *appendWriter) Close() error {
+	if err := w.w.Close(); err != nil {
+		return err
+	}
+	defer func() { _ = w.tmp.Delete(w.ctx) }()
+	for {
+		err := w.compose()
+		if err == nil {
+			return nil
+		}
+		if gerr, ok := err.(*googleapi.Error); !ok || gerr.Code != http.StatusPreconditionFailed || w.conds != nil {
+			return err
+		}
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// compose appends tmp to the object, or creates the object from it.
+func (w *appendWriter) compose() error {
+	obj := w.b.client.Bucket(w.b.name).Object(w.key)
+	attrs, err := obj.Attrs(w.ctx)
+	if err == storage.ErrObjectNotExist {
+		if w.conds != nil && w.conds.IfMatch != "" {
+			return errPreconditionFailed
+		}
+		c := obj.If(storage.Conditions{DoesNotExist: true}).ComposerFrom(w.tmp)
+		c.ObjectAttrs = *w.attrs
+		_, err = c.Run(w.ctx)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if w.conds != nil && (w.conds.IfNotExist || w.conds.IfMatch != "" && w.conds.IfMatch != strconv.FormatInt(attrs.Generation, 10)) {
+		return errPreconditionFailed
+	}
+	dst := obj.If(storage.Conditions{GenerationMatch: attrs.Generation})
+	c := dst.ComposerFrom(obj.Generation(attrs.Generation), w.tmp)
+	// Compose replaces the attributes of the destination, so keep those of
+	// the object.
+	c.ObjectAttrs = storage.ObjectAttrs{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		StorageClass:       attrs.StorageClass,
+	}
+	_, err = c.Run(w.ctx)
+	return err
+}
+
 // CopyObjectHandles holds the ObjectHandles for the destination and source
 // of a Copy. It is used by the BeforeCopy As hook.
 type CopyObjectHandles struct {
@@ -530,12 +830,18 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	// Add an extra level of indirection so that BeforeCopy can replace the
 	// dst or src ObjectHandles if needed.
 	// Also, make the Copier lazily in case this replacement happens.
+	dst, err := withConditions(bkt.Object(dstKey), opts.Conditions)
+	if err != nil {
+		return err
+	}
 	handles := CopyObjectHandles{
-		Dst: bkt.Object(dstKey),
+		Dst: dst,
 		Src: bkt.Object(srcKey),
 	}
 	makeCopier := func() *storage.Copier {
-		return handles.Dst.CopierFrom(handles.Src)
+		c := handles.Dst.CopierFrom(handles.Src)
+		c.StorageClass = storageClasses[opts.StorageClass]
+		return c
 	}
 
 	var copier *storage.Copier
@@ -561,18 +867,202 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	if copier == nil {
 		copier = makeCopier()
 	}
-	_, err := copier.Run(ctx)
+	_, err = copier.Run(ctx)
 	return err
 }
 
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	key = escapeKey(key)
 	bkt := b.client.Bucket(b.name)
-	obj := bkt.Object(key)
+	obj, err := withVersion(bkt.Object(key), opts.Version)
+	if err != nil {
+		return err
+	}
+	obj, err = withConditions(obj, opts.Conditions)
+	if err != nil {
+		return err
+	}
 	return obj.Delete(ctx)
 }
 
+// batchURL is the endpoint for batches of GCS JSON API requests.
+const batchURL = "https://storage.googleapis.com/batch/storage/v1"
+
+// maxBatchSize is the maximum number of requests in a GCS batch.
+const maxBatchSize = 100
+
+// DeleteMany implements driver.DeleteMany. The storage client library
+// doesn't support batches, so it sends them to the JSON API directly; see
+// https://cloud.google.com/storage/docs/json_api/v1/how-tos/batch.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	errs := make([]error, len(keys))
+	for start := 0; start < len(keys); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := b.deleteBatch(ctx, keys[start:end], errs[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return errs, nil
+}
+
+// deleteBatch deletes keys using a single batch request, and stores the
+// error for deleting each key in the corresponding element of errs.
+func (b *bucket) deleteBatch(ctx context.Context, keys []string, errs []error) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, key := range keys {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-ID", fmt.Sprintf("<%d>", i))
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("/storage/v1/b/%s/o/%s", url.PathEscape(b.name), url.PathEscape(escapeKey(key)))
+		if _, err := fmt.Fprintf(pw, "DELETE %s HTTP/1.1\r\n\r\n", path); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", batchURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	resp, err := b.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	seen := make([]bool, len(keys))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// The response to the request with Content-ID "<i>" has Content-ID
+		// "<response-i>".
+		id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-ID"), "<response-"), ">")
+		i, err := strconv.Atoi(id)
+		if err != nil || i < 0 || i >= len(keys) {
+			return fmt.Errorf("unexpected Content-ID %q in batch response", part.Header.Get("Content-ID"))
+		}
+		presp, err := http.ReadResponse(bufio.NewReader(part), req)
+		if err != nil {
+			return err
+		}
+		errs[i] = googleapi.CheckResponse(presp)
+		presp.Body.Close()
+		seen[i] = true
+	}
+	for i := range keys {
+		if !seen[i] {
+			errs[i] = fmt.Errorf("no response for %q in batch response", keys[i])
+		}
+	}
+	return nil
+}
+
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	key = escapeKey(key)
+	iter := b.client.Bucket(b.name).Objects(ctx, &storage.Query{Prefix: key, Versions: true})
+	var objs []*storage.ObjectAttrs
+	for {
+		obj, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if obj.Name == key {
+			objs = append(objs, obj)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Generation > objs[j].Generation })
+	versions := make([]*driver.Version, len(objs))
+	for i, obj := range objs {
+		obj := obj
+		versions[i] = &driver.Version{
+			ID:      strconv.FormatInt(obj.Generation, 10),
+			ModTime: obj.Updated,
+			Size:    obj.Size,
+			ETag:    strconv.FormatInt(obj.Generation, 10),
+			// Noncurrent versions have a deletion time.
+			IsLatest: obj.Deleted.IsZero(),
+			AsFunc: func(i interface{}) bool {
+				p, ok := i.(*storage.ObjectAttrs)
+				if !ok {
+					return false
+				}
+				*p = *obj
+				return true
+			},
+		}
+	}
+	return versions, nil
+}
+
+// withVersion returns obj for the generation identified by version, or obj
+// itself if version is empty.
+func withVersion(obj *storage.ObjectHandle, version string) (*storage.ObjectHandle, error) {
+	if version == "" {
+		return obj, nil
+	}
+	gen, err := strconv.ParseInt(version, 10, 64)
+	if err != nil || gen <= 0 {
+		// Not a generation number, so there's no such version.
+		return nil, storage.ErrObjectNotExist
+	}
+	return obj.Generation(gen), nil
+}
+
+// errPreconditionFailed is returned when a precondition is checked by
+// gcsblob rather than by GCS.
+var errPreconditionFailed = &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "precondition failed"}
+
+// withConditions returns obj with the GCS preconditions corresponding to
+// conds, which may be nil. IfModifiedSince is not handled.
+func withConditions(obj *storage.ObjectHandle, conds *driver.Conditions) (*storage.ObjectHandle, error) {
+	if conds == nil {
+		return obj, nil
+	}
+	var c storage.Conditions
+	if conds.IfNotExist {
+		c.DoesNotExist = true
+	}
+	if conds.IfMatch != "" {
+		gen, err := strconv.ParseInt(conds.IfMatch, 10, 64)
+		if err != nil || gen <= 0 {
+			// Not a generation number, so it can't match.
+			return nil, errPreconditionFailed
+		}
+		c.GenerationMatch = gen
+	}
+	if c == (storage.Conditions{}) {
+		return obj, nil
+	}
+	return obj.If(c), nil
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.SignedURLOptions) (string, error) {
 	if b.opts.GoogleAccessID == "" || (b.opts.PrivateKey == nil && b.opts.SignBytes == nil) {
 		return "", errors.New("to use SignedURL, you must call OpenBucket with a valid Options.GoogleAccessID and exactly one of Options.PrivateKey or Options.SignBytes")
@@ -580,7 +1070,8 @@ func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.Signed
 	key = escapeKey(key)
 	opts := &storage.SignedURLOptions{
 		Expires:        time.Now().Add(dopts.Expiry),
-		Method:         "GET",
+		Method:         dopts.Method,
+		ContentType:    dopts.ContentType,
 		GoogleAccessID: b.opts.GoogleAccessID,
 		PrivateKey:     b.opts.PrivateKey,
 		SignBytes:      b.opts.SignBytes,
@@ -588,6 +1079,362 @@ func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.Signed
 	return storage.SignedURL(b.name, key, opts)
 }
 
+// hideUploads removes the temporary objects with uploadsPrefix from objects,
+// the results of a listing of prefix, unless prefix is within uploadsPrefix.
+func hideUploads(prefix string, objects []*storage.ObjectAttrs) []*storage.ObjectAttrs {
+	if strings.HasPrefix(prefix, uploadsPrefix) {
+		return objects
+	}
+	var kept []*storage.ObjectAttrs
+	for _, obj := range objects {
+		// Either Name or Prefix ("directories") is set.
+		if !strings.HasPrefix(obj.Name+obj.Prefix, uploadsPrefix) {
+			kept = append(kept, obj)
+		}
+	}
+	return kept
+}
+
+// uploadsPrefix is the prefix of the temporary objects that hold the parts
+// of multipart uploads.
+const uploadsPrefix = ".gocdk-uploads/"
+
+// maxComposeSources is the maximum number of objects that can be composed
+// in one request.
+const maxComposeSources = 32
+
+// Compose implements driver.Compose. Up to maxComposeSources objects are
+// composed at once; more are composed in rounds, through temporary objects
+// like those of multipart uploads.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	attrs := &storage.ObjectAttrs{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        opts.ContentType,
+		Metadata:           opts.Metadata,
+	}
+	if opts.BeforeCompose != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**storage.ObjectAttrs)
+			if !ok {
+				return false
+			}
+			*p = attrs
+			return true
+		}
+		if err := opts.BeforeCompose(asFunc); err != nil {
+			return err
+		}
+	}
+	bkt := b.client.Bucket(b.name)
+	srcs := make([]*storage.ObjectHandle, len(srcKeys))
+	for i, key := range srcKeys {
+		srcs[i] = bkt.Object(escapeKey(key))
+	}
+	u := &multipartUpload{b: b, key: escapeKey(dstKey), id: uuid.New().String(), attrs: attrs, conds: opts.Conditions}
+	if len(srcs) > maxComposeSources {
+		defer func() { _ = u.deleteAll(ctx) }()
+	}
+	return u.compose(ctx, srcs)
+}
+
+// UpdateAttributes implements driver.UpdateAttributes by patching the
+// object's attributes. Removing metadata keys rewrites the object in place
+// instead, since this version of the GCS client can't remove single keys
+// with a patch.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	obj, err := withConditions(b.client.Bucket(b.name).Object(escapeKey(key)), opts.Conditions)
+	if err != nil {
+		return err
+	}
+	if len(opts.DeleteMetadata) > 0 {
+		return b.rewriteAttributes(ctx, obj, opts)
+	}
+	uattrs := &storage.ObjectAttrsToUpdate{Metadata: opts.Metadata}
+	if opts.CacheControl != nil {
+		uattrs.CacheControl = *opts.CacheControl
+	}
+	if opts.ContentDisposition != nil {
+		uattrs.ContentDisposition = *opts.ContentDisposition
+	}
+	if opts.ContentEncoding != nil {
+		uattrs.ContentEncoding = *opts.ContentEncoding
+	}
+	if opts.ContentLanguage != nil {
+		uattrs.ContentLanguage = *opts.ContentLanguage
+	}
+	if opts.ContentType != nil {
+		uattrs.ContentType = *opts.ContentType
+	}
+	if opts.BeforeUpdate != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**storage.ObjectAttrsToUpdate)
+			if !ok {
+				return false
+			}
+			*p = uattrs
+			return true
+		}
+		if err := opts.BeforeUpdate(asFunc); err != nil {
+			return err
+		}
+	}
+	_, err = obj.Update(ctx, *uattrs)
+	return err
+}
+
+// rewriteAttributes updates the attributes of obj by rewriting it onto
+// itself, which GCS does without copying its content, as long as its
+// location and storage class are unchanged.
+func (b *bucket) rewriteAttributes(ctx context.Context, obj *storage.ObjectHandle, opts *driver.UpdateAttributesOptions) error {
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return err
+	}
+	md, expires := splitExpires(attrs.Metadata)
+	a := driver.Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           md,
+	}
+	opts.Apply(&a)
+	// The rewrite fails if the object changed since it was read, so that
+	// concurrent changes aren't overwritten.
+	src := b.client.Bucket(b.name).Object(attrs.Name).Generation(attrs.Generation)
+	dst := b.client.Bucket(b.name).Object(attrs.Name).If(storage.Conditions{GenerationMatch: attrs.Generation})
+	c := dst.CopierFrom(src)
+	c.ObjectAttrs = storage.ObjectAttrs{
+		CacheControl:       a.CacheControl,
+		ContentDisposition: a.ContentDisposition,
+		ContentEncoding:    a.ContentEncoding,
+		ContentLanguage:    a.ContentLanguage,
+		ContentType:        a.ContentType,
+		Metadata:           withExpires(a.Metadata, expires),
+		StorageClass:       attrs.StorageClass,
+	}
+	c.DestinationKMSKeyName = attrs.KMSKeyName
+	if opts.BeforeUpdate != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**storage.Copier)
+			if !ok {
+				return false
+			}
+			*p = c
+			return true
+		}
+		if err := opts.BeforeUpdate(asFunc); err != nil {
+			return err
+		}
+	}
+	_, err = c.Run(ctx)
+	return err
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	key = escapeKey(key)
+	attrs := &storage.ObjectAttrs{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        contentType,
+		Metadata:           withExpires(opts.Metadata, opts.Expires),
+		StorageClass:       storageClasses[opts.StorageClass],
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**storage.ObjectAttrs)
+			if !ok {
+				return false
+			}
+			*p = attrs
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	u := &multipartUpload{b: b, key: key, id: uuid.New().String(), attrs: attrs, conds: opts.Conditions}
+	// Write a marker object recording the key, so that ResumeMultipartUpload
+	// can tell whether the upload exists.
+	w := u.marker().NewWriter(ctx)
+	w.Metadata = map[string]string{"key": key}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	key = escapeKey(key)
+	u := &multipartUpload{
+		b:   b,
+		key: key,
+		id:  uploadID,
+		attrs: &storage.ObjectAttrs{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        contentType,
+			Metadata:           withExpires(opts.Metadata, opts.Expires),
+			StorageClass:       storageClasses[opts.StorageClass],
+		},
+		conds: opts.Conditions,
+	}
+	attrs, err := u.marker().Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.Metadata["key"] != key {
+		return nil, storage.ErrObjectNotExist
+	}
+	return u, nil
+}
+
+// multipartUpload implements driver.MultipartUpload by uploading each part
+// as a temporary object, and composing them on Complete.
+type multipartUpload struct {
+	b     *bucket
+	key   string // escaped
+	id    string
+	attrs *storage.ObjectAttrs // attributes of the composed object
+	conds *driver.Conditions   // preconditions for the composed object, or nil
+}
+
+func (u *multipartUpload) ID() string { return u.id }
+
+// prefix returns the prefix of the temporary objects of the upload.
+func (u *multipartUpload) prefix() string {
+	return uploadsPrefix + u.id + "/"
+}
+
+func (u *multipartUpload) marker() *storage.ObjectHandle {
+	return u.b.client.Bucket(u.b.name).Object(u.prefix() + "upload")
+}
+
+func (u *multipartUpload) part(partNumber int) *storage.ObjectHandle {
+	return u.b.client.Bucket(u.b.name).Object(fmt.Sprintf("%s%05d", u.prefix(), partNumber))
+}
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	w := u.part(partNumber).NewWriter(ctx)
+	w.ChunkSize = 0 // upload the part in a single request
+	if _, err := w.Write(p); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	if _, err := u.marker().Attrs(ctx); err != nil {
+		return nil, err
+	}
+	var parts []int
+	iter := u.b.client.Bucket(u.b.name).Objects(ctx, &storage.Query{Prefix: u.prefix()})
+	for {
+		obj, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Skip the marker and intermediate composed objects.
+		n, err := strconv.Atoi(strings.TrimPrefix(obj.Name, u.prefix()))
+		if err != nil {
+			continue
+		}
+		parts = append(parts, n)
+	}
+	sort.Ints(parts)
+	return parts, nil
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	parts, err := u.ListParts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.New("complete multipart upload: no parts were uploaded")
+	}
+	srcs := make([]*storage.ObjectHandle, len(parts))
+	for i, n := range parts {
+		srcs[i] = u.part(n)
+	}
+	if err := u.compose(ctx, srcs); err != nil {
+		return err
+	}
+	return u.deleteAll(ctx)
+}
+
+// compose composes srcs into the object of the upload. Intermediate objects
+// are created under the upload's prefix if there are too many sources to
+// compose at once.
+func (u *multipartUpload) compose(ctx context.Context, srcs []*storage.ObjectHandle) error {
+	// Compose the sources in rounds of at most maxComposeSources objects,
+	// until there are few enough left to compose into the final object.
+	bkt := u.b.client.Bucket(u.b.name)
+	for round := 0; len(srcs) > maxComposeSources; round++ {
+		var next []*storage.ObjectHandle
+		for i := 0; i < len(srcs); i += maxComposeSources {
+			end := i + maxComposeSources
+			if end > len(srcs) {
+				end = len(srcs)
+			}
+			dst := bkt.Object(fmt.Sprintf("%scompose-%d-%05d", u.prefix(), round, len(next)))
+			if _, err := dst.ComposerFrom(srcs[i:end]...).Run(ctx); err != nil {
+				return err
+			}
+			next = append(next, dst)
+		}
+		srcs = next
+	}
+	dst, err := withConditions(bkt.Object(u.key), u.conds)
+	if err != nil {
+		return err
+	}
+	c := dst.ComposerFrom(srcs...)
+	c.ObjectAttrs = *u.attrs
+	_, err = c.Run(ctx)
+	return err
+}
+
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	if _, err := u.marker().Attrs(ctx); err != nil {
+		return err
+	}
+	return u.deleteAll(ctx)
+}
+
+// deleteAll deletes the temporary objects of the upload, including the
+// marker.
+func (u *multipartUpload) deleteAll(ctx context.Context) error {
+	bkt := u.b.client.Bucket(u.b.name)
+	iter := bkt.Objects(ctx, &storage.Query{Prefix: u.prefix()})
+	for {
+		obj, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := bkt.Object(obj.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return err
+		}
+	}
+}
+
 func bufferSize(size int) int {
 	if size == 0 {
 		return googleapi.DefaultUploadChunkSize
@@ -15,24 +15,35 @@
 package gcsblob
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/google/go-cmp/cmp"
 	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/blobpubsub"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/blob/drivertest"
 	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/gcp"
 	"github.com/eliben/gocdkx/internal/testing/setup"
+	"github.com/eliben/gocdkx/pubsub"
+	"github.com/eliben/gocdkx/pubsub/mempubsub"
 	"google.golang.org/api/googleapi"
 )
 
@@ -250,6 +261,56 @@ func TestBufferSize(t *testing.T) {
 	}
 }
 
+func TestExpiresMetadata(t *testing.T) {
+	exp := time.Date(2019, 7, 1, 12, 30, 0, 500, time.FixedZone("", 3600))
+	md := map[string]string{"foo": "bar"}
+	withExp := withExpires(md, exp)
+	if got := withExp[expiresKey]; got != "2019-07-01T11:30:00.0000005Z" {
+		t.Errorf("got %q stored for the expiration time, want it in UTC", got)
+	}
+	if _, ok := md[expiresKey]; ok {
+		t.Error("withExpires modified its argument")
+	}
+	gotMD, gotExp := splitExpires(withExp)
+	if diff := cmp.Diff(gotMD, md); diff != "" {
+		t.Errorf("got metadata diff (-got +want):\n%s", diff)
+	}
+	if !gotExp.Equal(exp) {
+		t.Errorf("got expiration time %v want %v", gotExp, exp)
+	}
+
+	// Metadata is unchanged without an expiration time.
+	if got := withExpires(md, time.Time{}); len(got) != 1 {
+		t.Errorf("got %v want %v", got, md)
+	}
+	if _, gotExp := splitExpires(md); !gotExp.IsZero() {
+		t.Errorf("got expiration time %v want zero", gotExp)
+	}
+}
+
+func TestHideUploads(t *testing.T) {
+	objects := []*storage.ObjectAttrs{
+		{Prefix: uploadsPrefix},
+		{Name: uploadsPrefix + "id/1"},
+		{Name: "a"},
+		{Prefix: "dir/"},
+	}
+	names := func(objs []*storage.ObjectAttrs) []string {
+		var got []string
+		for _, obj := range objs {
+			got = append(got, obj.Name+obj.Prefix)
+		}
+		return got
+	}
+	if diff := cmp.Diff(names(hideUploads("", objects)), []string{"a", "dir/"}); diff != "" {
+		t.Errorf("got diff (-got +want):\n%s", diff)
+	}
+	// The temporary objects are listed when asked for explicitly.
+	if got := hideUploads(uploadsPrefix+"id/", objects[1:2]); len(got) != 1 {
+		t.Errorf("got %v want the temporary object", names(got))
+	}
+}
+
 func TestOpenBucket(t *testing.T) {
 	tests := []struct {
 		description string
@@ -577,3 +638,171 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+// batchTransport is an http.RoundTripper that serves GCS batch delete
+// requests, failing deletes of objects named "missing".
+type batchTransport struct{}
+
+func (batchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mr := multipart.NewReader(req.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		preq, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			return nil, err
+		}
+		status := "204 No Content"
+		if preq.Method != "DELETE" || !strings.HasPrefix(preq.URL.Path, "/storage/v1/b/mybucket/o/") {
+			status = "400 Bad Request"
+		} else if strings.HasSuffix(preq.URL.Path, "/missing") {
+			status = "404 Not Found"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-ID", "<response-"+strings.Trim(part.Header.Get("Content-ID"), "<>")+">")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(pw, "HTTP/1.1 %s\r\nContent-Length: 0\r\n\r\n", status)
+	}
+	mw.Close()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"multipart/mixed; boundary=" + mw.Boundary()}},
+		Body:       ioutil.NopCloser(&body),
+		Request:    req,
+	}, nil
+}
+
+func TestDeleteManyBatch(t *testing.T) {
+	ctx := context.Background()
+	client := &gcp.HTTPClient{Client: http.Client{Transport: batchTransport{}}}
+	drv, err := openBucket(ctx, client, "mybucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More keys than fit in a single batch.
+	var keys []string
+	for i := 0; i < maxBatchSize+10; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	keys[5] = "missing"
+	keys[maxBatchSize+5] = "missing"
+	errs, err := drv.DeleteMany(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != len(keys) {
+		t.Fatalf("got %d errors want %d", len(errs), len(keys))
+	}
+	for i, err := range errs {
+		if keys[i] == "missing" {
+			if drv.ErrorCode(err) != gcerrors.NotFound {
+				t.Errorf("key %q: got %v want NotFound error", keys[i], err)
+			}
+		} else if err != nil {
+			t.Errorf("key %q: got %v want nil", keys[i], err)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+	client := &gcp.HTTPClient{Client: http.Client{Transport: batchTransport{}}}
+	drv, err := openBucket(ctx, client, "mybucket", &Options{NotificationSource: blobpubsub.NewNotificationSource(sub)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+	w, err := b.Watch(ctx, &blob.WatchOptions{Prefix: "dir/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	notify := func(eventType, object string, extra map[string]string, body string) {
+		t.Helper()
+		md := map[string]string{
+			"eventType":          eventType,
+			"bucketId":           "mybucket",
+			"objectId":           object,
+			"payloadFormat":      "JSON_API_V1",
+			"notificationConfig": "projects/_/buckets/mybucket/notificationConfigs/1",
+		}
+		for k, v := range extra {
+			md[k] = v
+		}
+		if err := topic.Send(ctx, &pubsub.Message{Body: []byte(body), Metadata: md}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Ignored: other bucket, other prefix, metadata update, overwritten
+	// object, multipart upload part, malformed body.
+	notify("OBJECT_FINALIZE", "dir/a", map[string]string{"bucketId": "other"}, "{}")
+	notify("OBJECT_FINALIZE", "other/a", nil, "{}")
+	notify("OBJECT_METADATA_UPDATE", "dir/a", nil, "{}")
+	notify("OBJECT_DELETE", "dir/a", map[string]string{"overwrittenByGeneration": "2"}, "{}")
+	notify("OBJECT_FINALIZE", uploadsPrefix+"dir/a/1", nil, "{}")
+	notify("OBJECT_FINALIZE", "dir/a", nil, "not json")
+	// Reported.
+	notify("OBJECT_FINALIZE", "dir/a", map[string]string{"objectGeneration": "2"}, `{"size": "11", "updated": "2019-06-01T12:00:00.000Z"}`)
+	notify("OBJECT_DELETE", "dir/__0x0a__b", nil, "{}")
+
+	// mempubsub doesn't preserve the order of messages, so the events are
+	// compared by key.
+	want := map[string]blob.Event{
+		"dir/a":   {Type: blob.EventCreated, Key: "dir/a", ModTime: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), Size: 11, ETag: "2"},
+		"dir/\nb": {Type: blob.EventDeleted, Key: "dir/\nb"},
+	}
+	for range want {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		got, err := w.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := want[got.Key]
+		if got.Type != want.Type || got.Key != want.Key || !got.ModTime.Equal(want.ModTime) || got.Size != want.Size || got.ETag != want.ETag {
+			t.Errorf("got event %+v want %+v", *got, want)
+		}
+		var m *pubsub.Message
+		if !got.As(&m) || m.Metadata["objectId"] == "" {
+			t.Error("Event.As failed to get *pubsub.Message")
+		}
+	}
+	var s *pubsub.Subscription
+	if !w.As(&s) || s != sub {
+		t.Error("Watcher.As failed to get *pubsub.Subscription")
+	}
+}
+
+func TestWatchWithoutSubscription(t *testing.T) {
+	ctx := context.Background()
+	client := &gcp.HTTPClient{Client: http.Client{Transport: batchTransport{}}}
+	b, err := OpenBucket(ctx, client, "mybucket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := b.Watch(ctx, nil); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("got error %v want Unimplemented", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsblob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eliben/gocdkx/blob/driver"
+)
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	if b.opts.NotificationSource == nil {
+		return nil, errNotImplemented
+	}
+	return &watcher{b: b, prefix: opts.Prefix, done: make(chan struct{})}, nil
+}
+
+// watcher implements driver.Watcher by receiving Cloud Pub/Sub
+// notifications for Cloud Storage.
+type watcher struct {
+	b      *bucket
+	prefix string
+	// done is closed by Close.
+	done chan struct{}
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		m, err := w.b.opts.NotificationSource.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Notifications that can't be parsed will never be, so they are
+		// dropped like the ones for other buckets.
+		ev, err := parseNotification(m, w.b.name)
+		m.Ack()
+		if err == nil && ev != nil && strings.HasPrefix(ev.Key, w.prefix) {
+			return []*driver.Event{ev}, nil
+		}
+	}
+}
+
+// parseNotification returns the event described by a Pub/Sub notification
+// for Cloud Storage, or nil if the notification is for another bucket, or
+// doesn't describe a change to an object's content.
+// See https://cloud.google.com/storage/docs/pubsub-notifications.
+func parseNotification(m *driver.Notification, bucketName string) (*driver.Event, error) {
+	if m.Metadata["bucketId"] != bucketName {
+		return nil, nil
+	}
+	name := m.Metadata["objectId"]
+	if name == "" {
+		return nil, fmt.Errorf("gcsblob: notification has no objectId")
+	}
+	if strings.HasPrefix(name, uploadsPrefix) {
+		// Parts of multipart uploads aren't blobs.
+		return nil, nil
+	}
+	ev := &driver.Event{Key: unescapeKey(name), AsFunc: m.AsFunc}
+	switch m.Metadata["eventType"] {
+	case "OBJECT_FINALIZE":
+		ev.Type = driver.EventCreated
+		ev.ETag = m.Metadata["objectGeneration"]
+		if m.Metadata["payloadFormat"] == "JSON_API_V1" {
+			var obj struct {
+				Size    string    `json:"size"`
+				Updated time.Time `json:"updated"`
+			}
+			if err := json.Unmarshal(m.Body, &obj); err != nil {
+				return nil, err
+			}
+			ev.Size, _ = strconv.ParseInt(obj.Size, 10, 64)
+			ev.ModTime = obj.Updated
+		}
+	case "OBJECT_DELETE", "OBJECT_ARCHIVE":
+		// An object that was overwritten is reported by the OBJECT_FINALIZE
+		// of the new generation.
+		if m.Metadata["overwrittenByGeneration"] != "" {
+			return nil, nil
+		}
+		ev.Type = driver.EventDeleted
+	default:
+		// OBJECT_METADATA_UPDATE doesn't change the content.
+		return nil, nil
+	}
+	return ev, nil
+}
+
+// As implements driver.Watcher.As.
+func (w *watcher) As(i interface{}) bool {
+	return w.b.opts.NotificationSource.As(i)
+}
+
+// Close implements driver.Watcher.Close. It doesn't close the notification
+// source.
+func (w *watcher) Close() error {
+	close(w.done)
+	return nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unwrap defines the type that package wrapper passes to the As
+// method of a *blob.Bucket to get its driver. It is separate from package
+// wrapper, which imports package blob, so that package blob can import it.
+package unwrap // import "github.com/eliben/gocdkx/blob/internal/unwrap"
+
+import "github.com/eliben/gocdkx/blob/driver"
+
+// Driver is set to the driver of a *blob.Bucket by its As method. Since this
+// package is internal, only the providers in this module can ask for it.
+type Driver struct {
+	Bucket driver.Bucket
+}
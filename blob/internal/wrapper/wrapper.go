@@ -0,0 +1,88 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wrapper provides a base for the blob providers that wrap another
+// bucket, like cacheblob and encryptblob.
+//
+// A wrapping provider calls the driver of the wrapped *blob.Bucket directly,
+// rather than its portable methods, so that tracing, metrics, retries and
+// limits happen once per call, in the portable type of the wrapping bucket,
+// and driver options like ReaderOptions.Raw reach the wrapped provider. As a
+// result, the retry policy and limits of the wrapped bucket, whether set with
+// SetRetryPolicy and SetLimits or with URL parameters, are not used; the
+// packages of wrapping providers document this.
+package wrapper // import "github.com/eliben/gocdkx/blob/internal/wrapper"
+
+import (
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/unwrap"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/internal/gcerr"
+	"golang.org/x/xerrors"
+)
+
+// InnerSchemes are the URL schemes of the providers in this module.
+var InnerSchemes = []string{"azblob", "file", "gs", "mem", "s3"}
+
+// RegisterSchemes registers opener on blob.DefaultURLMux under
+// scheme+"+"+s for each s in InnerSchemes.
+func RegisterSchemes(scheme string, opener blob.BucketURLOpener) {
+	for _, s := range InnerSchemes {
+		blob.DefaultURLMux().RegisterBucket(scheme+"+"+s, opener)
+	}
+}
+
+// Driver returns the driver of b.
+func Driver(b *blob.Bucket) driver.Bucket {
+	var d unwrap.Driver
+	b.As(&d)
+	return d.Bucket
+}
+
+// Bucket implements driver.Bucket by calling the methods of the wrapped
+// driver.Bucket. Providers embed it, and override the methods they change.
+//
+// The wrapped bucket's errors are returned unchanged, so that ErrorAs and
+// ErrorCode can use the wrapped provider's types.
+type Bucket struct {
+	driver.Bucket
+	// Owned is true if Close should close the wrapped bucket.
+	Owned bool
+}
+
+// New returns a Bucket that wraps the driver of inner. inner must not be
+// closed while the Bucket is in use.
+func New(inner *blob.Bucket) Bucket {
+	return Bucket{Bucket: Driver(inner)}
+}
+
+// ErrorCode implements driver.ErrorCode. Errors created by the wrapping
+// provider carry a code; others are returned by the wrapped bucket.
+func (b *Bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	var e *gcerr.Error
+	if xerrors.As(err, &e) {
+		return e.Code
+	}
+	return b.Bucket.ErrorCode(err)
+}
+
+// Close implements driver.Close. It closes the wrapped bucket if it is
+// owned.
+func (b *Bucket) Close() error {
+	if !b.Owned {
+		return nil
+	}
+	return b.Bucket.Close()
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eliben/gocdkx/internal/gcerr"
+	"github.com/eliben/gocdkx/internal/oc"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/time/rate"
+)
+
+// OperationKind is a kind of operation on a Bucket, to which a Rate applies;
+// see Limits.
+type OperationKind string
+
+// The kinds of operations on a Bucket.
+const (
+	// ReadOperation is the kind of NewReader, NewRangeReader and ReadAll.
+	ReadOperation OperationKind = "read"
+	// WriteOperation is the kind of NewWriter, WriteAll, Copy, Compose,
+	// UpdateAttributes, NewMultipartUpload, ResumeMultipartUpload, and of
+	// MultipartUpload.UploadPart and MultipartUpload.Complete, including
+	// the ones made by a Writer with WriterOptions.MaxConcurrency > 1.
+	WriteOperation OperationKind = "write"
+	// ListOperation is the kind of the listing of a page by List, ListPage
+	// and Walk, and of ListVersions.
+	ListOperation OperationKind = "list"
+	// AttributesOperation is the kind of Attributes and Exists.
+	AttributesOperation OperationKind = "attributes"
+	// DeleteOperation is the kind of Delete, and of the deletion of a batch
+	// of keys by DeleteMany and DeletePrefix.
+	DeleteOperation OperationKind = "delete"
+)
+
+// operationKinds are the kinds of operations, in the order of their URL
+// query parameters.
+var operationKinds = []OperationKind{ReadOperation, WriteOperation, ListOperation, AttributesOperation, DeleteOperation}
+
+// Limits limits the load that a Bucket puts on its provider; see
+// Bucket.SetLimits.
+//
+// The URL openers of Buckets set Limits from the following query parameters,
+// which they remove from the URL:
+//   - limit_concurrency: Sets MaxConcurrency.
+//   - limit_read, limit_write, limit_list, limit_attributes, limit_delete:
+//       Set the Rate of the operations of the kind in the parameter name, in
+//       operations per second, e.g. "limit_read=100". The burst is the rate
+//       rounded up, and at least 1.
+type Limits struct {
+	// MaxConcurrency is the maximum number of operations in flight at once.
+	// A Reader or a Writer is in flight until it is closed. A Writer with
+	// WriterOptions.MaxConcurrency > 1 counts as a single operation, although
+	// it uploads up to that many parts at once; each part is rate limited
+	// as a WriteOperation. If it is 0, the number of operations isn't
+	// limited.
+	MaxConcurrency int
+
+	// Rates are the rate limits of the operations, by kind. The operations
+	// of a kind without a Rate aren't rate limited.
+	Rates map[OperationKind]Rate
+}
+
+// A Rate is a token bucket rate limit: operations may be made at PerSecond
+// operations per second on average, with bursts of up to Burst operations.
+type Rate struct {
+	// PerSecond is the average number of operations per second. It must be
+	// positive.
+	PerSecond float64
+
+	// Burst is the maximum number of operations made at once. It must be at
+	// least 1.
+	Burst int
+}
+
+// SetLimits sets the limits on the operations of b. If l is nil, the
+// operations are not limited, which is the default.
+//
+// The operations wait until the limits allow them to be made, or until their
+// context is done. The retries of an operation under the retry policy of b
+// (see SetRetryPolicy) don't wait again, except for those of WriteAll, each
+// of which opens a Writer. The time waited is recorded in the
+// OpenCensus measure of the "wait" view in OpenCensusViews.
+//
+// SetLimits should not be called while operations are in flight, since
+// those operations are not counted against the new limits.
+func (b *Bucket) SetLimits(l *Limits) error {
+	lim, err := newLimiter(l)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limiter = lim
+	return nil
+}
+
+// limiter enforces Limits.
+type limiter struct {
+	sem   chan struct{} // holds a token per operation in flight; nil if unlimited
+	rates map[OperationKind]*rate.Limiter
+}
+
+// newLimiter validates l and returns a limiter that enforces it, or nil if
+// l is nil.
+func newLimiter(l *Limits) (*limiter, error) {
+	if l == nil {
+		return nil, nil
+	}
+	if l.MaxConcurrency < 0 {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Limits.MaxConcurrency must be >= 0 (%d)", l.MaxConcurrency)
+	}
+	lim := &limiter{rates: map[OperationKind]*rate.Limiter{}}
+	if l.MaxConcurrency > 0 {
+		lim.sem = make(chan struct{}, l.MaxConcurrency)
+	}
+	for kind, r := range l.Rates {
+		if r.PerSecond <= 0 {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Limits.Rates[%q].PerSecond must be > 0 (%v)", kind, r.PerSecond)
+		}
+		if r.Burst < 1 {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Limits.Rates[%q].Burst must be >= 1 (%d)", kind, r.Burst)
+		}
+		lim.rates[kind] = rate.NewLimiter(rate.Limit(r.PerSecond), r.Burst)
+	}
+	return lim, nil
+}
+
+// wait waits until the limits allow an operation of the given kind, made
+// by the method methodName of t.Package, and records the time waited. It
+// returns a function that must be called when the operation is no longer in
+// flight. A nil limiter doesn't wait.
+func (l *limiter) wait(ctx context.Context, t *oc.Tracer, methodName string, kind OperationKind) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			l.record(ctx, t, methodName, start)
+		}
+	}()
+	if err := l.waitRate(ctx, kind); err != nil {
+		return nil, err
+	}
+	if l.sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-l.sem }) }, nil
+}
+
+// throttle waits for the rate limit of kind to allow a call to methodName
+// made within an operation that is already in flight, like the upload of a
+// part by a Writer with WriterOptions.MaxConcurrency > 1. Unlike wait, it
+// doesn't take a concurrency slot, since the operation holds one.
+func (l *limiter) throttle(ctx context.Context, t *oc.Tracer, methodName string, kind OperationKind) error {
+	if l == nil {
+		return nil
+	}
+	start := time.Now()
+	if err := l.waitRate(ctx, kind); err != nil {
+		return err
+	}
+	l.record(ctx, t, methodName, start)
+	return nil
+}
+
+// waitRate waits for the rate limit of kind, if any.
+func (l *limiter) waitRate(ctx context.Context, kind OperationKind) error {
+	r := l.rates[kind]
+	if r == nil {
+		return nil
+	}
+	res := r.Reserve()
+	if d := res.Delay(); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			res.Cancel()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// record records the time a call to methodName waited since start.
+func (l *limiter) record(ctx context.Context, t *oc.Tracer, methodName string, start time.Time) {
+	stats.RecordWithTags(ctx, []tag.Mutator{
+		tag.Upsert(oc.MethodKey, t.Package+"."+methodName),
+		tag.Upsert(oc.ProviderKey, t.Provider),
+	}, waitMeasure.M(float64(time.Since(start).Nanoseconds())/1e6)) // milliseconds
+}
+
+// acquire waits for the limits of b to allow a call to methodName, and then
+// read-locks b.mu for the call. b.mu is not held while waiting, so that the
+// calls waiting for a slot don't block Close and SetLimits. If acquire
+// returns an error, b.mu is not held; otherwise the caller must unlock it,
+// and call release when the operation is done.
+func (b *Bucket) acquire(ctx context.Context, methodName string, kind OperationKind) (release func(), err error) {
+	b.mu.RLock()
+	l := b.limiter
+	b.mu.RUnlock()
+	release, err = l.wait(ctx, b.tracer, methodName, kind)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		release()
+		return nil, errClosed
+	}
+	return release, nil
+}
+
+// limitsFromURL returns the Limits set by the "limit_" query parameters of
+// u, and u without those parameters. If u has none of them, it returns nil
+// and u itself.
+func limitsFromURL(u *url.URL) (*Limits, *url.URL, error) {
+	q := u.Query()
+	l := &Limits{}
+	found := false
+	if s := q.Get("limit_concurrency"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid query parameter %q: %q", "limit_concurrency", s)
+		}
+		l.MaxConcurrency = n
+		found = true
+		q.Del("limit_concurrency")
+	}
+	for _, kind := range operationKinds {
+		param := "limit_" + string(kind)
+		s := q.Get(param)
+		if s == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(f > 0) || math.IsInf(f, 0) {
+			return nil, nil, fmt.Errorf("invalid query parameter %q: %q", param, s)
+		}
+		if l.Rates == nil {
+			l.Rates = map[OperationKind]Rate{}
+		}
+		l.Rates[kind] = Rate{PerSecond: f, Burst: int(math.Min(math.Ceil(f), math.MaxInt32))}
+		found = true
+		q.Del(param)
+	}
+	if !found {
+		return nil, u, nil
+	}
+	u2 := *u
+	u2.RawQuery = q.Encode()
+	return l, &u2, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+// timeoutCtx returns a context that is done shortly.
+func timeoutCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 20*time.Millisecond)
+}
+
+func TestLimitsConcurrency(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetLimits(&blob.Limits{MaxConcurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An open Reader is in flight, so other operations wait.
+	r, err := b.NewReader(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := timeoutCtx()
+	defer cancel()
+	if _, err := b.NewReader(tctx, "key", nil); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+		t.Errorf("NewReader: got error %v want DeadlineExceeded", err)
+	}
+	tctx, cancel = timeoutCtx()
+	defer cancel()
+	if _, err := b.Attributes(tctx, "key"); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+		t.Errorf("Attributes: got error %v want DeadlineExceeded", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); err != nil {
+		t.Errorf("ReadAll after Close: %v", err)
+	}
+
+	// Removing the limits stops the waits.
+	w, err := b.NewWriter(ctx, "key2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := b.SetLimits(nil); err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel = timeoutCtx()
+	defer cancel()
+	if _, err := b.Attributes(tctx, "key"); err != nil {
+		t.Errorf("Attributes without limits: %v", err)
+	}
+}
+
+func TestLimitsRate(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetLimits(&blob.Limits{
+		Rates: map[blob.OperationKind]blob.Rate{
+			blob.AttributesOperation: {PerSecond: 0.1, Burst: 2},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The burst is allowed at once.
+	tctx, cancel := timeoutCtx()
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, err := b.Attributes(tctx, "key"); err != nil {
+			t.Fatalf("Attributes #%d: %v", i+1, err)
+		}
+	}
+	if _, err := b.Exists(tctx, "key"); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+		t.Errorf("Exists: got error %v want DeadlineExceeded", err)
+	}
+	// Other kinds of operations aren't limited.
+	tctx, cancel = timeoutCtx()
+	defer cancel()
+	if _, err := b.ReadAll(tctx, "key"); err != nil {
+		t.Errorf("ReadAll: %v", err)
+	}
+	if err := b.Delete(tctx, "key"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+}
+
+// TestLimitsRateMultipart checks that the parts of a Writer that uploads
+// concurrently are rate limited.
+func TestLimitsRateMultipart(t *testing.T) {
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.SetLimits(&blob.Limits{
+		Rates: map[blob.OperationKind]blob.Rate{
+			blob.WriteOperation: {PerSecond: 0.1, Burst: 2},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// NewWriter and the first part use the burst, so the other parts wait.
+	tctx, cancel := timeoutCtx()
+	defer cancel()
+	w, err := b.NewWriter(tctx, "key", &blob.WriterOptions{ContentType: "text/plain", MaxConcurrency: 2, BufferSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+		t.Errorf("Close: got error %v want DeadlineExceeded", err)
+	}
+}
+
+// TestLimitsClose checks that the calls waiting for the limits don't block
+// Close, and fail once they stop waiting.
+func TestLimitsClose(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetLimits(&blob.Limits{MaxConcurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewReader(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := b.Attributes(ctx, "key")
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- b.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close is blocked by a call waiting for the limits")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("Attributes: got error %v want FailedPrecondition", err)
+	}
+}
+
+func TestInvalidLimits(t *testing.T) {
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	for _, l := range []*blob.Limits{
+		{MaxConcurrency: -1},
+		{Rates: map[blob.OperationKind]blob.Rate{blob.ReadOperation: {PerSecond: 0, Burst: 1}}},
+		{Rates: map[blob.OperationKind]blob.Rate{blob.ReadOperation: {PerSecond: 1, Burst: 0}}},
+	} {
+		if err := b.SetLimits(l); gcerrors.Code(err) != gcerrors.InvalidArgument {
+			t.Errorf("%+v: got error %v want InvalidArgument", l, err)
+		}
+	}
+}
+
+func TestLimitsFromURL(t *testing.T) {
+	ctx := context.Background()
+	b, err := blob.OpenBucket(ctx, "mem://?limit_concurrency=1&limit_delete=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	w, err := b.NewWriter(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := timeoutCtx()
+	defer cancel()
+	if err := b.Delete(tctx, "key"); gcerrors.Code(err) != gcerrors.DeadlineExceeded {
+		t.Errorf("Delete: got error %v want DeadlineExceeded", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "key"); err != nil {
+		t.Errorf("Delete after Close: %v", err)
+	}
+}
@@ -22,6 +22,27 @@
 // see URLOpener.
 // See https://github.com/eliben/gocdkx/concepts/urls/ for background information.
 //
+// Expiration
+//
+// Blobs written with blob.WriterOptions.Expires are deleted as soon as they
+// expire, and reported as not found from then on.
+//
+// Storage classes
+//
+// All of the storage classes of blob.StorageClass are supported, and recorded
+// in blob.Attributes.StorageClass, but they don't change the behavior of
+// blobs. The default class is blob.StorageClassStandard.
+//
+// Appending
+//
+// blob.WriterOptions.Append is supported for all blobs.
+//
+// Watch
+//
+// Changes are reported to watchers as soon as they are made. Blobs whose
+// attributes are changed by UpdateAttributes are reported as created, like
+// overwritten blobs.
+//
 // As
 //
 // memblob does not support any types for As.
@@ -37,6 +58,7 @@ import (
 	"io"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,8 +71,10 @@ import (
 const defaultPageSize = 1000
 
 var (
-	errNotFound       = errors.New("blob not found")
-	errNotImplemented = errors.New("not implemented")
+	errNotFound           = errors.New("blob not found")
+	errNotImplemented     = errors.New("not implemented")
+	errPreconditionFailed = errors.New("precondition failed")
+	errWatcherClosed      = errors.New("watcher closed")
 )
 
 func init() {
@@ -83,14 +107,26 @@ type blobEntry struct {
 }
 
 type bucket struct {
-	mu    sync.Mutex
-	blobs map[string]*blobEntry
+	mu      sync.Mutex
+	blobs   map[string]*blobEntry
+	uploads map[string]*uploadEntry
+	// nextUploadID is used to generate IDs for multipart uploads.
+	nextUploadID int
+	// watchers holds the open Watchers, which are notified of changes.
+	watchers map[*watcher]bool
+	// janitor deletes expired blobs at nextExpiry, the earliest time at
+	// which a blob expires. It is nil if no blob expires.
+	janitor    *time.Timer
+	nextExpiry time.Time
+	closed     bool
 }
 
 // openBucket creates a driver.Bucket backed by memory.
 func openBucket(_ *Options) driver.Bucket {
 	return &bucket{
-		blobs: map[string]*blobEntry{},
+		blobs:    map[string]*blobEntry{},
+		uploads:  map[string]*uploadEntry{},
+		watchers: map[*watcher]bool{},
 	}
 }
 
@@ -100,15 +136,81 @@ func OpenBucket(opts *Options) *blob.Bucket {
 }
 
 func (b *bucket) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.janitor != nil {
+		b.janitor.Stop()
+		b.janitor = nil
+	}
 	return nil
 }
 
+// get returns the blob at key, or nil if there is none or it has expired.
+// b.mu must be held.
+func (b *bucket) get(key string) *blobEntry {
+	entry := b.blobs[key]
+	if entry == nil || expired(entry, time.Now()) {
+		return nil
+	}
+	return entry
+}
+
+// expired reports whether entry has expired at now.
+func expired(entry *blobEntry, now time.Time) bool {
+	exp := entry.Attributes.Expires
+	return !exp.IsZero() && !exp.After(now)
+}
+
+// scheduleJanitor makes sure that the janitor runs at exp, when a blob
+// expires. b.mu must be held.
+func (b *bucket) scheduleJanitor(exp time.Time) {
+	if exp.IsZero() || b.closed {
+		return
+	}
+	if b.janitor != nil {
+		if !exp.Before(b.nextExpiry) {
+			return
+		}
+		b.janitor.Stop()
+	}
+	b.nextExpiry = exp
+	b.janitor = time.AfterFunc(time.Until(exp), b.deleteExpired)
+}
+
+// deleteExpired deletes the blobs that have expired, and schedules the
+// janitor for the next blob to expire.
+func (b *bucket) deleteExpired() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.janitor = nil
+	now := time.Now()
+	var next time.Time
+	for key, entry := range b.blobs {
+		exp := entry.Attributes.Expires
+		switch {
+		case exp.IsZero():
+		case expired(entry, now):
+			delete(b.blobs, key)
+			b.notify(&driver.Event{Type: driver.EventDeleted, Key: key})
+		case next.IsZero() || exp.Before(next):
+			next = exp
+		}
+	}
+	b.scheduleJanitor(next)
+}
+
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 	switch err {
 	case errNotFound:
 		return gcerrors.NotFound
 	case errNotImplemented:
 		return gcerrors.Unimplemented
+	case errPreconditionFailed:
+		return gcerrors.FailedPrecondition
 	default:
 		return gcerrors.Unknown
 	}
@@ -132,8 +234,11 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	}
 
 	var keys []string
-	for key := range b.blobs {
-		keys = append(keys, key)
+	now := time.Now()
+	for key, entry := range b.blobs {
+		if !expired(entry, now) {
+			keys = append(keys, key)
+		}
 	}
 	sort.Strings(keys)
 
@@ -154,6 +259,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			ModTime: entry.Attributes.ModTime,
 			Size:    entry.Attributes.Size,
 			MD5:     entry.Attributes.MD5,
+			ETag:    entry.Attributes.ETag,
 		}
 
 		// If using Delimiter, collapse "directories".
@@ -205,8 +311,8 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	entry, found := b.blobs[key]
-	if !found {
+	entry := b.get(key)
+	if entry == nil {
 		return nil, errNotFound
 	}
 	return entry.Attributes, nil
@@ -214,13 +320,19 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.Version != "" {
+		return nil, errNotImplemented
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	entry, found := b.blobs[key]
-	if !found {
+	entry := b.get(key)
+	if entry == nil {
 		return nil, errNotFound
 	}
+	if err := checkConditions(entry, opts.Conditions); err != nil {
+		return nil, err
+	}
 
 	if opts.BeforeRead != nil {
 		if err := opts.BeforeRead(func(interface{}) bool { return false }); err != nil {
@@ -334,14 +446,46 @@ func (w *writer) Close() error {
 			Size:               int64(len(content)),
 			ModTime:            time.Now(),
 			MD5:                md5sum,
+			// The ETag is derived from the content, like S3's ETag for
+			// objects that weren't uploaded in parts.
+			ETag:         fmt.Sprintf(`"%x"`, md5sum),
+			Expires:      w.opts.Expires,
+			StorageClass: storageClass(w.opts.StorageClass),
 		},
 	}
 	w.b.mu.Lock()
 	defer w.b.mu.Unlock()
+	prev := w.b.get(w.key)
+	if err := checkConditions(prev, w.opts.Conditions); err != nil {
+		return err
+	}
+	if w.opts.Append && prev != nil {
+		// Keep the attributes of the existing blob. The content is copied,
+		// since entries may be shared by copies.
+		content = append(append([]byte{}, prev.Content...), content...)
+		md5sum := md5.Sum(content)
+		attrs := *prev.Attributes
+		attrs.Size = int64(len(content))
+		attrs.ModTime = entry.Attributes.ModTime
+		attrs.MD5 = md5sum[:]
+		attrs.ETag = fmt.Sprintf(`"%x"`, md5sum)
+		entry = &blobEntry{Content: content, Attributes: &attrs}
+	}
 	w.b.blobs[w.key] = entry
+	w.b.scheduleJanitor(entry.Attributes.Expires)
+	w.b.notifyCreated(w.key, entry)
 	return nil
 }
 
+// storageClass returns the storage class that blobs written with class c are
+// stored in. All classes are supported, and behave the same.
+func storageClass(c driver.StorageClass) driver.StorageClass {
+	if c == driver.StorageClassDefault {
+		return driver.StorageClassStandard
+	}
+	return c
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
 	b.mu.Lock()
@@ -350,26 +494,377 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	if opts.BeforeCopy != nil {
 		return opts.BeforeCopy(func(interface{}) bool { return false })
 	}
-	v := b.blobs[srcKey]
+	v := b.get(srcKey)
 	if v == nil {
 		return errNotFound
 	}
+	if err := checkConditions(b.get(dstKey), opts.Conditions); err != nil {
+		return err
+	}
+	if sc := storageClass(opts.StorageClass); sc != v.Attributes.StorageClass {
+		attrs := *v.Attributes
+		attrs.StorageClass = sc
+		v = &blobEntry{Content: v.Content, Attributes: &attrs}
+	}
 	b.blobs[dstKey] = v
+	b.notifyCreated(dstKey, v)
+	return nil
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var content []byte
+	for _, key := range srcKeys {
+		v := b.get(key)
+		if v == nil {
+			return errNotFound
+		}
+		content = append(content, v.Content...)
+	}
+	if err := checkConditions(b.get(dstKey), opts.Conditions); err != nil {
+		return err
+	}
+	if opts.BeforeCompose != nil {
+		if err := opts.BeforeCompose(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
+	}
+	md5sum := md5.Sum(content)
+	entry := &blobEntry{
+		Content: content,
+		Attributes: &driver.Attributes{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        opts.ContentType,
+			Metadata:           opts.Metadata,
+			Size:               int64(len(content)),
+			ModTime:            time.Now(),
+			MD5:                md5sum[:],
+			ETag:               fmt.Sprintf(`"%x"`, md5sum),
+			StorageClass:       driver.StorageClassStandard,
+		},
+	}
+	b.blobs[dstKey] = entry
+	b.notifyCreated(dstKey, entry)
+	return nil
+}
+
+// UpdateAttributes implements driver.UpdateAttributes.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v := b.get(key)
+	if v == nil {
+		return errNotFound
+	}
+	if err := checkConditions(v, opts.Conditions); err != nil {
+		return err
+	}
+	if opts.BeforeUpdate != nil {
+		if err := opts.BeforeUpdate(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
+	}
+	// Entries may be shared by copies, so a new one is stored.
+	attrs := *v.Attributes
+	opts.Apply(&attrs)
+	entry := &blobEntry{Content: v.Content, Attributes: &attrs}
+	b.blobs[key] = entry
+	b.notifyCreated(key, entry)
 	return nil
 }
 
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	return nil, errNotImplemented
+}
+
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.Version != "" {
+		return errNotImplemented
+	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.blobs[key] == nil {
+	entry := b.get(key)
+	if entry == nil {
 		return errNotFound
 	}
+	if err := checkConditions(entry, opts.Conditions); err != nil {
+		return err
+	}
 	delete(b.blobs, key)
+	b.notify(&driver.Event{Type: driver.EventDeleted, Key: key})
+	return nil
+}
+
+// DeleteMany implements driver.DeleteMany.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		if b.get(key) == nil {
+			errs[i] = errNotFound
+			continue
+		}
+		delete(b.blobs, key)
+		b.notify(&driver.Event{Type: driver.EventDeleted, Key: key})
+	}
+	return errs, nil
+}
+
+// checkConditions returns errPreconditionFailed if entry, which is nil if
+// there is no blob, does not satisfy conds.
+func checkConditions(entry *blobEntry, conds *driver.Conditions) error {
+	if conds == nil {
+		return nil
+	}
+	if conds.IfNotExist && entry != nil {
+		return errPreconditionFailed
+	}
+	if conds.IfMatch != "" && (entry == nil || entry.Attributes.ETag != conds.IfMatch) {
+		return errPreconditionFailed
+	}
+	if !conds.IfModifiedSince.IsZero() && (entry == nil || !entry.Attributes.ModTime.After(conds.IfModifiedSince)) {
+		return errPreconditionFailed
+	}
 	return nil
 }
 
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	return "", errNotImplemented
 }
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	w := &watcher{
+		b:      b,
+		prefix: opts.Prefix,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.watchers[w] = true
+	return w, nil
+}
+
+// notifyCreated notifies the watchers that entry was written at key.
+// b.mu must be held.
+func (b *bucket) notifyCreated(key string, entry *blobEntry) {
+	b.notify(&driver.Event{
+		Type:    driver.EventCreated,
+		Key:     key,
+		ModTime: entry.Attributes.ModTime,
+		Size:    entry.Attributes.Size,
+		ETag:    entry.Attributes.ETag,
+	})
+}
+
+// notify passes ev to the watchers. b.mu must be held.
+func (b *bucket) notify(ev *driver.Event) {
+	for w := range b.watchers {
+		w.push(ev)
+	}
+}
+
+// watcher receives the changes to a bucket as they are made.
+type watcher struct {
+	b      *bucket
+	prefix string
+
+	mu     sync.Mutex
+	events []*driver.Event
+	// ready is signaled when events are pushed.
+	ready chan struct{}
+	// done is closed by Close.
+	done chan struct{}
+}
+
+func (w *watcher) push(ev *driver.Event) {
+	if !strings.HasPrefix(ev.Key, w.prefix) {
+		return
+	}
+	w.mu.Lock()
+	w.events = append(w.events, ev)
+	w.mu.Unlock()
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	for {
+		w.mu.Lock()
+		evs := w.events
+		w.events = nil
+		w.mu.Unlock()
+		if len(evs) > 0 {
+			return evs, nil
+		}
+		select {
+		case <-w.ready:
+		case <-w.done:
+			return nil, errWatcherClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (w *watcher) As(i interface{}) bool { return false }
+
+func (w *watcher) Close() error {
+	w.b.mu.Lock()
+	delete(w.b.watchers, w)
+	w.b.mu.Unlock()
+	close(w.done)
+	return nil
+}
+
+// uploadEntry holds the parts of an in-progress multipart upload.
+type uploadEntry struct {
+	key   string
+	parts map[int][]byte
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	if key == "" {
+		return nil, errors.New("invalid key (empty string)")
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextUploadID++
+	id := strconv.Itoa(b.nextUploadID)
+	b.uploads[id] = &uploadEntry{key: key, parts: map[int][]byte{}}
+	return &multipartUpload{b: b, id: id, key: key, contentType: contentType, opts: opts}, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if u := b.uploads[uploadID]; u == nil || u.key != key {
+		return nil, errNotFound
+	}
+	return &multipartUpload{b: b, id: uploadID, key: key, contentType: contentType, opts: opts}, nil
+}
+
+type multipartUpload struct {
+	b           *bucket
+	id          string
+	key         string
+	contentType string
+	opts        *driver.WriterOptions
+}
+
+func (u *multipartUpload) ID() string { return u.id }
+
+// entry returns the uploadEntry for u. u.b.mu must be held.
+func (u *multipartUpload) entry() (*uploadEntry, error) {
+	e := u.b.uploads[u.id]
+	if e == nil {
+		return nil, errNotFound
+	}
+	return e, nil
+}
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	u.b.mu.Lock()
+	defer u.b.mu.Unlock()
+
+	e, err := u.entry()
+	if err != nil {
+		return err
+	}
+	e.parts[partNumber] = append([]byte(nil), p...)
+	return nil
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	u.b.mu.Lock()
+	defer u.b.mu.Unlock()
+
+	e, err := u.entry()
+	if err != nil {
+		return nil, err
+	}
+	return e.partNumbers(), nil
+}
+
+// partNumbers returns the numbers of the uploaded parts in ascending order.
+func (e *uploadEntry) partNumbers() []int {
+	var nums []int
+	for n := range e.parts {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	u.b.mu.Lock()
+	e, err := u.entry()
+	if err != nil {
+		u.b.mu.Unlock()
+		return err
+	}
+	nums := e.partNumbers()
+	if len(nums) == 0 {
+		u.b.mu.Unlock()
+		return errors.New("no parts were uploaded")
+	}
+	var buf bytes.Buffer
+	for _, n := range nums {
+		buf.Write(e.parts[n])
+	}
+	u.b.mu.Unlock()
+
+	// BeforeWrite was already called when the upload was created.
+	opts := *u.opts
+	opts.BeforeWrite = nil
+	w, err := u.b.NewTypedWriter(ctx, u.key, u.contentType, &opts)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		// Keep the parts, so that Complete can be retried.
+		return err
+	}
+	u.b.mu.Lock()
+	delete(u.b.uploads, u.id)
+	u.b.mu.Unlock()
+	return nil
+}
+
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	u.b.mu.Lock()
+	defer u.b.mu.Unlock()
+
+	if _, err := u.entry(); err != nil {
+		return err
+	}
+	delete(u.b.uploads, u.id)
+	return nil
+}
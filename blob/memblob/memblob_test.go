@@ -15,13 +15,17 @@
 package memblob
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/eliben/gocdkx/blob"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/gcerrors"
 )
 
 type harness struct{}
@@ -48,6 +52,171 @@ func BenchmarkMemblob(b *testing.B) {
 	drivertest.RunBenchmarks(b, OpenBucket(nil))
 }
 
+func TestExpires(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket(nil)
+	defer b.Close()
+	w, err := b.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	const key = "key"
+	if err := b.WriteAll(ctx, key, []byte("hello"), &blob.WriterOptions{Expires: time.Now().Add(50 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "hello" {
+		t.Fatalf("got %q, %v want %q before the blob expires", got, err, "hello")
+	}
+
+	// The janitor deletes the blob when it expires, which is reported to
+	// watchers.
+	wctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	for {
+		ev, err := w.Next(wctx)
+		if err != nil {
+			t.Fatalf("waiting for the deletion of the expired blob: %v", err)
+		}
+		if ev.Type == blob.EventDeleted {
+			if ev.Key != key {
+				t.Errorf("got deleted event for %q want %q", ev.Key, key)
+			}
+			break
+		}
+	}
+	if _, err := b.ReadAll(ctx, key); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v reading an expired blob, want NotFound", err)
+	}
+	iter := b.List(nil)
+	if obj, err := iter.Next(ctx); err == nil {
+		t.Errorf("got %q from List, want no blobs", obj.Key)
+	}
+}
+
+// TestUpdateAttributesAfterCopy verifies that updating the attributes of a
+// copy doesn't change those of the source, which share its entry.
+func TestUpdateAttributesAfterCopy(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket(nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "src", []byte("hello"), &blob.WriterOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Copy(ctx, "dst", "src", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.UpdateAttributes(ctx, "dst", &blob.UpdateAttributesOptions{Metadata: map[string]string{"k": "updated"}}); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"src": "v", "dst": "updated"} {
+		a, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Metadata["k"]; got != want {
+			t.Errorf("%s: got metadata value %q want %q", key, got, want)
+		}
+	}
+}
+
+// TestUpdateAttributesWatch verifies that UpdateAttributes is reported to
+// watchers.
+func TestUpdateAttributesWatch(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket(nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	w, err := b.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := b.UpdateAttributes(ctx, "key", &blob.UpdateAttributesOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	wctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ev, err := w.Next(wctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != blob.EventCreated || ev.Key != "key" || ev.Size != 5 {
+		t.Errorf("got event %+v want a created event for %q of size 5", *ev, "key")
+	}
+}
+
+func TestAppendAfterWrite(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket(nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "key", []byte(" world"), &blob.WriterOptions{Append: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello world" {
+		t.Errorf("got %q, %v want %q", got, err, "hello world")
+	}
+	after, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ContentType != before.ContentType {
+		t.Errorf("got content type %q want %q", after.ContentType, before.ContentType)
+	}
+	if want := md5.Sum([]byte("hello world")); !bytes.Equal(after.MD5, want[:]) {
+		t.Errorf("got MD5 %x want %x", after.MD5, want)
+	}
+	if after.ETag == before.ETag {
+		t.Errorf("got unchanged ETag %q after appending", after.ETag)
+	}
+}
+
+func TestCompleteRetry(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket(nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+	u, err := b.NewMultipartUpload(ctx, "key", &blob.WriterOptions{Conditions: &blob.Conditions{IfNotExist: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.UploadPart(ctx, 1, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Complete(ctx); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Fatalf("got error %v want FailedPrecondition", err)
+	}
+	// The parts are kept, so Complete can be retried.
+	if parts, err := u.Parts(ctx); err != nil || len(parts) != 1 {
+		t.Fatalf("got parts %v, %v want [1]", parts, err)
+	}
+	if err := b.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Complete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "new" {
+		t.Errorf("got %q, %v want %q", got, err, "new")
+	}
+}
+
 func TestOpenBucketFromURL(t *testing.T) {
 	tests := []struct {
 		URL     string
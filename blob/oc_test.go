@@ -17,11 +17,13 @@ package blob_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/chaosblob"
 	"github.com/eliben/gocdkx/blob/memblob"
 	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/oc"
@@ -98,3 +100,71 @@ func TestOpenCensus(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenCensusAttempts(t *testing.T) {
+	ctx := context.Background()
+	te := octest.NewTestExporter(blob.OpenCensusViews)
+	defer te.Unregister()
+
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	b := chaosblob.OpenBucket(inner, &chaosblob.Options{
+		Faults: map[string]chaosblob.Fault{"Delete": {Nth: 1}},
+	})
+	defer b.Close()
+	b.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	if err := b.WriteAll(ctx, "key", []byte("foo"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The spans of the inner bucket have no attempts.
+	var got []interface{}
+	for _, s := range te.Spans() {
+		if a, ok := s.Attributes["attempts"]; ok && s.Name == "github.com/eliben/gocdkx/blob.Delete" {
+			got = append(got, a)
+		}
+	}
+	if want := []interface{}{int64(2)}; !cmp.Equal(got, want) {
+		t.Errorf("got attempts %v for the Delete spans, want %v", got, want)
+	}
+}
+
+func TestOpenCensusWait(t *testing.T) {
+	ctx := context.Background()
+	te := octest.NewTestExporter(blob.OpenCensusViews)
+	defer te.Unregister()
+
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.SetLimits(&blob.Limits{MaxConcurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Attributes(ctx, "key"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Fatalf("got error %v want NotFound", err)
+	}
+
+	want := map[string]string{
+		oc.ProviderKey.Name(): "github.com/eliben/gocdkx/blob/memblob",
+		oc.MethodKey.Name():   "github.com/eliben/gocdkx/blob.Attributes",
+	}
+	for {
+		data := <-te.Stats
+		if data.View.Name != "github.com/eliben/gocdkx/blob/wait" {
+			continue
+		}
+		got := map[string]string{}
+		for _, tag := range data.Rows[0].Tags {
+			got[tag.Key.Name()] = tag.Value
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("tags: %s", diff)
+		}
+		if dd, ok := data.Rows[0].Data.(*view.DistributionData); !ok || dd.Count != 1 {
+			t.Errorf("got data %#v, want a distribution of 1 wait", data.Rows[0].Data)
+		}
+		break
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/eliben/gocdkx/internal/gcerr"
+)
+
+// RandomAccessReaderOptions sets options for NewRandomAccessReader.
+type RandomAccessReaderOptions struct {
+	// ReadAhead, if positive, is the minimum number of bytes read from the
+	// provider at once. Reads of fewer bytes fetch ReadAhead bytes instead,
+	// and later reads within them are served from memory. This suits
+	// callers that make many small reads close to each other, like
+	// archive/zip. Reads of at least ReadAhead bytes are not buffered.
+	ReadAhead int
+
+	// BeforeRead is a callback that will be called before each read from the
+	// provider; see ReaderOptions.BeforeRead.
+	BeforeRead func(asFunc func(interface{}) bool) error
+}
+
+// RandomAccessReader reads the content of a blob at arbitrary offsets. It
+// implements io.ReaderAt, io.ReadSeeker and io.Closer, reading from the
+// provider with a NewRangeReader call for each read that isn't buffered.
+//
+// ReadAt may be called from several goroutines at once. Read and Seek,
+// which share the current offset, may not.
+type RandomAccessReader struct {
+	b         *Bucket
+	ctx       context.Context // for reads from the provider
+	key       string
+	attrs     *Attributes
+	readAhead int
+	ropts     *ReaderOptions
+
+	mu     sync.Mutex
+	pos    int64  // offset of Read
+	buf    []byte // read ahead content
+	bufOff int64  // offset of buf
+	closed bool
+}
+
+var errRandomAccessReaderClosed = gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: RandomAccessReader has been closed")
+
+// NewRandomAccessReader returns a RandomAccessReader to read the blob stored
+// at key. ctx is used for all the reads from the provider. A nil
+// RandomAccessReaderOptions is treated the same as the zero value.
+//
+// The reader reads the version of the blob that exists when it is created:
+// if the provider reports ETags, reads return an error for which
+// gcerrors.Code will return gcerrors.FailedPrecondition once the blob has
+// changed.
+//
+// If the blob does not exist, NewRandomAccessReader returns an error for
+// which gcerrors.Code will return gcerrors.NotFound.
+//
+// The caller must call Close on the returned reader when done reading.
+func (b *Bucket) NewRandomAccessReader(ctx context.Context, key string, opts *RandomAccessReaderOptions) (*RandomAccessReader, error) {
+	if opts == nil {
+		opts = &RandomAccessReaderOptions{}
+	}
+	if opts.ReadAhead < 0 {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: RandomAccessReaderOptions.ReadAhead must be >= 0 (%d)", opts.ReadAhead)
+	}
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	ropts := &ReaderOptions{BeforeRead: opts.BeforeRead}
+	if attrs.ETag != "" {
+		ropts.Conditions = &Conditions{IfMatch: attrs.ETag}
+	}
+	return &RandomAccessReader{
+		b:         b,
+		ctx:       ctx,
+		key:       key,
+		attrs:     attrs,
+		readAhead: opts.ReadAhead,
+		ropts:     ropts,
+	}, nil
+}
+
+// Size returns the size of the blob content in bytes.
+func (r *RandomAccessReader) Size() int64 {
+	return r.attrs.Size
+}
+
+// ContentType returns the MIME type of the blob.
+func (r *RandomAccessReader) ContentType() string {
+	return r.attrs.ContentType
+}
+
+// ModTime returns the time the blob was last modified.
+func (r *RandomAccessReader) ModTime() time.Time {
+	return r.attrs.ModTime
+}
+
+// ReadAt implements io.ReaderAt (https://golang.org/pkg/io/#ReaderAt).
+func (r *RandomAccessReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ReadAt offset must be non-negative (%d)", off)
+	}
+	n := 0
+	for n < len(p) {
+		if off >= r.attrs.Size {
+			return n, io.EOF
+		}
+		m, err := r.readAt(p[n:], off)
+		n += m
+		off += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readAt reads up to len(p) bytes at off, which is before the end of the
+// blob. It returns fewer bytes if only part of them is buffered.
+func (r *RandomAccessReader) readAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return 0, errRandomAccessReaderClosed
+	}
+	if off >= r.bufOff && off < r.bufOff+int64(len(r.buf)) {
+		n := copy(p, r.buf[off-r.bufOff:])
+		r.mu.Unlock()
+		return n, nil
+	}
+	r.mu.Unlock()
+
+	if rest := r.attrs.Size - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	if len(p) >= r.readAhead {
+		return r.fetch(p, off)
+	}
+	buf := make([]byte, r.readAhead)
+	if rest := r.attrs.Size - off; int64(len(buf)) > rest {
+		buf = buf[:rest]
+	}
+	n, err := r.fetch(buf, off)
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	r.buf, r.bufOff = buf[:n], off
+	r.mu.Unlock()
+	return copy(p, buf[:n]), nil
+}
+
+// fetch reads len(p) bytes at off from the provider.
+func (r *RandomAccessReader) fetch(p []byte, off int64) (int, error) {
+	rr, err := r.b.NewRangeReader(r.ctx, r.key, off, int64(len(p)), r.ropts)
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+	return io.ReadFull(rr, p)
+}
+
+// Read implements io.Reader (https://golang.org/pkg/io/#Reader), reading
+// from the current offset.
+func (r *RandomAccessReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	pos := r.pos
+	r.mu.Unlock()
+	n, err := r.ReadAt(p, pos)
+	r.mu.Lock()
+	r.pos = pos + int64(n)
+	r.mu.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker (https://golang.org/pkg/io/#Seeker). Seeking
+// past the end of the blob is allowed; reads there return io.EOF.
+func (r *RandomAccessReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errRandomAccessReaderClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.attrs.Size
+	default:
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Seek whence is invalid (%d)", whence)
+	}
+	if offset < 0 {
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Seek to a negative offset (%d)", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close implements io.Closer (https://golang.org/pkg/io/#Closer). It
+// releases the read ahead buffer; the reader holds no other resources.
+func (r *RandomAccessReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errRandomAccessReaderClosed
+	}
+	r.closed = true
+	r.buf = nil
+	return nil
+}
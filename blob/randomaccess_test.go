@@ -0,0 +1,170 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+func TestRandomAccessReader(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	if err := b.WriteAll(ctx, "key", content, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, readAhead := range []int{0, 64, 4096} {
+		r, err := b.NewRandomAccessReader(ctx, "key", &blob.RandomAccessReaderOptions{ReadAhead: readAhead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(len(content)) {
+			t.Errorf("readAhead %d: got size %d want %d", readAhead, r.Size(), len(content))
+		}
+		tests := []struct {
+			off     int64
+			length  int
+			want    []byte
+			wantEOF bool
+		}{
+			{0, 10, content[:10], false},
+			{5, 10, content[5:15], false},
+			{500, 100, content[500:600], false},
+			{990, 10, content[990:], false},
+			{995, 10, content[995:], true},
+			{1000, 1, nil, true},
+			{2000, 1, nil, true},
+		}
+		for _, test := range tests {
+			p := make([]byte, test.length)
+			n, err := r.ReadAt(p, test.off)
+			if (err == io.EOF) != test.wantEOF || err != nil && err != io.EOF {
+				t.Errorf("readAhead %d: ReadAt(%d, %d): got error %v, want EOF %v", readAhead, test.length, test.off, err, test.wantEOF)
+			}
+			if !bytes.Equal(p[:n], test.want) {
+				t.Errorf("readAhead %d: ReadAt(%d, %d): got %v want %v", readAhead, test.length, test.off, p[:n], test.want)
+			}
+		}
+
+		// Seek and Read share the current offset.
+		if pos, err := r.Seek(-10, io.SeekEnd); err != nil || pos != 990 {
+			t.Errorf("readAhead %d: Seek got %d, %v want 990", readAhead, pos, err)
+		}
+		if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, content[990:]) {
+			t.Errorf("readAhead %d: got %v, %v want %v", readAhead, got, err, content[990:])
+		}
+		if _, err := r.Seek(-1, io.SeekStart); gcerrors.Code(err) != gcerrors.InvalidArgument {
+			t.Errorf("readAhead %d: got error %v seeking to a negative offset, want InvalidArgument", readAhead, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadAt(make([]byte, 1), 0); err == nil {
+			t.Errorf("readAhead %d: got no error reading after Close", readAhead)
+		}
+	}
+}
+
+func TestRandomAccessReaderZip(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	files := map[string]string{
+		"a.txt":     "hello",
+		"dir/b.txt": "world",
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "archive.zip", buf.Bytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := b.NewRandomAccessReader(ctx, "archive.zip", &blob.RandomAccessReaderOptions{ReadAhead: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Errorf("got %d files want %d", len(zr.File), len(files))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != files[f.Name] {
+			t.Errorf("%s: got %q, %v want %q", f.Name, got, err, files[f.Name])
+		}
+	}
+}
+
+func TestRandomAccessReaderBlobChanged(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewRandomAccessReader(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := b.WriteAll(ctx, "key", []byte("world"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(make([]byte, 5), 0); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v reading a changed blob, want FailedPrecondition", err)
+	}
+
+	if _, err := b.NewRandomAccessReader(ctx, "missing", nil); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v for a missing blob, want NotFound", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replayblob_test
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/replayblob"
+	_ "github.com/eliben/gocdkx/blob/s3blob"
+)
+
+func ExampleOpenReplayer() {
+	ctx := context.Background()
+	record := flag.Bool("record", false, "whether to record the calls to the bucket")
+	flag.Parse()
+
+	// When recording, make the calls to an S3 bucket, and record them in a
+	// file; otherwise, replay them from the file.
+	var bucket *blob.Bucket
+	if *record {
+		inner, err := blob.OpenBucket(ctx, "s3://my-bucket?region=us-west-1")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer inner.Close()
+		if bucket, err = replayblob.OpenRecorder(inner, "testdata/calls.replay", nil); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var err error
+		if bucket, err = replayblob.OpenReplayer("testdata/calls.replay", nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer bucket.Close()
+
+	if err := bucket.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Example_openBucket() {
+	ctx := context.Background()
+
+	// Record the calls to an S3 bucket.
+	bucket, err := blob.OpenBucket(ctx, "record+s3://my-bucket?region=us-west-1&file=testdata%2Fcalls.replay")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+
+	// Replay them.
+	bucket, err = blob.OpenBucket(ctx, "replay://testdata/calls.replay")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
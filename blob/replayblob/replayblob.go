@@ -0,0 +1,1009 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replayblob provides a blob implementation for hermetic tests: it
+// records the calls made to another bucket, such as an s3blob or gcsblob
+// bucket, and their results, to a file, and replays them later without
+// accessing the bucket.
+// Use OpenRecorder to record calls, and OpenReplayer to replay them.
+//
+// Recording and replaying
+//
+// A recorder performs each call on the inner bucket, and records the name of
+// the driver method, its arguments and its result, or the code and message
+// of its error. Calls are written to the file as they complete, as one JSON
+// object per line.
+//
+// A replayer answers each call with the result of the first call in the
+// recording with the same method and arguments that hasn't been replayed
+// yet, so calls with the same arguments are replayed in the order they were
+// recorded. If there is no such call, it returns an error for which
+// gcerrors.Code returns gcerrors.FailedPrecondition. Replaying is
+// deterministic as long as the code under test makes the same calls with the
+// same arguments as when it was recorded: keys, options and condition times
+// must not depend on the current time or randomness. The content written to
+// blobs isn't recorded, only its size.
+//
+// Recorders read the whole range of a blob from the inner bucket when a
+// reader is created, and store it in the recording. Recorded errors are
+// replayed with their code and message, but not their type, so ErrorAs
+// returns false when replaying.
+//
+// URLs
+//
+// For blob.OpenBucket, replayblob registers for the schemes "record" and
+// "replay".
+// To customize the URL opener, or for more details on the URL format,
+// see RecorderURLOpener and ReplayerURLOpener.
+// See https://github.com/eliben/gocdkx/concepts/urls/ for background information.
+//
+// As
+//
+// When recording, replayblob exposes the types of the inner bucket's
+// provider for Bucket.As and Bucket.ErrorAs, and passes them to the
+// BeforeRead, BeforeWrite, BeforeList, BeforeCopy, BeforeCompose and
+// BeforeUpdate callbacks. Nothing else exposes provider-specific types, so
+// that code behaves the same when recording and when replaying, and when
+// replaying, nothing does.
+package replayblob // import "github.com/eliben/gocdkx/blob/replayblob"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/internal/wrapper"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/internal/gcerr"
+)
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(RecordScheme, &RecorderURLOpener{})
+	blob.DefaultURLMux().RegisterBucket(ReplayScheme, &ReplayerURLOpener{})
+	wrapper.RegisterSchemes(RecordScheme, &RecorderURLOpener{})
+}
+
+const (
+	// RecordScheme is the URL scheme replayblob registers its
+	// RecorderURLOpener under on blob.DefaultMux.
+	RecordScheme = "record"
+	// ReplayScheme is the URL scheme replayblob registers its
+	// ReplayerURLOpener under on blob.DefaultMux.
+	ReplayScheme = "replay"
+)
+
+// RecorderURLOpener opens URLs like "record+s3://mybucket?file=calls.replay"
+// or "record://?bucket=s3%3A%2F%2Fmybucket&file=calls.replay", which record
+// the calls made to a bucket.
+//
+// For URLs with a scheme like "record+s3", the inner bucket is opened with
+// RecorderURLOpener.BucketMux from the URL with the scheme "s3", and the
+// query parameters other than "file". These schemes are registered on
+// blob.DefaultMux for the providers in this module: "record+azblob",
+// "record+file", "record+gs", "record+mem" and "record+s3". For URLs with
+// the scheme "record", the query parameter "bucket" is required, and holds
+// the query-escaped URL of the inner bucket, which may use any scheme. The
+// query parameter "file" is required, and holds the name of the file to
+// write the recording to. Closing the returned bucket closes the inner
+// bucket.
+type RecorderURLOpener struct {
+	// BucketMux opens the inner bucket. Defaults to blob.DefaultURLMux().
+	BucketMux *blob.URLMux
+	// Options specifies the options to pass to OpenRecorder.
+	Options Options
+}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *RecorderURLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	q := u.Query()
+	filename := q.Get("file")
+	q.Del("file")
+	if filename == "" {
+		return nil, fmt.Errorf("open bucket %v: query parameter %q is required", u, "file")
+	}
+	var innerURL *url.URL
+	if i := strings.Index(u.Scheme, RecordScheme+"+"); i >= 0 {
+		inner := *u
+		inner.Scheme = u.Scheme[i+len(RecordScheme)+1:]
+		inner.RawQuery = q.Encode()
+		innerURL = &inner
+	} else {
+		s := q.Get("bucket")
+		q.Del("bucket")
+		for param := range q {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+		if s == "" {
+			return nil, fmt.Errorf("open bucket %v: query parameter %q is required", u, "bucket")
+		}
+		var err error
+		if innerURL, err = url.Parse(s); err != nil {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q: %v", u, "bucket", err)
+		}
+	}
+	mux := o.BucketMux
+	if mux == nil {
+		mux = blob.DefaultURLMux()
+	}
+	inner, err := mux.OpenBucketURL(ctx, innerURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: failed to open inner bucket: %v", u, err)
+	}
+	b, err := openRecorder(inner, filename, &o.Options)
+	if err != nil {
+		inner.Close()
+		return nil, fmt.Errorf("open bucket %v: %v", u, err)
+	}
+	b.Owned = true
+	return blob.NewBucket(b), nil
+}
+
+// ReplayerURLOpener opens URLs like "replay:///path/to/calls.replay", which
+// replay the calls recorded in a file.
+//
+// The URL's host and path form the name of the file, so that
+// "replay://testdata/calls.replay" names a file relative to the current
+// directory. No query parameters are supported.
+type ReplayerURLOpener struct {
+	// Options specifies the options to pass to OpenReplayer.
+	Options Options
+}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *ReplayerURLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	for param := range u.Query() {
+		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	}
+	filename := filepath.FromSlash(u.Host + u.Path)
+	if filename == "" {
+		return nil, fmt.Errorf("open bucket %v: the URL must name a file", u)
+	}
+	b, err := OpenReplayer(filename, &o.Options)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %v", u, err)
+	}
+	return b, nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by replayblob.
+type Options struct{}
+
+// OpenRecorder returns a *blob.Bucket that performs its operations on inner,
+// and records them in the file filename, replacing it. Closing the returned
+// bucket closes the file, but doesn't close inner, which must stay open while
+// the returned bucket is in use. A nil Options is treated the same as the
+// zero value.
+func OpenRecorder(inner *blob.Bucket, filename string, opts *Options) (*blob.Bucket, error) {
+	b, err := openRecorder(inner, filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(b), nil
+}
+
+func openRecorder(inner *blob.Bucket, filename string, opts *Options) (*bucket, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &bucket{Bucket: wrapper.New(inner), filename: filename, f: f}, nil
+}
+
+// OpenReplayer returns a *blob.Bucket that replays the calls recorded in the
+// file filename by a bucket returned by OpenRecorder. A nil Options is
+// treated the same as the zero value.
+func OpenReplayer(filename string, opts *Options) (*blob.Bucket, error) {
+	b, err := openReplayer(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(b), nil
+}
+
+func openReplayer(filename string, opts *Options) (*bucket, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var calls []*call
+	dec := json.NewDecoder(f)
+	for {
+		var c call
+		if err := dec.Decode(&c); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("replayblob: reading %s: %v", filename, err)
+		}
+		// Arguments are compared to the compact encoding of those of calls.
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, c.Args); err != nil {
+			return nil, fmt.Errorf("replayblob: reading %s: %v", filename, err)
+		}
+		c.Args = buf.Bytes()
+		calls = append(calls, &c)
+	}
+	return &bucket{filename: filename, calls: calls}, nil
+}
+
+// call is a recorded call to a driver method.
+type call struct {
+	Method string
+	Args   json.RawMessage
+	// Result is the value returned by the method, if any, and if it didn't
+	// fail.
+	Result json.RawMessage `json:",omitempty"`
+	Err    *callError      `json:",omitempty"`
+
+	replayed bool
+}
+
+// callError is a recorded error.
+type callError struct {
+	Code    gcerrors.ErrorCode
+	Message string
+}
+
+// newCallError returns the recorded form of err, an error returned by the
+// inner bucket.
+func (b *bucket) newCallError(err error) *callError {
+	if err == nil {
+		return nil
+	}
+	return &callError{Code: b.ErrorCode(err), Message: err.Error()}
+}
+
+func (e *callError) err() error {
+	if e == nil {
+		return nil
+	}
+	return gcerr.New(e.Code, nil, 1, e.Message)
+}
+
+// bucket implements driver.Bucket. It records the calls made to the driver
+// of the inner bucket, or, if there is none, replays calls.
+type bucket struct {
+	wrapper.Bucket
+	filename string
+
+	mu          sync.Mutex
+	f           *os.File // the recording, when recording
+	calls       []*call  // the recorded calls, when replaying
+	nextWatcher int
+}
+
+// recording reports whether b records calls, rather than replaying them.
+func (b *bucket) recording() bool {
+	return b.Bucket.Bucket != nil
+}
+
+// do performs a call to method. When recording, it calls fn, which performs
+// the call on the inner bucket and stores its result in result, and records
+// the call. When replaying, it stores the result of the matching recorded
+// call in result.
+func (b *bucket) do(method string, args, result interface{}, fn func() error) error {
+	argsData, err := json.Marshal(args)
+	if err != nil {
+		return gcerr.Newf(gcerr.Internal, err, "replayblob: encoding the arguments of %s", method)
+	}
+	if b.recording() {
+		callErr := fn()
+		c := &call{Method: method, Args: argsData, Err: b.newCallError(callErr)}
+		if callErr == nil && result != nil {
+			if c.Result, err = json.Marshal(result); err != nil {
+				return gcerr.Newf(gcerr.Internal, err, "replayblob: encoding the result of %s", method)
+			}
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return gcerr.Newf(gcerr.Internal, err, "replayblob: encoding a call to %s", method)
+		}
+		b.mu.Lock()
+		_, err = b.f.Write(append(data, '\n'))
+		b.mu.Unlock()
+		if err != nil {
+			return gcerr.Newf(gcerr.Internal, err, "replayblob: recording a call to %s", method)
+		}
+		return callErr
+	}
+
+	b.mu.Lock()
+	var c *call
+	for _, rc := range b.calls {
+		if !rc.replayed && rc.Method == method && bytes.Equal(rc.Args, argsData) {
+			c = rc
+			c.replayed = true
+			break
+		}
+	}
+	b.mu.Unlock()
+	if c == nil {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "replayblob: no call to %s(%s) left in %s", method, argsData, b.filename)
+	}
+	if c.Err != nil {
+		return c.Err.err()
+	}
+	if result != nil && len(c.Result) > 0 {
+		if err := json.Unmarshal(c.Result, result); err != nil {
+			return gcerr.Newf(gcerr.Internal, err, "replayblob: decoding the result of %s", method)
+		}
+	}
+	return nil
+}
+
+// noAs is the asFunc passed to callbacks when replaying.
+func noAs(interface{}) bool { return false }
+
+// before calls the callback fn with noAs when replaying. When recording,
+// the inner bucket calls it.
+func (b *bucket) before(fn func(func(interface{}) bool) error) error {
+	if b.recording() || fn == nil {
+		return nil
+	}
+	return fn(noAs)
+}
+
+// ErrorCode implements driver.ErrorCode. Replayed errors carry a code.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if !b.recording() {
+		return gcerrors.Code(err)
+	}
+	return b.Bucket.ErrorCode(err)
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	if !b.recording() {
+		return false
+	}
+	return b.Bucket.As(i)
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	if !b.recording() {
+		return false
+	}
+	return b.Bucket.ErrorAs(err, i)
+}
+
+// attributes is the recorded form of driver.Attributes.
+type attributes struct {
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	ContentType        string
+	Metadata           map[string]string
+	ModTime            time.Time
+	Size               int64
+	MD5                []byte
+	ETag               string
+	Expires            time.Time
+	StorageClass       driver.StorageClass
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	var a attributes
+	err := b.do("Attributes", struct{ Key string }{key}, &a, func() error {
+		ba, err := b.Bucket.Attributes(ctx, key)
+		if err != nil {
+			return err
+		}
+		a = attributes{
+			CacheControl:       ba.CacheControl,
+			ContentDisposition: ba.ContentDisposition,
+			ContentEncoding:    ba.ContentEncoding,
+			ContentLanguage:    ba.ContentLanguage,
+			ContentType:        ba.ContentType,
+			Metadata:           ba.Metadata,
+			ModTime:            ba.ModTime,
+			Size:               ba.Size,
+			MD5:                ba.MD5,
+			ETag:               ba.ETag,
+			Expires:            ba.Expires,
+			StorageClass:       ba.StorageClass,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{
+		CacheControl:       a.CacheControl,
+		ContentDisposition: a.ContentDisposition,
+		ContentEncoding:    a.ContentEncoding,
+		ContentLanguage:    a.ContentLanguage,
+		ContentType:        a.ContentType,
+		Metadata:           a.Metadata,
+		ModTime:            a.ModTime,
+		Size:               a.Size,
+		MD5:                a.MD5,
+		ETag:               a.ETag,
+		Expires:            a.Expires,
+		StorageClass:       a.StorageClass,
+	}, nil
+}
+
+// listObject is the recorded form of driver.ListObject.
+type listObject struct {
+	Key     string
+	ModTime time.Time
+	Size    int64
+	MD5     []byte
+	ETag    string
+	IsDir   bool
+}
+
+// listPage is the recorded form of driver.ListPage.
+type listPage struct {
+	Objects       []listObject
+	NextPageToken []byte
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if err := b.before(opts.BeforeList); err != nil {
+		return nil, err
+	}
+	args := struct {
+		Prefix    string
+		Delimiter string
+		PageSize  int
+		PageToken []byte
+	}{opts.Prefix, opts.Delimiter, opts.PageSize, opts.PageToken}
+	var page listPage
+	err := b.do("ListPaged", args, &page, func() error {
+		dpage, err := b.Bucket.ListPaged(ctx, opts)
+		if err != nil {
+			return err
+		}
+		page.NextPageToken = dpage.NextPageToken
+		for _, obj := range dpage.Objects {
+			page.Objects = append(page.Objects, listObject{
+				Key:     obj.Key,
+				ModTime: obj.ModTime,
+				Size:    obj.Size,
+				MD5:     obj.MD5,
+				ETag:    obj.ETag,
+				IsDir:   obj.IsDir,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dpage := &driver.ListPage{NextPageToken: page.NextPageToken}
+	for _, obj := range page.Objects {
+		dpage.Objects = append(dpage.Objects, &driver.ListObject{
+			Key:     obj.Key,
+			ModTime: obj.ModTime,
+			Size:    obj.Size,
+			MD5:     obj.MD5,
+			ETag:    obj.ETag,
+			IsDir:   obj.IsDir,
+		})
+	}
+	return dpage, nil
+}
+
+// readResult is the recorded result of NewRangeReader.
+type readResult struct {
+	ContentType string
+	ModTime     time.Time
+	Size        int64
+	Content     []byte
+	// ReadErr is the error returned after Content, if reading failed.
+	ReadErr *callError `json:",omitempty"`
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	args := struct {
+		Key            string
+		Offset, Length int64
+		Conditions     *driver.Conditions `json:",omitempty"`
+		Version        string             `json:",omitempty"`
+		Raw            bool               `json:",omitempty"`
+	}{key, offset, length, opts.Conditions, opts.Version, opts.Raw}
+	var res readResult
+	err := b.do("NewRangeReader", args, &res, func() error {
+		r, err := b.Bucket.NewRangeReader(ctx, key, offset, length, opts)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		a := r.Attributes()
+		res.ContentType = a.ContentType
+		res.ModTime = a.ModTime
+		res.Size = a.Size
+		res.Content, err = ioutil.ReadAll(r)
+		res.ReadErr = b.newCallError(err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := b.before(opts.BeforeRead); err != nil {
+		return nil, err
+	}
+	return &reader{
+		r:   bytes.NewReader(res.Content),
+		err: res.ReadErr.err(),
+		attrs: driver.ReaderAttributes{
+			ContentType: res.ContentType,
+			ModTime:     res.ModTime,
+			Size:        res.Size,
+		},
+	}, nil
+}
+
+// reader reads recorded content.
+type reader struct {
+	r     *bytes.Reader
+	err   error // returned once r is read
+	attrs driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && r.err != nil {
+		err = r.err
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return nil
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	return false
+}
+
+// writerOptions is the recorded form of driver.WriterOptions.
+type writerOptions struct {
+	CacheControl       string             `json:",omitempty"`
+	ContentDisposition string             `json:",omitempty"`
+	ContentEncoding    string             `json:",omitempty"`
+	ContentLanguage    string             `json:",omitempty"`
+	ContentMD5         []byte             `json:",omitempty"`
+	Metadata           map[string]string  `json:",omitempty"`
+	Conditions         *driver.Conditions `json:",omitempty"`
+	Expires            time.Time
+	StorageClass       driver.StorageClass `json:",omitempty"`
+	Append             bool                `json:",omitempty"`
+}
+
+func newWriterOptions(opts *driver.WriterOptions) writerOptions {
+	return writerOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentMD5:         opts.ContentMD5,
+		Metadata:           opts.Metadata,
+		Conditions:         opts.Conditions,
+		Expires:            opts.Expires,
+		StorageClass:       opts.StorageClass,
+		Append:             opts.Append,
+	}
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	args := struct {
+		Key         string
+		ContentType string
+		Options     writerOptions
+	}{key, contentType, newWriterOptions(opts)}
+	w := &writer{b: b, key: key}
+	err := b.do("NewTypedWriter", args, nil, func() (err error) {
+		w.w, err = b.Bucket.NewTypedWriter(ctx, key, contentType, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := b.before(opts.BeforeWrite); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// writer writes a blob to the inner bucket, or, when replaying, discards
+// its content.
+type writer struct {
+	b    *bucket
+	key  string
+	w    driver.Writer // nil when replaying
+	size int64
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.w == nil {
+		w.size += int64(len(p))
+		return len(p), nil
+	}
+	n, err := w.w.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *writer) Close() error {
+	args := struct {
+		Key  string
+		Size int64
+	}{w.key, w.size}
+	return w.b.do("Writer.Close", args, nil, func() error {
+		return w.w.Close()
+	})
+}
+
+// Copy implements driver.Copy.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if err := b.before(opts.BeforeCopy); err != nil {
+		return err
+	}
+	args := struct {
+		DstKey, SrcKey string
+		Conditions     *driver.Conditions  `json:",omitempty"`
+		StorageClass   driver.StorageClass `json:",omitempty"`
+	}{dstKey, srcKey, opts.Conditions, opts.StorageClass}
+	return b.do("Copy", args, nil, func() error {
+		return b.Bucket.Copy(ctx, dstKey, srcKey, opts)
+	})
+}
+
+// Compose implements driver.Compose.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	if err := b.before(opts.BeforeCompose); err != nil {
+		return err
+	}
+	args := struct {
+		DstKey             string
+		SrcKeys            []string
+		CacheControl       string             `json:",omitempty"`
+		ContentDisposition string             `json:",omitempty"`
+		ContentEncoding    string             `json:",omitempty"`
+		ContentLanguage    string             `json:",omitempty"`
+		ContentType        string             `json:",omitempty"`
+		Metadata           map[string]string  `json:",omitempty"`
+		Conditions         *driver.Conditions `json:",omitempty"`
+	}{dstKey, srcKeys, opts.CacheControl, opts.ContentDisposition, opts.ContentEncoding, opts.ContentLanguage, opts.ContentType, opts.Metadata, opts.Conditions}
+	return b.do("Compose", args, nil, func() error {
+		return b.Bucket.Compose(ctx, dstKey, srcKeys, opts)
+	})
+}
+
+// UpdateAttributes implements driver.UpdateAttributes.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	if err := b.before(opts.BeforeUpdate); err != nil {
+		return err
+	}
+	args := struct {
+		Key                string
+		CacheControl       *string            `json:",omitempty"`
+		ContentDisposition *string            `json:",omitempty"`
+		ContentEncoding    *string            `json:",omitempty"`
+		ContentLanguage    *string            `json:",omitempty"`
+		ContentType        *string            `json:",omitempty"`
+		Metadata           map[string]string  `json:",omitempty"`
+		DeleteMetadata     []string           `json:",omitempty"`
+		Conditions         *driver.Conditions `json:",omitempty"`
+	}{key, opts.CacheControl, opts.ContentDisposition, opts.ContentEncoding, opts.ContentLanguage, opts.ContentType, opts.Metadata, opts.DeleteMetadata, opts.Conditions}
+	return b.do("UpdateAttributes", args, nil, func() error {
+		return b.Bucket.UpdateAttributes(ctx, key, opts)
+	})
+}
+
+// version is the recorded form of driver.Version.
+type version struct {
+	ID       string
+	ModTime  time.Time
+	Size     int64
+	ETag     string
+	IsLatest bool
+}
+
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
+	var vs []version
+	err := b.do("ListVersions", struct{ Key string }{key}, &vs, func() error {
+		dvs, err := b.Bucket.ListVersions(ctx, key)
+		if err != nil {
+			return err
+		}
+		for _, v := range dvs {
+			vs = append(vs, version{
+				ID:       v.ID,
+				ModTime:  v.ModTime,
+				Size:     v.Size,
+				ETag:     v.ETag,
+				IsLatest: v.IsLatest,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dvs := make([]*driver.Version, len(vs))
+	for i, v := range vs {
+		dvs[i] = &driver.Version{
+			ID:       v.ID,
+			ModTime:  v.ModTime,
+			Size:     v.Size,
+			ETag:     v.ETag,
+			IsLatest: v.IsLatest,
+		}
+	}
+	return dvs, nil
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	args := struct {
+		Key        string
+		Conditions *driver.Conditions `json:",omitempty"`
+		Version    string             `json:",omitempty"`
+	}{key, opts.Conditions, opts.Version}
+	return b.do("Delete", args, nil, func() error {
+		return b.Bucket.Delete(ctx, key, opts)
+	})
+}
+
+// DeleteMany implements driver.DeleteMany.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	var cerrs []*callError
+	err := b.do("DeleteMany", struct{ Keys []string }{keys}, &cerrs, func() error {
+		errs, err := b.Bucket.DeleteMany(ctx, keys)
+		if err != nil {
+			return err
+		}
+		cerrs = make([]*callError, len(errs))
+		for i, err := range errs {
+			cerrs[i] = b.newCallError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(keys))
+	for i := range errs {
+		if i < len(cerrs) {
+			errs[i] = cerrs[i].err()
+		}
+	}
+	return errs, nil
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	args := struct {
+		Key         string
+		Expiry      time.Duration
+		Method      string
+		ContentType string `json:",omitempty"`
+	}{key, opts.Expiry, opts.Method, opts.ContentType}
+	var u string
+	err := b.do("SignedURL", args, &u, func() (err error) {
+		u, err = b.Bucket.SignedURL(ctx, key, opts)
+		return err
+	})
+	return u, err
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	args := struct {
+		Key         string
+		ContentType string
+		Options     writerOptions
+	}{key, contentType, newWriterOptions(opts)}
+	u := &multipartUpload{b: b, key: key}
+	err := b.do("NewMultipartUpload", args, &u.id, func() (err error) {
+		if u.u, err = b.Bucket.NewMultipartUpload(ctx, key, contentType, opts); err != nil {
+			return err
+		}
+		u.id = u.u.ID()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	args := struct {
+		Key         string
+		UploadID    string
+		ContentType string
+		Options     writerOptions
+	}{key, uploadID, contentType, newWriterOptions(opts)}
+	u := &multipartUpload{b: b, key: key, id: uploadID}
+	err := b.do("ResumeMultipartUpload", args, nil, func() (err error) {
+		u.u, err = b.Bucket.ResumeMultipartUpload(ctx, key, uploadID, contentType, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// multipartUpload uploads a blob to the inner bucket, or, when replaying,
+// replays the calls of an upload.
+type multipartUpload struct {
+	b   *bucket
+	key string
+	id  string
+	u   driver.MultipartUpload // nil when replaying
+}
+
+// uploadArgs are the recorded arguments of the methods of multipartUpload.
+type uploadArgs struct {
+	Key, ID    string
+	PartNumber int `json:",omitempty"`
+	Size       int `json:",omitempty"`
+}
+
+func (u *multipartUpload) ID() string {
+	return u.id
+}
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	args := uploadArgs{Key: u.key, ID: u.id, PartNumber: partNumber, Size: len(p)}
+	return u.b.do("MultipartUpload.UploadPart", args, nil, func() error {
+		return u.u.UploadPart(ctx, partNumber, p)
+	})
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	var parts []int
+	err := u.b.do("MultipartUpload.ListParts", uploadArgs{Key: u.key, ID: u.id}, &parts, func() (err error) {
+		parts, err = u.u.ListParts(ctx)
+		return err
+	})
+	return parts, err
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	return u.b.do("MultipartUpload.Complete", uploadArgs{Key: u.key, ID: u.id}, nil, func() error {
+		return u.u.Complete(ctx)
+	})
+}
+
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	return u.b.do("MultipartUpload.Abort", uploadArgs{Key: u.key, ID: u.id}, nil, func() error {
+		return u.u.Abort(ctx)
+	})
+}
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	w := &watcher{b: b}
+	err := b.do("Watch", struct{ Prefix string }{opts.Prefix}, &w.id, func() (err error) {
+		if w.w, err = b.Bucket.Watch(ctx, opts); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.nextWatcher++
+		w.id = b.nextWatcher
+		b.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// event is the recorded form of driver.Event.
+type event struct {
+	Type    driver.EventType
+	Key     string
+	ModTime time.Time
+	Size    int64
+	ETag    string
+}
+
+// watcher reports the events of the inner bucket's Watcher, or, when
+// replaying, recorded events. It implements driver.Watcher.
+type watcher struct {
+	b *bucket
+	// id tells apart the watchers of a recording.
+	id int
+	w  driver.Watcher // nil when replaying
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	var evs []event
+	err := w.b.do("Watcher.Next", struct{ Watcher int }{w.id}, &evs, func() error {
+		devs, err := w.w.Next(ctx)
+		if err != nil {
+			return err
+		}
+		for _, ev := range devs {
+			evs = append(evs, event{
+				Type:    ev.Type,
+				Key:     ev.Key,
+				ModTime: ev.ModTime,
+				Size:    ev.Size,
+				ETag:    ev.ETag,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	devs := make([]*driver.Event, len(evs))
+	for i, ev := range evs {
+		devs[i] = &driver.Event{
+			Type:    ev.Type,
+			Key:     ev.Key,
+			ModTime: ev.ModTime,
+			Size:    ev.Size,
+			ETag:    ev.ETag,
+		}
+	}
+	return devs, nil
+}
+
+// As implements driver.Watcher.As.
+func (w *watcher) As(i interface{}) bool {
+	return false
+}
+
+// Close implements driver.Watcher.Close.
+func (w *watcher) Close() error {
+	if w.w == nil {
+		return nil
+	}
+	return w.w.Close()
+}
+
+// Close implements driver.Close. When recording, it closes the file.
+func (b *bucket) Close() error {
+	if !b.recording() {
+		return nil
+	}
+	err := b.f.Close()
+	if cerr := b.Bucket.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replayblob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/driver"
+	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/blob/memblob"
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+// harness records the calls of a test into files named after it, or replays
+// them.
+type harness struct {
+	inner *blob.Bucket // nil when replaying
+	name  string
+	n     int // number of calls to MakeDriver
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	h.n++
+	filename := fmt.Sprintf("%s-%d.replay", h.name, h.n)
+	if h.inner != nil {
+		return openRecorder(h.inner, filename, nil)
+	}
+	return openReplayer(filename, nil)
+}
+
+func (h *harness) Close() {
+	if h.inner != nil {
+		h.inner.Close()
+	}
+}
+
+// timeDependent lists the conformance tests that pass arguments based on the
+// current time, and so can't be replayed.
+var timeDependent = map[string]bool{
+	"TestConditions/ReadIfModifiedSince":        true,
+	"TestConditions/SatisfiedPreconditionsWork": true,
+	"TestExpires":          true,
+	"TestUpdateAttributes": true,
+}
+
+// TestConformance runs the conformance tests while recording, and then
+// replays the recordings.
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replayblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newHarness := func(record bool) drivertest.HarnessMaker {
+		return func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+			// Drop "TestConformance/Record/" or "TestConformance/Replay/".
+			name := strings.SplitN(t.Name(), "/", 3)[2]
+			if !record && timeDependent[name] {
+				t.Skip("the test depends on the current time")
+			}
+			h := &harness{name: filepath.Join(dir, strings.Replace(name, "/", "_", -1))}
+			if record {
+				h.inner = memblob.OpenBucket(nil)
+			}
+			return h, nil
+		}
+	}
+	t.Run("Record", func(t *testing.T) {
+		drivertest.RunConformanceTests(t, newHarness(true), nil)
+	})
+	t.Run("Replay", func(t *testing.T) {
+		drivertest.RunConformanceTests(t, newHarness(false), nil)
+	})
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "replayblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "calls.replay")
+
+	// run makes the calls of the test, and returns the results of the reads.
+	run := func(b *blob.Bucket) []string {
+		var got []string
+		read := func(key string) {
+			data, err := b.ReadAll(ctx, key)
+			if err != nil {
+				got = append(got, gcerrors.Code(err).String())
+			} else {
+				got = append(got, string(data))
+			}
+		}
+		read("key")
+		if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+		read("key")
+		if err := b.WriteAll(ctx, "key", []byte("world"), nil); err != nil {
+			t.Fatal(err)
+		}
+		read("key")
+		if _, err := b.Attributes(ctx, "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+			t.Errorf("got error %v for a missing blob, want NotFound", err)
+		}
+		return got
+	}
+	want := []string{"NotFound", "hello", "world"}
+
+	inner := memblob.OpenBucket(nil)
+	defer inner.Close()
+	rb, err := OpenRecorder(inner, filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := run(rb); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("recording: got %q want %q", got, want)
+	}
+	if err := rb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying doesn't access the inner bucket.
+	if err := inner.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenReplayer(filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got := run(b); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("replaying: got %q want %q", got, want)
+	}
+	// All the calls have been replayed.
+	if _, err := b.ReadAll(ctx, "key"); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v for a call that wasn't recorded, want FailedPrecondition", err)
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "replayblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "calls.replay")
+
+	b, err := blob.OpenBucket(ctx, "record+mem://?file="+url.QueryEscape(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err = blob.OpenBucket(ctx, "replay://"+filepath.ToSlash(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello" {
+		t.Errorf("got %q, %v want %q", got, err, "hello")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		{"record://?bucket=" + url.QueryEscape("mem://") + "&file=" + url.QueryEscape(filename), false},
+		// Invalid parameter.
+		{"record://?bucket=" + url.QueryEscape("mem://") + "&file=" + url.QueryEscape(filename) + "&param=value", true},
+		// Missing bucket.
+		{"record://?file=" + url.QueryEscape(filename), true},
+		// Missing file.
+		{"record+mem://", true},
+		// Parameter of the inner bucket.
+		{"record+mem://?file=" + url.QueryEscape(filename) + "&param=value", true},
+		// Invalid parameter.
+		{"replay://" + filepath.ToSlash(filename) + "?param=value", true},
+		// Missing file.
+		{"replay://" + filepath.ToSlash(filepath.Join(dir, "missing.replay")), true},
+	}
+	for _, test := range tests {
+		b, err := blob.OpenBucket(ctx, test.URL)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+		if err == nil {
+			b.Close()
+		}
+	}
+}
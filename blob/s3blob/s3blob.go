@@ -38,6 +38,35 @@
 //    experimentation.
 //  - Metadata values: Escaped using URL encoding.
 //
+// Preconditions
+//
+// Preconditions (blob.Conditions) on writes, copies and deletes are sent to S3
+// as If-Match and If-None-Match headers on the requests that create or delete
+// the object. In addition, Delete checks IfMatch against the object's current
+// ETag before deleting it.
+//
+// Expiration
+//
+// blob.WriterOptions.Expires is sent to S3 as the object's Expires header,
+// which is returned in blob.Attributes.Expires, and tells caches not to
+// serve the object after that time. S3 doesn't delete objects when they
+// expire; use a lifecycle rule with an expiration action, for example for
+// the prefix of temporary objects, to delete them. Expired objects can be
+// read until they are deleted.
+//
+// Storage classes
+//
+// blob.StorageClass maps to S3 storage classes as follows:
+//  - StorageClassStandard: STANDARD
+//  - StorageClassInfrequentAccess: STANDARD_IA; ONEZONE_IA is also reported
+//    as StorageClassInfrequentAccess
+//  - StorageClassCold: GLACIER
+//  - StorageClassArchive: DEEP_ARCHIVE
+// Other classes, like INTELLIGENT_TIERING, can be set using
+// WriterOptions.BeforeWrite, and are reported as StorageClassDefault. Objects
+// in the GLACIER and DEEP_ARCHIVE classes must be restored before they can be
+// read; reading them fails until then.
+//
 // As
 //
 // s3blob exposes the following types for As:
@@ -49,11 +78,46 @@
 //  - Reader: s3.GetObjectOutput
 //  - ReaderOptions.BeforeRead: *s3.GetObjectInput
 //  - Attributes: s3.HeadObjectOutput
+//  - Version: s3.ObjectVersion
 //  - CopyOptions.BeforeCopy: *s3.CopyObjectInput
-//  - WriterOptions.BeforeWrite: *s3manager.UploadInput
+//  - WriterOptions.BeforeWrite: *s3manager.UploadInput, or
+//      *s3.CreateMultipartUploadInput for multipart uploads.
+//  - ComposeOptions.BeforeCompose: *s3.CreateMultipartUploadInput
+//  - UpdateAttributesOptions.BeforeUpdate: *s3.CopyObjectInput
+//  - Watcher, Event: the types exposed by Options.NotificationSource; see
+//      package blobpubsub.
+//
+// Compose
+//
+// s3blob composes objects with a multipart upload whose parts are copied from
+// the sources with UploadPartCopy. Since all of the parts but the last one
+// must be at least 5 MiB, sources smaller than that are downloaded and
+// uploaded together with their neighbors. Uploads have at most 10,000 parts.
+//
+// Appending
+//
+// S3 can't append to objects, so writes with blob.WriterOptions.Append set
+// fail with an Unimplemented error.
+//
+// Updating attributes
+//
+// S3 can't change the attributes of an existing object, so UpdateAttributes
+// copies the object onto itself with the new attributes, which changes its
+// modification time. Like Copy, this is limited to objects of at most 5 GB,
+// resets the object's ACL, and creates a new version in versioned buckets.
+//
+// Watch
+//
+// s3blob reports changes using S3 event notifications, received from
+// Options.NotificationSource; Watch returns an Unimplemented error if
+// it is nil. Messages must hold the event notification JSON; awssnssqs
+// unwraps the SNS envelope of notifications delivered through an SNS topic.
+// Each message is delivered to a single Watcher, so each Watcher needs a
+// subscription of its own.
 package s3blob // import "github.com/eliben/gocdkx/blob/s3blob"
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/hex"
@@ -70,6 +134,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -155,6 +220,13 @@ type Options struct {
 	// Some S3-compatible providers (like CEPH) do not currently support
 	// ListObjectsV2.
 	UseLegacyList bool
+
+	// NotificationSource receives the S3 event notifications for the
+	// bucket, typically from an SQS queue subscribed to the SNS topic the
+	// bucket publishes to; see blobpubsub.NewNotificationSource. Required to
+	// use Watch.
+	// See https://docs.aws.amazon.com/AmazonS3/latest/dev/NotificationHowTo.html.
+	NotificationSource driver.NotificationSource
 }
 
 // openBucket returns an S3 Bucket.
@@ -172,6 +244,7 @@ func openBucket(ctx context.Context, sess client.ConfigProvider, bucketName stri
 		name:          bucketName,
 		client:        s3.New(sess),
 		useLegacyList: opts.UseLegacyList,
+		notifications: opts.NotificationSource,
 	}, nil
 }
 
@@ -295,20 +368,30 @@ type bucket struct {
 	name          string
 	client        *s3.S3
 	useLegacyList bool
+	notifications driver.NotificationSource
 }
 
 func (b *bucket) Close() error {
 	return nil
 }
 
+// errNotImplemented is returned for features that s3blob doesn't support
+// with the given Options.
+var errNotImplemented = errors.New("not implemented")
+
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if err == errNotImplemented {
+		return gcerrors.Unimplemented
+	}
 	e, ok := err.(awserr.Error)
 	if !ok {
 		return gcerrors.Unknown
 	}
 	switch {
-	case e.Code() == "NoSuchKey" || e.Code() == "NotFound" || e.Code() == s3.ErrCodeObjectNotInActiveTierError:
+	case e.Code() == "NoSuchKey" || e.Code() == "NotFound" || e.Code() == s3.ErrCodeObjectNotInActiveTierError || e.Code() == s3.ErrCodeNoSuchUpload:
 		return gcerrors.NotFound
+	case e.Code() == "PreconditionFailed" || e.Code() == "NotModified" || e.Code() == "ConditionalRequestConflict":
+		return gcerrors.FailedPrecondition
 	default:
 		return gcerrors.Unknown
 	}
@@ -349,6 +432,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 				ModTime: *obj.LastModified,
 				Size:    *obj.Size,
 				MD5:     eTagToMD5(obj.ETag),
+				ETag:    aws.StringValue(obj.ETag),
 				AsFunc: func(i interface{}) bool {
 					p, ok := i.(*s3.Object)
 					if !ok {
@@ -476,12 +560,9 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		return nil, err
 	}
 
-	md := make(map[string]string, len(resp.Metadata))
-	for k, v := range resp.Metadata {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		md[escape.HexUnescape(escape.URLUnescape(k))] = escape.URLUnescape(aws.StringValue(v))
-	}
+	md := unescapeMetadata(resp.Metadata)
+	// Expires is only reported if it is a valid HTTP date.
+	expires, _ := http.ParseTime(aws.StringValue(resp.Expires))
 	return &driver.Attributes{
 		CacheControl:       aws.StringValue(resp.CacheControl),
 		ContentDisposition: aws.StringValue(resp.ContentDisposition),
@@ -492,6 +573,9 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ModTime:            aws.TimeValue(resp.LastModified),
 		Size:               aws.Int64Value(resp.ContentLength),
 		MD5:                eTagToMD5(resp.ETag),
+		ETag:               aws.StringValue(resp.ETag),
+		Expires:            expires,
+		StorageClass:       fromStorageClass(aws.StringValue(resp.StorageClass)),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*s3.HeadObjectOutput)
 			if !ok {
@@ -519,6 +603,17 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 	} else if length >= 0 {
 		in.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
 	}
+	if opts.Version != "" {
+		in.VersionId = aws.String(opts.Version)
+	}
+	if c := opts.Conditions; c != nil {
+		if c.IfMatch != "" {
+			in.IfMatch = aws.String(c.IfMatch)
+		}
+		if !c.IfModifiedSince.IsZero() {
+			in.IfModifiedSince = aws.Time(c.IfModifiedSince)
+		}
+	}
 	if opts.BeforeRead != nil {
 		asFunc := func(i interface{}) bool {
 			if p, ok := i.(**s3.GetObjectInput); ok {
@@ -531,7 +626,15 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			return nil, err
 		}
 	}
-	resp, err := b.client.GetObjectWithContext(ctx, in)
+	var ropts []request.Option
+	if opts.Raw {
+		// Setting Accept-Encoding prevents the HTTP client from
+		// transparently decompressing gzip content.
+		ropts = append(ropts, func(r *request.Request) {
+			r.HTTPRequest.Header.Set("Accept-Encoding", "identity")
+		})
+	}
+	resp, err := b.client.GetObjectWithContext(ctx, in, ropts...)
 	if err != nil {
 		return nil, err
 	}
@@ -622,27 +725,24 @@ func unescapeKey(key string) string {
 
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.Append {
+		// S3 objects can only be replaced.
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key)
 	uploader := s3manager.NewUploaderWithClient(b.client, func(u *s3manager.Uploader) {
 		if opts.BufferSize != 0 {
 			u.PartSize = int64(opts.BufferSize)
 		}
+		if opts.Conditions != nil {
+			u.RequestOptions = append(u.RequestOptions, withConditions(opts.Conditions))
+		}
 	})
-	md := make(map[string]*string, len(opts.Metadata))
-	for k, v := range opts.Metadata {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		k = escape.HexEscape(url.PathEscape(k), func(runes []rune, i int) bool {
-			c := runes[i]
-			return c == '@' || c == ':' || c == '='
-		})
-		md[k] = aws.String(url.PathEscape(v))
-	}
 	req := &s3manager.UploadInput{
 		Bucket:      aws.String(b.name),
 		ContentType: aws.String(contentType),
 		Key:         aws.String(key),
-		Metadata:    md,
+		Metadata:    escapeMetadata(opts.Metadata),
 	}
 	if opts.CacheControl != "" {
 		req.CacheControl = aws.String(opts.CacheControl)
@@ -659,6 +759,12 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	if len(opts.ContentMD5) > 0 {
 		req.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(opts.ContentMD5))
 	}
+	if !opts.Expires.IsZero() {
+		req.Expires = aws.Time(opts.Expires)
+	}
+	if opts.StorageClass != driver.StorageClassDefault {
+		req.StorageClass = aws.String(storageClasses[opts.StorageClass])
+	}
 	if opts.BeforeWrite != nil {
 		asFunc := func(i interface{}) bool {
 			p, ok := i.(**s3manager.UploadInput)
@@ -680,6 +786,401 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	}, nil
 }
 
+// withConditions returns a request.Option that adds the HTTP precondition
+// headers for conds to requests that create or delete an object. Other
+// requests, like the individual parts of a multipart upload, are unchanged.
+func withConditions(conds *driver.Conditions) request.Option {
+	return func(r *request.Request) {
+		switch r.Operation.Name {
+		case "PutObject", "CompleteMultipartUpload", "CopyObject", "DeleteObject":
+		default:
+			return
+		}
+		if conds.IfNotExist {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		}
+		if conds.IfMatch != "" {
+			r.HTTPRequest.Header.Set("If-Match", conds.IfMatch)
+		}
+	}
+}
+
+// escapeMetadata escapes metadata keys and values for S3.
+func escapeMetadata(metadata map[string]string) map[string]*string {
+	md := make(map[string]*string, len(metadata))
+	for k, v := range metadata {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		k = escape.HexEscape(url.PathEscape(k), func(runes []rune, i int) bool {
+			c := runes[i]
+			return c == '@' || c == ':' || c == '='
+		})
+		md[k] = aws.String(url.PathEscape(v))
+	}
+	return md
+}
+
+// unescapeMetadata unescapes metadata keys and values returned by S3.
+func unescapeMetadata(metadata map[string]*string) map[string]string {
+	md := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		md[escape.HexUnescape(escape.URLUnescape(k))] = escape.URLUnescape(aws.StringValue(v))
+	}
+	return md
+}
+
+const (
+	// minPartSize is the minimum size of the parts of a multipart upload,
+	// except for the last one.
+	minPartSize = 5 * 1024 * 1024
+	// maxCopyPartSize is the maximum size of a part copied with
+	// UploadPartCopy.
+	maxCopyPartSize = 5 * 1024 * 1024 * 1024
+)
+
+// Compose implements driver.Compose using a multipart upload. Sources of at
+// least minPartSize bytes are copied with UploadPartCopy. Smaller ones are
+// downloaded and uploaded along with their neighbors, since all of the parts
+// but the last one must be at least minPartSize bytes.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	// Get the sizes of the sources first, so that missing sources are
+	// reported before the upload is created.
+	srcs := make([]*s3.HeadObjectOutput, len(srcKeys))
+	for i, key := range srcKeys {
+		resp, err := b.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(escapeKey(key)),
+		})
+		if err != nil {
+			return err
+		}
+		srcs[i] = resp
+	}
+	mu, err := b.NewMultipartUpload(ctx, dstKey, opts.ContentType, &driver.WriterOptions{
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		Metadata:           opts.Metadata,
+		BeforeWrite:        opts.BeforeCompose,
+		Conditions:         opts.Conditions,
+	})
+	if err != nil {
+		return err
+	}
+	u := mu.(*multipartUpload)
+	err = u.composeParts(ctx, srcKeys, srcs)
+	if err == nil {
+		err = u.Complete(ctx)
+	}
+	if err != nil {
+		_ = u.Abort(ctx)
+	}
+	return err
+}
+
+// srcRange is a range of the content of a source of Compose.
+type srcRange struct {
+	src            int // index of the source
+	offset, length int64
+}
+
+// composePart is a part of a composed object: either a single range copied
+// with UploadPartCopy, or ranges that are downloaded and uploaded together.
+type composePart struct {
+	copy   bool
+	ranges []srcRange
+}
+
+// planCompose returns the parts of the object composed from sources of the
+// given sizes. All of the parts but the last one are at least minPartSize
+// bytes, and those that are uploaded are less than twice that.
+func planCompose(sizes []int64) []composePart {
+	var parts []composePart
+	var buffered composePart
+	var bufferedSize int64
+	buffer := func(r srcRange) {
+		if r.length == 0 {
+			return
+		}
+		buffered.ranges = append(buffered.ranges, r)
+		bufferedSize += r.length
+		if bufferedSize >= minPartSize {
+			parts = append(parts, buffered)
+			buffered, bufferedSize = composePart{}, 0
+		}
+	}
+	for i, size := range sizes {
+		var offset int64
+		if bufferedSize > 0 || size < minPartSize {
+			need := minPartSize - bufferedSize
+			if size-need < minPartSize {
+				// The rest couldn't be copied, so buffer the whole source.
+				buffer(srcRange{i, 0, size})
+				continue
+			}
+			// Complete the buffered part with the start of the source, and
+			// copy the rest.
+			buffer(srcRange{i, 0, need})
+			offset = need
+		}
+		// Copy the rest of the source in parts of at most maxCopyPartSize
+		// bytes, of about the same size so that none are too small.
+		rest := size - offset
+		n := (rest + maxCopyPartSize - 1) / maxCopyPartSize
+		partSize := (rest + n - 1) / n
+		for ; offset < size; offset += partSize {
+			length := partSize
+			if offset+length > size {
+				length = size - offset
+			}
+			parts = append(parts, composePart{copy: true, ranges: []srcRange{{i, offset, length}}})
+		}
+	}
+	// Upload the rest, or an empty part if all of the sources are empty.
+	if bufferedSize > 0 || len(parts) == 0 {
+		parts = append(parts, buffered)
+	}
+	return parts
+}
+
+// composeParts uploads the contents of the objects at keys, described by
+// srcs, as the parts of u.
+func (u *multipartUpload) composeParts(ctx context.Context, keys []string, srcs []*s3.HeadObjectOutput) error {
+	sizes := make([]int64, len(srcs))
+	for i, src := range srcs {
+		sizes[i] = aws.Int64Value(src.ContentLength)
+	}
+	var buf []byte
+	for i, part := range planCompose(sizes) {
+		partNumber := i + 1
+		if part.copy {
+			r := part.ranges[0]
+			_, err := u.b.client.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+				Bucket:            aws.String(u.b.name),
+				Key:               aws.String(u.key),
+				UploadId:          aws.String(u.id),
+				PartNumber:        aws.Int64(int64(partNumber)),
+				CopySource:        aws.String(u.b.name + "/" + escapeKey(keys[r.src])),
+				CopySourceIfMatch: srcs[r.src].ETag,
+				CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", r.offset, r.offset+r.length-1)),
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		buf = buf[:0]
+		for _, r := range part.ranges {
+			var err error
+			if buf, err = u.appendRange(ctx, buf, escapeKey(keys[r.src]), srcs[r.src].ETag, r.offset, r.length); err != nil {
+				return err
+			}
+		}
+		if err := u.UploadPart(ctx, partNumber, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendRange appends length bytes of the content of the object at key,
+// which is escaped, starting at offset, to buf. The object must have the
+// given ETag.
+func (u *multipartUpload) appendRange(ctx context.Context, buf []byte, key string, etag *string, offset, length int64) ([]byte, error) {
+	resp, err := u.b.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(u.b.name),
+		Key:     aws.String(key),
+		IfMatch: etag,
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	w := bytes.NewBuffer(buf)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// NewMultipartUpload implements driver.NewMultipartUpload.
+func (b *bucket) NewMultipartUpload(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	key = escapeKey(key)
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.name),
+		ContentType: aws.String(contentType),
+		Key:         aws.String(key),
+		Metadata:    escapeMetadata(opts.Metadata),
+	}
+	if opts.CacheControl != "" {
+		in.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		in.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.ContentEncoding != "" {
+		in.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if opts.ContentLanguage != "" {
+		in.ContentLanguage = aws.String(opts.ContentLanguage)
+	}
+	if !opts.Expires.IsZero() {
+		in.Expires = aws.Time(opts.Expires)
+	}
+	if opts.StorageClass != driver.StorageClassDefault {
+		in.StorageClass = aws.String(storageClasses[opts.StorageClass])
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**s3.CreateMultipartUploadInput)
+			if !ok {
+				return false
+			}
+			*p = in
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := b.client.CreateMultipartUploadWithContext(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return &multipartUpload{b: b, key: key, id: aws.StringValue(resp.UploadId), conds: opts.Conditions}, nil
+}
+
+// ResumeMultipartUpload implements driver.ResumeMultipartUpload.
+func (b *bucket) ResumeMultipartUpload(ctx context.Context, key, uploadID, contentType string, opts *driver.WriterOptions) (driver.MultipartUpload, error) {
+	u := &multipartUpload{b: b, key: escapeKey(key), id: uploadID, conds: opts.Conditions}
+	// Check that the upload exists; S3 returns NoSuchUpload otherwise.
+	if _, err := u.listParts(ctx); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// multipartUpload implements driver.MultipartUpload using S3's multipart
+// upload API. The content type and other attributes are set when the upload
+// is created.
+type multipartUpload struct {
+	b     *bucket
+	key   string // escaped
+	id    string
+	conds *driver.Conditions // may be nil
+}
+
+func (u *multipartUpload) ID() string { return u.id }
+
+func (u *multipartUpload) UploadPart(ctx context.Context, partNumber int, p []byte) error {
+	in := &s3.UploadPartInput{
+		Bucket:     aws.String(u.b.name),
+		Key:        aws.String(u.key),
+		UploadId:   aws.String(u.id),
+		PartNumber: aws.Int64(int64(partNumber)),
+		Body:       bytes.NewReader(p),
+	}
+	_, err := u.b.client.UploadPartWithContext(ctx, in)
+	return err
+}
+
+// listParts returns all of the uploaded parts, in ascending order of part
+// number.
+func (u *multipartUpload) listParts(ctx context.Context) ([]*s3.Part, error) {
+	in := &s3.ListPartsInput{
+		Bucket:   aws.String(u.b.name),
+		Key:      aws.String(u.key),
+		UploadId: aws.String(u.id),
+	}
+	var parts []*s3.Part
+	for {
+		resp, err := u.b.client.ListPartsWithContext(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, resp.Parts...)
+		if !aws.BoolValue(resp.IsTruncated) {
+			return parts, nil
+		}
+		in.PartNumberMarker = resp.NextPartNumberMarker
+	}
+}
+
+func (u *multipartUpload) ListParts(ctx context.Context) ([]int, error) {
+	parts, err := u.listParts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nums := make([]int, len(parts))
+	for i, p := range parts {
+		nums[i] = int(aws.Int64Value(p.PartNumber))
+	}
+	return nums, nil
+}
+
+func (u *multipartUpload) Complete(ctx context.Context) error {
+	parts, err := u.listParts(ctx)
+	if err != nil {
+		return err
+	}
+	completed := make([]*s3.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = &s3.CompletedPart{ETag: p.ETag, PartNumber: p.PartNumber}
+	}
+	in := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.b.name),
+		Key:             aws.String(u.key),
+		UploadId:        aws.String(u.id),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	}
+	var ropts []request.Option
+	if u.conds != nil {
+		ropts = append(ropts, withConditions(u.conds))
+	}
+	_, err = u.b.client.CompleteMultipartUploadWithContext(ctx, in, ropts...)
+	return err
+}
+
+func (u *multipartUpload) Abort(ctx context.Context) error {
+	in := &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.b.name),
+		Key:      aws.String(u.key),
+		UploadId: aws.String(u.id),
+	}
+	_, err := u.b.client.AbortMultipartUploadWithContext(ctx, in)
+	return err
+}
+
+// storageClasses maps portable storage classes to S3 storage classes.
+var storageClasses = map[driver.StorageClass]string{
+	driver.StorageClassStandard:         s3.StorageClassStandard,
+	driver.StorageClassInfrequentAccess: s3.StorageClassStandardIa,
+	driver.StorageClassCold:             s3.StorageClassGlacier,
+	driver.StorageClassArchive:          s3.StorageClassDeepArchive,
+}
+
+// fromStorageClass returns the portable storage class for the S3 storage
+// class sc.
+func fromStorageClass(sc string) driver.StorageClass {
+	switch sc {
+	case "", s3.StorageClassStandard:
+		// HeadObject doesn't report the STANDARD class.
+		return driver.StorageClassStandard
+	case s3.StorageClassStandardIa, s3.StorageClassOnezoneIa:
+		return driver.StorageClassInfrequentAccess
+	case s3.StorageClassGlacier:
+		return driver.StorageClassCold
+	case s3.StorageClassDeepArchive:
+		return driver.StorageClassArchive
+	default:
+		return driver.StorageClassDefault
+	}
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
 	dstKey = escapeKey(dstKey)
@@ -689,6 +1190,9 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 		CopySource: aws.String(b.name + "/" + srcKey),
 		Key:        aws.String(dstKey),
 	}
+	if opts.StorageClass != driver.StorageClassDefault {
+		input.StorageClass = aws.String(storageClasses[opts.StorageClass])
+	}
 	if opts.BeforeCopy != nil {
 		asFunc := func(i interface{}) bool {
 			switch v := i.(type) {
@@ -702,30 +1206,222 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 			return err
 		}
 	}
-	_, err := b.client.CopyObjectWithContext(ctx, input)
+	var ropts []request.Option
+	if opts.Conditions != nil {
+		ropts = append(ropts, withConditions(opts.Conditions))
+	}
+	_, err := b.client.CopyObjectWithContext(ctx, input, ropts...)
 	return err
 }
 
-// Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
-	if _, err := b.Attributes(ctx, key); err != nil {
+// UpdateAttributes implements driver.UpdateAttributes by copying the object
+// onto itself, since S3 can't change the attributes of an existing object.
+func (b *bucket) UpdateAttributes(ctx context.Context, key string, opts *driver.UpdateAttributesOptions) error {
+	key = escapeKey(key)
+	in := &s3.HeadObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(key),
+	}
+	if opts.Conditions != nil {
+		in.IfMatch = aws.String(opts.Conditions.IfMatch)
+	}
+	resp, err := b.client.HeadObjectWithContext(ctx, in)
+	if err != nil {
 		return err
 	}
+	a := driver.Attributes{
+		CacheControl:       aws.StringValue(resp.CacheControl),
+		ContentDisposition: aws.StringValue(resp.ContentDisposition),
+		ContentEncoding:    aws.StringValue(resp.ContentEncoding),
+		ContentLanguage:    aws.StringValue(resp.ContentLanguage),
+		ContentType:        aws.StringValue(resp.ContentType),
+		Metadata:           unescapeMetadata(resp.Metadata),
+	}
+	opts.Apply(&a)
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(b.name),
+		CopySource: aws.String(b.name + "/" + key),
+		Key:        aws.String(key),
+		// The copy fails if the object changed since HeadObject, so that
+		// concurrent changes aren't overwritten.
+		CopySourceIfMatch: resp.ETag,
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		ContentType:       aws.String(a.ContentType),
+		Metadata:          escapeMetadata(a.Metadata),
+		// The copy would otherwise get the defaults for these.
+		StorageClass:            resp.StorageClass,
+		ServerSideEncryption:    resp.ServerSideEncryption,
+		SSEKMSKeyId:             resp.SSEKMSKeyId,
+		WebsiteRedirectLocation: resp.WebsiteRedirectLocation,
+	}
+	if a.CacheControl != "" {
+		input.CacheControl = aws.String(a.CacheControl)
+	}
+	if a.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(a.ContentDisposition)
+	}
+	if a.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(a.ContentEncoding)
+	}
+	if a.ContentLanguage != "" {
+		input.ContentLanguage = aws.String(a.ContentLanguage)
+	}
+	if expires, err := http.ParseTime(aws.StringValue(resp.Expires)); err == nil {
+		input.Expires = aws.Time(expires)
+	}
+	if opts.BeforeUpdate != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**s3.CopyObjectInput)
+			if !ok {
+				return false
+			}
+			*p = input
+			return true
+		}
+		if err := opts.BeforeUpdate(asFunc); err != nil {
+			return err
+		}
+	}
+	_, err = b.client.CopyObjectWithContext(ctx, input)
+	return err
+}
+
+// ListVersions implements driver.ListVersions.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.Version, error) {
 	key = escapeKey(key)
+	in := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(b.name),
+		Prefix: aws.String(key),
+	}
+	versions := []*driver.Version{}
+	err := b.client.ListObjectVersionsPagesWithContext(ctx, in, func(resp *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, v := range resp.Versions {
+			if aws.StringValue(v.Key) != key {
+				// Another object with key as a prefix.
+				continue
+			}
+			v := v
+			versions = append(versions, &driver.Version{
+				ID:       aws.StringValue(v.VersionId),
+				ModTime:  aws.TimeValue(v.LastModified),
+				Size:     aws.Int64Value(v.Size),
+				ETag:     aws.StringValue(v.ETag),
+				IsLatest: aws.BoolValue(v.IsLatest),
+				AsFunc: func(i interface{}) bool {
+					p, ok := i.(*s3.ObjectVersion)
+					if !ok {
+						return false
+					}
+					*p = *v
+					return true
+				},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// errPreconditionFailed is returned by Delete when the object's ETag doesn't
+// match Conditions.IfMatch.
+var errPreconditionFailed = awserr.New("PreconditionFailed", "At least one of the preconditions you specified did not hold.", nil)
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	key = escapeKey(key)
+	headIn := &s3.HeadObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(key),
+	}
+	if opts.Version != "" {
+		headIn.VersionId = aws.String(opts.Version)
+	}
+	head, err := b.client.HeadObjectWithContext(ctx, headIn)
+	if err != nil {
+		return err
+	}
+	var ropts []request.Option
+	if c := opts.Conditions; c != nil {
+		if c.IfMatch != aws.StringValue(head.ETag) {
+			return errPreconditionFailed
+		}
+		ropts = append(ropts, withConditions(c))
+	}
 	input := &s3.DeleteObjectInput{
 		Bucket: aws.String(b.name),
 		Key:    aws.String(key),
 	}
-	_, err := b.client.DeleteObjectWithContext(ctx, input)
+	if opts.Version != "" {
+		input.VersionId = aws.String(opts.Version)
+	}
+	_, err = b.client.DeleteObjectWithContext(ctx, input, ropts...)
 	return err
 }
 
+// DeleteMany implements driver.DeleteMany using DeleteObjects.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	// Map escaped keys back to their indexes in keys, which may contain
+	// duplicates.
+	idx := map[string][]int{}
+	var objs []*s3.ObjectIdentifier
+	for i, key := range keys {
+		key = escapeKey(key)
+		if _, ok := idx[key]; !ok {
+			objs = append(objs, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		idx[key] = append(idx[key], i)
+	}
+	out, err := b.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(b.name),
+		Delete: &s3.Delete{
+			Objects: objs,
+			// Only report keys that failed.
+			Quiet: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(keys))
+	for _, e := range out.Errors {
+		err := awserr.New(aws.StringValue(e.Code), aws.StringValue(e.Message), nil)
+		for _, i := range idx[aws.StringValue(e.Key)] {
+			errs[i] = err
+		}
+	}
+	return errs, nil
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	key = escapeKey(key)
-	in := &s3.GetObjectInput{
-		Bucket: aws.String(b.name),
-		Key:    aws.String(key),
+	var req *request.Request
+	switch opts.Method {
+	case http.MethodGet:
+		req, _ = b.client.GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		})
+	case http.MethodPut:
+		in := &s3.PutObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		}
+		// The Content-Type header is included in the signature, so uploads
+		// with a different one are rejected.
+		if opts.ContentType != "" {
+			in.ContentType = aws.String(opts.ContentType)
+		}
+		req, _ = b.client.PutObjectRequest(in)
+	case http.MethodDelete:
+		req, _ = b.client.DeleteObjectRequest(&s3.DeleteObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		})
+	default:
+		return "", fmt.Errorf("unsupported Method %q", opts.Method)
 	}
-	req, _ := b.client.GetObjectRequest(in)
 	return req.Presign(opts.Expiry)
 }
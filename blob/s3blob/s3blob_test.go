@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -28,9 +29,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/blobpubsub"
 	"github.com/eliben/gocdkx/blob/driver"
 	"github.com/eliben/gocdkx/blob/drivertest"
+	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/testing/setup"
+	"github.com/eliben/gocdkx/pubsub"
+	"github.com/eliben/gocdkx/pubsub/mempubsub"
 )
 
 // These constants record the region & bucket used for the last --record.
@@ -203,6 +208,78 @@ func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
 	return nil
 }
 
+func TestStorageClasses(t *testing.T) {
+	for c, sc := range storageClasses {
+		if got := fromStorageClass(sc); got != c {
+			t.Errorf("%v: got %v from S3 storage class %q", c, got, sc)
+		}
+	}
+	// HeadObject doesn't report the STANDARD class.
+	if got := fromStorageClass(""); got != driver.StorageClassStandard {
+		t.Errorf("got %v for an object without a storage class, want %v", got, driver.StorageClassStandard)
+	}
+	if got := fromStorageClass(s3.StorageClassIntelligentTiering); got != driver.StorageClassDefault {
+		t.Errorf("got %v for INTELLIGENT_TIERING, want %v", got, driver.StorageClassDefault)
+	}
+}
+
+func TestPlanCompose(t *testing.T) {
+	const mib = 1024 * 1024
+	tests := []struct {
+		description string
+		sizes       []int64
+		wantCopied  int // number of parts copied with UploadPartCopy
+		wantParts   int
+	}{
+		{"empty sources", []int64{0, 0}, 0, 1},
+		{"small sources", []int64{1, 2, 3}, 0, 1},
+		{"large sources", []int64{6 * mib, 7 * mib}, 2, 2},
+		{"small then large source", []int64{1 * mib, 10 * mib}, 1, 2},
+		{"small then barely large source", []int64{1 * mib, 6 * mib}, 0, 1},
+		{"small sources adding up", []int64{3 * mib, 3 * mib, 3 * mib}, 0, 2},
+		{"source larger than a copied part", []int64{12 * 1024 * mib}, 3, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			parts := planCompose(test.sizes)
+			copied := 0
+			next := make([]int64, len(test.sizes)) // next offset, by source
+			for i, part := range parts {
+				var size int64
+				for _, r := range part.ranges {
+					if r.offset != next[r.src] {
+						t.Fatalf("part %d: got offset %d for source %d, want %d", i, r.offset, r.src, next[r.src])
+					}
+					if r.src > 0 && next[r.src-1] != test.sizes[r.src-1] {
+						t.Fatalf("part %d: source %d starts before source %d is complete", i, r.src, r.src-1)
+					}
+					next[r.src] += r.length
+					size += r.length
+				}
+				if part.copy {
+					copied++
+					if size > maxCopyPartSize {
+						t.Errorf("part %d: copied %d bytes, more than %d", i, size, int64(maxCopyPartSize))
+					}
+				} else if size >= 2*minPartSize {
+					t.Errorf("part %d: uploaded %d bytes, want less than %d", i, size, 2*minPartSize)
+				}
+				if i < len(parts)-1 && size < minPartSize {
+					t.Errorf("part %d: got %d bytes, want at least %d", i, size, minPartSize)
+				}
+			}
+			for i, size := range test.sizes {
+				if next[i] != size {
+					t.Errorf("source %d: got %d bytes in parts, want %d", i, next[i], size)
+				}
+			}
+			if copied != test.wantCopied || len(parts) != test.wantParts {
+				t.Errorf("got %d parts, %d copied; want %d, %d copied", len(parts), copied, test.wantParts, test.wantCopied)
+			}
+		})
+	}
+}
+
 func TestOpenBucket(t *testing.T) {
 	tests := []struct {
 		description string
@@ -277,3 +354,74 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+	b := blob.NewBucket(&bucket{name: "mybucket", notifications: blobpubsub.NewNotificationSource(sub)})
+	defer b.Close()
+	w, err := b.Watch(ctx, &blob.WatchOptions{Prefix: "dir/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	send := func(body string) {
+		t.Helper()
+		if err := topic.Send(ctx, &pubsub.Message{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record := func(eventName, bucket, key string) string {
+		return fmt.Sprintf(`{"eventName": %q, "eventTime": "2019-06-01T12:00:00.000Z", "s3": {"bucket": {"name": %q}, "object": {"key": %q, "size": 11, "eTag": "abc"}}}`, eventName, bucket, key)
+	}
+	// Ignored: test event, malformed body, other bucket, other prefix,
+	// unrelated event.
+	send(`{"Service": "Amazon S3", "Event": "s3:TestEvent", "Bucket": "mybucket"}`)
+	send("not json")
+	send(`{"Records": [` + record("ObjectCreated:Put", "other", "dir/a") + `]}`)
+	send(`{"Records": [` + record("ObjectCreated:Put", "mybucket", "other/a") + `]}`)
+	send(`{"Records": [` + record("ObjectRestore:Completed", "mybucket", "dir/a") + `]}`)
+	// Reported.
+	send(`{"Records": [` + record("ObjectCreated:Put", "mybucket", "dir/a+b") + `]}`)
+	send(`{"Records": [` + record("ObjectRemoved:Delete", "mybucket", "dir/c%2Bd__0x0a__") + `]}`)
+
+	// mempubsub doesn't preserve the order of messages, so the events are
+	// compared by key.
+	want := map[string]blob.Event{
+		"dir/a b":   {Type: blob.EventCreated, Key: "dir/a b", ModTime: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC), Size: 11, ETag: `"abc"`},
+		"dir/c+d\n": {Type: blob.EventDeleted, Key: "dir/c+d\n"},
+	}
+	for range want {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		got, err := w.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := want[got.Key]
+		if got.Type != want.Type || got.Key != want.Key || !got.ModTime.Equal(want.ModTime) || got.Size != want.Size || got.ETag != want.ETag {
+			t.Errorf("got event %+v want %+v", *got, want)
+		}
+		var m *pubsub.Message
+		if !got.As(&m) || len(m.Body) == 0 {
+			t.Error("Event.As failed to get *pubsub.Message")
+		}
+	}
+	var s *pubsub.Subscription
+	if !w.As(&s) || s != sub {
+		t.Error("Watcher.As failed to get *pubsub.Subscription")
+	}
+}
+
+func TestWatchWithoutSubscription(t *testing.T) {
+	ctx := context.Background()
+	b := blob.NewBucket(&bucket{name: "mybucket"})
+	defer b.Close()
+	if _, err := b.Watch(ctx, nil); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("got error %v want Unimplemented", err)
+	}
+}
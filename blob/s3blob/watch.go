@@ -0,0 +1,144 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3blob
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eliben/gocdkx/blob/driver"
+)
+
+// Watch implements driver.Watch.
+func (b *bucket) Watch(ctx context.Context, opts *driver.WatchOptions) (driver.Watcher, error) {
+	if b.notifications == nil {
+		return nil, errNotImplemented
+	}
+	return &watcher{b: b, prefix: opts.Prefix, done: make(chan struct{})}, nil
+}
+
+// watcher implements driver.Watcher by receiving S3 event notifications.
+type watcher struct {
+	b      *bucket
+	prefix string
+	// done is closed by Close.
+	done chan struct{}
+}
+
+// Next implements driver.Watcher.Next.
+func (w *watcher) Next(ctx context.Context) ([]*driver.Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		m, err := w.b.notifications.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Notifications that can't be parsed will never be, so they are
+		// dropped like the ones for other buckets.
+		evs, err := parseNotification(m, w.b.name)
+		m.Ack()
+		if err != nil {
+			continue
+		}
+		var matching []*driver.Event
+		for _, ev := range evs {
+			if strings.HasPrefix(ev.Key, w.prefix) {
+				matching = append(matching, ev)
+			}
+		}
+		if len(matching) > 0 {
+			return matching, nil
+		}
+	}
+}
+
+// notification is the body of an S3 event notification.
+// See https://docs.aws.amazon.com/AmazonS3/latest/dev/notification-content-structure.html.
+type notification struct {
+	Records []struct {
+		EventName string
+		EventTime time.Time
+		S3        struct {
+			Bucket struct {
+				Name string
+			}
+			Object struct {
+				Key  string
+				Size int64
+				ETag string
+			}
+		}
+	}
+}
+
+// parseNotification returns the events described by an S3 event
+// notification for the bucket named bucketName.
+func parseNotification(m *driver.Notification, bucketName string) ([]*driver.Event, error) {
+	var n notification
+	if err := json.Unmarshal(m.Body, &n); err != nil {
+		return nil, err
+	}
+	var evs []*driver.Event
+	for _, r := range n.Records {
+		if r.S3.Bucket.Name != bucketName {
+			continue
+		}
+		// Keys are URL-encoded, with spaces encoded as "+".
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return nil, err
+		}
+		ev := &driver.Event{Key: unescapeKey(key), AsFunc: m.AsFunc}
+		switch {
+		case strings.HasPrefix(r.EventName, "ObjectCreated:"):
+			ev.Type = driver.EventCreated
+			ev.ModTime = r.EventTime
+			ev.Size = r.S3.Object.Size
+			// HeadObject reports quoted ETags.
+			if r.S3.Object.ETag != "" {
+				ev.ETag = `"` + r.S3.Object.ETag + `"`
+			}
+		case strings.HasPrefix(r.EventName, "ObjectRemoved:"):
+			ev.Type = driver.EventDeleted
+		default:
+			continue
+		}
+		evs = append(evs, ev)
+	}
+	return evs, nil
+}
+
+// As implements driver.Watcher.As.
+func (w *watcher) As(i interface{}) bool {
+	return w.b.notifications.As(i)
+}
+
+// Close implements driver.Watcher.Close. It doesn't close the notification
+// source.
+func (w *watcher) Close() error {
+	close(w.done)
+	return nil
+}
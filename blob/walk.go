@@ -0,0 +1,211 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/eliben/gocdkx/internal/gcerr"
+)
+
+// SkipDir can be returned by a WalkFunc to skip a "directory". Returned for a
+// ListObject with IsDir set, Walk doesn't descend into the directory;
+// returned for a blob, Walk skips the rest of the directory holding it.
+// SkipDir is never returned by Walk.
+var SkipDir = errors.New("blob: skip this directory")
+
+// WalkFunc is the type of the function called by Walk for each blob and
+// "directory". ctx is canceled once the walk fails. If the function returns
+// an error other than SkipDir, the walk stops and Walk returns the error.
+type WalkFunc func(ctx context.Context, obj *ListObject) error
+
+// walkConcurrency is the default number of directories that Walk lists
+// concurrently.
+const walkConcurrency = 10
+
+// WalkOptions sets options for Walk.
+type WalkOptions struct {
+	// Prefix indicates that only blobs with a key starting with this prefix
+	// should be walked.
+	Prefix string
+	// Delimiter separates the "directories" of the hierarchical namespace,
+	// as for ListOptions.Delimiter. Defaults to "/".
+	Delimiter string
+
+	// Glob, if not empty, is a pattern in the syntax of path.Match; only
+	// blobs whose whole key matches it are passed to the WalkFunc. As in
+	// path.Match, "*" doesn't match "/".
+	Glob string
+	// Regexp, if not nil, only passes blobs whose key it matches to the
+	// WalkFunc. If both Glob and Regexp are set, keys must match both.
+	//
+	// Filters apply to blobs only: "directories" are always passed to the
+	// WalkFunc, which can return SkipDir for those that can't hold matches.
+	Regexp *regexp.Regexp
+
+	// Concurrency is the maximum number of directories listed at the same
+	// time. Defaults to 10; 1 walks the bucket sequentially.
+	Concurrency int
+
+	// BeforeList is a callback that will be called before each call to the
+	// the underlying provider's list functionality; see
+	// ListOptions.BeforeList.
+	BeforeList func(asFunc func(interface{}) bool) error
+}
+
+// Walk walks the blobs with keys beginning with opts.Prefix, descending into
+// the "directories" of the hierarchical namespace defined by opts.Delimiter,
+// and calls fn for each blob and directory. A nil WalkOptions is treated the
+// same as the zero value.
+//
+// Directories are listed concurrently, so fn may be called from several
+// goroutines at once. The entries of each directory are passed to fn in
+// the order they are listed, and a directory is passed to fn before its
+// entries, but there is no order between directories.
+func (b *Bucket) Walk(ctx context.Context, opts *WalkOptions, fn WalkFunc) (err error) {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+	if !utf8.ValidString(opts.Prefix) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WalkOptions.Prefix must be a valid UTF-8 string: %q", opts.Prefix)
+	}
+	if !utf8.ValidString(opts.Delimiter) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WalkOptions.Delimiter must be a valid UTF-8 string: %q", opts.Delimiter)
+	}
+	if _, err := path.Match(opts.Glob, ""); err != nil {
+		return gcerr.Newf(gcerr.InvalidArgument, err, "blob: WalkOptions.Glob is not a valid pattern: %q", opts.Glob)
+	}
+	if opts.Concurrency < 0 {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WalkOptions.Concurrency must be >= 0 (%d)", opts.Concurrency)
+	}
+	if fn == nil {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Walk fn must not be nil")
+	}
+	ctx = b.tracer.Start(ctx, "Walk")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &walker{b: b, opts: opts, fn: fn, cancel: cancel, delimiter: opts.Delimiter}
+	if w.delimiter == "" {
+		w.delimiter = "/"
+	}
+	n := opts.Concurrency
+	if n == 0 {
+		n = walkConcurrency
+	}
+	// The calling goroutine lists directories too.
+	w.sem = make(chan struct{}, n-1)
+	w.fail(w.walk(ctx, opts.Prefix))
+	w.wg.Wait()
+	return w.err
+}
+
+// walker holds the state of a call to Walk.
+type walker struct {
+	b         *Bucket
+	opts      *WalkOptions
+	fn        WalkFunc
+	cancel    func()
+	delimiter string
+	// sem limits the number of goroutines listing directories besides the
+	// one calling Walk.
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // the first error
+}
+
+// fail records err, if it's the first error, and cancels the walk.
+func (w *walker) fail(err error) {
+	if err == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+// match reports whether key matches the filters of the walk.
+func (w *walker) match(key string) bool {
+	if w.opts.Glob != "" {
+		if ok, _ := path.Match(w.opts.Glob, key); !ok {
+			return false
+		}
+	}
+	return w.opts.Regexp == nil || w.opts.Regexp.MatchString(key)
+}
+
+// walk walks the directory prefix. Subdirectories are walked by new
+// goroutines while there are fewer than the maximum, and by the current one
+// otherwise.
+func (w *walker) walk(ctx context.Context, prefix string) error {
+	iter := w.b.List(&ListOptions{Prefix: prefix, Delimiter: w.delimiter, BeforeList: w.opts.BeforeList})
+	for {
+		// Some providers don't check ctx, so stop on errors elsewhere in
+		// the walk here.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !obj.IsDir {
+			if !w.match(obj.Key) {
+				continue
+			}
+			if err := w.fn(ctx, obj); err == SkipDir {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.fn(ctx, obj); err == SkipDir {
+			continue
+		} else if err != nil {
+			return err
+		}
+		select {
+		case w.sem <- struct{}{}:
+			w.wg.Add(1)
+			go func(prefix string) {
+				defer func() {
+					<-w.sem
+					w.wg.Done()
+				}()
+				w.fail(w.walk(ctx, prefix))
+			}(obj.Key)
+		default:
+			if err := w.walk(ctx, obj.Key); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -18,6 +18,7 @@ package gcerrors
 
 import (
 	"context"
+	"strings"
 
 	"github.com/eliben/gocdkx/internal/gcerr"
 	"golang.org/x/xerrors"
@@ -89,3 +90,14 @@ func Code(err error) ErrorCode {
 	}
 	return Unknown
 }
+
+// ParseCode returns the ErrorCode whose name is s, like "NotFound", ignoring
+// case. It reports false if s doesn't name a code; "OK" isn't accepted.
+func ParseCode(s string) (ErrorCode, bool) {
+	for c := Unknown; c <= DeadlineExceeded; c++ {
+		if strings.EqualFold(c.String(), s) {
+			return c, true
+		}
+	}
+	return 0, false
+}
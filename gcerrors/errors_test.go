@@ -50,3 +50,24 @@ func TestCode(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCode(t *testing.T) {
+	for _, test := range []struct {
+		in     string
+		want   ErrorCode
+		wantOK bool
+	}{
+		{"NotFound", NotFound, true},
+		{"notfound", NotFound, true},
+		{"Unknown", Unknown, true},
+		{"DeadlineExceeded", DeadlineExceeded, true},
+		{"OK", 0, false},
+		{"", 0, false},
+		{"Oops", 0, false},
+	} {
+		got, ok := ParseCode(test.in)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("ParseCode(%q) = %v, %t, want %v, %t", test.in, got, ok, test.want, test.wantOK)
+		}
+	}
+}
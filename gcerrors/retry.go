@@ -0,0 +1,75 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcerrors
+
+import "time"
+
+// A RetryPolicy controls how the portable types (blob.Bucket, pubsub.Topic,
+// pubsub.Subscription, secrets.Keeper and runtimevar.Variable) retry the
+// calls to their provider that fail.
+//
+// A call is retried if it fails with an error whose code is in Codes, and
+// it has been made fewer than MaxAttempts times. Between attempts, the
+// portable type sleeps for a duration that starts at InitialBackoff, and
+// grows by Multiplier up to MaxBackoff, with jitter. Retries stop early when
+// the context of the call is done.
+//
+// The URL openers of the portable types set a RetryPolicy from the following
+// query parameters, which they remove from the URL:
+//   - retry_max_attempts: Sets MaxAttempts.
+//   - retry_initial_backoff: Sets InitialBackoff, in the format accepted by
+//       time.ParseDuration, e.g. "100ms".
+//   - retry_max_backoff: Sets MaxBackoff.
+//   - retry_multiplier: Sets Multiplier.
+//   - retry_codes: A comma-separated list of the names of the codes to
+//       retry, e.g. "Internal,Unknown". Sets Codes.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a call, including the
+	// first one. If it is 0, calls are retried until their context is done.
+	MaxAttempts int
+
+	// InitialBackoff is the pause after the first failed attempt.
+	// Defaults to 1 second.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum pause between two attempts.
+	// Defaults to 30 seconds.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the pause grows after each attempt.
+	// It must be at least 1. Defaults to 2.
+	Multiplier float64
+
+	// Codes are the codes of the errors to retry.
+	// Defaults to Internal and ResourceExhausted.
+	Codes []ErrorCode
+}
+
+// defaultRetryCodes are the codes retried when RetryPolicy.Codes is empty.
+var defaultRetryCodes = []ErrorCode{Internal, ResourceExhausted}
+
+// Retries reports whether p retries the errors with code c.
+func (p *RetryPolicy) Retries(c ErrorCode) bool {
+	codes := p.Codes
+	if len(codes) == 0 {
+		codes = defaultRetryCodes
+	}
+	for _, code := range codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcerrors
+
+import "testing"
+
+func TestRetries(t *testing.T) {
+	for _, test := range []struct {
+		codes []ErrorCode
+		in    ErrorCode
+		want  bool
+	}{
+		{nil, Internal, true},
+		{nil, ResourceExhausted, true},
+		{nil, NotFound, false},
+		{nil, Unknown, false},
+		{[]ErrorCode{Unknown, DeadlineExceeded}, Unknown, true},
+		{[]ErrorCode{Unknown, DeadlineExceeded}, DeadlineExceeded, true},
+		{[]ErrorCode{Unknown, DeadlineExceeded}, Internal, false},
+	} {
+		p := &RetryPolicy{Codes: test.codes}
+		if got := p.Retries(test.in); got != test.want {
+			t.Errorf("%v: Retries(%v) = %t, want %t", test.codes, test.in, got, test.want)
+		}
+	}
+}
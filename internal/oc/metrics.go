@@ -38,6 +38,9 @@ var (
 	MethodKey   = mustKey("gocdk_method")
 	StatusKey   = mustKey("gocdk_status")
 	ProviderKey = mustKey("gocdk_provider")
+	// CacheResultKey tags the measurements of the measure returned by
+	// CacheMeasure with the outcome: "hit", "miss" or "eviction".
+	CacheResultKey = mustKey("gocdk_cache_result")
 )
 
 func mustKey(name string) tag.Key {
@@ -67,3 +70,49 @@ func Views(pkg string, latencyMeasure *stats.Float64Measure) []*view.View {
 		},
 	}
 }
+
+// WaitMeasure returns the measure for the time that method calls wait
+// before they are made, for example to respect a rate limit, used by Go CDK
+// APIs.
+func WaitMeasure(pkg string) *stats.Float64Measure {
+	return stats.Float64(
+		pkg+"/wait",
+		"Time waited before method call",
+		stats.UnitMilliseconds)
+}
+
+// WaitViews returns the views for the measure returned by WaitMeasure.
+func WaitViews(pkg string, waitMeasure *stats.Float64Measure) []*view.View {
+	return []*view.View{
+		{
+			Name:        pkg + "/wait",
+			Measure:     waitMeasure,
+			Description: "Distribution of the time waited before method calls, by provider and method.",
+			TagKeys:     []tag.Key{ProviderKey, MethodKey},
+			Aggregation: ocgrpc.DefaultMillisecondsDistribution,
+		},
+	}
+}
+
+// CacheMeasure returns the measure for the reads served from a cache (hits)
+// or not (misses), and the entries evicted from a cache, used by Go CDK
+// APIs. Measurements are tagged with CacheResultKey.
+func CacheMeasure(pkg string) *stats.Int64Measure {
+	return stats.Int64(
+		pkg+"/cache",
+		"Cache hits, misses and evictions",
+		stats.UnitDimensionless)
+}
+
+// CacheViews returns the views for the measure returned by CacheMeasure.
+func CacheViews(pkg string, cacheMeasure *stats.Int64Measure) []*view.View {
+	return []*view.View{
+		{
+			Name:        pkg + "/cache",
+			Measure:     cacheMeasure,
+			Description: "Count of cache hits, misses and evictions, by provider and result.",
+			TagKeys:     []tag.Key{ProviderKey, CacheResultKey},
+			Aggregation: view.Count(),
+		},
+	}
+}
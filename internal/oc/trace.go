@@ -79,3 +79,9 @@ func (t *Tracer) End(ctx context.Context, err error) {
 	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(StatusKey, fmt.Sprint(code))},
 		t.LatencyMeasure.M(float64(elapsed.Nanoseconds())/1e6)) // milliseconds
 }
+
+// SetAttempts records on the span of ctx the number of attempts made by a
+// retried call.
+func SetAttempts(ctx context.Context, n int) {
+	trace.FromContext(ctx).AddAttributes(trace.Int64Attribute("attempts", int64(n)))
+}
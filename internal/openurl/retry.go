@@ -0,0 +1,83 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openurl
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eliben/gocdkx/gcerrors"
+)
+
+// RetryPolicyFromURL returns the retry policy configured by the query
+// parameters of u described in gcerrors.RetryPolicy, or nil if u has none of
+// them, and a copy of u without them.
+func RetryPolicyFromURL(u *url.URL) (*gcerrors.RetryPolicy, *url.URL, error) {
+	q := u.Query()
+	var p gcerrors.RetryPolicy
+	found := false
+	for _, param := range []string{"retry_max_attempts", "retry_initial_backoff", "retry_max_backoff", "retry_multiplier", "retry_codes"} {
+		if _, ok := q[param]; !ok {
+			continue
+		}
+		s := q.Get(param)
+		found = true
+		q.Del(param)
+		var err error
+		switch param {
+		case "retry_max_attempts":
+			p.MaxAttempts, err = strconv.Atoi(s)
+			if err == nil && p.MaxAttempts < 0 {
+				err = fmt.Errorf("negative value")
+			}
+		case "retry_initial_backoff":
+			p.InitialBackoff, err = time.ParseDuration(s)
+		case "retry_max_backoff":
+			p.MaxBackoff, err = time.ParseDuration(s)
+		case "retry_multiplier":
+			p.Multiplier, err = strconv.ParseFloat(s, 64)
+			if err == nil && p.Multiplier < 1 {
+				err = fmt.Errorf("less than 1")
+			}
+		case "retry_codes":
+			p.Codes, err = parseCodes(s)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid query parameter %q: %q", param, s)
+		}
+	}
+	if !found {
+		return nil, u, nil
+	}
+	u2 := *u
+	u2.RawQuery = q.Encode()
+	return &p, &u2, nil
+}
+
+// parseCodes parses a comma-separated list of error code names.
+func parseCodes(s string) ([]gcerrors.ErrorCode, error) {
+	var codes []gcerrors.ErrorCode
+	for _, name := range strings.Split(s, ",") {
+		c, ok := gcerrors.ParseCode(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown code %q", name)
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
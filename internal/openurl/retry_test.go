@@ -0,0 +1,76 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openurl_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/eliben/gocdkx/gcerrors"
+	"github.com/eliben/gocdkx/internal/openurl"
+)
+
+func TestRetryPolicyFromURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+		want    *gcerrors.RetryPolicy
+		wantURL string
+	}{
+		{"foo://a?p=v", false, nil, "foo://a?p=v"},
+		{"foo://a?p=v&retry_max_attempts=3", false, &gcerrors.RetryPolicy{MaxAttempts: 3}, "foo://a?p=v"},
+		{
+			"foo://a?retry_initial_backoff=10ms&retry_max_backoff=1s&retry_multiplier=1.5&retry_codes=Unknown,internal",
+			false,
+			&gcerrors.RetryPolicy{
+				InitialBackoff: 10 * time.Millisecond,
+				MaxBackoff:     time.Second,
+				Multiplier:     1.5,
+				Codes:          []gcerrors.ErrorCode{gcerrors.Unknown, gcerrors.Internal},
+			},
+			"foo://a",
+		},
+		{"foo://a?retry_max_attempts=x", true, nil, ""},
+		{"foo://a?retry_max_attempts=-1", true, nil, ""},
+		{"foo://a?retry_initial_backoff=x", true, nil, ""},
+		{"foo://a?retry_max_backoff=x", true, nil, ""},
+		{"foo://a?retry_multiplier=0.5", true, nil, ""},
+		{"foo://a?retry_codes=Internal,Oops", true, nil, ""},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, gotURL, err := openurl.RetryPolicyFromURL(u)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.url, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: got policy diff %s", test.url, diff)
+		}
+		if gotURL.String() != test.wantURL {
+			t.Errorf("%s: got URL %q want %q", test.url, gotURL, test.wantURL)
+		}
+		if u.String() != test.url {
+			t.Errorf("%s: the URL was modified to %q", test.url, u)
+		}
+	}
+}
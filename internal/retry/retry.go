@@ -40,23 +40,36 @@ func Call(ctx context.Context, bo gax.Backoff, isRetryable func(error) bool, f f
 	return call(ctx, bo, isRetryable, f, gax.Sleep)
 }
 
+// CallAttempts is like Call, but if maxAttempts is positive, it calls f at most
+// maxAttempts times, and returns the last error returned by f when f fails
+// maxAttempts times. It also returns the number of times f was called.
+func CallAttempts(ctx context.Context, maxAttempts int, bo gax.Backoff, isRetryable func(error) bool, f func() error) (int, error) {
+	return callAttempts(ctx, maxAttempts, bo, isRetryable, f, gax.Sleep)
+}
+
 // Split out for testing.
 func call(ctx context.Context, bo gax.Backoff, isRetryable func(error) bool, f func() error,
 	sleep func(context.Context, time.Duration) error) error {
+	_, err := callAttempts(ctx, 0, bo, isRetryable, f, sleep)
+	return err
+}
+
+func callAttempts(ctx context.Context, maxAttempts int, bo gax.Backoff, isRetryable func(error) bool, f func() error,
+	sleep func(context.Context, time.Duration) error) (int, error) {
 	// Do nothing if context is done on entry.
 	if err := ctx.Err(); err != nil {
-		return &ContextError{CtxErr: err}
+		return 0, &ContextError{CtxErr: err}
 	}
-	for {
+	for n := 1; ; n++ {
 		err := f()
 		if err == nil {
-			return nil
+			return n, nil
 		}
-		if !isRetryable(err) {
-			return err
+		if !isRetryable(err) || n == maxAttempts {
+			return n, err
 		}
 		if cerr := sleep(ctx, bo.Pause()); cerr != nil {
-			return &ContextError{CtxErr: cerr, FuncErr: err}
+			return n, &ContextError{CtxErr: cerr, FuncErr: err}
 		}
 	}
 }
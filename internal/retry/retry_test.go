@@ -92,6 +92,40 @@ func TestCall(t *testing.T) {
 	}
 }
 
+func TestCallAttempts(t *testing.T) {
+	for _, test := range []struct {
+		desc        string
+		maxAttempts int
+		failures    int // number of calls to f that fail with errRetry
+		wantErr     error
+		wantCount   int
+	}{
+		{"no limit", 0, 5, nil, 6},
+		{"succeeds before the limit", 3, 2, nil, 3},
+		{"reaches the limit", 3, 5, errRetry, 3},
+		{"single attempt", 1, 5, errRetry, 1},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			sleep := func(context.Context, time.Duration) error { return nil }
+			gotCount := 0
+			f := func() error {
+				gotCount++
+				if gotCount <= test.failures {
+					return errRetry
+				}
+				return nil
+			}
+			n, gotErr := callAttempts(context.Background(), test.maxAttempts, gax.Backoff{}, retryable, f, sleep)
+			if gotErr != test.wantErr {
+				t.Errorf("error: got %v, want %v", gotErr, test.wantErr)
+			}
+			if gotCount != test.wantCount || n != test.wantCount {
+				t.Errorf("retry count: got %d (returned %d), want %d", gotCount, n, test.wantCount)
+			}
+		})
+	}
+}
+
 func TestCallCancel(t *testing.T) {
 	t.Run("done on entry", func(t *testing.T) {
 		// If the context is done on entry, f is never called.
@@ -100,6 +100,9 @@ func NewRecordReplayClient(ctx context.Context, t *testing.T, rf func(r *httprep
 
 		return c, cleanup, state.UnixNano()
 	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		t.Skipf("No golden file %s; run with -record to create it", path)
+	}
 	t.Logf("Replaying from golden file %s", path)
 	rep, err := httpreplay.NewReplayer(path)
 	if err != nil {
@@ -48,6 +48,13 @@
 //    non-UTF-8 message bodies. By default, non-UTF-8 message bodies are base64
 //    encoded.
 //
+// Dead-Letter Policies
+//
+// SQS can only redrive messages to another SQS queue, not to an SNS topic, so
+// the dead-letter policies of subscriptions are applied by
+// pubsub.Subscription, using the ApproximateReceiveCount of the messages. To
+// use SQS redrive instead, set a RedrivePolicy on the queue.
+//
 // As
 //
 // awssnssqs exposes the following types for As:
@@ -429,6 +436,9 @@ var errorCodeMap = map[string]gcerrors.ErrorCode{
 type subscription struct {
 	client *sqs.SQS
 	qURL   string
+
+	mu             sync.Mutex
+	reportAttempts bool // whether to request the ApproximateReceiveCount of messages
 }
 
 // SubscriptionOptions will contain configuration for subscriptions.
@@ -448,10 +458,16 @@ func openSubscription(ctx context.Context, sess client.ConfigProvider, qURL stri
 
 // ReceiveBatch implements driver.Subscription.ReceiveBatch.
 func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*driver.Message, error) {
-	output, err := s.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+	in := &sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(s.qURL),
 		MaxNumberOfMessages: aws.Int64(int64(maxMessages)),
-	})
+	}
+	s.mu.Lock()
+	if s.reportAttempts {
+		in.AttributeNames = []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)}
+	}
+	s.mu.Unlock()
+	output, err := s.client.ReceiveMessageWithContext(ctx, in)
 	if err != nil {
 		return nil, err
 	}
@@ -490,10 +506,15 @@ func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*dr
 			b = []byte(body.Message)
 		}
 
+		var attempt int
+		if n, ok := m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]; ok {
+			attempt, _ = strconv.Atoi(aws.StringValue(n))
+		}
 		m2 := &driver.Message{
-			Body:     b,
-			Metadata: attrs,
-			AckID:    m.ReceiptHandle,
+			Body:            b,
+			Metadata:        attrs,
+			DeliveryAttempt: attempt,
+			AckID:           m.ReceiptHandle,
 			AsFunc: func(i interface{}) bool {
 				p, ok := i.(**sqs.Message)
 				if !ok {
@@ -572,6 +593,15 @@ func (s *subscription) SendNacks(ctx context.Context, ids []driver.AckID) error
 	return nil
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+// See the package documentation for details.
+func (s *subscription) SetDeadLetterPolicy(ctx context.Context, maxDeliveryAttempts int, _ driver.Topic) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reportAttempts = maxDeliveryAttempts > 0
+	return false, nil
+}
+
 // IsRetryable implements driver.Subscription.IsRetryable.
 func (*subscription) IsRetryable(error) bool {
 	// The client handles retries.
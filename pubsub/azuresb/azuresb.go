@@ -37,6 +37,15 @@
 // See https://godoc.org/github.com/eliben/gocdkx/pubsub#hdr-At_most_once_and_At_least_once_Delivery
 // for more background.
 //
+// Dead-Letter Policies
+//
+// The dead-letter policies of subscriptions are applied by
+// pubsub.Subscription, using the DeliveryCount of the messages. Service Bus
+// also dead-letters messages natively, to the dead-letter queue of the
+// subscription, after the MaxDeliveryCount of the subscription, which
+// defaults to 10; configure it, and ForwardDeadLetteredMessagesTo to forward
+// those messages to a topic, on the subscription itself.
+//
 // As
 //
 // azuresb exposes the following types for As:
@@ -454,10 +463,11 @@ func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*dr
 				return nil
 			})
 			messages = append(messages, &driver.Message{
-				Body:     sbmsg.Data,
-				Metadata: metadata,
-				AckID:    sbmsg.LockToken,
-				AsFunc:   messageAsFunc(sbmsg),
+				Body:            sbmsg.Data,
+				Metadata:        metadata,
+				DeliveryAttempt: int(sbmsg.DeliveryCount),
+				AckID:           sbmsg.LockToken,
+				AsFunc:          messageAsFunc(sbmsg),
 			})
 			if len(messages) >= maxMessages {
 				cancel()
@@ -504,6 +514,12 @@ func (s *subscription) SendNacks(ctx context.Context, ids []driver.AckID) error
 	return s.updateMessageDispositions(ctx, ids, dispositionForNack)
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+// See the package documentation for details.
+func (s *subscription) SetDeadLetterPolicy(context.Context, int, driver.Topic) (bool, error) {
+	return false, nil
+}
+
 // IMPORTANT: This is a workaround to issue message dispositions in bulk which is not supported in the Service Bus SDK.
 func (s *subscription) updateMessageDispositions(ctx context.Context, ids []driver.AckID, disposition string) error {
 	if len(ids) == 0 {
@@ -0,0 +1,60 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eliben/gocdkx/pubsub/driver"
+)
+
+func TestCountDelivery(t *testing.T) {
+	s := &Subscription{}
+	a := &driver.Message{ID: "a"}
+	b := &driver.Message{ID: "b"}
+	for want := 1; want <= 3; want++ {
+		if got := s.countDelivery(a); got != want {
+			t.Errorf("got count %d, want %d", got, want)
+		}
+	}
+	if got := s.countDelivery(b); got != 1 {
+		t.Errorf("got count %d for another message, want 1", got)
+	}
+	if got := s.countDelivery(&driver.Message{}); got != 0 {
+		t.Errorf("got count %d for a message without an ID, want 0", got)
+	}
+	if len(s.deliveries) != 2 {
+		t.Errorf("got %d counts, want 2", len(s.deliveries))
+	}
+
+	// Acked or dead-lettered messages are forgotten.
+	s.forgetDeliveries(a)
+	if got := s.countDelivery(a); got != 1 {
+		t.Errorf("got count %d after forgetting, want 1", got)
+	}
+
+	// Counts idle for longer than deliveryCountTTL are dropped, such as
+	// those of messages acked by another process.
+	s.deliveries["b"].last = time.Now().Add(-2 * deliveryCountTTL)
+	s.deliveriesSwept = time.Time{}
+	s.countDelivery(a)
+	if _, ok := s.deliveries["b"]; ok {
+		t.Error("got a count idle for longer than deliveryCountTTL, want it dropped")
+	}
+	if got := s.deliveries["a"].n; got != 2 {
+		t.Errorf("got count %d for a recent message, want 2", got)
+	}
+}
@@ -53,6 +53,20 @@ type Message struct {
 	// AsFunc must be populated on messages returned from ReceiveBatch.
 	AsFunc func(interface{}) bool
 
+	// DeliveryAttempt is the number of times the message has been delivered
+	// to the subscription, including this delivery, if the provider tracks
+	// it; otherwise it is 0. This field should only be set by methods
+	// implementing Subscription.ReceiveBatch.
+	DeliveryAttempt int
+
+	// ID identifies the message across redeliveries to the subscription,
+	// unlike AckID, which may change. The concrete type uses it to count
+	// deliveries when DeliveryAttempt is 0; it may be empty if the provider
+	// has no such identifier, in which case dead-letter policies can't be
+	// applied to the message. This field should only be set by methods
+	// implementing Subscription.ReceiveBatch.
+	ID string
+
 	// BeforeSend is a callback used when sending a message. It should remain
 	// nil on messages returned from ReceiveBatch.
 	//
@@ -178,6 +192,21 @@ type Subscription interface {
 	// pubsub.NewSubscription.
 	SendNacks(ctx context.Context, ackIDs []AckID) error
 
+	// SetDeadLetterPolicy should configure the provider to stop redelivering
+	// the messages that have been delivered maxDeliveryAttempts times without
+	// being acked, and to send them to deadLetter instead. deadLetter may
+	// come from another driver; providers can only dead-letter to their own
+	// topics. If maxDeliveryAttempts is 0, SetDeadLetterPolicy should remove
+	// the configuration, and deadLetter is nil.
+	//
+	// SetDeadLetterPolicy should return true if the provider applies the
+	// policy. Otherwise, it should return false, and the concrete type will
+	// apply the policy, using Message.DeliveryAttempt if ReceiveBatch sets it,
+	// or counting the deliveries of each Message.ID itself.
+	//
+	// SetDeadLetterPolicy will only be called if AckFunc returns nil.
+	SetDeadLetterPolicy(ctx context.Context, maxDeliveryAttempts int, deadLetter Topic) (bool, error)
+
 	// IsRetryable should report whether err can be retried.
 	// err will always be a non-nil error returned from ReceiveBatch or SendAcks.
 	IsRetryable(err error) bool
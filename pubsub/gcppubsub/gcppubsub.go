@@ -364,6 +364,7 @@ func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*dr
 			Body:     rmm.Data,
 			Metadata: rmm.Attributes,
 			AckID:    rm.AckId,
+			ID:       rmm.MessageId,
 			AsFunc:   messageAsFunc(rmm),
 		}
 		ms = append(ms, m)
@@ -407,6 +408,14 @@ func (s *subscription) SendNacks(ctx context.Context, ids []driver.AckID) error
 	})
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+// The version of the Pub/Sub API used by gcppubsub supports neither
+// dead-letter policies nor delivery attempts, so the concrete type counts
+// the deliveries.
+func (s *subscription) SetDeadLetterPolicy(context.Context, int, driver.Topic) (bool, error) {
+	return false, nil
+}
+
 // IsRetryable implements driver.Subscription.IsRetryable.
 func (s *subscription) IsRetryable(error) bool {
 	// The client handles retries.
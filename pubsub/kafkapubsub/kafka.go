@@ -513,6 +513,7 @@ func (s *subscription) ReceiveBatch(ctx context.Context, maxMessages int) ([]*dr
 			Body:     msg.Value,
 			Metadata: md,
 			AckID:    ack,
+			ID:       fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset),
 			AsFunc: func(i interface{}) bool {
 				if p, ok := i.(**sarama.ConsumerMessage); ok {
 					*p = msg
@@ -572,6 +573,12 @@ func (s *subscription) Close() error {
 	return nil
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+// Kafka doesn't track deliveries, so the concrete type counts them.
+func (*subscription) SetDeadLetterPolicy(context.Context, int, driver.Topic) (bool, error) {
+	return false, nil
+}
+
 // IsRetryable implements driver.Subscription.IsRetryable.
 func (*subscription) IsRetryable(error) bool {
 	return false
@@ -34,6 +34,14 @@
 // See https://godoc.org/github.com/eliben/gocdkx/pubsub#hdr-At_most_once_and_At_least_once_Delivery
 // for more background.
 //
+// Dead-Letter Policies
+//
+// mempubsub applies the dead-letter policies of subscriptions whose
+// dead-letter topic comes from mempubsub; it records the number of delivery
+// attempts of the dead-lettered messages in their Metadata under
+// pubsub.DeliveryAttemptsKey. For other topics, the policy is applied by
+// pubsub.Subscription.
+//
 // As
 //
 // mempubsub does not support any types for As.
@@ -45,6 +53,7 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"strconv"
 	"sync"
 	"time"
 
@@ -202,6 +211,10 @@ type subscription struct {
 	topic       *topic
 	ackDeadline time.Duration
 	msgs        map[driver.AckID]*message // all unacknowledged messages
+
+	// Set by SetDeadLetterPolicy; deadLetter is nil if there is no policy.
+	maxDeliveries int
+	deadLetter    *topic
 }
 
 // NewSubscription creates a new subscription for the given topic.
@@ -233,6 +246,7 @@ func newSubscription(topic *topic, ackDeadline time.Duration) *subscription {
 type message struct {
 	msg        *driver.Message
 	expiration time.Time
+	deliveries int // number of times the message has been delivered
 }
 
 func (s *subscription) add(ms []*driver.Message) {
@@ -249,19 +263,43 @@ func (s *subscription) add(ms []*driver.Message) {
 // Collect some messages available for delivery. Since we're iterating over a map,
 // the order of the messages won't match the publish order, which mimics the actual
 // behavior of most pub/sub services.
+//
+// Messages that have used up their delivery attempts are sent to the
+// dead-letter topic instead.
 func (s *subscription) receiveNoWait(now time.Time, max int) []*driver.Message {
-	var msgs []*driver.Message
+	var msgs, dead []*driver.Message
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	for _, m := range s.msgs {
-		if now.After(m.expiration) {
-			msgs = append(msgs, m.msg)
-			m.expiration = now.Add(s.ackDeadline)
-			if len(msgs) == max {
-				return msgs
+	for id, m := range s.msgs {
+		if !now.After(m.expiration) {
+			continue
+		}
+		if s.deadLetter != nil && m.deliveries >= s.maxDeliveries {
+			delete(s.msgs, id)
+			md := map[string]string{}
+			for k, v := range m.msg.Metadata {
+				md[k] = v
 			}
+			md[pubsub.DeliveryAttemptsKey] = strconv.Itoa(m.deliveries)
+			dead = append(dead, &driver.Message{Body: m.msg.Body, Metadata: md})
+			continue
+		}
+		m.deliveries++
+		m.expiration = now.Add(s.ackDeadline)
+		// The driver.Message is shared with the other subscriptions of the
+		// topic, so return a copy with this subscription's delivery attempt.
+		dm := *m.msg
+		dm.DeliveryAttempt = m.deliveries
+		msgs = append(msgs, &dm)
+		if len(msgs) == max {
+			break
 		}
 	}
+	deadLetter := s.deadLetter
+	s.mu.Unlock()
+	if len(dead) > 0 {
+		// SendBatch only fails for a canceled context.
+		_ = deadLetter.SendBatch(context.Background(), dead)
+	}
 	return msgs
 }
 
@@ -341,6 +379,21 @@ func (s *subscription) SendNacks(ctx context.Context, ackIDs []driver.AckID) err
 	return nil
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+func (s *subscription) SetDeadLetterPolicy(ctx context.Context, maxDeliveryAttempts int, deadLetter driver.Topic) (bool, error) {
+	if s.topic == nil {
+		return false, errNotExist
+	}
+	t, _ := deadLetter.(*topic)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxDeliveries = maxDeliveryAttempts
+	s.deadLetter = t
+	// For topics from other drivers, ReceiveBatch reports the delivery
+	// attempts so that the concrete type can apply the policy.
+	return t != nil, nil
+}
+
 // IsRetryable implements driver.Subscription.IsRetryable.
 func (*subscription) IsRetryable(error) bool { return false }
 
@@ -76,6 +76,59 @@ func TestReceive(t *testing.T) {
 	}
 }
 
+func TestDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	dlTopic := &topic{}
+	dlSub := newSubscription(dlTopic, 3*time.Second)
+	topic := &topic{}
+	sub := newSubscription(topic, 3*time.Second)
+	native, err := sub.SetDeadLetterPolicy(ctx, 2, dlTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !native {
+		t.Error("got non-native dead-letter policy for a mempubsub topic")
+	}
+	if err := topic.SendBatch(ctx, []*driver.Message{
+		{Body: []byte("a"), Metadata: map[string]string{"k": "v"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The message is delivered twice, without being acked.
+	now := time.Now()
+	for want := 1; want <= 2; want++ {
+		msgs := sub.receiveNoWait(now, 10)
+		if len(msgs) != 1 {
+			t.Fatalf("got %d messages, want 1", len(msgs))
+		}
+		if got := msgs[0].DeliveryAttempt; got != want {
+			t.Errorf("got delivery attempt %d, want %d", got, want)
+		}
+		now = now.Add(time.Hour)
+	}
+	// Then it is sent to the dead-letter topic instead of being redelivered.
+	if msgs := sub.receiveNoWait(now, 10); len(msgs) != 0 {
+		t.Fatalf("got %d messages, want 0", len(msgs))
+	}
+	msgs := dlSub.receiveNoWait(now, 10)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d dead-lettered messages, want 1", len(msgs))
+	}
+	if got, want := string(msgs[0].Body), "a"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+	if got, want := msgs[0].Metadata["k"], "v"; got != want {
+		t.Errorf("got metadata value %q, want %q", got, want)
+	}
+	if got, want := msgs[0].Metadata[pubsub.DeliveryAttemptsKey], "2"; got != want {
+		t.Errorf("got %s %q, want %q", pubsub.DeliveryAttemptsKey, got, want)
+	}
+	// A subscription of a topic that doesn't exist can't have a policy.
+	if _, err := newSubscription(nil, time.Second).SetDeadLetterPolicy(ctx, 2, dlTopic); err == nil {
+		t.Error("got nil error for a nonexistent topic, want error")
+	}
+}
+
 func TestOpenTopicFromURL(t *testing.T) {
 	tests := []struct {
 		URL     string
@@ -404,6 +404,12 @@ func (s *subscription) SendNacks(ctx context.Context, ids []driver.AckID) error
 	panic("unreachable")
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+// It should never be called because we provide a non-nil AckFunc.
+func (*subscription) SetDeadLetterPolicy(context.Context, int, driver.Topic) (bool, error) {
+	return false, nil
+}
+
 // IsRetryable implements driver.Subscription.IsRetryable.
 func (s *subscription) IsRetryable(error) bool { return false }
 
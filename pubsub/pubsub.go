@@ -87,6 +87,7 @@
 //  - Topic.Send
 //  - Topic.Shutdown
 //  - Subscription.Receive
+//  - Subscription.SetDeadLetterPolicy
 //  - Subscription.Shutdown
 //  - The internal driver methods SendBatch, SendAcks and ReceiveBatch.
 // All trace and metric names begin with the package import path.
@@ -111,6 +112,7 @@ import (
 	"net/url"
 	"reflect"
 	"runtime"
+	"strconv"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -230,6 +232,9 @@ type Topic struct {
 	mu      sync.Mutex
 	err     error
 
+	// retryPolicy is protected by mu.
+	retryPolicy *gcerrors.RetryPolicy
+
 	// cancel cancels all SendBatch calls.
 	cancel func()
 }
@@ -272,6 +277,21 @@ func (t *Topic) Send(ctx context.Context, m *Message) (err error) {
 	return t.batcher.Add(ctx, dm)
 }
 
+// SetRetryPolicy sets the policy used to retry the batches of messages that
+// fail to be sent, or restores the default if p is nil. By default, failed
+// batches are retried until the Topic is shut down if the provider reports
+// that their errors are retryable.
+//
+// With a policy, batches are also retried if their errors have one of the
+// policy's codes, and at most p.MaxAttempts times. Each attempt is traced as
+// a call to driver.Topic.SendBatch, whose span's "attempts" attribute is the
+// number of attempts made so far.
+func (t *Topic) SetRetryPolicy(p *gcerrors.RetryPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.retryPolicy = p
+}
+
 var errTopicShutdown = gcerr.Newf(gcerr.FailedPrecondition, nil, "pubsub: Topic has been Shutdown")
 
 // Shutdown flushes pending message sends and disconnects the Topic.
@@ -327,10 +347,11 @@ func newSendBatcher(ctx context.Context, t *Topic, dt driver.Topic, opts *batche
 	const maxHandlers = 1
 	handler := func(items interface{}) error {
 		dms := items.([]*driver.Message)
-		err := retry.Call(ctx, gax.Backoff{}, dt.IsRetryable, func() (err error) {
-			ctx2 := t.tracer.Start(ctx, "driver.Topic.SendBatch")
-			defer func() { t.tracer.End(ctx2, err) }()
-			return dt.SendBatch(ctx2, dms)
+		t.mu.Lock()
+		policy := t.retryPolicy
+		t.mu.Unlock()
+		err := retryCall(ctx, t.tracer, "driver.Topic.SendBatch", dt, policy, func(ctx context.Context) error {
+			return dt.SendBatch(ctx, dms)
 		})
 		if err != nil {
 			return wrapError(dt, err)
@@ -396,6 +417,20 @@ type Subscription struct {
 	throughputEnd    time.Time         // end time for throughput measurement, or the zero Time if queue is not empty
 	throughputCount  int               // number of msgs given out via Receive since throughputStart
 
+	retryPolicy *gcerrors.RetryPolicy // set by SetRetryPolicy
+	deadLetter  *DeadLetterPolicy     // set by SetDeadLetterPolicy if the provider doesn't apply it
+
+	// deliveries counts the deliveries of the messages for which the
+	// provider doesn't report them, by driver.Message.ID, while deadLetter
+	// is set. Counts idle for longer than deliveryCountTTL are dropped when
+	// deliveriesSwept is older than a tenth of it.
+	deliveries      map[string]*deliveryCount
+	deliveriesSwept time.Time
+
+	// deadLetters tracks the messages being sent to the dead-letter topic.
+	// Add is only called while s.mu is held and s.err is nil.
+	deadLetters sync.WaitGroup
+
 	// Used in tests.
 	preReceiveBatchHook func(maxMessages int)
 }
@@ -598,6 +633,16 @@ func (s *Subscription) Receive(ctx context.Context) (_ *Message, err error) {
 			// At least one message is available. Return it.
 			m := s.q[0]
 			s.q = s.q[1:]
+			dl := s.deadLetter
+			attempt := m.DeliveryAttempt
+			if dl != nil && attempt == 0 {
+				attempt = s.countDelivery(m)
+			}
+			if dl != nil && attempt > dl.MaxDeliveryAttempts {
+				// The message wasn't acked in its allowed delivery attempts.
+				s.sendToDeadLetter(dl, m, attempt)
+				continue
+			}
 			s.throughputCount++
 
 			// Convert driver.Message to Message.
@@ -614,6 +659,17 @@ func (s *Subscription) Receive(ctx context.Context) (_ *Message, err error) {
 			}
 			if s.ackFunc == nil {
 				m2.ack = func(isAck bool) {
+					if !isAck && dl != nil && attempt >= dl.MaxDeliveryAttempts {
+						s.mu.Lock()
+						defer s.mu.Unlock()
+						s.sendToDeadLetter(dl, m, attempt)
+						return
+					}
+					if isAck && dl != nil && m.DeliveryAttempt == 0 {
+						s.mu.Lock()
+						s.forgetDeliveries(m)
+						s.mu.Unlock()
+					}
 					// Ignore the error channel. Errors are dealt with
 					// in the ackBatcher handler.
 					_ = s.ackBatcher.AddNoWait(&driver.AckInfo{AckID: id, IsAck: isAck})
@@ -670,14 +726,14 @@ func (s *Subscription) getNextBatch(nMessages int) ([]*driver.Message, error) {
 	batches := batcher.Split(nMessages, s.recvBatchOpts)
 
 	g, ctx := errgroup.WithContext(s.backgroundCtx)
+	s.mu.Lock()
+	policy := s.retryPolicy
+	s.mu.Unlock()
 	for _, maxMessagesInBatch := range batches {
 		g.Go(func() error {
 			var msgs []*driver.Message
-			err := retry.Call(ctx, gax.Backoff{}, s.driver.IsRetryable, func() error {
-				var err error
-				ctx2 := s.tracer.Start(ctx, "driver.Subscription.ReceiveBatch")
-				defer func() { s.tracer.End(ctx2, err) }()
-				msgs, err = s.driver.ReceiveBatch(ctx2, maxMessagesInBatch)
+			err := retryCall(ctx, s.tracer, "driver.Subscription.ReceiveBatch", s.driver, policy, func(ctx context.Context) (err error) {
+				msgs, err = s.driver.ReceiveBatch(ctx, maxMessagesInBatch)
 				return err
 			})
 			if err != nil {
@@ -695,6 +751,169 @@ func (s *Subscription) getNextBatch(nMessages int) ([]*driver.Message, error) {
 	return q, nil
 }
 
+// SetRetryPolicy sets the policy used to retry the calls to the provider that
+// receive messages and send acks, or restores the default if p is nil. By
+// default, failed calls are retried until the Subscription is shut down if
+// the provider reports that their errors are retryable.
+//
+// With a policy, calls are also retried if their errors have one of the
+// policy's codes, and at most p.MaxAttempts times. The span of each attempt
+// records the number of attempts made so far in its "attempts" attribute.
+func (s *Subscription) SetRetryPolicy(p *gcerrors.RetryPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryPolicy = p
+}
+
+// DeliveryAttemptsKey is the Metadata key under which a Subscription records
+// the number of delivery attempts of the messages that it sends to the topic
+// of its DeadLetterPolicy.
+const DeliveryAttemptsKey = "gocdk-delivery-attempts"
+
+// A DeadLetterPolicy sends the messages of a Subscription that aren't acked
+// after a number of deliveries to a dead-letter Topic, instead of
+// redelivering them forever; see Subscription.SetDeadLetterPolicy.
+type DeadLetterPolicy struct {
+	// MaxDeliveryAttempts is the number of times a message is delivered
+	// before it is dead-lettered. It must be at least 1.
+	MaxDeliveryAttempts int
+
+	// Topic is the dead-letter topic. It must not be shut down before the
+	// Subscription.
+	Topic *Topic
+}
+
+// SetDeadLetterPolicy sets the policy for the messages that are not acked
+// after repeated deliveries, or removes it if p is nil. By default, such
+// messages are redelivered forever.
+//
+// If the provider supports dead-lettering to p.Topic, it applies the policy;
+// see the provider-specific package documentation. Otherwise, the
+// Subscription applies it: a message that is nacked on its last allowed
+// delivery, or that is received after it, is sent to p.Topic with its number
+// of delivery attempts in its Metadata under DeliveryAttemptsKey, and then
+// acked.
+//
+// The Subscription uses the number of delivery attempts that the provider
+// reports. If the provider doesn't report them, the Subscription counts the
+// deliveries itself, from the time the policy is set until the message is
+// acked or dead-lettered, using the message IDs that the provider reports;
+// messages without one are never dead-lettered. The counts are kept in
+// memory, so they don't cover deliveries to other processes or Subscriptions,
+// and are lost on Shutdown. A count is also dropped after an hour without
+// deliveries of its message, which may have been acked elsewhere.
+func (s *Subscription) SetDeadLetterPolicy(ctx context.Context, p *DeadLetterPolicy) (err error) {
+	ctx = s.tracer.Start(ctx, "Subscription.SetDeadLetterPolicy")
+	defer func() { s.tracer.End(ctx, err) }()
+
+	maxDeliveryAttempts := 0
+	var dt driver.Topic
+	if p != nil {
+		if p.MaxDeliveryAttempts < 1 {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "pubsub: DeadLetterPolicy.MaxDeliveryAttempts must be >= 1 (%d)", p.MaxDeliveryAttempts)
+		}
+		if p.Topic == nil {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "pubsub: DeadLetterPolicy.Topic must be set")
+		}
+		if s.ackFunc != nil {
+			return gcerr.Newf(gcerr.FailedPrecondition, nil, "pubsub: dead-letter policies require a provider that redelivers messages")
+		}
+		maxDeliveryAttempts, dt = p.MaxDeliveryAttempts, p.Topic.driver
+	}
+	native, err := s.driver.SetDeadLetterPolicy(ctx, maxDeliveryAttempts, dt)
+	if err != nil {
+		return wrapError(s.driver, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if native {
+		s.deadLetter = nil
+	} else {
+		s.deadLetter = p
+	}
+	if s.deadLetter == nil {
+		s.deliveries = nil
+	}
+	return nil
+}
+
+// sendToDeadLetter sends m to the topic of p in the background, and then acks
+// it. If the send fails, m is nacked, so that it is dead-lettered again when
+// it is redelivered. attempt is the number of deliveries of m.
+// s.mu must be held.
+func (s *Subscription) sendToDeadLetter(p *DeadLetterPolicy, m *driver.Message, attempt int) {
+	if s.err != nil {
+		// The ack batcher may be shut down; m will be redelivered.
+		return
+	}
+	md := make(map[string]string, len(m.Metadata)+1)
+	for k, v := range m.Metadata {
+		md[k] = v
+	}
+	md[DeliveryAttemptsKey] = strconv.Itoa(attempt)
+	s.deadLetters.Add(1)
+	go func() {
+		defer s.deadLetters.Done()
+		err := p.Topic.Send(s.backgroundCtx, &Message{Body: m.Body, Metadata: md})
+		if err != nil && !s.canNack {
+			return
+		}
+		if err == nil && m.DeliveryAttempt == 0 {
+			s.mu.Lock()
+			s.forgetDeliveries(m)
+			s.mu.Unlock()
+		}
+		_ = s.ackBatcher.AddNoWait(&driver.AckInfo{AckID: m.AckID, IsAck: err == nil})
+	}()
+}
+
+// deliveryCountTTL is how long the delivery count of a message is kept after
+// its last delivery. It is much longer than typical ack deadlines, so that
+// counts are only dropped for messages that are no longer redelivered to this
+// Subscription, like the messages acked by another process.
+const deliveryCountTTL = time.Hour
+
+// deliveryCount is the number of deliveries of a message, counted by a
+// Subscription.
+type deliveryCount struct {
+	n    int
+	last time.Time // time of the last delivery
+}
+
+// countDelivery records a delivery of m, for a provider that doesn't report
+// delivery attempts, and returns the number of deliveries of m so far, or 0
+// if m has no ID. s.mu must be held.
+func (s *Subscription) countDelivery(m *driver.Message) int {
+	if m.ID == "" {
+		return 0
+	}
+	now := time.Now()
+	if now.Sub(s.deliveriesSwept) >= deliveryCountTTL/10 {
+		for id, c := range s.deliveries {
+			if now.Sub(c.last) > deliveryCountTTL {
+				delete(s.deliveries, id)
+			}
+		}
+		s.deliveriesSwept = now
+	}
+	if s.deliveries == nil {
+		s.deliveries = map[string]*deliveryCount{}
+	}
+	c := s.deliveries[m.ID]
+	if c == nil {
+		c = &deliveryCount{}
+		s.deliveries[m.ID] = c
+	}
+	c.n++
+	c.last = now
+	return c.n
+}
+
+// forgetDeliveries drops the delivery count of m. s.mu must be held.
+func (s *Subscription) forgetDeliveries(m *driver.Message) {
+	delete(s.deliveries, m.ID)
+}
+
 var errSubscriptionShutdown = gcerr.Newf(gcerr.FailedPrecondition, nil, "pubsub: Subscription has been Shutdown")
 
 // Shutdown flushes pending ack sends and disconnects the Subscription.
@@ -713,6 +932,7 @@ func (s *Subscription) Shutdown(ctx context.Context) (err error) {
 	c := make(chan struct{})
 	go func() {
 		defer close(c)
+		s.deadLetters.Wait()
 		if s.ackBatcher != nil {
 			s.ackBatcher.Shutdown()
 		}
@@ -794,22 +1014,21 @@ func newAckBatcher(ctx context.Context, s *Subscription, ds driver.Subscription,
 				nacks = append(nacks, a.AckID)
 			}
 		}
+		s.mu.Lock()
+		policy := s.retryPolicy
+		s.mu.Unlock()
 		g, ctx := errgroup.WithContext(ctx)
 		if len(acks) > 0 {
 			g.Go(func() error {
-				return retry.Call(ctx, gax.Backoff{}, ds.IsRetryable, func() (err error) {
-					ctx2 := s.tracer.Start(ctx, "driver.Subscription.SendAcks")
-					defer func() { s.tracer.End(ctx2, err) }()
-					return ds.SendAcks(ctx2, acks)
+				return retryCall(ctx, s.tracer, "driver.Subscription.SendAcks", ds, policy, func(ctx context.Context) error {
+					return ds.SendAcks(ctx, acks)
 				})
 			})
 		}
 		if len(nacks) > 0 {
 			g.Go(func() error {
-				return retry.Call(ctx, gax.Backoff{}, ds.IsRetryable, func() (err error) {
-					ctx2 := s.tracer.Start(ctx, "driver.Subscription.SendNacks")
-					defer func() { s.tracer.End(ctx2, err) }()
-					return ds.SendNacks(ctx2, nacks)
+				return retryCall(ctx, s.tracer, "driver.Subscription.SendNacks", ds, policy, func(ctx context.Context) error {
+					return ds.SendNacks(ctx, nacks)
 				})
 			})
 		}
@@ -832,6 +1051,39 @@ type errorCoder interface {
 	ErrorCode(error) gcerrors.ErrorCode
 }
 
+// retrier is implemented by driver.Topic and driver.Subscription.
+type retrier interface {
+	errorCoder
+	IsRetryable(error) bool
+}
+
+// retryCall calls f until it succeeds, or fails with an error that d doesn't
+// report as retryable, and that p doesn't retry. If p is not nil, it also
+// sets the backoff and the maximum number of attempts.
+// Each attempt is traced as a call to methodName, whose span records the
+// number of attempts made so far.
+func retryCall(ctx context.Context, tracer *oc.Tracer, methodName string, d retrier, p *gcerrors.RetryPolicy, f func(context.Context) error) error {
+	var maxAttempts int
+	var bo gax.Backoff
+	isRetryable := d.IsRetryable
+	if p != nil {
+		maxAttempts = p.MaxAttempts
+		bo = gax.Backoff{Initial: p.InitialBackoff, Max: p.MaxBackoff, Multiplier: p.Multiplier}
+		isRetryable = func(err error) bool {
+			return d.IsRetryable(err) || p.Retries(d.ErrorCode(err))
+		}
+	}
+	attempts := 0
+	_, err := retry.CallAttempts(ctx, maxAttempts, bo, isRetryable, func() (err error) {
+		attempts++
+		ctx2 := tracer.Start(ctx, methodName)
+		oc.SetAttempts(ctx2, attempts)
+		defer func() { tracer.End(ctx2, err) }()
+		return f(ctx2)
+	})
+	return err
+}
+
 func wrapError(ec errorCoder, err error) error {
 	if err == nil {
 		return nil
@@ -900,41 +1152,69 @@ func (mux *URLMux) RegisterSubscription(scheme string, opener SubscriptionURLOpe
 // OpenTopic calls OpenTopicURL with the URL parsed from urlstr.
 // OpenTopic is safe to call from multiple goroutines.
 func (mux *URLMux) OpenTopic(ctx context.Context, urlstr string) (*Topic, error) {
-	opener, u, err := mux.topicSchemes.FromString("Topic", urlstr)
+	_, u, err := mux.topicSchemes.FromString("Topic", urlstr)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(TopicURLOpener).OpenTopicURL(ctx, u)
+	return mux.OpenTopicURL(ctx, u)
 }
 
 // OpenSubscription calls OpenSubscriptionURL with the URL parsed from urlstr.
 // OpenSubscription is safe to call from multiple goroutines.
 func (mux *URLMux) OpenSubscription(ctx context.Context, urlstr string) (*Subscription, error) {
-	opener, u, err := mux.subscriptionSchemes.FromString("Subscription", urlstr)
+	_, u, err := mux.subscriptionSchemes.FromString("Subscription", urlstr)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(SubscriptionURLOpener).OpenSubscriptionURL(ctx, u)
+	return mux.OpenSubscriptionURL(ctx, u)
 }
 
 // OpenTopicURL dispatches the URL to the opener that is registered with the
 // URL's scheme. OpenTopicURL is safe to call from multiple goroutines.
+//
+// The "retry_" query parameters described in gcerrors.RetryPolicy set the
+// retry policy of the topic; they are not passed to the opener.
 func (mux *URLMux) OpenTopicURL(ctx context.Context, u *url.URL) (*Topic, error) {
+	policy, u, err := openurl.RetryPolicyFromURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("open pubsub.Topic: %v", err)
+	}
 	opener, err := mux.topicSchemes.FromURL("Topic", u)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(TopicURLOpener).OpenTopicURL(ctx, u)
+	t, err := opener.(TopicURLOpener).OpenTopicURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		t.SetRetryPolicy(policy)
+	}
+	return t, nil
 }
 
 // OpenSubscriptionURL dispatches the URL to the opener that is registered with the
 // URL's scheme. OpenSubscriptionURL is safe to call from multiple goroutines.
+//
+// The "retry_" query parameters described in gcerrors.RetryPolicy set the
+// retry policy of the subscription; they are not passed to the opener.
 func (mux *URLMux) OpenSubscriptionURL(ctx context.Context, u *url.URL) (*Subscription, error) {
+	policy, u, err := openurl.RetryPolicyFromURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("open pubsub.Subscription: %v", err)
+	}
 	opener, err := mux.subscriptionSchemes.FromURL("Subscription", u)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(SubscriptionURLOpener).OpenSubscriptionURL(ctx, u)
+	s, err := opener.(SubscriptionURLOpener).OpenSubscriptionURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		s.SetRetryPolicy(policy)
+	}
+	return s, nil
 }
 
 var defaultURLMux = &URLMux{}
@@ -136,6 +136,253 @@ func TestSendReceive(t *testing.T) {
 	m2.Ack()
 }
 
+func TestDeadLetterPolicy(t *testing.T) {
+	ctx := context.Background()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+
+	// The policy is validated.
+	for _, p := range []*pubsub.DeadLetterPolicy{
+		{MaxDeliveryAttempts: 0, Topic: topic},
+		{MaxDeliveryAttempts: 2},
+	} {
+		if err := sub.SetDeadLetterPolicy(ctx, p); gcerrors.Code(err) != gcerrors.InvalidArgument {
+			t.Errorf("%+v: got error %v, want InvalidArgument", p, err)
+		}
+	}
+
+	// mempubsub only dead-letters natively to its own topics, so the policy
+	// is applied by the portable type.
+	ds := NewDriverSub()
+	dlTopic := pubsub.NewTopic(&driverTopic{subs: []*driverSub{ds}}, nil)
+	defer dlTopic.Shutdown(ctx)
+	if err := sub.SetDeadLetterPolicy(ctx, &pubsub.DeadLetterPolicy{MaxDeliveryAttempts: 2, Topic: dlTopic}); err != nil {
+		t.Fatal(err)
+	}
+	if err := topic.Send(ctx, &pubsub.Message{Body: []byte("a"), Metadata: map[string]string{"k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		m, err := sub.Receive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Nack()
+	}
+	dlSub := pubsub.NewSubscription(ds, nil, nil)
+	defer dlSub.Shutdown(ctx)
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	m, err := dlSub.Receive(ctx2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Ack()
+	want := map[string]string{"k": "v", pubsub.DeliveryAttemptsKey: "2"}
+	if string(m.Body) != "a" || !cmp.Equal(m.Metadata, want) {
+		t.Errorf("got dead-lettered message %q %v, want %q %v", m.Body, m.Metadata, "a", want)
+	}
+	// The dead-lettered message is acked, so it isn't redelivered.
+	ctx3, cancel3 := context.WithTimeout(ctx, time.Second)
+	defer cancel3()
+	if m, err := sub.Receive(ctx3); err == nil {
+		t.Errorf("got redelivered message %q, want none", m.Body)
+	}
+
+	// Removing the policy stops dead-lettering: a message nacked past the
+	// maximum is redelivered.
+	if err := sub.SetDeadLetterPolicy(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := topic.Send(ctx, &pubsub.Message{Body: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		ctx4, cancel4 := context.WithTimeout(ctx, 10*time.Second)
+		m, err := sub.Receive(ctx4)
+		cancel4()
+		if err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+		if string(m.Body) != "b" {
+			t.Fatalf("delivery %d: got message %q, want %q", i+1, m.Body, "b")
+		}
+		if i < 3 {
+			m.Nack()
+		} else {
+			m.Ack()
+		}
+	}
+	ctx5, cancel5 := context.WithTimeout(ctx, time.Second)
+	defer cancel5()
+	if m, err := dlSub.Receive(ctx5); err == nil {
+		t.Errorf("got dead-lettered message %q, want none", m.Body)
+	}
+}
+
+// nackingDriverSub redelivers nacked messages, without reporting delivery
+// attempts. Unless noIDs is set, each message sent to it has a distinct ID.
+type nackingDriverSub struct {
+	*driverSub
+	noIDs   bool
+	mu      sync.Mutex
+	nextAck int
+	pending map[driver.AckID]*driver.Message
+	ids     map[*driver.Message]string
+}
+
+func newNackingDriverSub() *nackingDriverSub {
+	return &nackingDriverSub{
+		driverSub: NewDriverSub(),
+		pending:   map[driver.AckID]*driver.Message{},
+		ids:       map[*driver.Message]string{},
+	}
+}
+
+func (s *nackingDriverSub) ReceiveBatch(ctx context.Context, maxMessages int) ([]*driver.Message, error) {
+	ms, err := s.driverSub.ReceiveBatch(ctx, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ms2 []*driver.Message
+	for _, m := range ms {
+		s.nextAck++
+		m2 := &driver.Message{Body: m.Body, Metadata: m.Metadata, AckID: s.nextAck}
+		if !s.noIDs {
+			if _, ok := s.ids[m]; !ok {
+				s.ids[m] = fmt.Sprint(len(s.ids))
+			}
+			m2.ID = s.ids[m]
+		}
+		s.pending[m2.AckID] = m
+		ms2 = append(ms2, m2)
+	}
+	return ms2, nil
+}
+
+func (s *nackingDriverSub) SendAcks(ctx context.Context, ackIDs []driver.AckID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ackIDs {
+		delete(s.pending, id)
+	}
+	return nil
+}
+
+func (s *nackingDriverSub) SendNacks(ctx context.Context, ackIDs []driver.AckID) error {
+	s.mu.Lock()
+	var ms []*driver.Message
+	for _, id := range ackIDs {
+		ms = append(ms, s.pending[id])
+		delete(s.pending, id)
+	}
+	s.mu.Unlock()
+	<-s.sem
+	s.q = append(s.q, ms...)
+	s.sem <- struct{}{}
+	return nil
+}
+
+func (*nackingDriverSub) SetDeadLetterPolicy(context.Context, int, driver.Topic) (bool, error) {
+	return false, nil
+}
+
+func (*nackingDriverSub) CanNack() bool { return true }
+
+func TestDeadLetterPolicyCountsDeliveries(t *testing.T) {
+	ctx := context.Background()
+	dlds := NewDriverSub()
+	dlTopic := pubsub.NewTopic(&driverTopic{subs: []*driverSub{dlds}}, nil)
+	defer dlTopic.Shutdown(ctx)
+	dlSub := pubsub.NewSubscription(dlds, nil, nil)
+	defer dlSub.Shutdown(ctx)
+
+	// newSub returns a Topic and Subscription whose provider doesn't report
+	// delivery attempts, with a policy of 2 attempts.
+	newSub := func(noIDs bool) (*pubsub.Topic, *pubsub.Subscription) {
+		ds := newNackingDriverSub()
+		ds.noIDs = noIDs
+		topic := pubsub.NewTopic(&driverTopic{subs: []*driverSub{ds.driverSub}}, nil)
+		sub := pubsub.NewSubscription(ds, nil, nil)
+		if err := sub.SetDeadLetterPolicy(ctx, &pubsub.DeadLetterPolicy{MaxDeliveryAttempts: 2, Topic: dlTopic}); err != nil {
+			t.Fatal(err)
+		}
+		return topic, sub
+	}
+	send := func(topic *pubsub.Topic, m *pubsub.Message) {
+		t.Helper()
+		if err := topic.Send(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func(s *pubsub.Subscription, want string) *pubsub.Message {
+		t.Helper()
+		ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		m, err := s.Receive(ctx2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Body) != want {
+			t.Fatalf("got message %q, want %q", m.Body, want)
+		}
+		return m
+	}
+	noDeadLetters := func() {
+		t.Helper()
+		ctx2, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if m, err := dlSub.Receive(ctx2); err == nil {
+			t.Errorf("got dead-lettered message %q, want none", m.Body)
+		}
+	}
+
+	topic, sub := newSub(false)
+	defer topic.Shutdown(ctx)
+	defer sub.Shutdown(ctx)
+
+	// Identical messages are counted separately: each is delivered twice
+	// without being dead-lettered.
+	send(topic, &pubsub.Message{Body: []byte("a")})
+	send(topic, &pubsub.Message{Body: []byte("a")})
+	receive(sub, "a").Nack()
+	receive(sub, "a").Nack()
+	receive(sub, "a").Ack()
+	receive(sub, "a").Ack()
+	noDeadLetters()
+
+	// A message is dead-lettered after its allowed deliveries.
+	send(topic, &pubsub.Message{Body: []byte("b"), Metadata: map[string]string{"k": "v"}})
+	receive(sub, "b").Nack()
+	receive(sub, "b").Nack()
+	m := receive(dlSub, "b")
+	m.Ack()
+	want := map[string]string{"k": "v", pubsub.DeliveryAttemptsKey: "2"}
+	if !cmp.Equal(m.Metadata, want) {
+		t.Errorf("got dead-lettered metadata %v, want %v", m.Metadata, want)
+	}
+	ctx3, cancel3 := context.WithTimeout(ctx, time.Second)
+	defer cancel3()
+	if m, err := sub.Receive(ctx3); err == nil {
+		t.Errorf("got redelivered message %q, want none", m.Body)
+	}
+
+	// Messages without an ID can't be counted, so they are redelivered.
+	topic2, sub2 := newSub(true)
+	defer topic2.Shutdown(ctx)
+	defer sub2.Shutdown(ctx)
+	send(topic2, &pubsub.Message{Body: []byte("c")})
+	for i := 0; i < 3; i++ {
+		receive(sub2, "c").Nack()
+	}
+	receive(sub2, "c").Ack()
+	noDeadLetters()
+}
+
 func TestConcurrentReceivesGetAllTheMessages(t *testing.T) {
 	howManyToSend := int(1e3)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -353,12 +600,90 @@ func (t *failSub) ReceiveBatch(ctx context.Context, maxMessages int) ([]*driver.
 
 func (*failSub) SendAcks(ctx context.Context, ackIDs []driver.AckID) error { return nil }
 func (*failSub) IsRetryable(err error) bool                                { return isRetryable(err) }
+func (*failSub) ErrorCode(error) gcerrors.ErrorCode                        { return gcerrors.Unknown }
 func (*failSub) AckFunc() func()                                           { return nil }
 func (*failSub) CanNack() bool                                             { return false }
 func (*failSub) Close() error                                              { return nil }
 
 // TODO(jba): add a test for retry of SendAcks.
 
+var errInternal = errors.New("internal")
+
+// internalTopic fails the first failures calls to SendBatch with an error
+// that is not retryable, and whose code is Internal.
+type internalTopic struct {
+	driver.Topic
+	failures int
+	calls    int
+}
+
+func (t *internalTopic) SendBatch(ctx context.Context, ms []*driver.Message) error {
+	t.calls++
+	if t.calls <= t.failures {
+		return errInternal
+	}
+	return nil
+}
+
+func (*internalTopic) IsRetryable(err error) bool         { return false }
+func (*internalTopic) ErrorCode(error) gcerrors.ErrorCode { return gcerrors.Internal }
+func (*internalTopic) Close() error                       { return nil }
+
+func TestRetryPolicyTopic(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc      string
+		policy    *gcerrors.RetryPolicy
+		wantErr   bool
+		wantCalls int
+	}{
+		{"no policy", nil, true, 1},
+		{"retried by the policy", &gcerrors.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, false, 3},
+		{"code not retried", &gcerrors.RetryPolicy{MaxAttempts: 3, Codes: []gcerrors.ErrorCode{gcerrors.Unknown}}, true, 1},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			it := &internalTopic{failures: 2}
+			topic := pubsub.NewTopic(it, nil)
+			defer topic.Shutdown(ctx)
+			topic.SetRetryPolicy(test.policy)
+			err := topic.Send(ctx, &pubsub.Message{})
+			if (err != nil) != test.wantErr {
+				t.Errorf("Send: got error %v, want error %t", err, test.wantErr)
+			}
+			if it.calls != test.wantCalls {
+				t.Errorf("calls: got %d, want %d", it.calls, test.wantCalls)
+			}
+		})
+	}
+
+	t.Run("retryable but too many attempts", func(t *testing.T) {
+		ft := &failTopic{}
+		topic := pubsub.NewTopic(ft, nil)
+		defer topic.Shutdown(ctx)
+		topic.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: nRetryCalls, InitialBackoff: time.Millisecond})
+		if err := topic.Send(ctx, &pubsub.Message{}); err == nil {
+			t.Error("Send: got nil, want error")
+		}
+		if got, want := ft.calls, nRetryCalls; got != want {
+			t.Errorf("calls: got %d, want %d", got, want)
+		}
+	})
+}
+
+func TestRetryPolicyReceive(t *testing.T) {
+	ctx := context.Background()
+	fs := &failSub{}
+	sub := pubsub.NewSubscription(fs, nil, nil)
+	defer sub.Shutdown(ctx)
+	sub.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: nRetryCalls, InitialBackoff: time.Millisecond})
+	if _, err := sub.Receive(ctx); err == nil {
+		t.Error("Receive: got nil, want error")
+	}
+	if got, want := fs.calls, nRetryCalls; got != want {
+		t.Errorf("calls: got %d, want %d", got, want)
+	}
+}
+
 var errDriver = errors.New("driver error")
 
 type erroringTopic struct {
@@ -548,6 +873,7 @@ func TestURLMux(t *testing.T) {
 		name    string
 		url     string
 		wantErr bool
+		wantURL string // the URL passed to the opener, if not url
 	}{
 		{
 			name:    "empty URL",
@@ -597,7 +923,21 @@ func TestURLMux(t *testing.T) {
 			name: "using api schema prefix",
 			url:  "pubsub+foo://foo",
 		},
+		{
+			name:    "retry options",
+			url:     "foo://myps?retry_max_attempts=3&x=a&retry_multiplier=1.5",
+			wantURL: "foo://myps?x=a",
+		},
+		{
+			name:    "invalid retry options",
+			url:     "foo://myps?retry_initial_backoff=x",
+			wantErr: true,
+		},
 	} {
+		wantURL := tc.wantURL
+		if wantURL == "" {
+			wantURL = tc.url
+		}
 		t.Run("topic: "+tc.name, func(t *testing.T) {
 			_, gotErr := mux.OpenTopic(ctx, tc.url)
 			if (gotErr != nil) != tc.wantErr {
@@ -606,8 +946,8 @@ func TestURLMux(t *testing.T) {
 			if gotErr != nil {
 				return
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 			// Repeat with OpenTopicURL.
 			parsed, err := url.Parse(tc.url)
@@ -618,8 +958,8 @@ func TestURLMux(t *testing.T) {
 			if gotErr != nil {
 				t.Fatalf("got err %v, want nil", gotErr)
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 		})
 		t.Run("subscription: "+tc.name, func(t *testing.T) {
@@ -630,8 +970,8 @@ func TestURLMux(t *testing.T) {
 			if gotErr != nil {
 				return
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 			// Repeat with OpenSubscriptionURL.
 			parsed, err := url.Parse(tc.url)
@@ -642,8 +982,8 @@ func TestURLMux(t *testing.T) {
 			if gotErr != nil {
 				t.Fatalf("got err %v, want nil", gotErr)
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 		})
 	}
@@ -658,7 +998,7 @@ func (o *fakeOpener) OpenTopicURL(ctx context.Context, u *url.URL) (*pubsub.Topi
 		return nil, errors.New("fail")
 	}
 	o.u = u
-	return nil, nil
+	return pubsub.NewTopic(&failTopic{}, nil), nil
 }
 
 func (o *fakeOpener) OpenSubscriptionURL(ctx context.Context, u *url.URL) (*pubsub.Subscription, error) {
@@ -666,5 +1006,5 @@ func (o *fakeOpener) OpenSubscriptionURL(ctx context.Context, u *url.URL) (*pubs
 		return nil, errors.New("fail")
 	}
 	o.u = u
-	return nil, nil
+	return pubsub.NewSubscription(&failSub{}, nil, nil), nil
 }
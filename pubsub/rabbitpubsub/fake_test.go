@@ -188,6 +188,7 @@ func (ch *fakeChannel) Publish(exchangeName string, pub amqp.Publishing) error {
 		del := amqp.Delivery{
 			Headers:     pub.Headers,
 			Body:        pub.Body,
+			MessageId:   pub.MessageId,
 			DeliveryTag: ch.deliveryTag,
 			// We don't care about the other fields.
 		}
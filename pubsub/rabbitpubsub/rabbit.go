@@ -26,6 +26,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/pubsub"
@@ -375,15 +376,17 @@ func closeErr(closec <-chan *amqp.Error) error {
 	}
 }
 
-// toPublishing converts a driver.Message to an amqp.Publishing.
+// toPublishing converts a driver.Message to an amqp.Publishing. It is given a
+// random MessageId, which identifies it across redeliveries.
 func toPublishing(m *driver.Message) amqp.Publishing {
 	h := amqp.Table{}
 	for k, v := range m.Metadata {
 		h[k] = v
 	}
 	return amqp.Publishing{
-		Headers: h,
-		Body:    m.Body,
+		Headers:   h,
+		Body:      m.Body,
+		MessageId: uuid.New().String(),
 	}
 }
 
@@ -628,6 +631,7 @@ func toMessage(d amqp.Delivery) *driver.Message {
 	return &driver.Message{
 		Body:     d.Body,
 		AckID:    d.DeliveryTag,
+		ID:       d.MessageId,
 		Metadata: md,
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*amqp.Delivery)
@@ -684,6 +688,13 @@ func (s *subscription) sendAcksOrNacks(ctx context.Context, ackIDs []driver.AckI
 	return nil
 }
 
+// SetDeadLetterPolicy implements driver.Subscription.SetDeadLetterPolicy.
+// RabbitMQ only reports whether a message has been redelivered, not how many
+// times, so the concrete type counts the deliveries of each MessageId.
+func (*subscription) SetDeadLetterPolicy(context.Context, int, driver.Topic) (bool, error) {
+	return false, nil
+}
+
 // IsRetryable implements driver.Subscription.IsRetryable.
 func (*subscription) IsRetryable(err error) bool {
 	return isRetryable(err)
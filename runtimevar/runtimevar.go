@@ -68,9 +68,12 @@ import (
 	"sync"
 	"time"
 
+	gax "github.com/googleapis/gax-go"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/gcerr"
 	"github.com/eliben/gocdkx/internal/oc"
 	"github.com/eliben/gocdkx/internal/openurl"
@@ -143,6 +146,8 @@ type Variable struct {
 	last     Snapshot
 	lastErr  error
 	lastGood Snapshot
+
+	retryPolicy *gcerrors.RetryPolicy // set by SetRetryPolicy
 }
 
 // New is intended for use by provider implementations.
@@ -208,9 +213,30 @@ func (c *Variable) Watch(ctx context.Context) (Snapshot, error) {
 	return c.last, c.lastErr
 }
 
+// SetRetryPolicy sets the policy used to retry reading the variable when the
+// provider returns an error, or disables retries if p is nil, which is the
+// default. It should be called right after the Variable is created.
+//
+// Errors retried by the policy are not returned by Watch, Latest or
+// CheckHealth until the policy's maximum number of attempts is reached.
+// Reading the variable in the background stops when the Variable is closed,
+// so with a MaxAttempts of 0, retried errors are never returned; Watch and
+// Latest stop waiting for a value when their context is done. Until a good
+// value arrives, the provider's wait hint is replaced by the policy's
+// backoff. Each read made while a policy is set is traced, and its span
+// records the number of attempts.
+func (c *Variable) SetRetryPolicy(p *gcerrors.RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retryPolicy = p
+}
+
 func (c *Variable) background(ctx context.Context) {
 	var curState, prevState driver.State
 	var wait time.Duration
+	// The backoff and number of attempts for the errors being retried.
+	var bo *gax.Backoff
+	var attempts int
 	for {
 		select {
 		case <-ctx.Done():
@@ -221,12 +247,42 @@ func (c *Variable) background(ctx context.Context) {
 			// Continue.
 		}
 
-		curState, wait = c.dw.WatchVariable(ctx, prevState)
+		c.mu.RLock()
+		p := c.retryPolicy
+		c.mu.RUnlock()
+		if p == nil {
+			curState, wait = c.dw.WatchVariable(ctx, prevState)
+		} else {
+			wctx, span := trace.StartSpan(ctx, pkgName+".WatchVariable")
+			oc.SetAttempts(wctx, attempts+1)
+			curState, wait = c.dw.WatchVariable(wctx, prevState)
+			span.End()
+		}
 		if curState == nil {
 			// No change.
+			if bo != nil {
+				wait = bo.Pause()
+			}
 			continue
 		}
 
+		// Retry errors as configured by the retry policy, leaving prevState
+		// unchanged so that the error is returned again. Once MaxAttempts is
+		// reached, if it isn't 0, errors are returned, but the backoff still
+		// applies until a good value arrives.
+		if _, err := curState.Value(); err != nil && p != nil && p.Retries(c.dw.ErrorCode(err)) {
+			attempts++
+			if bo == nil {
+				bo = &gax.Backoff{Initial: p.InitialBackoff, Max: p.MaxBackoff, Multiplier: p.Multiplier}
+			}
+			wait = bo.Pause()
+			if p.MaxAttempts == 0 || attempts < p.MaxAttempts {
+				continue
+			}
+		} else {
+			bo, attempts = nil, 0
+		}
+
 		// There's something new to return!
 		prevState = curState
 		_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(oc.ProviderKey, c.provider)}, changeMeasure.M(1))
@@ -387,21 +443,35 @@ func (mux *URLMux) RegisterVariable(scheme string, opener VariableURLOpener) {
 // OpenVariable calls OpenVariableURL with the URL parsed from urlstr.
 // OpenVariable is safe to call from multiple goroutines.
 func (mux *URLMux) OpenVariable(ctx context.Context, urlstr string) (*Variable, error) {
-	opener, u, err := mux.schemes.FromString("Variable", urlstr)
+	_, u, err := mux.schemes.FromString("Variable", urlstr)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(VariableURLOpener).OpenVariableURL(ctx, u)
+	return mux.OpenVariableURL(ctx, u)
 }
 
 // OpenVariableURL dispatches the URL to the opener that is registered with the
 // URL's scheme. OpenVariableURL is safe to call from multiple goroutines.
+//
+// The "retry_" query parameters described in gcerrors.RetryPolicy set the
+// retry policy of the variable; they are not passed to the opener.
 func (mux *URLMux) OpenVariableURL(ctx context.Context, u *url.URL) (*Variable, error) {
+	policy, u, err := openurl.RetryPolicyFromURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("open runtimevar.Variable: %v", err)
+	}
 	opener, err := mux.schemes.FromURL("Variable", u)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(VariableURLOpener).OpenVariableURL(ctx, u)
+	v, err := opener.(VariableURLOpener).OpenVariableURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		v.SetRetryPolicy(policy)
+	}
+	return v, nil
 }
 
 var defaultURLMux = new(URLMux)
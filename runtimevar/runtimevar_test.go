@@ -32,6 +32,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/gcerr"
+	"github.com/eliben/gocdkx/internal/testing/octest"
 	"github.com/eliben/gocdkx/runtimevar/driver"
 	"github.com/eliben/gocdkx/secrets/localsecrets"
 )
@@ -373,6 +374,108 @@ func TestVariable_LatestBlockedDuringClose(t *testing.T) {
 
 var errFake = errors.New("fake")
 
+// flakyWatcher returns an error state for the first failures calls to
+// WatchVariable, and then a good state. The calls block until start is
+// closed.
+type flakyWatcher struct {
+	driver.Watcher
+	start chan struct{}
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (w *flakyWatcher) WatchVariable(ctx context.Context, prev driver.State) (driver.State, time.Duration) {
+	select {
+	case <-w.start:
+	case <-ctx.Done():
+		return &state{err: ctx.Err()}, 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if prev != nil {
+		return nil, time.Hour
+	}
+	w.calls++
+	if w.calls <= w.failures {
+		return &state{err: errFake}, 0
+	}
+	return &state{val: "hello"}, 0
+}
+
+func (w *flakyWatcher) Calls() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.calls
+}
+
+func (*flakyWatcher) Close() error                       { return nil }
+func (*flakyWatcher) ErrorCode(error) gcerrors.ErrorCode { return gcerrors.Internal }
+
+func TestVariable_RetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		desc        string
+		maxAttempts int
+		wantErr     bool
+		wantCalls   int
+	}{
+		// The errors are hidden until a good value arrives.
+		{"succeeds after retries", 5, false, 4},
+		// The error is returned after the last attempt.
+		{"too many failures", 2, true, 2},
+		// Without a maximum, errors are retried until a good value arrives.
+		{"no max attempts", 0, false, 4},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			fw := &flakyWatcher{start: make(chan struct{}), failures: 3}
+			v := New(fw)
+			defer v.Close()
+			v.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: test.maxAttempts, InitialBackoff: time.Millisecond})
+			close(fw.start)
+			snap, err := v.Watch(ctx)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v want error %t", err, test.wantErr)
+			}
+			if err == nil && snap.Value != "hello" {
+				t.Errorf("got %v want hello", snap.Value)
+			}
+			if got := fw.Calls(); got != test.wantCalls {
+				t.Errorf("got %d calls want %d", got, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestVariable_RetryPolicyAttempts(t *testing.T) {
+	ctx := context.Background()
+	te := octest.NewTestExporter(OpenCensusViews)
+	defer te.Unregister()
+
+	fw := &flakyWatcher{start: make(chan struct{}), failures: 2}
+	v := New(fw)
+	v.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond})
+	close(fw.start)
+	if _, err := v.Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+
+	// Reads after the good value start again from 1, so only the first
+	// spans are compared.
+	var got []interface{}
+	for _, s := range te.Spans() {
+		if s.Name == pkgName+".WatchVariable" {
+			got = append(got, s.Attributes["attempts"])
+		}
+	}
+	want := []interface{}{int64(1), int64(2), int64(3)}
+	if len(got) < len(want) || !cmp.Equal(got[:len(want)], want) {
+		t.Errorf("got attempts %v for the WatchVariable spans, want %v first", got, want)
+	}
+}
+
 // erroringWatcher implements driver.Watcher.
 // WatchVariable always returns a state with errFake, and Close
 // always returns errFake.
@@ -441,6 +544,7 @@ func TestURLMux(t *testing.T) {
 		name    string
 		url     string
 		wantErr bool
+		wantURL string // the URL passed to the opener, if not url
 	}{
 		{
 			name:    "empty URL",
@@ -494,29 +598,45 @@ func TestURLMux(t *testing.T) {
 			name: "using api+type scheme prefix",
 			url:  "runtimevar+variable+foo:///foo/bar/baz",
 		},
+		{
+			name:    "retry options",
+			url:     "foo://myvar?retry_max_attempts=3&x=a&retry_codes=Unknown,NotFound",
+			wantURL: "foo://myvar?x=a",
+		},
+		{
+			name:    "invalid retry options",
+			url:     "foo://myvar?retry_max_backoff=x",
+			wantErr: true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			_, gotErr := mux.OpenVariable(ctx, tc.url)
+			v, gotErr := mux.OpenVariable(ctx, tc.url)
 			if (gotErr != nil) != tc.wantErr {
 				t.Fatalf("got err %v, want error %v", gotErr, tc.wantErr)
 			}
 			if gotErr != nil {
 				return
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			defer v.Close()
+			wantURL := tc.wantURL
+			if wantURL == "" {
+				wantURL = tc.url
+			}
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 			// Repeat with OpenVariableURL.
 			parsed, err := url.Parse(tc.url)
 			if err != nil {
 				t.Fatal(err)
 			}
-			_, gotErr = mux.OpenVariableURL(ctx, parsed)
+			v, gotErr = mux.OpenVariableURL(ctx, parsed)
 			if gotErr != nil {
 				t.Fatalf("got err %v, want nil", gotErr)
 			}
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			defer v.Close()
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 		})
 	}
@@ -531,7 +651,7 @@ func (o *fakeOpener) OpenVariableURL(ctx context.Context, u *url.URL) (*Variable
 		return nil, errors.New("fail")
 	}
 	o.u = u
-	return nil, nil
+	return New(&fakeWatcher{}), nil
 }
 
 func TestDecoder(t *testing.T) {
@@ -26,6 +26,7 @@ import (
 
 	"github.com/google/subcommands"
 	"github.com/eliben/gocdkx/blob"
+	"github.com/eliben/gocdkx/blob/blobsync"
 
 	// Import the blob driver packages we want to be able to open.
 	_ "github.com/eliben/gocdkx/blob/azureblob"
@@ -46,6 +47,7 @@ func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(&downloadCmd{}, "")
 	subcommands.Register(&listCmd{}, "")
+	subcommands.Register(&syncCmd{}, "")
 	subcommands.Register(&uploadCmd{}, "")
 	log.SetFlags(0)
 	log.SetPrefix("gocdk-blob: ")
@@ -203,3 +205,76 @@ func (*uploadCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}
 	}
 	return subcommands.ExitSuccess
 }
+
+type syncCmd struct {
+	prefix      string
+	dryRun      bool
+	delete      bool
+	concurrency int
+	verbose     bool
+}
+
+func (*syncCmd) Name() string     { return "sync" }
+func (*syncCmd) Synopsis() string { return "Mirror blobs from one bucket to another" }
+func (*syncCmd) Usage() string {
+	return `sync [-p <prefix>] [-n] [-delete] [-j <concurrency>] [-v] <src bucket URL> <dst bucket URL>
+
+  Copy the blobs in <src bucket URL> that are missing or different in
+  <dst bucket URL>.
+
+  Example:
+    gocdk-blob sync -p "photos/" -delete gs://mybucket file:///tmp/snapshot` + helpSuffix
+}
+
+func (cmd *syncCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.prefix, "p", "", "only sync blobs whose keys begin with this prefix")
+	f.BoolVar(&cmd.dryRun, "n", false, "dry run; print what would be done without doing it")
+	f.BoolVar(&cmd.delete, "delete", false, "delete blobs in the destination that are not in the source")
+	f.IntVar(&cmd.concurrency, "j", blobsync.DefaultConcurrency, "number of blobs to copy concurrently")
+	f.BoolVar(&cmd.verbose, "v", false, "also print blobs that are already in sync")
+}
+
+func (cmd *syncCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 2 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	// Open both buckets; they can use different providers.
+	src, err := blob.OpenBucket(ctx, f.Arg(0))
+	if err != nil {
+		log.Printf("Failed to open source bucket: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	defer src.Close()
+	dst, err := blob.OpenBucket(ctx, f.Arg(1))
+	if err != nil {
+		log.Printf("Failed to open destination bucket: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	defer dst.Close()
+
+	opts := &blobsync.Options{
+		Prefix:           cmd.prefix,
+		DryRun:           cmd.dryRun,
+		DeleteExtraneous: cmd.delete,
+		Concurrency:      cmd.concurrency,
+		Progress: func(ev blobsync.Event) {
+			switch {
+			case ev.Err != nil:
+				log.Printf("Failed to %s %q: %v\n", ev.Action, ev.Key, ev.Err)
+			case ev.Action != blobsync.Skip || cmd.verbose:
+				fmt.Printf("%s %s (%d bytes)\n", ev.Action, ev.Key, ev.Size)
+			}
+		},
+	}
+	res, err := blobsync.Sync(ctx, dst, src, opts)
+	if res != nil {
+		fmt.Printf("copied %d (%d bytes), deleted %d, skipped %d\n", res.Copied, res.BytesCopied, res.Deleted, res.Skipped)
+	}
+	if err != nil {
+		log.Printf("Failed to sync: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
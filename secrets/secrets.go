@@ -60,12 +60,16 @@ package secrets // import "github.com/eliben/gocdkx/secrets"
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"sync"
 
+	gax "github.com/googleapis/gax-go"
+	"github.com/eliben/gocdkx/gcerrors"
 	"github.com/eliben/gocdkx/internal/gcerr"
 	"github.com/eliben/gocdkx/internal/oc"
 	"github.com/eliben/gocdkx/internal/openurl"
+	"github.com/eliben/gocdkx/internal/retry"
 	"github.com/eliben/gocdkx/secrets/driver"
 )
 
@@ -75,11 +79,12 @@ type Keeper struct {
 	k      driver.Keeper
 	tracer *oc.Tracer
 
-	// mu protects the closed variable.
+	// mu protects the closed and retryPolicy variables.
 	// Read locks are kept to allow holding a read lock for long-running calls,
 	// and thereby prevent closing until a call finishes.
-	mu     sync.RWMutex
-	closed bool
+	mu          sync.RWMutex
+	closed      bool
+	retryPolicy *gcerrors.RetryPolicy
 }
 
 // NewKeeper is intended for use by provider implementations.
@@ -119,9 +124,13 @@ func (k *Keeper) Encrypt(ctx context.Context, plaintext []byte) (ciphertext []by
 		return nil, errClosed
 	}
 
-	b, err := k.k.Encrypt(ctx, plaintext)
+	var b []byte
+	err = k.retry(ctx, func() (err error) {
+		b, err = k.k.Encrypt(ctx, plaintext)
+		return err
+	})
 	if err != nil {
-		return nil, wrapError(k, err)
+		return nil, err
 	}
 	return b, nil
 }
@@ -137,13 +146,42 @@ func (k *Keeper) Decrypt(ctx context.Context, ciphertext []byte) (plaintext []by
 		return nil, errClosed
 	}
 
-	b, err := k.k.Decrypt(ctx, ciphertext)
+	var b []byte
+	err = k.retry(ctx, func() (err error) {
+		b, err = k.k.Decrypt(ctx, ciphertext)
+		return err
+	})
 	if err != nil {
-		return nil, wrapError(k, err)
+		return nil, err
 	}
 	return b, nil
 }
 
+// SetRetryPolicy sets the policy used to retry the calls to Encrypt and
+// Decrypt that fail, or disables retries if p is nil, which is the default.
+// The number of attempts is recorded in the "attempts" attribute of the
+// OpenCensus span of the call.
+func (k *Keeper) SetRetryPolicy(p *gcerrors.RetryPolicy) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.retryPolicy = p
+}
+
+// retry calls f, retrying it as configured by the retry policy of k, and
+// returns its wrapped error. k.mu must be held.
+func (k *Keeper) retry(ctx context.Context, f func() error) error {
+	p := k.retryPolicy
+	if p == nil {
+		return wrapError(k, f())
+	}
+	bo := gax.Backoff{Initial: p.InitialBackoff, Max: p.MaxBackoff, Multiplier: p.Multiplier}
+	n, err := retry.CallAttempts(ctx, p.MaxAttempts, bo, func(err error) bool {
+		return p.Retries(k.k.ErrorCode(err))
+	}, f)
+	oc.SetAttempts(ctx, n)
+	return wrapError(k, err)
+}
+
 var errClosed = gcerr.Newf(gcerr.FailedPrecondition, nil, "secrets: Keeper has been closed")
 
 // Close releases any resources used for the Keeper.
@@ -213,21 +251,35 @@ func (mux *URLMux) RegisterKeeper(scheme string, opener KeeperURLOpener) {
 // OpenKeeper calls OpenKeeperURL with the URL parsed from urlstr.
 // OpenKeeper is safe to call from multiple goroutines.
 func (mux *URLMux) OpenKeeper(ctx context.Context, urlstr string) (*Keeper, error) {
-	opener, u, err := mux.schemes.FromString("Keeper", urlstr)
+	_, u, err := mux.schemes.FromString("Keeper", urlstr)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(KeeperURLOpener).OpenKeeperURL(ctx, u)
+	return mux.OpenKeeperURL(ctx, u)
 }
 
 // OpenKeeperURL dispatches the URL to the opener that is registered with the
 // URL's scheme. OpenKeeperURL is safe to call from multiple goroutines.
+//
+// The "retry_" query parameters described in gcerrors.RetryPolicy set the
+// retry policy of the keeper; they are not passed to the opener.
 func (mux *URLMux) OpenKeeperURL(ctx context.Context, u *url.URL) (*Keeper, error) {
+	policy, u, err := openurl.RetryPolicyFromURL(u)
+	if err != nil {
+		return nil, fmt.Errorf("open secrets.Keeper: %v", err)
+	}
 	opener, err := mux.schemes.FromURL("Keeper", u)
 	if err != nil {
 		return nil, err
 	}
-	return opener.(KeeperURLOpener).OpenKeeperURL(ctx, u)
+	k, err := opener.(KeeperURLOpener).OpenKeeperURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		k.SetRetryPolicy(policy)
+	}
+	return k, nil
 }
 
 var defaultURLMux = new(URLMux)
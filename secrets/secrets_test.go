@@ -21,6 +21,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/eliben/gocdkx/gcerrors"
@@ -110,6 +111,65 @@ func TestOpenCensus(t *testing.T) {
 	}
 }
 
+// flakyKeeper fails the first failures calls with errFake.
+type flakyKeeper struct {
+	erroringKeeper
+	failures int
+	calls    int
+}
+
+func (k *flakyKeeper) Encrypt(ctx context.Context, b []byte) ([]byte, error) {
+	k.calls++
+	if k.calls <= k.failures {
+		return nil, errFake
+	}
+	return b, nil
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	te := octest.NewTestExporter(OpenCensusViews)
+	defer te.Unregister()
+
+	drv := &flakyKeeper{failures: 2}
+	k := NewKeeper(drv)
+	defer k.Close()
+	k.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	if _, err := k.Encrypt(ctx, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if drv.calls != 3 {
+		t.Errorf("got %d calls want 3", drv.calls)
+	}
+
+	drv.calls = 0
+	drv.failures = 5
+	if _, err := k.Encrypt(ctx, []byte("hello")); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v want Internal", err)
+	}
+	if drv.calls != 3 {
+		t.Errorf("got %d calls want 3", drv.calls)
+	}
+
+	// Internal errors aren't retried if they're not in the policy's codes.
+	drv.calls = 0
+	k.SetRetryPolicy(&gcerrors.RetryPolicy{MaxAttempts: 3, Codes: []gcerrors.ErrorCode{gcerrors.Unknown}})
+	if _, err := k.Encrypt(ctx, []byte("hello")); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v want Internal", err)
+	}
+	if drv.calls != 1 {
+		t.Errorf("got %d calls want 1", drv.calls)
+	}
+
+	var got []interface{}
+	for _, s := range te.Spans() {
+		got = append(got, s.Attributes["attempts"])
+	}
+	if want := []interface{}{int64(3), int64(3), int64(1)}; !cmp.Equal(got, want) {
+		t.Errorf("got attempts %v for the spans, want %v", got, want)
+	}
+}
+
 var (
 	testOpenOnce sync.Once
 	testOpenGot  *url.URL
@@ -137,6 +197,7 @@ func TestURLMux(t *testing.T) {
 		name    string
 		url     string
 		wantErr bool
+		wantURL string // the URL passed to the opener, if not url
 	}{
 		{
 			name:    "empty URL",
@@ -190,6 +251,16 @@ func TestURLMux(t *testing.T) {
 			name: "using api+type scheme prefix",
 			url:  "secrets+keeper+foo://mykeeper",
 		},
+		{
+			name:    "retry options",
+			url:     "foo://mykeeper?retry_max_attempts=3&x=a&retry_max_backoff=1s",
+			wantURL: "foo://mykeeper?x=a",
+		},
+		{
+			name:    "invalid retry options",
+			url:     "foo://mykeeper?retry_codes=Oops",
+			wantErr: true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			keeper, gotErr := mux.OpenKeeper(ctx, tc.url)
@@ -200,8 +271,12 @@ func TestURLMux(t *testing.T) {
 				return
 			}
 			defer keeper.Close()
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			wantURL := tc.wantURL
+			if wantURL == "" {
+				wantURL = tc.url
+			}
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 			// Repeat with OpenKeeperURL.
 			parsed, err := url.Parse(tc.url)
@@ -213,8 +288,8 @@ func TestURLMux(t *testing.T) {
 				t.Fatalf("got err %v, want nil", gotErr)
 			}
 			defer keeper.Close()
-			if got := fake.u.String(); got != tc.url {
-				t.Errorf("got %q want %q", got, tc.url)
+			if got := fake.u.String(); got != wantURL {
+				t.Errorf("got %q want %q", got, wantURL)
 			}
 		})
 	}